@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/longxiucai/logkit/conf"
 	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/auto"
 	. "github.com/longxiucai/logkit/utils/models"
 
 	"github.com/labstack/echo"
@@ -89,3 +91,37 @@ func (rs *RestService) PostParserCheck() echo.HandlerFunc {
 		return RespSuccess(c, nil)
 	}
 }
+
+// POST /logkit/parser/detect 根据 sampleLog 中的样本日志检测日志格式
+func (rs *RestService) PostParserDetect() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		parserConfig := conf.MapConf{}
+		if err := c.Bind(&parserConfig); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrParseParse, err.Error())
+		}
+		rawData, _ := parserConfig.GetStringOr(KeySampleLog, "")
+		delete(parserConfig, KeySampleLog)
+		decision, err := auto.Detect(parserConfig, strings.Split(rawData, "\n"))
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrParseParse, err.Error())
+		}
+		return RespSuccess(c, decision)
+	}
+}
+
+// get /logkit/parser/detection/<name> 获取 runner 中 auto 解析器的检测结论
+func (rs *RestService) GetParserDetection() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "runner name is empty"
+			return RespError(c, http.StatusBadRequest, ErrParseParse, errMsg)
+		}
+
+		decision, err := rs.mgr.ParserDetection(name)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrParseParse, err.Error())
+		}
+		return RespSuccess(c, decision)
+	}
+}
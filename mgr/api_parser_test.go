@@ -5,6 +5,7 @@ import (
 
 	conf2 "github.com/longxiucai/logkit/conf"
 	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/auto"
 	. "github.com/longxiucai/logkit/utils/models"
 
 	jsoniter "github.com/json-iterator/go"
@@ -89,6 +90,49 @@ func parserParseTest(p *testParam) {
 	assert.Equal(t, exp3, got3.Data.SamplePoints[0])
 }
 
+func parserDetectTest(p *testParam) {
+	t := p.t
+	rs := p.rs
+
+	autoConf := conf2.MapConf{}
+	autoConf[KeySampleLog] = parser.SampleLogs[parser.TypeAuto]
+	autoConf[parser.KeyAutoCandidates] = "logfmt,json"
+	rawpst, err := jsoniter.Marshal(autoConf)
+	assert.NoError(t, err)
+	url := "http://127.0.0.1" + rs.address + "/logkit/parser/detect"
+	respCode, respBody, err := makeRequest(url, http.MethodPost, rawpst)
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode, string(respBody))
+
+	var got struct {
+		Code string        `json:"code"`
+		Data auto.Decision `json:"data"`
+	}
+	err = jsoniter.Unmarshal(respBody, &got)
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, parser.TypeJSON, got.Data.Type)
+	assert.Equal(t, parser.TypeJSON, got.Data.Config[parser.KeyParserType])
+	assert.Equal(t, 2, got.Data.SampleLines)
+	assert.Equal(t, 2, len(got.Data.Candidates))
+
+	autoConf[parser.KeyParserType] = parser.TypeAuto
+	rawpst, err = jsoniter.Marshal(autoConf)
+	assert.NoError(t, err)
+	url = "http://127.0.0.1" + rs.address + "/logkit/parser/parse"
+	respCode, respBody, err = makeRequest(url, http.MethodPost, rawpst)
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode)
+	var got2 respParserRet
+	err = jsoniter.Unmarshal(respBody, &got2)
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, 2, len(got2.Data.SamplePoints))
+
+	url = "http://127.0.0.1" + rs.address + "/logkit/parser/detection/notexist"
+	respCode, respBody, err = makeRequest(url, http.MethodGet, []byte{})
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusBadRequest, respCode)
+}
+
 func parserAPITest(p *testParam) {
 	t := p.t
 	rs := p.rs
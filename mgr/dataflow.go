@@ -350,6 +350,11 @@ func getSampleData(parserConfig conf.MapConf) ([]string, error) {
 	case parser.TypeMySQL:
 		sampleData = strings.Split(rawData, "\n")
 		sampleData = append(sampleData, parser.PandoraParseFlushSignal)
+	case parser.TypeAuto, parser.TypeAuditd:
+		sampleData = strings.Split(rawData, "\n")
+		sampleData = append(sampleData, parser.PandoraParseFlushSignal)
+	case parser.TypeW3C:
+		sampleData = strings.Split(rawData, "\n")
 	case parser.TypeGrok:
 		grokMode, _ := parserConfig.GetString(parser.KeyGrokMode)
 		if grokMode != grok.ModeMulti {
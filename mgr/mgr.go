@@ -14,6 +14,7 @@ import (
 	"github.com/longxiucai/logkit/cleaner"
 	config "github.com/longxiucai/logkit/conf"
 	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/auto"
 	"github.com/longxiucai/logkit/reader"
 	"github.com/longxiucai/logkit/sender"
 	. "github.com/longxiucai/logkit/utils/models"
@@ -605,6 +606,34 @@ func (m *Manager) Error(name string) (rss ErrorsResult, err error) {
 	return rss, ErrNotExist
 }
 
+// ParserDetection 返回 runner 中 auto 解析器最近一次的检测结论
+func (m *Manager) ParserDetection(name string) (*auto.Decision, error) {
+	m.runnerLock.RLock()
+	defer m.runnerLock.RUnlock()
+	for key := range m.runnerConfigs {
+		if r, ex := m.runners[key]; ex {
+			if r.Name() != name {
+				continue
+			}
+
+			lr, ok := r.(*LogExportRunner)
+			if !ok {
+				return nil, ErrNotSupport
+			}
+			ap, ok := lr.parser.(*auto.Parser)
+			if !ok {
+				return nil, fmt.Errorf("parser of runner %v is not %v", name, parser.TypeAuto)
+			}
+			decision := ap.Decision()
+			if decision == nil {
+				return nil, fmt.Errorf("parser of runner %v has not detected log format yet", name)
+			}
+			return decision, nil
+		}
+	}
+	return nil, ErrNotExist
+}
+
 func (m *Manager) Configs() (rss map[string]RunnerConfig) {
 	rss = make(map[string]RunnerConfig)
 	tmpRss := make(map[string]RunnerConfig)
@@ -89,6 +89,8 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	router.POST(PREFIX+"/parser/parse", rs.PostParse())
 	router.GET(PREFIX+"/parser/samplelogs", rs.GetParserSampleLogs())
 	router.POST(PREFIX+"/parser/check", rs.PostParserCheck())
+	router.POST(PREFIX+"/parser/detect", rs.PostParserDetect())
+	router.GET(PREFIX+"/parser/detection/:name", rs.GetParserDetection())
 
 	//transformer API
 	router.GET(PREFIX+"/transformer/usages", rs.GetTransformerUsages())
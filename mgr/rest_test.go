@@ -227,6 +227,7 @@ func TestWebAPI(t *testing.T) {
 	funcMap := map[string]func(*testParam){
 		// "metricAPITest":      metricAPITest,
 		"parserParseTest":    parserParseTest,
+		"parserDetectTest":   parserDetectTest,
 		"parserAPITest":      parserAPITest,
 		"readerAPITest":      readerAPITest,
 		"senderAPITest":      senderAPITest,
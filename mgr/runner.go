@@ -492,7 +492,9 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 		err          error
 		lines, froms []string
 		line         string
+		lineTags     []map[string]interface{}
 	)
+	ltr, hasLineTags := r.reader.(reader.LineTagsReader)
 	for !r.batchFullOrTimeout() {
 		line, err = r.reader.ReadLine()
 		if os.IsNotExist(err) {
@@ -515,6 +517,9 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 		if dataSourceTag != "" {
 			froms = append(froms, r.reader.Source())
 		}
+		if hasLineTags {
+			lineTags = append(lineTags, ltr.LineTags())
+		}
 
 		r.batchLen++
 		r.batchSize += int64(len(line))
@@ -598,6 +603,14 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 			log.Infof("Runner[%v] datasourcetag add error, datas %v datasourceSkipIndex froms %v", datas, se.DatasourceSkipIndex, froms)
 		}
 	}
+	// 与 source 相同，只有解析后的数据与行一一对应时才添加每一行的附加字段
+	if len(lineTags) > 0 {
+		if len(datas) <= len(lineTags) {
+			datas = addLineTagsToData(lineTags, se, datas, r.Name())
+		} else {
+			log.Errorf("Runner[%v] line tags add error, datas(TOTAL %v) not match with lines(TOTAL %v)", r.Name(), len(datas), len(lineTags))
+		}
+	}
 	return datas
 }
 
@@ -783,6 +796,31 @@ func addSourceToData(sourceFroms []string, se *StatsError, datas []Data, datasou
 	return datas
 }
 
+func addLineTagsToData(lineTags []map[string]interface{}, se *StatsError, datas []Data, runnerName string) []Data {
+	j := 0
+	eql := len(lineTags) == len(datas)
+	for i, tags := range lineTags {
+		if !eql && se != nil && se.ErrorIndexIn(i) {
+			continue
+		}
+		if eql {
+			j = i
+		}
+		if j >= len(datas) {
+			continue
+		}
+		for k, v := range tags {
+			if dt, ok := datas[j][k]; ok {
+				log.Infof("Runner[%v] line tag already has data %v, ignore %v", runnerName, dt, v)
+			} else {
+				datas[j][k] = v
+			}
+		}
+		j++
+	}
+	return datas
+}
+
 func addTagsToData(tags map[string]interface{}, datas []Data, runnername string) []Data {
 	for j, data := range datas {
 		for k, v := range tags {
@@ -996,6 +1034,9 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 	r.rs.ReaderStats.Speed = r.rs.ReadSpeed
 	r.rs.ReaderStats.Trend = r.rs.ReadSpeedTrend
 	r.rs.ReaderStats.Success = r.rs.ReadDataCount
+	if esr, ok := r.reader.(reader.ExtraStatsReader); ok {
+		r.rs.ReaderStats.Extra = esr.ExtraStats()
+	}
 
 	//对于DataReader，不需要Parser，默认全部成功
 	if _, ok := r.reader.(reader.DataReader); ok {
@@ -1005,6 +1046,9 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 	} else {
 		r.rs.ParserStats.Speed, r.rs.ParserStats.Trend = calcSpeedTrend(r.lastRs.ParserStats, r.rs.ParserStats, elaspedtime)
 	}
+	if esp, ok := r.parser.(parser.ExtraStatsParser); ok {
+		r.rs.ParserStats.Extra = esp.ExtraStats()
+	}
 
 	for i := range r.senders {
 		sts, ok := r.senders[i].(sender.StatsSender)
@@ -1189,6 +1189,26 @@ func TestAddDatasource(t *testing.T) {
 
 }
 
+func TestAddLineTagsToData(t *testing.T) {
+	lineTags := []map[string]interface{}{
+		{"client": "a"},
+		{"client": "b"},
+		{"client": "c", "f2": "x"},
+	}
+	se := &StatsError{
+		DatasourceSkipIndex: []int{0},
+	}
+	datas := []Data{
+		{"f1": "1"},
+		{"f2": "2"},
+	}
+	exp := []Data{
+		{"f1": "1", "client": "b"},
+		{"f2": "2", "client": "c"},
+	}
+	assert.Equal(t, exp, addLineTagsToData(lineTags, se, datas, "runner1"))
+}
+
 func TestAddDatasourceForErrData(t *testing.T) {
 	sourceFroms := []string{"a", "b", "c", "d", "e", "f"}
 	se := &StatsError{
@@ -0,0 +1,291 @@
+package apache
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/times"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeApache, NewParser)
+}
+
+// errorLogRegexp 匹配 2.2 与 2.4 默认 ErrorLogFormat 的错误日志，如
+// [Wed Oct 11 14:32:52.123456 2000] [proxy:error] [pid 1234:tid 5678] (111)Connection refused: [client 127.0.0.1:4321] AH00957: message, referer: http://a.com/
+// IPv6 的客户端地址可能带有方括号，如 [client [::1]:4321]
+var errorLogRegexp = regexp.MustCompile(`^\[([^\]]+)\] \[(?:([^:\]]+):)?([^\]]+)\]` +
+	`(?: \[pid (\d+)(?::tid (\d+))?\])?` +
+	`(?: \((-?\d+)\)([^:]*):)?` +
+	`(?: \[client (\[[^\]]*\](?::\d+)?|[^\]]+)\])?` +
+	` (?:(AH\d+): )?(.*?)(?:, referer: (.*))?$`)
+
+var errorLogTimeLayouts = []string{
+	"Mon Jan _2 15:04:05.000000 2006",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+type Parser struct {
+	name                 string
+	logType              string
+	regexp               *regexp.Regexp
+	fields               []field
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	logType, _ := c.GetStringOr(parser.KeyApacheLogType, parser.ApacheLogTypeAccess)
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+
+	p := &Parser{
+		name:                 name,
+		logType:              logType,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}
+	switch logType {
+	case parser.ApacheLogTypeError:
+		return p, nil
+	case parser.ApacheLogTypeAccess:
+	default:
+		return nil, fmt.Errorf("unsupported %v %q", parser.KeyApacheLogType, logType)
+	}
+
+	format, _ := c.GetStringOr(parser.KeyApacheLogFormat, "")
+	if format == "" {
+		confPath, _ := c.GetStringOr(parser.KeyApacheConfPath, "")
+		formatName, _ := c.GetStringOr(parser.KeyApacheLogFormatName, "combined")
+		var err error
+		if format, err = ResolveFormatFromConf(confPath, formatName); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if p.regexp, p.fields, err = CompileFormat(format); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeApache
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, true, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		if len(parseResult.Data) < 1 { //数据为空时不发送
+			se.ErrorDetail = fmt.Errorf("parsed no data by line [%v]", parseResult.Line)
+			se.AddErrors()
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	line = strings.TrimRight(line, "\r\n")
+	var (
+		entry Data
+		err   error
+	)
+	if p.logType == parser.ApacheLogTypeError {
+		entry, err = parseErrorLog(line)
+	} else {
+		entry, err = p.parseAccessLog(line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range p.labels {
+		entry[l.Name] = l.Value
+	}
+	return entry, nil
+}
+
+func (p *Parser) parseAccessLog(line string) (Data, error) {
+	values := p.regexp.FindStringSubmatch(line)
+	if values == nil {
+		return nil, fmt.Errorf("ApacheParser fail to parse log line [%v], given format is [%v]", TruncateStrSize(line, DefaultTruncateMaxSize), p.regexp)
+	}
+	entry := make(Data, len(p.fields))
+	for i, f := range p.fields {
+		raw := values[i+1]
+		switch f.kind {
+		case kindLong:
+			if raw == "-" {
+				entry[f.name] = int64(0)
+				continue
+			}
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("convert for %q to int64 failed, %q", f.name, raw)
+			}
+			entry[f.name] = v
+		case kindTime:
+			if tm, err := times.StrToTime(raw); err == nil {
+				entry[f.name] = tm.Format(time.RFC3339Nano)
+			} else {
+				entry[f.name] = raw
+			}
+		case kindEpoch:
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("convert for %q to int64 failed, %q", f.name, raw)
+			}
+			entry[f.name] = time.Unix(v/f.unit, v%f.unit*(int64(time.Second)/f.unit)).Format(time.RFC3339Nano)
+		case kindRequest:
+			raw = unescape(raw)
+			entry[f.name] = raw
+			// 请求行拆分为方法、路径与协议，格式不完整时只保留原始请求行
+			if parts := strings.Split(raw, " "); len(parts) == 3 {
+				entry[f.name+"_method"] = parts[0]
+				entry[f.name+"_uri"] = parts[1]
+				entry[f.name+"_protocol"] = parts[2]
+			}
+		default:
+			entry[f.name] = unescape(raw)
+		}
+	}
+	return entry, nil
+}
+
+// unescape 还原 httpd 在日志中转义的双引号与反斜杠
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+func parseErrorLog(line string) (Data, error) {
+	values := errorLogRegexp.FindStringSubmatch(line)
+	if values == nil {
+		return nil, fmt.Errorf("ApacheParser fail to parse error log line [%v]", TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	entry := Data{
+		"level":   values[3],
+		"message": values[10],
+	}
+	entry["time"] = values[1]
+	for _, layout := range errorLogTimeLayouts {
+		if tm, err := time.ParseInLocation(layout, values[1], time.Local); err == nil {
+			entry["time"] = tm.Format(time.RFC3339Nano)
+			break
+		}
+	}
+	if values[2] != "" {
+		entry["module"] = values[2]
+	}
+	for i, name := range map[int]string{4: "pid", 5: "tid", 6: "os_errno"} {
+		if values[i] == "" {
+			continue
+		}
+		if v, err := strconv.ParseInt(values[i], 10, 64); err == nil {
+			entry[name] = v
+		}
+	}
+	if values[7] != "" {
+		entry["os_error"] = values[7]
+	}
+	if values[9] != "" {
+		entry["error_code"] = values[9]
+	}
+	if values[11] != "" {
+		entry["referer"] = values[11]
+	}
+	if client := values[8]; client != "" {
+		entry["client"] = client
+		// 2.4 的客户端地址带有端口，2.2 没有模块与端口
+		ip := client
+		if i := strings.LastIndexByte(client, ':'); i > 0 && values[2] != "" {
+			if port, err := strconv.ParseInt(client[i+1:], 10, 64); err == nil {
+				ip = client[:i]
+				entry["client_port"] = port
+			}
+		}
+		entry["client_ip"] = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	}
+	return entry, nil
+}
@@ -0,0 +1,146 @@
+package apache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func parseOne(t *testing.T, c conf.MapConf, line string) Data {
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{line})
+	if se, ok := err.(*StatsError); ok {
+		require.NoError(t, se.ErrorDetail)
+	}
+	require.Len(t, datas, 1)
+	return datas[0]
+}
+
+func TestBuiltinFormat(t *testing.T) {
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=\"b\" HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+	data := parseOne(t, conf.MapConf{parser.KeyParserName: "apache", parser.KeyLabels: "app web"}, line)
+	assert.Equal(t, Data{
+		"remote_host":      "127.0.0.1",
+		"remote_logname":   "-",
+		"remote_user":      "frank",
+		"time":             "2000-10-10T13:55:36-07:00",
+		"request":          `GET /apache_pb.gif?a="b" HTTP/1.0`,
+		"request_method":   "GET",
+		"request_uri":      `/apache_pb.gif?a="b"`,
+		"request_protocol": "HTTP/1.0",
+		"status":           int64(200),
+		"response_bytes":   int64(2326),
+		"http_referer":     "http://www.example.com/start.html",
+		"http_user_agent":  "Mozilla/4.08 [en] (Win98; I ;Nav)",
+		"app":              "web",
+	}, data)
+
+	data = parseOne(t, conf.MapConf{parser.KeyApacheLogFormatName: "common"}, `::1 - - [10/Oct/2000:13:55:36 +0000] "-" 408 -`)
+	assert.Equal(t, "-", data["request"])
+	assert.Equal(t, int64(0), data["response_bytes"])
+	assert.NotContains(t, data, "request_method")
+}
+
+func TestFormatFromConf(t *testing.T) {
+	c := conf.MapConf{parser.KeyApacheConfPath: "test_data/httpd.conf", parser.KeyApacheLogFormatName: "combined"}
+	data := parseOne(t, c, `10.0.0.1 - - [10/Oct/2000:13:55:36 +0800] "POST /api HTTP/1.1" 201 12 "-" "curl/7.61.1" 1534`)
+	assert.Equal(t, int64(1534), data["request_time_us"])
+
+	c[parser.KeyApacheLogFormatName] = "custom"
+	data = parseOne(t, c, `www.example.com 10.0.0.2 1.2.3.4 [10/Oct/2000:13:55:36 +0800] "GET / HTTP/2.0" 304 180 3 id=42`)
+	assert.Equal(t, "www.example.com", data["server_name"])
+	assert.Equal(t, "10.0.0.2", data["client_ip"])
+	assert.Equal(t, "1.2.3.4", data["http_x_forwarded_for"])
+	assert.Equal(t, int64(180), data["bytes_sent"])
+	assert.Equal(t, int64(3), data["request_time_ms"])
+	assert.Equal(t, "id=42", data["note_cookie"])
+
+	// 根据 CustomLog 的日志文件找到格式
+	c[parser.KeyApacheLogFormatName] = "logs/access_log"
+	data = parseOne(t, c, `10.0.0.1 - - [10/Oct/2000:13:55:36 +0800] "GET / HTTP/1.1" 200 12 "-" "curl" 1`)
+	assert.Equal(t, int64(1), data["request_time_us"])
+	c[parser.KeyApacheLogFormatName] = "logs/inline_log"
+	data = parseOne(t, c, `2000-10-10 13:55:36 10.0.0.1 "GET / HTTP/1.1" 200`)
+	assert.Equal(t, "2000-10-10T13:55:36Z", data["time"])
+
+	c[parser.KeyApacheLogFormatName] = "notexist"
+	_, err := NewParser(c)
+	assert.Error(t, err)
+}
+
+func TestCompileFormat(t *testing.T) {
+	c := conf.MapConf{parser.KeyApacheLogFormat: `%{msec}t %{c}a:%{remote}p %400,501{User-agent}i %<s %>s %{tid}P %% %{Content-Type}o`}
+	data := parseOne(t, c, `971211336123 10.0.0.3:5555 - 200 404 77 % text/html`)
+	assert.Equal(t, Data{
+		"time":                   time.Unix(971211336, 123000000).Format(time.RFC3339Nano),
+		"peer_ip":                "10.0.0.3",
+		"remote_port":            int64(5555),
+		"http_user_agent":        "-",
+		"status":                 int64(200),
+		"status_2":               int64(404),
+		"tid":                    int64(77),
+		"sent_http_content_type": "text/html",
+	}, data)
+
+	_, _, err := CompileFormat(`%h %Z`)
+	assert.Error(t, err)
+
+	p, err := NewParser(conf.MapConf{parser.KeyApacheLogFormat: `%h %>s`, parser.KeyDisableRecordErrData: "true"})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{"1.1.1.1 abc", ""})
+	assert.Empty(t, datas)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+}
+
+func TestErrorLog(t *testing.T) {
+	c := conf.MapConf{parser.KeyApacheLogType: parser.ApacheLogTypeError}
+	data := parseOne(t, c, `[Wed Oct 11 14:32:52.123456 2000] [proxy:error] [pid 1234:tid 140226] (111)Connection refused: [client 10.0.0.1:52164] AH00957: HTTP: attempt to connect to 127.0.0.1:8080 failed, referer: http://example.com/`)
+	assert.Equal(t, Data{
+		"time":        time.Date(2000, 10, 11, 14, 32, 52, 123456000, time.Local).Format(time.RFC3339Nano),
+		"module":      "proxy",
+		"level":       "error",
+		"pid":         int64(1234),
+		"tid":         int64(140226),
+		"os_errno":    int64(111),
+		"os_error":    "Connection refused",
+		"client":      "10.0.0.1:52164",
+		"client_ip":   "10.0.0.1",
+		"client_port": int64(52164),
+		"error_code":  "AH00957",
+		"message":     "HTTP: attempt to connect to 127.0.0.1:8080 failed",
+		"referer":     "http://example.com/",
+	}, data)
+
+	data = parseOne(t, c, `[Wed Oct 11 14:32:52.123456 2000] [core:error] [pid 1234:tid 140226] (2)No such file or directory: [client ::1:52164] AH00132: file permissions deny server access: /var/www/x`)
+	assert.Equal(t, "::1", data["client_ip"])
+	assert.Equal(t, int64(52164), data["client_port"])
+	assert.Equal(t, int64(2), data["os_errno"])
+	assert.Equal(t, "No such file or directory", data["os_error"])
+	assert.Equal(t, "AH00132", data["error_code"])
+	assert.Equal(t, "file permissions deny server access: /var/www/x", data["message"])
+
+	data = parseOne(t, c, `[Wed Oct 11 14:32:52.123456 2000] [core:error] [pid 1234:tid 140226] [client [::1]:52164] AH00132: file permissions deny server access: /var/www/x`)
+	assert.Equal(t, "[::1]:52164", data["client"])
+	assert.Equal(t, "::1", data["client_ip"])
+	assert.Equal(t, int64(52164), data["client_port"])
+	assert.Equal(t, "AH00132", data["error_code"])
+	assert.Equal(t, "file permissions deny server access: /var/www/x", data["message"])
+
+	data = parseOne(t, c, `[Wed Oct 11 14:32:52 2000] [error] [client 127.0.0.1] client denied by server configuration: /export/home/live/ap/htdocs/test`)
+	assert.Equal(t, Data{
+		"time":      time.Date(2000, 10, 11, 14, 32, 52, 0, time.Local).Format(time.RFC3339Nano),
+		"level":     "error",
+		"client":    "127.0.0.1",
+		"client_ip": "127.0.0.1",
+		"message":   "client denied by server configuration: /export/home/live/ap/htdocs/test",
+	}, data)
+}
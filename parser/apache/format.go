@@ -0,0 +1,340 @@
+package apache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// 字段值的类型
+const (
+	kindString = iota
+	kindLong
+	kindTime
+	// kindEpoch 为 unix 时间戳，unit 为每秒的单位数
+	kindEpoch
+	kindRequest
+)
+
+type field struct {
+	name string
+	kind int
+	unit int64
+}
+
+// builtinFormats 为 httpd 默认配置中的 LogFormat
+var builtinFormats = map[string]string{
+	"common":         `%h %l %u %t "%r" %>s %b`,
+	"combined":       `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`,
+	"combinedio":     `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i" %I %O`,
+	"vhost_combined": `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`,
+	"referer":        `%{Referer}i -> %U`,
+	"agent":          `%{User-agent}i`,
+}
+
+// directives 为不带参数时各个格式指令的字段
+var directives = map[string]field{
+	"a": {name: "client_ip"},
+	"A": {name: "local_ip"},
+	"b": {name: "response_bytes", kind: kindLong},
+	"B": {name: "response_bytes", kind: kindLong},
+	"D": {name: "request_time_us", kind: kindLong},
+	"f": {name: "filename"},
+	"h": {name: "remote_host"},
+	"H": {name: "protocol"},
+	"k": {name: "keepalive_requests", kind: kindLong},
+	"l": {name: "remote_logname"},
+	"L": {name: "log_id"},
+	"m": {name: "method"},
+	"p": {name: "server_port", kind: kindLong},
+	"P": {name: "pid", kind: kindLong},
+	"q": {name: "query_string"},
+	"r": {name: "request", kind: kindRequest},
+	"R": {name: "handler"},
+	"s": {name: "status", kind: kindLong},
+	"t": {name: "time", kind: kindTime},
+	"T": {name: "request_time", kind: kindLong},
+	"u": {name: "remote_user"},
+	"U": {name: "url_path"},
+	"v": {name: "server_name"},
+	"V": {name: "host"},
+	"X": {name: "connection_status"},
+	"I": {name: "bytes_received", kind: kindLong},
+	"O": {name: "bytes_sent", kind: kindLong},
+	"S": {name: "bytes_transferred", kind: kindLong},
+}
+
+// directiveRe 匹配一个格式指令：状态码条件、< > 修饰、{参数} 以及指令字母
+var directiveRe = regexp.MustCompile(`^%(!?[0-9,]*)([<>]?)(?:\{([^}]*)\})?([<>]?)(\^t[io]|[a-zA-Z%])`)
+
+// fieldName 将请求头等名称转换为字段名，如 User-Agent 转换为 user_agent
+func fieldName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, s)
+}
+
+// resolveDirective 返回带参数的指令对应的字段
+func resolveDirective(arg, letter string) (field, error) {
+	switch letter {
+	case "i":
+		return field{name: "http_" + fieldName(arg)}, nil
+	case "o":
+		return field{name: "sent_http_" + fieldName(arg)}, nil
+	case "e":
+		return field{name: "env_" + fieldName(arg)}, nil
+	case "n":
+		return field{name: "note_" + fieldName(arg)}, nil
+	case "C":
+		return field{name: "cookie_" + fieldName(arg)}, nil
+	case "^ti":
+		return field{name: "trailer_" + fieldName(arg)}, nil
+	case "^to":
+		return field{name: "sent_trailer_" + fieldName(arg)}, nil
+	}
+	if arg == "" {
+		f, ok := directives[letter]
+		if !ok {
+			return f, fmt.Errorf("unsupported format directive %%%v", letter)
+		}
+		return f, nil
+	}
+	switch letter {
+	case "a":
+		if arg == "c" {
+			return field{name: "peer_ip"}, nil
+		}
+	case "p":
+		if arg == "remote" {
+			return field{name: "remote_port", kind: kindLong}, nil
+		}
+		return directives["p"], nil
+	case "P":
+		if arg == "hextid" {
+			return field{name: "tid"}, nil
+		}
+		if arg == "tid" {
+			return field{name: "tid", kind: kindLong}, nil
+		}
+		return directives["P"], nil
+	case "T":
+		switch arg {
+		case "ms":
+			return field{name: "request_time_ms", kind: kindLong}, nil
+		case "us":
+			return field{name: "request_time_us", kind: kindLong}, nil
+		}
+		return directives["T"], nil
+	case "t":
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "begin:"), "end:")
+		switch arg {
+		case "sec":
+			return field{name: "time", kind: kindEpoch, unit: 1}, nil
+		case "msec":
+			return field{name: "time", kind: kindEpoch, unit: 1e3}, nil
+		case "usec":
+			return field{name: "time", kind: kindEpoch, unit: 1e6}, nil
+		case "msec_frac", "usec_frac":
+			return field{name: "time_" + arg, kind: kindLong}, nil
+		}
+		// strftime 格式的时间尽量按照时间解析，解析失败时保留原始字符串
+		return field{name: "time", kind: kindTime}, nil
+	}
+	return field{}, fmt.Errorf("unsupported format directive %%{%v}%v", arg, letter)
+}
+
+// strftimePattern 根据 strftime 格式生成匹配其输出的正则表达式，每个转换符匹配一段非空白字符
+func strftimePattern(format string) string {
+	format = strings.TrimPrefix(strings.TrimPrefix(format, "begin:"), "end:")
+	var expr strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] == '%' && i+1 < len(format) {
+			i++
+			if format[i] == '%' {
+				expr.WriteString("%")
+			} else {
+				expr.WriteString(`\S+?`)
+			}
+			continue
+		}
+		expr.WriteString(regexp.QuoteMeta(format[i : i+1]))
+	}
+	return expr.String()
+}
+
+// CompileFormat 将 LogFormat 格式串编译为正则表达式，返回的字段与正则表达式的分组一一对应
+func CompileFormat(format string) (*regexp.Regexp, []field, error) {
+	var (
+		expr   strings.Builder
+		fields []field
+		used   = make(map[string]int)
+	)
+	expr.WriteString("^")
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			j := strings.IndexByte(format[i:], '%')
+			if j < 0 {
+				j = len(format) - i
+			}
+			expr.WriteString(regexp.QuoteMeta(format[i : i+j]))
+			i += j
+			continue
+		}
+		m := directiveRe.FindStringSubmatch(format[i:])
+		if m == nil {
+			return nil, nil, fmt.Errorf("invalid format directive at %q", format[i:])
+		}
+		i += len(m[0])
+		if m[5] == "%" {
+			expr.WriteString("%")
+			continue
+		}
+		f, err := resolveDirective(m[3], m[5])
+		if err != nil {
+			return nil, nil, err
+		}
+		// 同一个字段出现多次时依次加上序号
+		used[f.name]++
+		if n := used[f.name]; n > 1 {
+			f.name = fmt.Sprintf("%s_%d", f.name, n)
+		}
+		fields = append(fields, f)
+
+		var next byte
+		if i < len(format) {
+			next = format[i]
+		}
+		switch {
+		case f.kind == kindTime && m[3] == "":
+			// %t 的输出带有中括号
+			expr.WriteString(`\[([^\]]*)\]`)
+		case f.kind == kindTime:
+			expr.WriteString("(" + strftimePattern(m[3]) + ")")
+		case f.kind == kindLong || f.kind == kindEpoch:
+			expr.WriteString(`(-|\d+)`)
+		case next == '"':
+			// 值中的双引号会被转义为 \"
+			expr.WriteString(`((?:[^"\\]|\\.)*)`)
+		case next == 0:
+			expr.WriteString(`(.*)`)
+		case next == '%':
+			expr.WriteString(`(\S*)`)
+		default:
+			expr.WriteString(`([^` + regexp.QuoteMeta(string(next)) + `]*)`)
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("compile log format %q failed: %v", format, err)
+	}
+	return re, fields, nil
+}
+
+// ResolveFormatFromConf 从 httpd 配置文件中读取给定名称的 LogFormat，name 也可以是 CustomLog 指令中的日志文件路径，
+// 配置文件中没有定义时使用内置的同名格式
+func ResolveFormatFromConf(confPath, name string) (string, error) {
+	formats := make(map[string]string, len(builtinFormats))
+	for k, v := range builtinFormats {
+		formats[k] = v
+	}
+	customLogs := make(map[string]string)
+	if confPath != "" {
+		f, err := os.Open(confPath)
+		if err != nil {
+			return "", fmt.Errorf("open: %v", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		var line string
+		for scanner.Scan() {
+			// 以 \ 结尾的行与下一行为同一条指令
+			text := strings.TrimSpace(scanner.Text())
+			if strings.HasSuffix(text, `\`) {
+				line += strings.TrimSuffix(text, `\`)
+				continue
+			}
+			line += text
+			args := splitArgs(line)
+			line = ""
+			if len(args) < 2 {
+				continue
+			}
+			switch strings.ToLower(args[0]) {
+			case "logformat":
+				if len(args) >= 3 {
+					formats[args[2]] = args[1]
+				}
+			case "customlog":
+				if len(args) >= 3 {
+					customLogs[args[1]] = args[2]
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+	}
+	if format, ok := formats[name]; ok {
+		return format, nil
+	}
+	if format, ok := customLogs[name]; ok {
+		if strings.Contains(format, "%") {
+			return format, nil
+		}
+		if named, ok := formats[format]; ok {
+			return named, nil
+		}
+		return "", fmt.Errorf("LogFormat %v used by CustomLog %v not found", format, name)
+	}
+	return "", fmt.Errorf("LogFormat or CustomLog %v not found in %v", name, confPath)
+}
+
+// splitArgs 按照空白分隔配置指令的参数，双引号中的参数可以包含空白以及 \" 转义
+func splitArgs(line string) []string {
+	if strings.HasPrefix(line, "#") {
+		return nil
+	}
+	var args []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			var arg strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg.WriteByte('\n')
+					case 't':
+						arg.WriteByte('\t')
+					default:
+						arg.WriteByte(line[i])
+					}
+					continue
+				}
+				arg.WriteByte(line[i])
+			}
+			args = append(args, arg.String())
+			i++
+		default:
+			j := strings.IndexAny(line[i:], " \t")
+			if j < 0 {
+				j = len(line) - i
+			}
+			args = append(args, line[i:i+j])
+			i += j
+		}
+	}
+	return args
+}
@@ -0,0 +1,486 @@
+package auditd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeAuditd, NewParser)
+}
+
+const (
+	KeyAuditTime   = "audit_time"
+	KeyAuditSerial = "audit_serial"
+	KeyNode        = "node"
+	KeyRecordTypes = "record_types"
+	KeyProctitle   = "proctitle"
+	KeyArgv        = "argv"
+
+	typeEOE       = "EOE"
+	typeProctitle = "PROCTITLE"
+	typeExecve    = "EXECVE"
+
+	// 未设置的 uid/gid，如 auid=4294967295
+	unsetID = "4294967295"
+)
+
+var (
+	recordRegex = regexp.MustCompile(`^(?:node=(\S+)\s+)?type=(\S+)\s+msg=audit\((\d+)\.(\d+):(\d+)\):\s*(.*)$`)
+	argRegex    = regexp.MustCompile(`^a(\d+)(?:\[(\d+)\])?$`)
+
+	// 同一个事件中可能出现多条的记录，总是以数组输出
+	multiRecordTypes = map[string]bool{"PATH": true, "OBJ_PID": true}
+
+	// 用户态程序写入的记录，每个事件只有一条，没有 EOE 结尾
+	singleRecordPrefixes = []string{"USER_", "CRED_", "DAEMON_", "SERVICE_", "SYSTEM_", "ACCT_", "ANOM_"}
+
+	// 内容中有特殊字符时 auditd 会以不带引号的十六进制输出的字段
+	encodedFields = map[string]bool{
+		"name": true, "cwd": true, "comm": true, "exe": true, "proctitle": true, "path": true,
+		"key": true, "data": true, "cmd": true, "acct": true, "old-comm": true, "new-comm": true,
+	}
+
+	uidFields = map[string]bool{
+		"uid": true, "auid": true, "euid": true, "suid": true, "fsuid": true, "ouid": true,
+		"sauid": true, "oauid": true, "obj_uid": true, "inode_uid": true, "new_uid": true,
+	}
+	gidFields = map[string]bool{
+		"gid": true, "egid": true, "sgid": true, "fsgid": true, "ogid": true,
+		"obj_gid": true, "inode_gid": true, "new_gid": true,
+	}
+)
+
+type field struct {
+	key    string
+	value  string
+	quoted bool
+}
+
+type record struct {
+	typ    string
+	fields []field
+}
+
+// event 为 serial 相同的一组记录
+type event struct {
+	node    string
+	time    time.Time
+	serial  int64
+	records []*record
+}
+
+type Parser struct {
+	name                 string
+	labels               []parser.Label
+	users                map[string]string
+	groups               map[string]string
+	maxPending           int
+	disableRecordErrData bool
+
+	pending map[string]*event
+	order   []string
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	passwdFile, _ := c.GetStringOr(parser.KeyAuditdPasswdFile, "")
+	groupFile, _ := c.GetStringOr(parser.KeyAuditdGroupFile, "")
+	maxPending, _ := c.GetIntOr(parser.KeyAuditdMaxPending, parser.DefaultAuditdMaxPending)
+	if maxPending <= 0 {
+		return nil, fmt.Errorf("%v should be greater than 0, got %v", parser.KeyAuditdMaxPending, maxPending)
+	}
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := map[string]struct{}{
+		KeyAuditTime:   {},
+		KeyAuditSerial: {},
+		KeyNode:        {},
+		KeyRecordTypes: {},
+	}
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+
+	p := &Parser{
+		name:                 name,
+		labels:               labels,
+		maxPending:           maxPending,
+		disableRecordErrData: disableRecordErrData,
+		pending:              make(map[string]*event),
+	}
+	var err error
+	if passwdFile != "" {
+		if p.users, err = loadIDNames(passwdFile); err != nil {
+			return nil, fmt.Errorf("load %v failed: %v", parser.KeyAuditdPasswdFile, err)
+		}
+	}
+	if groupFile != "" {
+		if p.groups, err = loadIDNames(groupFile); err != nil {
+			return nil, fmt.Errorf("load %v failed: %v", parser.KeyAuditdGroupFile, err)
+		}
+	}
+	return p, nil
+}
+
+// loadIDNames 读取 /etc/passwd 或 /etc/group 格式的文件，返回 id 到名称的映射
+func loadIDNames(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 3 {
+			continue
+		}
+		if _, ok := names[parts[2]]; !ok {
+			names[parts[2]] = parts[0]
+		}
+	}
+	return names, scanner.Err()
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeAuditd
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	se := &StatsError{}
+	var datas []Data
+	emit := func(ev *event) {
+		data := p.build(ev)
+		for _, l := range p.labels {
+			data[l.Name] = l.Value
+		}
+		datas = append(datas, data)
+		se.AddSuccess()
+	}
+	for idx, line := range lines {
+		if line == parser.PandoraParseFlushSignal {
+			for _, ev := range p.popAll() {
+				emit(ev)
+			}
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		key, ev, rec, err := parseRecord(line)
+		if err != nil {
+			se.AddErrors()
+			se.ErrorDetail = err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			}
+			continue
+		}
+		cur, ok := p.pending[key]
+		if !ok && rec.typ == typeEOE {
+			// 事件已经因为超过缓存数量输出过了
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		if !ok {
+			cur = ev
+			p.pending[key] = cur
+			p.order = append(p.order, key)
+		}
+		if rec.typ != typeEOE {
+			cur.records = append(cur.records, rec)
+		}
+		if rec.typ == typeEOE || (len(cur.records) == 1 && isSingleRecord(rec.typ)) {
+			p.remove(key)
+			emit(cur)
+		}
+		for len(p.order) > p.maxPending {
+			emit(p.pop())
+		}
+	}
+	return datas, se
+}
+
+// Flush 输出最早的一个未结束的事件
+func (p *Parser) Flush() (Data, error) {
+	if len(p.order) == 0 {
+		return nil, nil
+	}
+	data := p.build(p.pop())
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+func (p *Parser) pop() *event {
+	key := p.order[0]
+	ev := p.pending[key]
+	p.remove(key)
+	return ev
+}
+
+func (p *Parser) popAll() []*event {
+	evs := make([]*event, 0, len(p.order))
+	for _, key := range p.order {
+		evs = append(evs, p.pending[key])
+	}
+	p.pending = make(map[string]*event)
+	p.order = nil
+	return evs
+}
+
+func (p *Parser) remove(key string) {
+	delete(p.pending, key)
+	for i, k := range p.order {
+		if k == key {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func isSingleRecord(typ string) bool {
+	for _, prefix := range singleRecordPrefixes {
+		if strings.HasPrefix(typ, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRecord 解析一条记录，返回记录所属事件的标识
+func parseRecord(line string) (string, *event, *record, error) {
+	matches := recordRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return "", nil, nil, fmt.Errorf("%v is not an auditd record", TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	node, typ, sec, frac, serial, body := matches[1], matches[2], matches[3], matches[4], matches[5], matches[6]
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	nsec, err := strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	sn, err := strconv.ParseInt(serial, 10, 64)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ev := &event{
+		node:   node,
+		time:   time.Unix(secs, nsec),
+		serial: sn,
+	}
+	rec := &record{
+		typ:    typ,
+		fields: parseFields(body),
+	}
+	return node + "|" + sec + "." + frac + ":" + serial, ev, rec, nil
+}
+
+// parseFields 解析 key=value 形式的字段，值可以用双引号或单引号括起来，
+// 用户态记录中单引号括起来的 msg 会展开为其中的字段，enriched 格式中的 \x1d 视为空白
+func parseFields(s string) []field {
+	var fields []field
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == 0x1d
+	}
+	i := 0
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) || s[i] != '=' {
+			continue
+		}
+		key := s[start:i]
+		i++
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote := s[i]
+			end := strings.IndexByte(s[i+1:], quote)
+			var value string
+			if end < 0 {
+				value = s[i+1:]
+				i = len(s)
+			} else {
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			}
+			if quote == '\'' && key == "msg" {
+				fields = append(fields, parseFields(value)...)
+				continue
+			}
+			fields = append(fields, field{key: key, value: value, quoted: true})
+			continue
+		}
+		start = i
+		for i < len(s) && !isSpace(s[i]) {
+			i++
+		}
+		fields = append(fields, field{key: key, value: s[start:i]})
+	}
+	return fields
+}
+
+// decodeHex 解码 auditd 以十六进制输出的值，不是合法的十六进制时原样返回
+func decodeHex(value string) string {
+	if len(value) == 0 || len(value)%2 != 0 {
+		return value
+	}
+	for _, c := range value {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return value
+		}
+	}
+	bs, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	return string(bs)
+}
+
+func (p *Parser) fieldValue(f field, encoded bool) string {
+	if !f.quoted && encoded {
+		return decodeHex(f.value)
+	}
+	return f.value
+}
+
+func (p *Parser) fieldsMap(rec *record) map[string]interface{} {
+	m := make(map[string]interface{}, len(rec.fields))
+	for _, f := range rec.fields {
+		value := p.fieldValue(f, encodedFields[f.key])
+		m[f.key] = value
+		if value == unsetID {
+			continue
+		}
+		if uidFields[f.key] && p.users != nil {
+			if name, ok := p.users[value]; ok {
+				m[f.key+"_name"] = name
+			}
+		}
+		if gidFields[f.key] && p.groups != nil {
+			if name, ok := p.groups[value]; ok {
+				m[f.key+"_name"] = name
+			}
+		}
+	}
+	return m
+}
+
+// execveMap 将 EXECVE 记录中的 aN 与分段的 aN[i] 参数拼接为 argv
+func (p *Parser) execveMap(rec *record) map[string]interface{} {
+	m := make(map[string]interface{})
+	type part struct {
+		idx, seg int
+		value    string
+	}
+	var parts []part
+	for _, f := range rec.fields {
+		if sub := argRegex.FindStringSubmatch(f.key); sub != nil {
+			idx, _ := strconv.Atoi(sub[1])
+			seg := -1
+			if sub[2] != "" {
+				seg, _ = strconv.Atoi(sub[2])
+			}
+			parts = append(parts, part{idx: idx, seg: seg, value: p.fieldValue(f, true)})
+			continue
+		}
+		if strings.HasPrefix(f.key, "a") && strings.HasSuffix(f.key, "_len") {
+			continue
+		}
+		m[f.key] = f.value
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		if parts[i].idx != parts[j].idx {
+			return parts[i].idx < parts[j].idx
+		}
+		return parts[i].seg < parts[j].seg
+	})
+	var args []string
+	for i, pt := range parts {
+		if i > 0 && parts[i-1].idx == pt.idx {
+			args[len(args)-1] += pt.value
+			continue
+		}
+		args = append(args, pt.value)
+	}
+	m[KeyArgv] = strings.Join(args, " ")
+	return m
+}
+
+// build 把一个事件中的记录合并为一条数据，每种记录放在以小写类型命名的字段中
+func (p *Parser) build(ev *event) Data {
+	data := Data{
+		KeyAuditTime:   ev.time.UTC().Format(time.RFC3339Nano),
+		KeyAuditSerial: ev.serial,
+	}
+	if ev.node != "" {
+		data[KeyNode] = ev.node
+	}
+	types := make([]string, 0, len(ev.records))
+	for _, rec := range ev.records {
+		types = append(types, rec.typ)
+		key := strings.ToLower(rec.typ)
+		switch rec.typ {
+		case typeProctitle:
+			for _, f := range rec.fields {
+				if f.key == KeyProctitle {
+					data[KeyProctitle] = strings.TrimSpace(strings.Replace(p.fieldValue(f, true), "\x00", " ", -1))
+				}
+			}
+			continue
+		case typeExecve:
+			data[key] = p.execveMap(rec)
+			continue
+		}
+		m := p.fieldsMap(rec)
+		if multiRecordTypes[rec.typ] {
+			list, _ := data[key].([]interface{})
+			data[key] = append(list, m)
+			continue
+		}
+		if old, ok := data[key].(map[string]interface{}); ok {
+			for k, v := range m {
+				old[k] = v
+			}
+			continue
+		}
+		data[key] = m
+	}
+	data[KeyRecordTypes] = strings.Join(types, ",")
+	return data
+}
@@ -0,0 +1,137 @@
+package auditd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	require.NoError(t, ioutil.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(group, []byte("# comment\nroot:x:0:\nalice:x:1000:\n"), 0644))
+
+	p, err := NewParser(conf.MapConf{
+		parser.KeyParserName:       "auditd",
+		parser.KeyAuditdPasswdFile: passwd,
+		parser.KeyAuditdGroupFile:  group,
+		parser.KeyLabels:           "host h1",
+	})
+	require.NoError(t, err)
+	_, ok := p.(parser.Flushable)
+	assert.True(t, ok)
+
+	datas, err := p.Parse([]string{
+		`type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=59 success=yes exit=0 a0=7fffd19c5592 items=2 ppid=2686 pid=3538 auid=4294967295 uid=1000 gid=1000 euid=0 comm="cat" exe="/bin/cat" key=(null)`,
+		`type=EXECVE msg=audit(1364481363.243:24287): argc=3 a0="cat" a1=2F746D702F6120622E747874 a2_len=6 a2[0]="abc" a2[1]="def"`,
+		`type=CWD msg=audit(1364481363.243:24287): cwd=2F686F6D652F6D7920646972`,
+		``,
+		`type=PATH msg=audit(1364481363.243:24287): item=0 name="/bin/cat" inode=409248 mode=0100755 ouid=0 ogid=0`,
+		`type=PATH msg=audit(1364481363.243:24287): item=1 name=(null) inode=409249 ouid=1000 ogid=1000`,
+		`type=PROCTITLE msg=audit(1364481363.243:24287): proctitle=636174002F746D702F6120622E747874`,
+		`type=EOE msg=audit(1364481363.243:24287):`,
+	})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, int64(0), se.Errors)
+	assert.Equal(t, []int{3}, se.DatasourceSkipIndex)
+	require.Len(t, datas, 1)
+
+	exp := Data{
+		KeyAuditTime:   "2013-03-28T14:36:03.243Z",
+		KeyAuditSerial: int64(24287),
+		KeyRecordTypes: "SYSCALL,EXECVE,CWD,PATH,PATH,PROCTITLE",
+		KeyProctitle:   "cat /tmp/a b.txt",
+		"host":         "h1",
+		"syscall": map[string]interface{}{
+			"arch": "c000003e", "syscall": "59", "success": "yes", "exit": "0", "a0": "7fffd19c5592",
+			"items": "2", "ppid": "2686", "pid": "3538", "auid": "4294967295",
+			"uid": "1000", "uid_name": "alice", "gid": "1000", "gid_name": "alice", "euid": "0", "euid_name": "root",
+			"comm": "cat", "exe": "/bin/cat", "key": "(null)",
+		},
+		"execve": map[string]interface{}{
+			"argc": "3",
+			"argv": "cat /tmp/a b.txt abcdef",
+		},
+		"cwd": map[string]interface{}{"cwd": "/home/my dir"},
+		"path": []interface{}{
+			map[string]interface{}{"item": "0", "name": "/bin/cat", "inode": "409248", "mode": "0100755",
+				"ouid": "0", "ouid_name": "root", "ogid": "0", "ogid_name": "root"},
+			map[string]interface{}{"item": "1", "name": "(null)", "inode": "409249",
+				"ouid": "1000", "ouid_name": "alice", "ogid": "1000", "ogid_name": "alice"},
+		},
+	}
+	assert.Equal(t, exp, datas[0])
+}
+
+func TestParseCorrelation(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		parser.KeyAuditdMaxPending:     "2",
+		parser.KeyDisableRecordErrData: "true",
+	})
+	require.NoError(t, err)
+
+	// 交错的事件与用户态的单条记录
+	datas, err := p.Parse([]string{
+		`node=h1 type=SYSCALL msg=audit(1500000000.001:1): syscall=2 comm="a"`,
+		`node=h1 type=SYSCALL msg=audit(1500000000.002:2): syscall=3 comm="b"`,
+		`node=h1 type=USER_LOGIN msg=audit(1500000000.003:3): pid=1 uid=0 msg='op=login acct="root" exe="/usr/sbin/sshd" res=success'`,
+		`node=h1 type=CWD msg=audit(1500000000.001:1): cwd="/"`,
+		`node=h1 type=EOE msg=audit(1500000000.001:1):`,
+		`not an audit line`,
+	})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Success)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []int{5}, se.DatasourceSkipIndex)
+	require.Len(t, datas, 2)
+	assert.Equal(t, "USER_LOGIN", datas[0][KeyRecordTypes])
+	assert.Equal(t, map[string]interface{}{
+		"pid": "1", "uid": "0", "op": "login", "acct": "root", "exe": "/usr/sbin/sshd", "res": "success",
+	}, datas[0]["user_login"])
+	assert.Equal(t, "h1", datas[0][KeyNode])
+	assert.Equal(t, "SYSCALL,CWD", datas[1][KeyRecordTypes])
+	assert.Equal(t, int64(1), datas[1][KeyAuditSerial])
+
+	// 超过缓存数量时输出最早的事件
+	datas, _ = p.Parse([]string{
+		`type=SYSCALL msg=audit(1500000000.004:4): syscall=4`,
+		`type=SYSCALL msg=audit(1500000000.005:5): syscall=5`,
+	})
+	require.Len(t, datas, 1)
+	assert.Equal(t, int64(2), datas[0][KeyAuditSerial])
+
+	data, err := p.(parser.Flushable).Flush()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), data[KeyAuditSerial])
+
+	datas, err = p.Parse([]string{parser.PandoraParseFlushSignal})
+	require.Len(t, datas, 1)
+	assert.Equal(t, int64(5), datas[0][KeyAuditSerial])
+	assert.Equal(t, []int{0}, err.(*StatsError).DatasourceSkipIndex)
+
+	data, err = p.(parser.Flushable).Flush()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestNewParserError(t *testing.T) {
+	_, err := NewParser(conf.MapConf{parser.KeyAuditdPasswdFile: "/not/exist/passwd"})
+	assert.Error(t, err)
+	_, err = NewParser(conf.MapConf{parser.KeyAuditdMaxPending: "0"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package auto
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeAuto, NewParser)
+}
+
+// Parser 根据样本日志检测日志格式，使用检测出的解析器解析日志
+type Parser struct {
+	name                 string
+	conf                 conf.MapConf
+	sampleLines          int
+	redetectInterval     time.Duration
+	disableRecordErrData bool
+
+	mux        sync.Mutex
+	current    parser.Parser
+	decision   *Decision
+	samples    []string
+	sampling   bool
+	nextDetect time.Time
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	sampleLines, _ := c.GetIntOr(parser.KeyAutoSampleLines, DefaultSampleLines)
+	if sampleLines <= 0 {
+		return nil, fmt.Errorf("%v should be greater than 0, got %v", parser.KeyAutoSampleLines, sampleLines)
+	}
+	if _, err := getMinSuccessRate(c); err != nil {
+		return nil, err
+	}
+	intervalStr, _ := c.GetStringOr(parser.KeyAutoRedetectInterval, "0s")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v failed: %v", parser.KeyAutoRedetectInterval, err)
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("%v should not be negative, got %v", parser.KeyAutoRedetectInterval, intervalStr)
+	}
+	types, _ := c.GetStringListOr(parser.KeyAutoCandidates, strings.Split(parser.DefaultAutoCandidates, ","))
+	for _, typ := range types {
+		if typ = strings.TrimSpace(typ); typ == parser.TypeAuto || typ == parser.TypeChain {
+			return nil, fmt.Errorf("%v can not be a candidate of auto parser", typ)
+		}
+	}
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	return &Parser{
+		name:                 name,
+		conf:                 c,
+		sampleLines:          sampleLines,
+		redetectInterval:     interval,
+		disableRecordErrData: disableRecordErrData,
+		sampling:             true,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeAuto
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	cur, pending, err := p.prepare(lines)
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		// 还没有可用的样本，说明都是空行
+		se := &StatsError{}
+		for idx := range lines {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+		}
+		return pending, se
+	}
+	if _, ok := cur.(parser.Flushable); !ok {
+		// 当前解析器不处理 flush 信号，作为空行跳过
+		copied := false
+		for idx, line := range lines {
+			if line != parser.PandoraParseFlushSignal {
+				continue
+			}
+			if !copied {
+				lines = append([]string(nil), lines...)
+				copied = true
+			}
+			lines[idx] = ""
+		}
+	}
+	datas, err := cur.Parse(lines)
+	if len(pending) > 0 {
+		datas = append(pending, datas...)
+	}
+	return datas, err
+}
+
+// prepare 收集样本并在需要时重新检测，返回当前使用的解析器，以及切换解析器时旧解析器中缓存的数据
+func (p *Parser) prepare(lines []string) (parser.Parser, []Data, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := time.Now()
+	if !p.sampling && p.redetectInterval > 0 && !now.Before(p.nextDetect) {
+		p.sampling = true
+		p.samples = nil
+	}
+	if !p.sampling {
+		return p.current, nil, nil
+	}
+	for _, line := range lines {
+		if len(p.samples) >= p.sampleLines {
+			break
+		}
+		if strings.TrimSpace(line) == "" || line == parser.PandoraParseFlushSignal {
+			continue
+		}
+		p.samples = append(p.samples, line)
+	}
+	if len(p.samples) == 0 {
+		return p.current, nil, nil
+	}
+	// 第一批数据先用已有的样本检测，样本数够了以后再检测一次
+	if p.current != nil && len(p.samples) < p.sampleLines {
+		return p.current, nil, nil
+	}
+	pending, err := p.detect(now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(p.samples) >= p.sampleLines {
+		p.sampling = false
+		p.samples = nil
+		p.nextDetect = now.Add(p.redetectInterval)
+	}
+	return p.current, pending, nil
+}
+
+func (p *Parser) detect(now time.Time) ([]Data, error) {
+	decision, err := Detect(p.conf, p.samples)
+	if err != nil {
+		return nil, err
+	}
+	decision.DetectedAt = now
+	var pending []Data
+	if p.current != nil && p.decision != nil && reflect.DeepEqual(p.decision.Config, decision.Config) {
+		p.decision = decision
+		return nil, nil
+	}
+	cur, err := parser.NewRegistry().NewLogParser(decision.Config)
+	if err != nil {
+		return nil, fmt.Errorf("create %v parser detected by auto parser failed: %v", decision.Type, err)
+	}
+	if fp, ok := p.current.(parser.Flushable); ok {
+		if data, err := fp.Flush(); err == nil && data != nil {
+			pending = append(pending, data)
+		}
+	}
+	if p.decision == nil || p.decision.Type != decision.Type {
+		log.Infof("auto parser %v detected log format %v, success rate %.2f, avg fields %.2f",
+			p.name, decision.Type, decision.SuccessRate, decision.AvgFields)
+	}
+	p.current = cur
+	p.decision = decision
+	return pending, nil
+}
+
+// Flush 在检测出的解析器为多行解析器时，输出其缓存的数据
+func (p *Parser) Flush() (Data, error) {
+	p.mux.Lock()
+	cur := p.current
+	p.mux.Unlock()
+	if fp, ok := cur.(parser.Flushable); ok {
+		return fp.Flush()
+	}
+	return nil, nil
+}
+
+// Decision 返回最近一次检测的结论，还没有检测时返回 nil
+func (p *Parser) Decision() *Decision {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.decision
+}
+
+func (p *Parser) ExtraStats() map[string]interface{} {
+	decision := p.Decision()
+	if decision == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"detection": decision,
+	}
+}
@@ -0,0 +1,216 @@
+package auto
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	_ "github.com/longxiucai/logkit/parser/csv"
+	_ "github.com/longxiucai/logkit/parser/json"
+	_ "github.com/longxiucai/logkit/parser/kafkarest"
+	_ "github.com/longxiucai/logkit/parser/logfmt"
+	_ "github.com/longxiucai/logkit/parser/raw"
+	_ "github.com/longxiucai/logkit/parser/syslog"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		typ   string
+		check func(t *testing.T, d *Decision)
+	}{
+		{
+			name: "json",
+			lines: []string{
+				`{"level":"info","msg":"started","port":8080}`,
+				`{"level":"warn","msg":"slow request","cost":1.2}`,
+			},
+			typ: parser.TypeJSON,
+		},
+		{
+			name: "logfmt",
+			lines: []string{
+				`level=info msg=started port=8080`,
+				`level=warn msg="slow request" cost=1.2`,
+			},
+			typ: parser.TypeLogfmt,
+		},
+		{
+			name: "nginx combined",
+			lines: []string{
+				`127.0.0.1 - - [10/Oct/2018:13:55:36 +0800] "GET /index.html HTTP/1.1" 200 612 "-" "curl/7.29.0"`,
+				`10.0.0.2 - bob [10/Oct/2018:13:55:37 +0800] "POST /login HTTP/1.1" 302 0 "http://a.com/" "Mozilla/5.0"`,
+			},
+			typ: parser.TypeNginx,
+			check: func(t *testing.T, d *Decision) {
+				assert.Contains(t, d.Config[parser.NginxFormatRegex], "http_user_agent")
+				assert.NotContains(t, d.Config[parser.NginxFormatRegex], "http_x_forwarded_for")
+			},
+		},
+		{
+			name: "csv",
+			lines: []string{
+				`alice|18|1.5`,
+				`bob|20|2`,
+				`carol|22|3.25`,
+			},
+			typ: parser.TypeCSV,
+			check: func(t *testing.T, d *Decision) {
+				assert.Equal(t, "|", d.Config[parser.KeyCSVSplitter])
+				assert.Equal(t, "field1 string,field2 long,field3 float", d.Config[parser.KeyCSVSchema])
+			},
+		},
+		{
+			name: "raw",
+			lines: []string{
+				`some free text`,
+				`another free text`,
+			},
+			typ: parser.TypeRaw,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Detect(conf.MapConf{
+				parser.KeyAutoCandidates: "json,logfmt,nginx,csv",
+				parser.KeyLabels:         "app web",
+			}, tt.lines)
+			require.NoError(t, err)
+			assert.Equal(t, tt.typ, d.Type)
+			assert.Equal(t, tt.typ, d.Config[parser.KeyParserType])
+			assert.Equal(t, "app web", d.Config[parser.KeyLabels])
+			assert.Equal(t, len(tt.lines), d.SampleLines)
+			_, ok := d.Config[parser.KeyAutoCandidates]
+			assert.False(t, ok)
+			if tt.check != nil {
+				tt.check(t, d)
+			}
+		})
+	}
+
+	_, err := Detect(conf.MapConf{}, []string{"", "  "})
+	assert.Error(t, err)
+	_, err = Detect(conf.MapConf{parser.KeyAutoMinSuccessRate: "2"}, []string{"a"})
+	assert.Error(t, err)
+}
+
+func TestDetectCandidateError(t *testing.T) {
+	d, err := Detect(conf.MapConf{
+		parser.KeyAutoCandidates: "unknown,csv,json",
+	}, []string{`{"a":1}`})
+	require.NoError(t, err)
+	assert.Equal(t, parser.TypeJSON, d.Type)
+	errs := map[string]string{}
+	for _, c := range d.Candidates {
+		errs[c.Type] = c.Error
+	}
+	assert.NotEmpty(t, errs["unknown"])
+	assert.NotEmpty(t, errs["csv"])
+	assert.Empty(t, errs[parser.TypeJSON])
+}
+
+func TestParser(t *testing.T) {
+	c := conf.MapConf{
+		parser.KeyParserType:         parser.TypeAuto,
+		parser.KeyAutoCandidates:     "logfmt,json",
+		parser.KeyAutoSampleLines:    "3",
+		parser.KeyAutoMinSuccessRate: "0.5",
+		parser.KeyLabels:             "app web",
+	}
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	ap := p.(*Parser)
+	assert.Nil(t, ap.ExtraStats())
+
+	// 第一批只有空行，不检测
+	datas, err := p.Parse([]string{"", parser.PandoraParseFlushSignal})
+	assert.Len(t, datas, 0)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, []int{0, 1}, se.DatasourceSkipIndex)
+	assert.Nil(t, ap.Decision())
+
+	datas, err = p.Parse([]string{`{"a":"b"}`, parser.PandoraParseFlushSignal})
+	se, ok = err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, []int{1}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{{"a": "b", "app": "web"}}, datas)
+	assert.Equal(t, parser.TypeJSON, ap.Decision().Type)
+	assert.Equal(t, 1, ap.Decision().SampleLines)
+
+	// 样本数达到后重新检测，样本中 logfmt 占多数
+	datas, err = p.Parse([]string{`a=1 b=2`, `c=3 d=4`, `e=5`})
+	assert.Equal(t, parser.TypeLogfmt, ap.Decision().Type)
+	assert.Equal(t, 3, ap.Decision().SampleLines)
+	se, ok = err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), se.Success)
+	assert.Len(t, datas, 3)
+
+	// 不再检测
+	p.Parse([]string{`{"x":1}`, `{"y":2}`, `{"z":3}`})
+	assert.Equal(t, parser.TypeLogfmt, ap.Decision().Type)
+	extra := ap.ExtraStats()
+	assert.Equal(t, ap.Decision(), extra["detection"])
+}
+
+func TestParserRedetect(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		parser.KeyAutoCandidates:       "json,logfmt",
+		parser.KeyAutoSampleLines:      "1",
+		parser.KeyAutoRedetectInterval: "1h",
+	})
+	require.NoError(t, err)
+	ap := p.(*Parser)
+	p.Parse([]string{`{"a":1}`})
+	assert.Equal(t, parser.TypeJSON, ap.Decision().Type)
+	p.Parse([]string{`a=1`})
+	assert.Equal(t, parser.TypeJSON, ap.Decision().Type)
+
+	ap.mux.Lock()
+	ap.nextDetect = time.Now().Add(-time.Second)
+	ap.mux.Unlock()
+	p.Parse([]string{`a=1`})
+	assert.Equal(t, parser.TypeLogfmt, ap.Decision().Type)
+}
+
+func TestParserSyslog(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		parser.KeyAutoCandidates:  "syslog,json",
+		parser.KeyAutoSampleLines: "2",
+	})
+	require.NoError(t, err)
+	lines := []string{
+		`<38>Feb 05 01:02:03 abc system[253]: Listening at 0.0.0.0:3000`,
+		`<1>Feb 05 01:02:03 abc system[23]: Listening at 0.0.0.0:2000`,
+	}
+	datas, _ := p.Parse(lines)
+	assert.Equal(t, parser.TypeSyslog, p.(*Parser).Decision().Type)
+	more, _ := p.Parse([]string{parser.PandoraParseFlushSignal})
+	datas = append(datas, more...)
+	assert.Len(t, datas, 2)
+	for _, data := range datas {
+		assert.Equal(t, "abc", data["hostname"], fmt.Sprint(data))
+	}
+}
+
+func TestNewParserError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{parser.KeyAutoSampleLines: "0"},
+		{parser.KeyAutoMinSuccessRate: "abc"},
+		{parser.KeyAutoRedetectInterval: "abc"},
+		{parser.KeyAutoRedetectInterval: "-1s"},
+		{parser.KeyAutoCandidates: "json,auto"},
+	} {
+		_, err := NewParser(c)
+		assert.Error(t, err, fmt.Sprint(c))
+	}
+}
@@ -0,0 +1,331 @@
+package auto
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/nginx"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	DefaultSampleLines    = 100
+	DefaultMinSuccessRate = 0.8
+
+	// csv 分隔符检测时，字段数一致的行至少要占的比例
+	csvConsistentRate = 0.9
+)
+
+var csvSplitters = []string{",", "\t", "|", ";"}
+
+// Candidate 为一个候选解析器的检测结果
+type Candidate struct {
+	Type        string       `json:"type"`
+	Config      conf.MapConf `json:"config,omitempty"`
+	Score       float64      `json:"score"`
+	SuccessRate float64      `json:"success_rate"`
+	AvgFields   float64      `json:"avg_fields"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Decision 为一次检测的结论，Config 为最终使用的解析器配置
+type Decision struct {
+	Type        string       `json:"type"`
+	Config      conf.MapConf `json:"config"`
+	Score       float64      `json:"score"`
+	SuccessRate float64      `json:"success_rate"`
+	AvgFields   float64      `json:"avg_fields"`
+	SampleLines int          `json:"sample_lines"`
+	DetectedAt  time.Time    `json:"detected_at"`
+	Candidates  []Candidate  `json:"candidates"`
+}
+
+// Detect 使用样本日志为各个候选解析器打分，返回得分最高的解析器，没有解析器满足最低成功率时使用 raw 解析器
+func Detect(c conf.MapConf, lines []string) (*Decision, error) {
+	types, _ := c.GetStringListOr(parser.KeyAutoCandidates, strings.Split(parser.DefaultAutoCandidates, ","))
+	minRate, err := getMinSuccessRate(c)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" || line == parser.PandoraParseFlushSignal {
+			continue
+		}
+		samples = append(samples, line)
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no sample lines to detect log format")
+	}
+
+	base := baseConf(c)
+	ps := parser.NewRegistry()
+	decision := &Decision{
+		SampleLines: len(samples),
+		DetectedAt:  time.Now(),
+	}
+	best := -1
+	for _, typ := range types {
+		typ = strings.TrimSpace(typ)
+		if typ == "" {
+			continue
+		}
+		confs, err := candidateConfs(typ, base, samples)
+		if err != nil {
+			decision.Candidates = append(decision.Candidates, Candidate{Type: typ, Error: err.Error()})
+			continue
+		}
+		for _, cc := range confs {
+			cand := evaluate(ps, typ, cc, samples)
+			decision.Candidates = append(decision.Candidates, cand)
+			if cand.Error != "" || cand.SuccessRate < minRate {
+				continue
+			}
+			// 得分相同时靠前的候选优先
+			if best < 0 || cand.Score > decision.Candidates[best].Score {
+				best = len(decision.Candidates) - 1
+			}
+		}
+	}
+	if best < 0 {
+		decision.Type = parser.TypeRaw
+		decision.Config = withType(base, parser.TypeRaw)
+		decision.Candidates = sortedCandidates(decision.Candidates)
+		return decision, nil
+	}
+	chosen := decision.Candidates[best]
+	decision.Type = chosen.Type
+	decision.Config = chosen.Config
+	decision.Score = chosen.Score
+	decision.SuccessRate = chosen.SuccessRate
+	decision.AvgFields = chosen.AvgFields
+	decision.Candidates = sortedCandidates(decision.Candidates)
+	return decision, nil
+}
+
+func getMinSuccessRate(c conf.MapConf) (float64, error) {
+	raw, _ := c.GetStringOr(parser.KeyAutoMinSuccessRate, "")
+	if raw == "" {
+		return DefaultMinSuccessRate, nil
+	}
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%v should be a number between 0 and 1, got %q", parser.KeyAutoMinSuccessRate, raw)
+	}
+	return rate, nil
+}
+
+// baseConf 复制用户的配置并去掉 auto 解析器自身的配置项
+func baseConf(c conf.MapConf) conf.MapConf {
+	base := make(conf.MapConf, len(c))
+	for k, v := range c {
+		switch k {
+		case parser.KeyParserType, parser.KeyAutoCandidates, parser.KeyAutoSampleLines,
+			parser.KeyAutoMinSuccessRate, parser.KeyAutoRedetectInterval:
+			continue
+		}
+		base[k] = v
+	}
+	return base
+}
+
+func withType(base conf.MapConf, typ string) conf.MapConf {
+	c := make(conf.MapConf, len(base)+1)
+	for k, v := range base {
+		c[k] = v
+	}
+	c[parser.KeyParserType] = typ
+	return c
+}
+
+// candidateConfs 生成某种解析器的候选配置，nginx 与 csv 在用户没有配置时根据样本推断
+func candidateConfs(typ string, base conf.MapConf, samples []string) ([]conf.MapConf, error) {
+	switch typ {
+	case parser.TypeAuto, parser.TypeChain:
+		return nil, fmt.Errorf("%v can not be a candidate of auto parser", typ)
+	case parser.TypeNginx:
+		if _, err := base.GetString(parser.NginxFormatRegex); err == nil {
+			return []conf.MapConf{withType(base, typ)}, nil
+		}
+		if _, err := base.GetString(parser.NginxConfPath); err == nil {
+			return []conf.MapConf{withType(base, typ)}, nil
+		}
+		confs := make([]conf.MapConf, 0, 2)
+		for _, format := range []string{nginx.MainFormat, nginx.CombinedFormat} {
+			re, err := nginx.FormatRegexp(format)
+			if err != nil {
+				return nil, err
+			}
+			c := withType(base, typ)
+			c[parser.NginxFormatRegex] = re.String()
+			confs = append(confs, c)
+		}
+		return confs, nil
+	case parser.TypeCSV:
+		if _, err := base.GetString(parser.KeyCSVSchema); err == nil {
+			return []conf.MapConf{withType(base, typ)}, nil
+		}
+		splitter, schema, err := inferCSV(samples)
+		if err != nil {
+			return nil, err
+		}
+		c := withType(base, typ)
+		c[parser.KeyCSVSplitter] = splitter
+		c[parser.KeyCSVSchema] = schema
+		return []conf.MapConf{c}, nil
+	}
+	return []conf.MapConf{withType(base, typ)}, nil
+}
+
+// inferCSV 按顺序尝试常见的分隔符，字段数一致的行足够多时按字段值推断每一列的类型
+func inferCSV(samples []string) (splitter, schema string, err error) {
+	for _, sep := range csvSplitters {
+		counts := make(map[int]int)
+		for _, line := range samples {
+			counts[len(strings.Split(line, sep))]++
+		}
+		num, most := 0, 0
+		for n, cnt := range counts {
+			if cnt > most || (cnt == most && n > num) {
+				num, most = n, cnt
+			}
+		}
+		if num < 2 || float64(most) < csvConsistentRate*float64(len(samples)) {
+			continue
+		}
+		types := make([]parser.DataType, num)
+		for i := range types {
+			types[i] = parser.TypeLong
+		}
+		for _, line := range samples {
+			values := strings.Split(line, sep)
+			if len(values) != num {
+				continue
+			}
+			for i, value := range values {
+				types[i] = narrowType(types[i], strings.TrimSpace(value))
+			}
+		}
+		fields := make([]string, num)
+		for i, t := range types {
+			fields[i] = fmt.Sprintf("field%d %v", i+1, t)
+		}
+		return sep, strings.Join(fields, ","), nil
+	}
+	return "", "", errors.New("no consistent csv splitter found in sample lines")
+}
+
+// narrowType 根据一个字段值收窄列的类型，long -> float -> string
+func narrowType(t parser.DataType, value string) parser.DataType {
+	if t == parser.TypeLong {
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return t
+		}
+		t = parser.TypeFloat
+	}
+	if t == parser.TypeFloat {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return t
+		}
+	}
+	return parser.TypeString
+}
+
+// evaluate 计算候选解析器的得分，得分为成功率乘以 1+log10(1+平均字段数)
+func evaluate(ps *parser.Registry, typ string, c conf.MapConf, samples []string) Candidate {
+	cand := Candidate{Type: typ, Config: c}
+	sc := make(conf.MapConf, len(c))
+	for k, v := range c {
+		sc[k] = v
+	}
+	// 打分时不记录错误数据，也不添加标签，避免影响字段数
+	sc[parser.KeyDisableRecordErrData] = "true"
+	delete(sc, parser.KeyLabels)
+	p, err := ps.NewLogParser(sc)
+	if err != nil {
+		cand.Error = err.Error()
+		return cand
+	}
+
+	var success, fields int
+	if fp, ok := p.(parser.Flushable); ok {
+		// 多行解析器需要整批解析，成功数按解析出的记录数计算
+		datas, _ := p.Parse(samples)
+		if data, err := fp.Flush(); err == nil && data != nil {
+			datas = append(datas, data)
+		}
+		for _, data := range datas {
+			if n := countFields(data); n > 0 {
+				success++
+				fields += n
+			}
+		}
+		if success > len(samples) {
+			success = len(samples)
+		}
+	} else {
+		for _, line := range samples {
+			n := parseLine(p, line)
+			if n > 0 {
+				success++
+				fields += n
+			}
+		}
+	}
+	if success == 0 {
+		return cand
+	}
+	cand.SuccessRate = float64(success) / float64(len(samples))
+	cand.AvgFields = float64(fields) / float64(success)
+	cand.Score = cand.SuccessRate * (1 + math.Log10(1+cand.AvgFields))
+	return cand
+}
+
+// parseLine 解析一行日志，返回解析出的有效字段数，解析失败时返回 0
+func parseLine(p parser.Parser, line string) int {
+	datas, err := p.Parse([]string{line})
+	if se, ok := err.(*StatsError); ok {
+		if se.Errors > 0 {
+			return 0
+		}
+	} else if err != nil {
+		return 0
+	}
+	n := 0
+	for _, data := range datas {
+		n += countFields(data)
+	}
+	return n
+}
+
+// countFields 统计有效字段数，空值与错误数据字段不计入
+func countFields(data Data) int {
+	n := 0
+	for k, v := range data {
+		if k == KeyPandoraStash || v == nil {
+			continue
+		}
+		if s, ok := v.(string); ok && strings.TrimSpace(s) == "" {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// sortedCandidates 按得分从高到低排列候选结果，用于展示
+func sortedCandidates(cands []Candidate) []Candidate {
+	ret := make([]Candidate, len(cands))
+	copy(ret, cands)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Score > ret[j].Score
+	})
+	return ret
+}
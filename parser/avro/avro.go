@@ -0,0 +1,156 @@
+package avro
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeAvro, NewParser)
+}
+
+type Parser struct {
+	name string
+	// codec 与 registry 只有一个不为空
+	codec                *schemaCodec
+	registry             *registry
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	schemaFile, _ := c.GetStringOr(parser.KeyAvroSchemaFile, "")
+	registryURL, _ := c.GetStringOr(parser.KeyAvroSchemaRegistry, "")
+	p := &Parser{name: name}
+	switch {
+	case schemaFile != "" && registryURL != "":
+		return nil, fmt.Errorf("only one of %v and %v can be set", parser.KeyAvroSchemaFile, parser.KeyAvroSchemaRegistry)
+	case schemaFile != "":
+		schema, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			return nil, err
+		}
+		if p.codec, err = newSchemaCodec(string(schema)); err != nil {
+			return nil, fmt.Errorf("invalid avro schema %v: %v", schemaFile, err)
+		}
+	case registryURL != "":
+		p.registry = newRegistry(registryURL)
+	default:
+		return nil, errors.New("avro parser needs " + parser.KeyAvroSchemaFile + " or " + parser.KeyAvroSchemaRegistry)
+	}
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	p.labels = parser.GetLabels(labelList, nameMap)
+	p.disableRecordErrData, _ = c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	p.numRoutine = MaxProcs
+	if p.numRoutine == 0 {
+		p.numRoutine = 1
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeAvro
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		// 二进制数据不能去掉首尾的空白字符
+		go parser.ParseLine(sendChan, resultChan, wg, false, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	codec, payload := p.codec, []byte(line)
+	if p.registry != nil {
+		id, body, err := splitHeader(payload)
+		if err != nil {
+			return nil, err
+		}
+		if codec, err = p.registry.codec(id); err != nil {
+			return nil, err
+		}
+		payload = body
+	}
+	record, err := codec.decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode avro message failed: %v", err)
+	}
+	data := Data(record)
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
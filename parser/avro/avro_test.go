@@ -0,0 +1,183 @@
+package avro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const testSchema = `{
+	"type": "record",
+	"name": "Event",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["INFO", "WARN"]}},
+		{"name": "msg", "type": ["null", "string"]},
+		{"name": "ts", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attrs", "type": {"type": "map", "values": ["null", "int"]}},
+		{"name": "host", "type": ["null", {"type": "record", "name": "Host", "fields": [
+			{"name": "name", "type": "string"},
+			{"name": "load", "type": "float"}
+		]}]},
+		{"name": "peer", "type": ["null", "Host"]}
+	]
+}`
+
+func encode(t *testing.T, native map[string]interface{}) []byte {
+	codec, err := goavro.NewCodec(testSchema)
+	require.NoError(t, err)
+	bs, err := codec.BinaryFromNative(nil, native)
+	require.NoError(t, err)
+	return bs
+}
+
+func testEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"id":    int64(1),
+		"level": "WARN",
+		"msg":   goavro.Union("string", "disk full"),
+		"ts":    time.Unix(1500000000, 0),
+		"tags":  []interface{}{"a", "b"},
+		"attrs": map[string]interface{}{"x": goavro.Union("int", int32(3)), "y": nil},
+		"host":  goavro.Union("com.example.Host", map[string]interface{}{"name": "web1", "load": float32(0.5)}),
+		"peer":  nil,
+	}
+}
+
+var expectedEvent = Data{
+	"id":    int64(1),
+	"level": "WARN",
+	"msg":   "disk full",
+	"ts":    time.Unix(1500000000, 0).UTC().Format(time.RFC3339Nano),
+	"tags":  []interface{}{"a", "b"},
+	"attrs": map[string]interface{}{"x": int64(3), "y": nil},
+	"host":  map[string]interface{}{"name": "web1", "load": float64(0.5)},
+	"peer":  nil,
+}
+
+func TestSchemaFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avro")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	schemaFile := filepath.Join(dir, "event.avsc")
+	require.NoError(t, ioutil.WriteFile(schemaFile, []byte(testSchema), 0644))
+
+	p, err := NewParser(conf.MapConf{parser.KeyAvroSchemaFile: schemaFile, parser.KeyLabels: "app web"})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{string(encode(t, testEvent())), "", "\x02"})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []int{1}, se.DatasourceSkipIndex)
+	require.Len(t, datas, 2)
+	expected := Data{"app": "web"}
+	for k, v := range expectedEvent {
+		expected[k] = v
+	}
+	assert.Equal(t, expected, datas[0])
+	assert.Equal(t, Data{KeyPandoraStash: "\x02"}, datas[1])
+
+	_, err = NewParser(conf.MapConf{parser.KeyAvroSchemaFile: schemaFile, parser.KeyAvroSchemaRegistry: "http://127.0.0.1:8081"})
+	assert.Error(t, err)
+	_, err = NewParser(conf.MapConf{})
+	assert.Error(t, err)
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/schemas/ids/7":
+			schema, _ := jsonString(testSchema)
+			fmt.Fprintf(w, `{"schema": %s}`, schema)
+		case "/schemas/ids/8":
+			fmt.Fprint(w, `{"schema": "syntax = \"proto3\";", "schemaType": "PROTOBUF"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	frame := func(id uint32, payload []byte) string {
+		header := make([]byte, headerSize)
+		binary.BigEndian.PutUint32(header[1:], id)
+		return string(append(header, payload...))
+	}
+	p, err := NewParser(conf.MapConf{parser.KeyAvroSchemaRegistry: server.URL + "/", parser.KeyDisableRecordErrData: "true"})
+	require.NoError(t, err)
+	payload := encode(t, testEvent())
+	datas, err := p.Parse([]string{frame(7, payload), frame(7, payload), frame(8, payload), frame(9, payload), "\x01abcdef"})
+	se := err.(*StatsError)
+	assert.Equal(t, int64(2), se.Success)
+	assert.Equal(t, int64(3), se.Errors)
+	assert.Equal(t, []Data{expectedEvent, expectedEvent}, datas)
+	// schema 7 只请求一次
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// 获取失败的 schema 在 failureTTL 内不再请求，过期后重新请求
+	_, err = p.Parse([]string{frame(9, payload)})
+	assert.Equal(t, int64(1), err.(*StatsError).Errors)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	reg := p.(*Parser).registry
+	reg.mu.Lock()
+	reg.failures[9] = fetchFailure{err: reg.failures[9].err, at: time.Now().Add(-failureTTL)}
+	reg.mu.Unlock()
+	_, err = p.Parse([]string{frame(9, payload)})
+	assert.Equal(t, int64(1), err.(*StatsError).Errors)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+}
+
+func TestSchemaRegistryConcurrent(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		schema, _ := jsonString(testSchema)
+		fmt.Fprintf(w, `{"schema": %s}`, schema)
+	}))
+	defer server.Close()
+
+	reg := newRegistry(server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reg.codec(7)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	// 并发获取同一个 schema 只请求一次
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func jsonString(s string) (string, error) {
+	bs, err := goavro.NewCodec(`"string"`)
+	if err != nil {
+		return "", err
+	}
+	textual, err := bs.TextualFromNative(nil, s)
+	return string(textual), err
+}
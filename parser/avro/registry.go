@@ -0,0 +1,136 @@
+package avro
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"golang.org/x/sync/singleflight"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	// Confluent 的消息格式：1 字节的 magic byte，4 字节大端序的 schema id，之后为 avro 二进制编码
+	magicByte  = 0
+	headerSize = 5
+
+	registryTimeout = 10 * time.Second
+	// failureTTL 为获取 schema 失败后的缓存时间，期间同一个 id 的消息直接返回上次的错误，避免每条消息都请求 registry
+	failureTTL = 30 * time.Second
+)
+
+type schemaResponse struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+// fetchFailure 记录获取 schema 失败的错误与时间
+type fetchFailure struct {
+	err error
+	at  time.Time
+}
+
+// registry 从 schema registry 获取 schema，获取过的 schema 按照 id 缓存在内存中
+type registry struct {
+	url    string
+	client *http.Client
+	group  singleflight.Group
+
+	mu       sync.RWMutex
+	codecs   map[uint32]*schemaCodec
+	failures map[uint32]fetchFailure
+}
+
+func newRegistry(url string) *registry {
+	return &registry{
+		url:      strings.TrimSuffix(url, "/"),
+		client:   &http.Client{Timeout: registryTimeout},
+		codecs:   make(map[uint32]*schemaCodec),
+		failures: make(map[uint32]fetchFailure),
+	}
+}
+
+// splitHeader 拆分消息头部，返回 schema id 与 avro 编码的内容
+func splitHeader(msg []byte) (uint32, []byte, error) {
+	if len(msg) < headerSize {
+		return 0, nil, fmt.Errorf("message size %d is too small for schema registry framing", len(msg))
+	}
+	if msg[0] != magicByte {
+		return 0, nil, fmt.Errorf("unknown magic byte %d", msg[0])
+	}
+	return binary.BigEndian.Uint32(msg[1:headerSize]), msg[headerSize:], nil
+}
+
+func (r *registry) codec(id uint32) (*schemaCodec, error) {
+	r.mu.RLock()
+	sc, ok := r.codecs[id]
+	failure, failed := r.failures[id]
+	r.mu.RUnlock()
+	if ok {
+		return sc, nil
+	}
+	if failed && time.Since(failure.at) < failureTTL {
+		return nil, failure.err
+	}
+
+	// 不持有锁请求 registry，并发解析时同一个 id 只请求一次，不影响其他 schema 的解析
+	v, err, _ := r.group.Do(strconv.FormatUint(uint64(id), 10), func() (interface{}, error) {
+		sc, err := r.fetch(id)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err != nil {
+			r.failures[id] = fetchFailure{err: err, at: time.Now()}
+			return nil, err
+		}
+		delete(r.failures, id)
+		r.codecs[id] = sc
+		return sc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*schemaCodec), nil
+}
+
+func (r *registry) fetch(id uint32) (*schemaCodec, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d", r.url, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get schema %d from registry failed, status code %d, body %s", id, resp.StatusCode, TruncateStrSize(string(body), DefaultTruncateMaxSize))
+	}
+	var sr schemaResponse
+	if err = jsoniter.Unmarshal(body, &sr); err != nil {
+		return nil, err
+	}
+	if sr.SchemaType != "" && sr.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema %d is %v, not avro", id, sr.SchemaType)
+	}
+	if sr.Schema == "" {
+		return nil, errors.New("registry returned an empty schema")
+	}
+	sc, err := newSchemaCodec(sr.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %d from registry: %v", id, err)
+	}
+	return sc, nil
+}
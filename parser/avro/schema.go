@@ -0,0 +1,212 @@
+package avro
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/linkedin/goavro/v2"
+)
+
+// schemaCodec 为编译好的 avro schema，goavro 解码出的 union 会包装为 {"类型名": 值}，
+// 需要根据 schema 去掉这一层包装
+type schemaCodec struct {
+	codec  *goavro.Codec
+	schema interface{}
+	// named 为 record、enum 与 fixed 等命名类型，同时以全名与短名索引
+	named map[string]map[string]interface{}
+}
+
+func newSchemaCodec(schema string) (*schemaCodec, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	sc := &schemaCodec{codec: codec, named: make(map[string]map[string]interface{})}
+	if err = jsoniter.Unmarshal([]byte(schema), &sc.schema); err != nil {
+		return nil, err
+	}
+	if m, ok := sc.schema.(map[string]interface{}); !ok || m["type"] != "record" {
+		return nil, errors.New("avro schema should be a record")
+	}
+	sc.collect(sc.schema, "")
+	return sc, nil
+}
+
+// fullName 按照 avro 的规则计算命名类型的全名
+func fullName(m map[string]interface{}, namespace string) (string, string) {
+	name, _ := m["name"].(string)
+	if strings.Contains(name, ".") {
+		return name, name[:strings.LastIndex(name, ".")]
+	}
+	if ns, ok := m["namespace"].(string); ok {
+		namespace = ns
+	}
+	if namespace == "" {
+		return name, namespace
+	}
+	return namespace + "." + name, namespace
+}
+
+func (sc *schemaCodec) collect(schema interface{}, namespace string) {
+	switch s := schema.(type) {
+	case []interface{}:
+		for _, member := range s {
+			sc.collect(member, namespace)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record", "error", "enum", "fixed":
+			full, ns := fullName(s, namespace)
+			sc.named[full] = s
+			sc.named[full[strings.LastIndex(full, ".")+1:]] = s
+			if fields, ok := s["fields"].([]interface{}); ok {
+				for _, f := range fields {
+					if fm, ok := f.(map[string]interface{}); ok {
+						sc.collect(fm["type"], ns)
+					}
+				}
+			}
+		case "array":
+			sc.collect(s["items"], namespace)
+		case "map":
+			sc.collect(s["values"], namespace)
+		default:
+			sc.collect(s["type"], namespace)
+		}
+	}
+}
+
+func (sc *schemaCodec) decode(payload []byte) (map[string]interface{}, error) {
+	native, rest, err := sc.codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes left after decoding avro record", len(rest))
+	}
+	data, _ := sc.convert(sc.schema, native).(map[string]interface{})
+	return data, nil
+}
+
+// typeName 返回 union 中成员类型的名称，与 goavro 包装 union 时使用的名称一致
+func (sc *schemaCodec) typeName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		if m, ok := sc.named[s]; ok {
+			name, _ := m["name"].(string)
+			return name
+		}
+		return s
+	case map[string]interface{}:
+		switch t := s["type"].(type) {
+		case string:
+			if t == "record" || t == "error" || t == "enum" || t == "fixed" {
+				name, _ := s["name"].(string)
+				return name
+			}
+			if lt, ok := s["logicalType"].(string); ok && t != "array" && t != "map" {
+				return t + "." + lt
+			}
+			return t
+		default:
+			return sc.typeName(t)
+		}
+	}
+	return ""
+}
+
+func (sc *schemaCodec) unionMember(members []interface{}, name string) interface{} {
+	short := name[strings.LastIndex(name, ".")+1:]
+	for _, member := range members {
+		typ := sc.typeName(member)
+		if typ == name || typ == short || strings.HasSuffix(typ, "."+short) {
+			return member
+		}
+	}
+	return nil
+}
+
+// convert 根据 schema 将 goavro 解码出的值转换为 logkit 的字段值
+func (sc *schemaCodec) convert(schema interface{}, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch s := schema.(type) {
+	case string:
+		if named, ok := sc.named[s]; ok {
+			return sc.convert(named, value)
+		}
+	case []interface{}:
+		wrapped, ok := value.(map[string]interface{})
+		if !ok || len(wrapped) != 1 {
+			return convertScalar(value)
+		}
+		for name, v := range wrapped {
+			return sc.convert(sc.unionMember(s, name), v)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record", "error":
+			record, ok := value.(map[string]interface{})
+			if !ok {
+				break
+			}
+			fields, _ := s["fields"].([]interface{})
+			for _, f := range fields {
+				fm, _ := f.(map[string]interface{})
+				name, _ := fm["name"].(string)
+				if v, ok := record[name]; ok {
+					record[name] = sc.convert(fm["type"], v)
+				}
+			}
+			return record
+		case "array":
+			items, ok := value.([]interface{})
+			if !ok {
+				break
+			}
+			for i, v := range items {
+				items[i] = sc.convert(s["items"], v)
+			}
+			return items
+		case "map":
+			values, ok := value.(map[string]interface{})
+			if !ok {
+				break
+			}
+			for k, v := range values {
+				values[k] = sc.convert(s["values"], v)
+			}
+			return values
+		case "enum", "fixed":
+		default:
+			if _, ok := s["type"].(string); !ok {
+				return sc.convert(s["type"], value)
+			}
+		}
+	}
+	return convertScalar(value)
+}
+
+func convertScalar(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
@@ -2,15 +2,26 @@
 package builtin
 
 import (
+	_ "github.com/longxiucai/logkit/parser/apache"
+	_ "github.com/longxiucai/logkit/parser/auditd"
+	_ "github.com/longxiucai/logkit/parser/auto"
+	_ "github.com/longxiucai/logkit/parser/avro"
+	_ "github.com/longxiucai/logkit/parser/cef"
+	_ "github.com/longxiucai/logkit/parser/chain"
 	_ "github.com/longxiucai/logkit/parser/csv"
+	_ "github.com/longxiucai/logkit/parser/dissect"
 	_ "github.com/longxiucai/logkit/parser/empty"
+	_ "github.com/longxiucai/logkit/parser/gelf"
 	_ "github.com/longxiucai/logkit/parser/grok"
 	_ "github.com/longxiucai/logkit/parser/json"
 	_ "github.com/longxiucai/logkit/parser/kafkarest"
+	_ "github.com/longxiucai/logkit/parser/leef"
 	_ "github.com/longxiucai/logkit/parser/logfmt"
 	_ "github.com/longxiucai/logkit/parser/mysql"
 	_ "github.com/longxiucai/logkit/parser/nginx"
+	_ "github.com/longxiucai/logkit/parser/protobuf"
 	_ "github.com/longxiucai/logkit/parser/qiniu"
 	_ "github.com/longxiucai/logkit/parser/raw"
 	_ "github.com/longxiucai/logkit/parser/syslog"
+	_ "github.com/longxiucai/logkit/parser/w3c"
 )
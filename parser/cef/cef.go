@@ -0,0 +1,286 @@
+package cef
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/syslog"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	prefix = "CEF:"
+	// headerCount 为 CEF 头部的字段数，之后为扩展部分
+	headerCount = 7
+	labelSuffix = "Label"
+	// extensionPrefix 为与头部字段重名的扩展字段加上的前缀
+	extensionPrefix = "ext_"
+)
+
+// headerFields 为 CEF 头部中 Version 之后各个字段的名称
+var headerFields = []string{"device_vendor", "device_product", "device_version", "device_event_class_id", "name", "severity"}
+
+func init() {
+	parser.RegisterConstructor(parser.TypeCEF, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	stripSyslog          bool
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	stripSyslog, _ := c.GetBoolOr(parser.KeyStripSyslogHeader, false)
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	return &Parser{
+		name:                 name,
+		stripSyslog:          stripSyslog,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeCEF
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, true, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	line = strings.TrimRight(line, "\r\n")
+	data := Data{}
+	idx := strings.Index(line, prefix)
+	switch {
+	case idx < 0:
+		return nil, fmt.Errorf("CEFParser fail to parse log line [%v], %v not found", TruncateStrSize(line, DefaultTruncateMaxSize), prefix)
+	case idx > 0 && !p.stripSyslog:
+		return nil, fmt.Errorf("CEFParser fail to parse log line [%v], it should start with %v", TruncateStrSize(line, DefaultTruncateMaxSize), prefix)
+	case idx > 0:
+		header, err := syslog.ParseHeader(line, idx)
+		if err != nil {
+			return nil, fmt.Errorf("CEFParser fail to parse syslog header of log line [%v]: %v", TruncateStrSize(line, DefaultTruncateMaxSize), err)
+		}
+		for k, v := range header {
+			data["syslog_"+k] = v
+		}
+	}
+
+	parts := SplitHeader(line[idx+len(prefix):], headerCount)
+	if len(parts) < headerCount {
+		return nil, fmt.Errorf("CEFParser fail to parse log line [%v], header should have %d fields", TruncateStrSize(line, DefaultTruncateMaxSize), headerCount)
+	}
+	version, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("CEFParser fail to parse version %q of log line [%v]", parts[0], TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	data["version"] = version
+	for i, name := range headerFields {
+		data[name] = parts[i+1]
+	}
+	if len(parts) > headerCount {
+		for k, v := range pairLabels(parseExtension(parts[headerCount])) {
+			if _, ok := data[k]; ok {
+				// 不覆盖头部字段与 syslog 字段
+				k = extensionPrefix + k
+			}
+			data[k] = v
+		}
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// SplitHeader 按照未转义的 | 切分 CEF 或 LEEF 的头部，最多切分出 n 个头部字段，剩余部分作为最后一个元素，头部字段中的 \| 与 \\ 会被还原
+func SplitHeader(s string, n int) []string {
+	var (
+		parts []string
+		field strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\'):
+			i++
+			field.WriteByte(s[i])
+		case c == '|':
+			parts = append(parts, field.String())
+			field.Reset()
+			if len(parts) == n {
+				return append(parts, s[i+1:])
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(parts, field.String())
+}
+
+func isKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' || c == '[' || c == ']'
+}
+
+// parseExtension 解析扩展部分的 key=value，值中可以有空格，未转义的 = 之前以空格分隔的单词为下一个 key
+func parseExtension(ext string) map[string]string {
+	fields := make(map[string]string)
+	key, valueStart := "", -1
+	for i := 0; i < len(ext); i++ {
+		if ext[i] == '\\' {
+			i++
+			continue
+		}
+		if ext[i] != '=' {
+			continue
+		}
+		keyStart := i
+		for keyStart > 0 && isKeyChar(ext[keyStart-1]) {
+			keyStart--
+		}
+		if keyStart == i || (keyStart > 0 && ext[keyStart-1] != ' ') {
+			// = 之前不是一个单独的 key，作为值的一部分
+			continue
+		}
+		if valueStart >= 0 {
+			fields[key] = unescapeValue(strings.TrimSpace(ext[valueStart:keyStart]))
+		}
+		key, valueStart = ext[keyStart:i], i+1
+	}
+	if valueStart >= 0 {
+		fields[key] = unescapeValue(strings.TrimSpace(ext[valueStart:]))
+	}
+	return fields
+}
+
+func unescapeValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// pairLabels 将 cs1 与 cs1Label 等自定义字段合并为以 Label 命名的字段，Label 中的空白替换为 _，
+// Label 为空或者与其他字段重名时保留原字段
+func pairLabels(fields map[string]string) map[string]string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if strings.HasSuffix(key, labelSuffix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		label := strings.Join(strings.Fields(fields[key]), "_")
+		field := strings.TrimSuffix(key, labelSuffix)
+		value, ok := fields[field]
+		if label == "" || !ok {
+			continue
+		}
+		if _, exist := fields[label]; exist {
+			continue
+		}
+		fields[label] = value
+		delete(fields, field)
+		delete(fields, key)
+	}
+	return fields
+}
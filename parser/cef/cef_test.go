@@ -0,0 +1,84 @@
+package cef
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestCEFParser(t *testing.T) {
+	p, err := NewParser(conf.MapConf{parser.KeyParserName: "cef", parser.KeyLabels: "team sec", parser.KeyStripSyslogHeader: "true"})
+	require.NoError(t, err)
+	assert.Equal(t, "cef", p.Name())
+
+	datas, err := p.Parse([]string{
+		`CEF:0|Security|threat\|manager|1.0|100|detected a \\ in packet|10|src=10.0.0.1 dst=2.1.2.2 spt=1232 cs1Label=Rule Name cs1=Block all cs2=orphan msg=Detected a threat. No action\=needed.\nDone request=http://a.com/?a=b&c=d`,
+		`<134>Feb 10 10:00:00 fw01 CEF:0|Vendor|Product|2.0|200|Login|Low|name=alice severity=1 syslog_hostname=x`,
+		``,
+	})
+	if se, ok := err.(*StatsError); ok {
+		require.NoError(t, se.ErrorDetail)
+		assert.Equal(t, int64(2), se.Success)
+	}
+	require.Len(t, datas, 2)
+	assert.Equal(t, Data{
+		"version":               int64(0),
+		"device_vendor":         "Security",
+		"device_product":        "threat|manager",
+		"device_version":        "1.0",
+		"device_event_class_id": "100",
+		"name":                  `detected a \ in packet`,
+		"severity":              "10",
+		"src":                   "10.0.0.1",
+		"dst":                   "2.1.2.2",
+		"spt":                   "1232",
+		"Rule_Name":             "Block all",
+		"cs2":                   "orphan",
+		"msg":                   "Detected a threat. No action=needed.\nDone",
+		"request":               "http://a.com/?a=b&c=d",
+		"team":                  "sec",
+	}, datas[0])
+	assert.Equal(t, "fw01", datas[1]["syslog_hostname"])
+	assert.Equal(t, 134, datas[1]["syslog_priority"])
+	assert.Equal(t, "Login", datas[1]["name"])
+	assert.Equal(t, "Low", datas[1]["severity"])
+	// 与头部字段重名的扩展字段加上前缀
+	assert.Equal(t, "alice", datas[1]["ext_name"])
+	assert.Equal(t, "1", datas[1]["ext_severity"])
+	assert.Equal(t, "x", datas[1]["ext_syslog_hostname"])
+	assert.NotContains(t, datas[1], "syslog_tag")
+
+	// 默认不去掉 syslog 头
+	p, err = NewParser(conf.MapConf{parser.KeyDisableRecordErrData: "true"})
+	require.NoError(t, err)
+	datas, err = p.Parse([]string{
+		`<134>Feb 10 10:00:00 fw01 CEF:0|Vendor|Product|2.0|200|Login|Low|`,
+		`CEF:0|Vendor|Product`,
+		`CEF:x|Vendor|Product|2.0|200|Login|Low|`,
+	})
+	assert.Empty(t, datas)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), se.Errors)
+}
+
+func TestParseExtension(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"a":     "1 2",
+		"b":     "",
+		"c":     "x=y",
+		"ad.id": `\path`,
+	}, parseExtension(`a=1 2  b= c=x\=y ad.id=\\path`))
+
+	// 重名的 Label 保留原字段
+	assert.Equal(t, map[string]string{
+		"src":      "1",
+		"cs1":      "2",
+		"cs1Label": "src",
+	}, pairLabels(map[string]string{"src": "1", "cs1": "2", "cs1Label": "src"}))
+}
@@ -0,0 +1,294 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeChain, NewParser)
+}
+
+// subParser 为链中的一个解析器，prefix 与 regex 为前置条件，都满足时才会尝试该解析器
+type subParser struct {
+	name   string
+	prefix string
+	regex  *regexp.Regexp
+	parser parser.Parser
+}
+
+func (s *subParser) accept(line string) bool {
+	if s.prefix != "" && !strings.HasPrefix(line, s.prefix) {
+		return false
+	}
+	if s.regex != nil && !s.regex.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// Parser 按顺序尝试子解析器，使用第一个解析成功的结果
+type Parser struct {
+	name                 string
+	parsers              []*subParser
+	matchedField         string
+	labels               []parser.Label
+	disableRecordErrData bool
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	raw, err := c.GetString(parser.KeyChainParsers)
+	if err != nil {
+		return nil, err
+	}
+	confs, err := parseSubConfs(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(confs) == 0 {
+		return nil, errors.New("chain parser needs at least one sub parser in " + parser.KeyChainParsers)
+	}
+	ps := parser.NewRegistry()
+	parsers := make([]*subParser, 0, len(confs))
+	for i, sc := range confs {
+		sp, err := newSubParser(ps, i, sc)
+		if err != nil {
+			return nil, err
+		}
+		parsers = append(parsers, sp)
+	}
+	matchedField, _ := c.GetStringOr(parser.KeyChainMatchedField, parser.DefaultChainMatchedField)
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := map[string]struct{}{matchedField: {}}
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	return &Parser{
+		name:                 name,
+		parsers:              parsers,
+		matchedField:         matchedField,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+	}, nil
+}
+
+// parseSubConfs 解析子解析器配置，json 中的数字、布尔值等转换为字符串
+func parseSubConfs(raw string) ([]conf.MapConf, error) {
+	var items []map[string]interface{}
+	if err := jsoniter.Unmarshal([]byte(raw), &items); err != nil {
+		return nil, fmt.Errorf("%v should be a json array of parser configs: %v", parser.KeyChainParsers, err)
+	}
+	confs := make([]conf.MapConf, 0, len(items))
+	for _, item := range items {
+		mc := make(conf.MapConf, len(item))
+		for k, v := range item {
+			switch vv := v.(type) {
+			case string:
+				mc[k] = vv
+			case []interface{}, map[string]interface{}:
+				bs, err := jsoniter.Marshal(vv)
+				if err != nil {
+					return nil, err
+				}
+				mc[k] = string(bs)
+			case nil:
+			default:
+				mc[k] = fmt.Sprint(vv)
+			}
+		}
+		confs = append(confs, mc)
+	}
+	return confs, nil
+}
+
+func newSubParser(ps *parser.Registry, idx int, c conf.MapConf) (*subParser, error) {
+	typ, err := c.GetString(parser.KeyParserType)
+	if err != nil {
+		return nil, fmt.Errorf("sub parser %d of chain: %v", idx, err)
+	}
+	if typ == parser.TypeChain {
+		return nil, fmt.Errorf("sub parser %d of chain can not be %v", idx, parser.TypeChain)
+	}
+	sp := &subParser{}
+	sp.name, _ = c.GetStringOr(parser.KeyParserName, fmt.Sprintf("%v_%d", typ, idx))
+	sp.prefix, _ = c.GetStringOr(parser.KeyChainPrefix, "")
+	if expr, _ := c.GetStringOr(parser.KeyChainRegex, ""); expr != "" {
+		if sp.regex, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("sub parser %v of chain has invalid %v: %v", sp.name, parser.KeyChainRegex, err)
+		}
+	}
+	delete(c, parser.KeyChainPrefix)
+	delete(c, parser.KeyChainRegex)
+	// 子解析器解析失败时不记录错误数据，交给下一个解析器
+	c[parser.KeyDisableRecordErrData] = "true"
+	if sp.parser, err = ps.NewLogParser(c); err != nil {
+		return nil, fmt.Errorf("create sub parser %v of chain failed: %v", sp.name, err)
+	}
+	// 链中的各个解析器只解析前一个解析器失败的日志，无法处理跨行的日志
+	if _, ok := sp.parser.(parser.Flushable); ok {
+		return nil, fmt.Errorf("sub parser %v of chain parses multi-line logs, which is not supported", sp.name)
+	}
+	return sp, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeChain
+}
+
+// Parse 将整批日志交给第一个子解析器，解析失败的日志再交给下一个子解析器，最后按原来的顺序输出
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	se := &StatsError{}
+	results := make([][]Data, len(lines))
+	errs := make([]error, len(lines))
+	pending := make([]int, 0, len(lines))
+	for idx, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			pending = append(pending, idx)
+		}
+	}
+
+	for _, sp := range p.parsers {
+		if len(pending) == 0 {
+			break
+		}
+		var (
+			batch    []string
+			batchIdx []int
+			rest     []int
+		)
+		for _, idx := range pending {
+			if sp.accept(lines[idx]) {
+				batch = append(batch, lines[idx])
+				batchIdx = append(batchIdx, idx)
+			} else {
+				rest = append(rest, idx)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		parsed, failed := parseBatch(sp.parser, batch)
+		for i, idx := range batchIdx {
+			if failed[i] != nil {
+				errs[idx] = fmt.Errorf("%v: %v", sp.name, failed[i])
+				rest = append(rest, idx)
+				continue
+			}
+			for _, data := range parsed[i] {
+				if p.matchedField != "" {
+					data[p.matchedField] = sp.name
+				}
+				for _, l := range p.labels {
+					data[l.Name] = l.Value
+				}
+			}
+			results[idx] = parsed[i]
+		}
+		sort.Ints(rest)
+		pending = rest
+	}
+
+	datas := make([]Data, 0, len(lines))
+	for idx, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		if results[idx] != nil {
+			se.AddSuccess()
+			datas = append(datas, results[idx]...)
+			continue
+		}
+		se.AddErrors()
+		if errs[idx] == nil {
+			se.ErrorDetail = fmt.Errorf("%v does not satisfy the precondition of any parser in chain", TruncateStrSize(line, DefaultTruncateMaxSize))
+		} else {
+			se.ErrorDetail = fmt.Errorf("%v can not be parsed by any parser in chain, last error: %v", TruncateStrSize(line, DefaultTruncateMaxSize), errs[idx])
+		}
+		if !p.disableRecordErrData {
+			datas = append(datas, Data{
+				KeyPandoraStash: line,
+			})
+		} else {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+		}
+	}
+
+	return datas, se
+}
+
+// parseBatch 使用子解析器解析一批日志，返回每行的解析结果与失败的原因。
+// 子解析器不记录错误数据，失败的行在 DatasourceSkipIndex 中，其余行与返回的数据一一对应；
+// 无法对应时(如一行解析出多条数据)逐行解析
+func parseBatch(p parser.Parser, lines []string) ([][]Data, []error) {
+	parsed := make([][]Data, len(lines))
+	failed := make([]error, len(lines))
+	datas, err := p.Parse(lines)
+	se, ok := err.(*StatsError)
+	if err == nil || ok {
+		skipped := make(map[int]bool)
+		var detail error
+		if ok {
+			for _, idx := range se.DatasourceSkipIndex {
+				if idx >= 0 && idx < len(lines) {
+					skipped[idx] = true
+				}
+			}
+			detail = se.ErrorDetail
+			if se.Errors != int64(len(skipped)) {
+				skipped = nil
+			}
+		}
+		if skipped != nil && len(datas) == len(lines)-len(skipped) {
+			if detail == nil {
+				detail = errors.New("parse failed")
+			}
+			for idx := range lines {
+				if skipped[idx] {
+					failed[idx] = detail
+					continue
+				}
+				parsed[idx] = []Data{datas[0]}
+				datas = datas[1:]
+			}
+			return parsed, failed
+		}
+	}
+
+	for idx, line := range lines {
+		parsed[idx], failed[idx] = tryParse(p, line)
+	}
+	return parsed, failed
+}
+
+// tryParse 使用子解析器解析一行日志，有解析错误或者没有解析出数据都视为失败
+func tryParse(p parser.Parser, line string) ([]Data, error) {
+	datas, err := p.Parse([]string{line})
+	if se, ok := err.(*StatsError); ok {
+		if se.ErrorDetail != nil {
+			return nil, se.ErrorDetail
+		}
+		if se.Errors > 0 {
+			return nil, errors.New("parse failed")
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(datas) == 0 {
+		return nil, errors.New("no data was parsed")
+	}
+	return datas, nil
+}
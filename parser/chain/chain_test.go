@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	_ "github.com/longxiucai/logkit/parser/dissect"
+	_ "github.com/longxiucai/logkit/parser/json"
+	_ "github.com/longxiucai/logkit/parser/logfmt"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestChain(t *testing.T) {
+	c := conf.MapConf{
+		parser.KeyChainParsers: `[
+			{"type": "json", "chain_prefix": "{"},
+			{"type": "dissect", "name": "access", "dissect_pattern": "%{ip} %{status}", "dissect_schema": "status long", "chain_regex": "^\\d+\\.\\d+\\.\\d+\\.\\d+ "},
+			{"type": "logfmt"}
+		]`,
+		parser.KeyLabels: "app web",
+	}
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{
+		`{"level":"info","n":1}`,
+		`10.0.0.1 200`,
+		``,
+		`level=warn msg=started`,
+		`{broken json`,
+	})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), se.Success)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []int{2}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{
+		{"level": "info", "n": json.Number("1"), "matched_parser": "json_0", "app": "web"},
+		{"ip": "10.0.0.1", "status": int64(200), "matched_parser": "access", "app": "web"},
+		{"level": "warn", "msg": "started", "matched_parser": "logfmt_2", "app": "web"},
+		{KeyPandoraStash: `{broken json`},
+	}, datas)
+}
+
+func TestChainError(t *testing.T) {
+	c := conf.MapConf{
+		parser.KeyChainParsers:      `[{"type": "json"}, {"type": "dissect", "dissect_pattern": "[%{level}] %{msg}", "chain_prefix": "["}]`,
+		parser.KeyChainMatchedField: "by",
+	}
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{"[INFO] ok", "plain text", "[broken"})
+	se := err.(*StatsError)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, []Data{
+		{"level": "INFO", "msg": "ok", "by": "dissect_1"},
+		{KeyPandoraStash: "plain text"},
+		{KeyPandoraStash: "[broken"},
+	}, datas)
+	assert.Contains(t, se.ErrorDetail.Error(), "dissect_1")
+
+	for _, raw := range []string{
+		`[]`,
+		`{"type": "json"}`,
+		`[{"name": "no type"}]`,
+		`[{"type": "chain"}]`,
+		`[{"type": "json", "chain_regex": "("}]`,
+		`[{"type": "notexist"}]`,
+	} {
+		_, err = NewParser(conf.MapConf{parser.KeyChainParsers: raw})
+		assert.Error(t, err, raw)
+	}
+}
+
+// countingParser 记录子解析器每次收到的日志
+type countingParser struct {
+	parser.Parser
+	calls [][]string
+}
+
+func (c *countingParser) Parse(lines []string) ([]Data, error) {
+	c.calls = append(c.calls, lines)
+	return c.Parser.Parse(lines)
+}
+
+func TestChainBatch(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		parser.KeyChainParsers: `[{"type": "json"}, {"type": "logfmt"}]`,
+	})
+	require.NoError(t, err)
+	chain := p.(*Parser)
+	first := &countingParser{Parser: chain.parsers[0].parser}
+	second := &countingParser{Parser: chain.parsers[1].parser}
+	chain.parsers[0].parser = first
+	chain.parsers[1].parser = second
+
+	datas, err := p.Parse([]string{`{"a":1}`, `b=2`, `{"c":3}`, `d=4`})
+	se := err.(*StatsError)
+	assert.Equal(t, int64(4), se.Success)
+	assert.Equal(t, []Data{
+		{"a": json.Number("1"), "matched_parser": "json_0"},
+		{"b": float64(2), "matched_parser": "logfmt_1"},
+		{"c": json.Number("3"), "matched_parser": "json_0"},
+		{"d": float64(4), "matched_parser": "logfmt_1"},
+	}, datas)
+	// 每个子解析器只调用一次，第二个解析器只收到第一个解析器失败的日志
+	assert.Equal(t, [][]string{{`{"a":1}`, `b=2`, `{"c":3}`, `d=4`}}, first.calls)
+	assert.Equal(t, [][]string{{`b=2`, `d=4`}}, second.calls)
+}
@@ -0,0 +1,209 @@
+package dissect
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/times"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeDissect, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	dissector            *dissector
+	appendSeparator      string
+	schema               map[string]parser.DataType
+	timeZoneOffset       int
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	pattern, err := c.GetString(parser.KeyDissectPattern)
+	if err != nil {
+		return nil, err
+	}
+	d, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	appendSeparator, _ := c.GetStringOr(parser.KeyDissectAppendSeparator, " ")
+	schemaRaw, _ := c.GetStringOr(parser.KeyDissectSchema, "")
+	schema, err := parseSchema(schemaRaw)
+	if err != nil {
+		return nil, err
+	}
+	timeZoneOffsetRaw, _ := c.GetStringOr(parser.KeyTimeZoneOffset, "")
+	timeZoneOffset := parser.ParseTimeZoneOffset(timeZoneOffsetRaw)
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	return &Parser{
+		name:                 name,
+		dissector:            d,
+		appendSeparator:      appendSeparator,
+		schema:               schema,
+		timeZoneOffset:       timeZoneOffset,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+// parseSchema 解析 "name type, name type" 格式的字段类型
+func parseSchema(raw string) (map[string]parser.DataType, error) {
+	schema := make(map[string]parser.DataType)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Fields(item)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid dissect schema %q, should be like \"name type\"", item)
+		}
+		switch t := parser.DataType(strings.ToLower(parts[1])); t {
+		case parser.TypeLong, parser.TypeFloat, parser.TypeDate, parser.TypeString:
+			schema[parts[0]] = t
+		default:
+			return nil, fmt.Errorf("dissect schema type %q of field %q is not supported", parts[1], parts[0])
+		}
+	}
+	return schema, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeDissect
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, false, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(strings.TrimSpace(parseResult.Line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	// 分隔符可能包含空白字符，只去掉换行符
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	fields, ok := p.dissector.dissect(line, p.appendSeparator)
+	if !ok {
+		return nil, fmt.Errorf("%v no value was parsed after dissect pattern %v", TruncateStrSize(line, DefaultTruncateMaxSize), p.dissector.pattern)
+	}
+	data := make(Data, len(fields)+len(p.labels))
+	for k, v := range fields {
+		switch p.schema[k] {
+		case parser.TypeLong:
+			iv, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				log.Warningf("E! Error parsing %s to long: %s, ignore this field...", v, err)
+			} else {
+				data[k] = iv
+			}
+		case parser.TypeFloat:
+			fv, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				log.Warningf("E! Error parsing %s to float: %s, ignore this field...", v, err)
+			} else {
+				data[k] = fv
+			}
+		case parser.TypeDate:
+			ts, err := times.StrToTime(v)
+			if err != nil {
+				log.Warningf("E! Error parsing %s to time: %s, ignore this field...", v, err)
+			} else {
+				data[k] = ts.Add(time.Duration(p.timeZoneOffset) * time.Hour).Format(time.RFC3339Nano)
+			}
+		default:
+			data[k] = v
+		}
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
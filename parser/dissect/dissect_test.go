@@ -0,0 +1,104 @@
+package dissect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func parseOne(t *testing.T, c conf.MapConf, line string) Data {
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{line})
+	if se, ok := err.(*StatsError); ok {
+		require.NoError(t, se.ErrorDetail)
+	}
+	require.Len(t, datas, 1)
+	return datas[0]
+}
+
+func TestDissect(t *testing.T) {
+	c := conf.MapConf{
+		parser.KeyDissectPattern: `%{clientip} %{?ident} %{user} [%{time}] "%{method} %{path} %{protocol}" %{status} %{bytes}`,
+		parser.KeyDissectSchema:  "status long, bytes long, time date",
+		parser.KeyLabels:         "app web",
+	}
+	data := parseOne(t, c, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`)
+	assert.Equal(t, Data{
+		"clientip": "127.0.0.1",
+		"user":     "frank",
+		"time":     "2000-10-10T13:55:36-07:00",
+		"method":   "GET",
+		"path":     "/apache_pb.gif",
+		"protocol": "HTTP/1.0",
+		"status":   int64(200),
+		"bytes":    int64(2326),
+		"app":      "web",
+	}, data)
+
+	// 类型转换失败的字段被忽略
+	data = parseOne(t, c, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 -`)
+	assert.NotContains(t, data, "bytes")
+}
+
+func TestModifiers(t *testing.T) {
+	c := conf.MapConf{
+		parser.KeyDissectPattern:         `%{+ts/2} %{+ts/1} %{level->} %{*k1}=%{&k1} %{} %{msg}`,
+		parser.KeyDissectAppendSeparator: "T",
+	}
+	data := parseOne(t, c, `10:00:00 2018-01-01 INFO     user=alice xx hello world`)
+	assert.Equal(t, Data{
+		"ts":    "2018-01-01T10:00:00",
+		"level": "INFO",
+		"user":  "alice",
+		"msg":   "hello world",
+	}, data)
+
+	c = conf.MapConf{parser.KeyDissectPattern: `<%{a}>%{+a}|%{b}]`}
+	data = parseOne(t, c, `<x>y|z]`)
+	assert.Equal(t, Data{"a": "x y", "b": "z"}, data)
+}
+
+func TestCompileError(t *testing.T) {
+	for _, pattern := range []string{
+		"no key",
+		"%{a}%{b}",
+		"%{*a} %{b}",
+		"%{+a/x} %{b}",
+		"%{+} %{b}",
+	} {
+		_, err := NewParser(conf.MapConf{parser.KeyDissectPattern: pattern})
+		assert.Error(t, err, pattern)
+	}
+	_, err := NewParser(conf.MapConf{parser.KeyDissectPattern: "%{a} %{b}", parser.KeyDissectSchema: "a int"})
+	assert.Error(t, err)
+}
+
+func TestNotMatch(t *testing.T) {
+	c := conf.MapConf{parser.KeyDissectPattern: `[%{level}] %{msg}`}
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{"[INFO] ok", "INFO no brackets", "", "[WARN"})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, []Data{
+		{"level": "INFO", "msg": "ok"},
+		{KeyPandoraStash: "INFO no brackets"},
+		{KeyPandoraStash: "[WARN"},
+	}, datas)
+
+	c[parser.KeyDisableRecordErrData] = "true"
+	p, err = NewParser(c)
+	require.NoError(t, err)
+	datas, err = p.Parse([]string{"INFO no brackets", "[INFO] ok"})
+	se = err.(*StatsError)
+	assert.Equal(t, []Data{{"level": "INFO", "msg": "ok"}}, datas)
+	assert.Equal(t, []int{0}, se.DatasourceSkipIndex)
+}
@@ -0,0 +1,190 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 引用字段的类型，%{*key} 的值作为字段名，%{&key} 的值作为字段值
+const (
+	refNone = iota
+	refKey
+	refValue
+)
+
+var keyRegexp = regexp.MustCompile(`%\{([^}]*)\}`)
+
+type key struct {
+	name string
+	// skip 表示 %{} 或者 %{?name}，只切分不输出
+	skip bool
+	// appendTo 表示 %{+name}，order 为 %{+name/2} 中指定的追加顺序
+	appendTo bool
+	order    int
+	ref      int
+	// rightPad 表示 %{name->}，跳过之后重复的分隔符
+	rightPad bool
+}
+
+// dissector 为编译后的模式串，delims[i] 为 keys[i] 之后的分隔符，最后一个分隔符为空时最后一个字段取剩余的全部内容
+type dissector struct {
+	pattern string
+	prefix  string
+	keys    []key
+	delims  []string
+}
+
+func parseKey(s string) (key, error) {
+	var k key
+	if strings.HasSuffix(s, "->") {
+		k.rightPad = true
+		s = strings.TrimSuffix(s, "->")
+	}
+	if s != "" {
+		switch s[0] {
+		case '+':
+			k.appendTo = true
+			s = s[1:]
+		case '?':
+			k.skip = true
+			s = s[1:]
+		case '*':
+			k.ref = refKey
+			s = s[1:]
+		case '&':
+			k.ref = refValue
+			s = s[1:]
+		}
+	}
+	if k.appendTo {
+		if i := strings.LastIndexByte(s, '/'); i >= 0 {
+			order, err := strconv.Atoi(s[i+1:])
+			if err != nil {
+				return k, fmt.Errorf("invalid append order in %%{+%v}", s)
+			}
+			k.order, s = order, s[:i]
+		}
+	}
+	k.name = s
+	if k.name == "" {
+		if k.appendTo || k.ref != refNone {
+			return k, errors.New("dissect key with modifier must have a name")
+		}
+		k.skip = true
+	}
+	return k, nil
+}
+
+func compile(pattern string) (*dissector, error) {
+	matches := keyRegexp.FindAllStringSubmatchIndex(pattern, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no key found in dissect pattern %q", pattern)
+	}
+	d := &dissector{pattern: pattern, prefix: pattern[:matches[0][0]]}
+	refs := make(map[string]int)
+	for i, m := range matches {
+		k, err := parseKey(pattern[m[2]:m[3]])
+		if err != nil {
+			return nil, err
+		}
+		end := len(pattern)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		delim := pattern[m[1]:end]
+		if delim == "" && i+1 < len(matches) {
+			return nil, fmt.Errorf("dissect keys %v and %v must be separated by a delimiter", pattern[m[0]:m[1]], pattern[matches[i+1][0]:matches[i+1][1]])
+		}
+		if k.ref != refNone {
+			refs[k.name] |= k.ref
+		}
+		d.keys = append(d.keys, k)
+		d.delims = append(d.delims, delim)
+	}
+	for name, ref := range refs {
+		if ref != refKey|refValue {
+			return nil, fmt.Errorf("dissect reference key %q must have both %%{*%v} and %%{&%v}", name, name, name)
+		}
+	}
+	return d, nil
+}
+
+type part struct {
+	order int
+	seq   int
+	value string
+}
+
+// dissect 按照分隔符切分日志，日志与模式串不匹配时返回 false
+func (d *dissector) dissect(line, appendSeparator string) (map[string]string, bool) {
+	if !strings.HasPrefix(line, d.prefix) {
+		return nil, false
+	}
+	pos := len(d.prefix)
+	values := make([]string, len(d.keys))
+	for i, k := range d.keys {
+		delim := d.delims[i]
+		switch {
+		case delim == "":
+			values[i], pos = line[pos:], len(line)
+		case i == len(d.keys)-1:
+			// 最后的分隔符必须在行尾
+			if !strings.HasSuffix(line[pos:], delim) {
+				return nil, false
+			}
+			values[i], pos = line[pos:len(line)-len(delim)], len(line)
+		default:
+			j := strings.Index(line[pos:], delim)
+			if j < 0 {
+				return nil, false
+			}
+			values[i] = line[pos : pos+j]
+			pos += j + len(delim)
+			if k.rightPad {
+				for strings.HasPrefix(line[pos:], delim) {
+					pos += len(delim)
+				}
+			}
+		}
+	}
+
+	parts := make(map[string][]part)
+	refKeys := make(map[string]string)
+	refValues := make(map[string]string)
+	for i, k := range d.keys {
+		switch {
+		case k.skip:
+		case k.ref == refKey:
+			refKeys[k.name] = values[i]
+		case k.ref == refValue:
+			refValues[k.name] = values[i]
+		default:
+			parts[k.name] = append(parts[k.name], part{order: k.order, seq: i, value: values[i]})
+		}
+	}
+	fields := make(map[string]string, len(parts)+len(refKeys))
+	for name, ps := range parts {
+		if len(ps) == 1 {
+			fields[name] = ps[0].value
+			continue
+		}
+		sort.SliceStable(ps, func(i, j int) bool {
+			return ps[i].order < ps[j].order
+		})
+		vs := make([]string, len(ps))
+		for i, p := range ps {
+			vs[i] = p.value
+		}
+		fields[name] = strings.Join(vs, appendSeparator)
+	}
+	for name, k := range refKeys {
+		if k != "" {
+			fields[k] = refValues[name]
+		}
+	}
+	return fields, true
+}
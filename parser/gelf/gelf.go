@@ -0,0 +1,226 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	fieldTimestamp = "timestamp"
+	fieldLevel     = "level"
+	fieldLine      = "line"
+)
+
+var (
+	chunkedMagic = []byte{0x1e, 0x0f}
+	gzipMagic    = []byte{0x1f, 0x8b}
+
+	// 使用 json.Number 区分整数与浮点数
+	decoder = jsoniter.Config{UseNumber: true}.Froze()
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeGELF, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	return &Parser{
+		name:                 name,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeGELF
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		// 压缩后的数据不能去掉首尾的空白字符
+		go parser.ParseLine(sendChan, resultChan, wg, false, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(strings.TrimSpace(parseResult.Line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+// decompress 根据头部识别 gzip 与 zlib 压缩，未压缩的消息原样返回
+func decompress(msg []byte) ([]byte, error) {
+	var (
+		rd  io.ReadCloser
+		err error
+	)
+	switch {
+	case bytes.HasPrefix(msg, chunkedMagic):
+		return nil, errors.New("chunked GELF message should be reassembled by socket reader with GELF rule first")
+	case bytes.HasPrefix(msg, gzipMagic):
+		rd, err = gzip.NewReader(bytes.NewReader(msg))
+	case len(msg) > 1 && msg[0]&0x0f == 8 && (uint16(msg[0])<<8|uint16(msg[1]))%31 == 0:
+		rd, err = zlib.NewReader(bytes.NewReader(msg))
+	default:
+		return msg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return ioutil.ReadAll(rd)
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	msg, err := decompress([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("decompress GELF message failed: %v", err)
+	}
+	var raw map[string]interface{}
+	if err = decoder.Unmarshal(msg, &raw); err != nil {
+		return nil, fmt.Errorf("%v is not a valid GELF message: %v", TruncateStrSize(string(msg), DefaultTruncateMaxSize), err)
+	}
+	if _, ok := raw["short_message"]; !ok {
+		return nil, fmt.Errorf("%v is not a valid GELF message: short_message is missing", TruncateStrSize(string(msg), DefaultTruncateMaxSize))
+	}
+
+	data := make(Data, len(raw)+len(p.labels))
+	for k, v := range raw {
+		if !strings.HasPrefix(k, "_") {
+			data[k] = convert(k, v)
+		}
+	}
+	// 附加字段去掉下划线，与标准字段重名时保留下划线
+	for k, v := range raw {
+		if !strings.HasPrefix(k, "_") {
+			continue
+		}
+		name := k[1:]
+		if _, ok := raw[name]; ok || name == "" {
+			name = k
+		}
+		data[name] = convert(k, v)
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+func convert(key string, v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if key == fieldTimestamp {
+		// GELF 的时间戳为带小数的秒
+		if f, err := n.Float64(); err == nil {
+			sec, frac := math.Modf(f)
+			return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		if key == fieldLevel || key == fieldLine {
+			return int64(f)
+		}
+		return f
+	}
+	return n.String()
+}
@@ -0,0 +1,56 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const message = `{"version":"1.1","host":"example.org","short_message":"A short message","full_message":"Backtrace here\n\nmore stuff","timestamp":1385053862.3072,"level":1,"_user_id":9001,"_some_info":"foo","_host":"inner","_load":0.5}`
+
+func TestGELF(t *testing.T) {
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(message))
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(message))
+	zw.Close()
+
+	p, err := NewParser(conf.MapConf{parser.KeyLabels: "app web"})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{message, gz.String(), zl.String(), "", `{"host":"a"}`, "\x1e\x0fxxxxxxxx\x00\x02{"})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), se.Success)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, []int{3}, se.DatasourceSkipIndex)
+	require.Len(t, datas, 5)
+
+	expected := Data{
+		"version":       "1.1",
+		"host":          "example.org",
+		"short_message": "A short message",
+		"full_message":  "Backtrace here\n\nmore stuff",
+		"timestamp":     "2013-11-21T17:11:02.3072Z",
+		"level":         int64(1),
+		"user_id":       int64(9001),
+		"some_info":     "foo",
+		"_host":         "inner",
+		"load":          0.5,
+		"app":           "web",
+	}
+	for _, data := range datas[:3] {
+		assert.Equal(t, expected, data)
+	}
+	assert.Equal(t, Data{KeyPandoraStash: `{"host":"a"}`}, datas[3])
+	assert.Contains(t, se.ErrorDetail.Error(), "chunked")
+}
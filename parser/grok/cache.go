@@ -0,0 +1,251 @@
+package grok
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+const (
+	EngineRE2     = "re2"
+	EngineRegexp2 = "regexp2"
+
+	// DefaultMatchTimeout 为 regexp2 单次匹配的默认超时时间，regexp2 为回溯引擎，避免表达式写得不好时卡住解析
+	DefaultMatchTimeout = time.Second
+
+	// maxCompiledCache 为编译结果缓存的最大条数，超过时淘汰最久未使用的，已创建的解析器仍持有自己的编译结果
+	maxCompiledCache = 1024
+)
+
+var (
+	// 与 github.com/vjeantet/grok 相同的 pattern 引用语法
+	normalRe   = regexp.MustCompile(`%{([\w-.]+(?::[\w-.]+(?::[\w-.]+)?)?)}`)
+	validRe    = regexp.MustCompile(`^\w+([-.]\w+)*(:([-.\w]+)(:(string|float|int))?)?$`)
+	symbolicRe = regexp.MustCompile(`\W`)
+)
+
+// compatPatterns 为 vjeantet/grok 内置而 DEFAULT_PATTERNS 中没有的 pattern，保留以兼容已有的配置
+var compatPatterns = map[string]string{
+	"EMAILLOCALPART":       `[a-zA-Z][a-zA-Z0-9_.+-=:]+`,
+	"EMAILADDRESS":         `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"MONTHNUM2":            `(?:0[1-9]|1[0-2])`,
+	"DATESTAMP_RFC2822":    `%{DAY}, %{MONTHDAY} %{MONTH} %{YEAR} %{TIME} %{ISO8601_TIMEZONE}`,
+	"DATESTAMP_EVENTLOG":   `%{YEAR}%{MONTHNUM2}%{MONTHDAY}%{HOUR}%{MINUTE}%{SECOND}`,
+	"COMMONENVOYACCESSLOG": `\[%{TIMESTAMP_ISO8601:timestamp}\] \"%{DATA:method} (?:%{URIPATH:uri_path}(?:%{URIPARAM:uri_param})?|%{DATA:}) %{DATA:protocol}\" %{NUMBER:status_code} %{DATA:response_flags} %{NUMBER:bytes_received} %{NUMBER:bytes_sent} %{NUMBER:duration} (?:%{NUMBER:upstream_service_time}|%{DATA:tcp_service_time}) \"%{DATA:forwarded_for}\" \"%{DATA:user_agent}\" \"%{DATA:request_id}\" \"%{DATA:authority}\" \"%{DATA:upstream_service}\"`,
+}
+
+// matcher 为编译好的表达式，默认使用 Go 的 RE2 引擎，RE2 不支持的语法（如环视、(?<name>)）使用 regexp2 引擎
+type matcher interface {
+	// captures 返回各个命名分组匹配到的非空值，不匹配时返回 nil，匹配超时时返回 error
+	captures(line string) (map[string]string, error)
+	// literalPrefix 返回匹配结果必须包含的字面前缀，没有时返回空
+	literalPrefix() string
+	engine() string
+}
+
+type re2Matcher struct {
+	re     *regexp.Regexp
+	prefix string
+}
+
+func (m *re2Matcher) captures(line string) (map[string]string, error) {
+	match := m.re.FindStringSubmatch(line)
+	if len(match) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string)
+	for i, name := range m.re.SubexpNames() {
+		if name != "" && match[i] != "" {
+			values[name] = match[i]
+		}
+	}
+	return values, nil
+}
+
+func (m *re2Matcher) literalPrefix() string {
+	return m.prefix
+}
+
+func (m *re2Matcher) engine() string {
+	return EngineRE2
+}
+
+type regexp2Matcher struct {
+	re *regexp2.Regexp
+}
+
+func (m *regexp2Matcher) captures(line string) (map[string]string, error) {
+	match, err := m.re.FindStringMatch(line)
+	if err != nil || match == nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, num := range m.re.GetGroupNumbers() {
+		name := m.re.GroupNameFromNumber(num)
+		if name == strconv.Itoa(num) {
+			// 没有命名的分组
+			continue
+		}
+		if g := match.GroupByNumber(num); g != nil && g.Length > 0 {
+			values[name] = g.String()
+		}
+	}
+	return values, nil
+}
+
+func (m *regexp2Matcher) literalPrefix() string {
+	return ""
+}
+
+func (m *regexp2Matcher) engine() string {
+	return EngineRegexp2
+}
+
+// compiledCache 以展开后的表达式为 key 缓存编译结果，同一进程中的所有 grok 解析器共享，按 LRU 淘汰
+var compiledCache = struct {
+	sync.Mutex
+	lru      *list.List
+	matchers map[string]*list.Element
+}{lru: list.New(), matchers: make(map[string]*list.Element)}
+
+type cacheEntry struct {
+	key string
+	m   matcher
+}
+
+// compileExpanded 编译展开后的表达式，RE2 编译失败时使用 regexp2 编译，timeout 为 regexp2 单次匹配的超时时间
+func compileExpanded(expr string, timeout time.Duration) (matcher, error) {
+	// regexp2 的超时时间设置在编译结果上，超时时间不同的编译结果不能共享
+	key := timeout.String() + " " + expr
+	compiledCache.Lock()
+	if elem, ok := compiledCache.matchers[key]; ok {
+		compiledCache.lru.MoveToFront(elem)
+		compiledCache.Unlock()
+		return elem.Value.(*cacheEntry).m, nil
+	}
+	compiledCache.Unlock()
+
+	var m matcher
+	if re, err := regexp.Compile(expr); err == nil {
+		prefix, _ := re.LiteralPrefix()
+		m = &re2Matcher{re: re, prefix: prefix}
+	} else {
+		re2, err2 := regexp2.Compile(expr, regexp2.RE2)
+		if err2 != nil {
+			return nil, fmt.Errorf("compile grok pattern failed, re2: %v, regexp2: %v", err, err2)
+		}
+		re2.MatchTimeout = timeout
+		m = &regexp2Matcher{re: re2}
+	}
+
+	compiledCache.Lock()
+	defer compiledCache.Unlock()
+	if elem, ok := compiledCache.matchers[key]; ok {
+		compiledCache.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).m, nil
+	}
+	compiledCache.matchers[key] = compiledCache.lru.PushFront(&cacheEntry{key: key, m: m})
+	for compiledCache.lru.Len() > maxCompiledCache {
+		oldest := compiledCache.lru.Back()
+		compiledCache.lru.Remove(oldest)
+		delete(compiledCache.matchers, oldest.Value.(*cacheEntry).key)
+	}
+	return m, nil
+}
+
+// expander 将 %{NAME:field} 形式的 pattern 展开为正则表达式，规则与 vjeantet/grok 相同
+type expander struct {
+	raw      map[string]string
+	expanded map[string]string
+	// aliases 为分组名到字段名的映射，字段名中有非法字符时分组名会替换为下划线
+	aliases map[string]string
+}
+
+func newExpander(patterns map[string]string) (*expander, error) {
+	raw := make(map[string]string, len(compatPatterns)+len(patterns))
+	for name, pattern := range compatPatterns {
+		raw[name] = pattern
+	}
+	for name, pattern := range patterns {
+		raw[name] = pattern
+	}
+	// 检查所有 pattern 的引用，配置错误时尽早报错
+	for name, pattern := range raw {
+		for _, ref := range normalRe.FindAllStringSubmatch(pattern, -1) {
+			if !validRe.MatchString(ref[1]) {
+				return nil, fmt.Errorf("cannot add pattern %q: invalid pattern %%{%s}", name, ref[1])
+			}
+			syntax := strings.Split(ref[1], ":")[0]
+			if _, ok := raw[syntax]; !ok {
+				return nil, fmt.Errorf("cannot add pattern %q: no pattern found for %%{%s}", name, syntax)
+			}
+		}
+	}
+	return &expander{
+		raw:      raw,
+		expanded: make(map[string]string),
+		aliases:  make(map[string]string),
+	}, nil
+}
+
+// expand 展开一个 pattern 中引用的所有 pattern
+func (e *expander) expand(pattern string) (string, error) {
+	return e.expandWithStack(pattern, nil)
+}
+
+func (e *expander) expandWithStack(pattern string, stack []string) (string, error) {
+	for _, values := range normalRe.FindAllStringSubmatch(pattern, -1) {
+		if !validRe.MatchString(values[1]) {
+			return "", fmt.Errorf("invalid pattern %%{%s}", values[1])
+		}
+		names := strings.Split(values[1], ":")
+		syntax := names[0]
+		stored, err := e.expandName(syntax, stack)
+		if err != nil {
+			return "", err
+		}
+
+		var buffer bytes.Buffer
+		if len(names) > 1 {
+			alias := symbolicRe.ReplaceAllString(names[1], "_")
+			e.aliases[alias] = names[1]
+			buffer.WriteString("(?P<")
+			buffer.WriteString(alias)
+			buffer.WriteString(">")
+		} else {
+			buffer.WriteString("(")
+		}
+		buffer.WriteString(stored)
+		buffer.WriteString(")")
+		pattern = strings.Replace(pattern, values[0], buffer.String(), -1)
+	}
+	return pattern, nil
+}
+
+func (e *expander) expandName(name string, stack []string) (string, error) {
+	if expr, ok := e.expanded[name]; ok {
+		return expr, nil
+	}
+	for _, s := range stack {
+		if s == name {
+			return "", fmt.Errorf("pattern %%{%s} references itself: %v", name, strings.Join(append(stack, name), " -> "))
+		}
+	}
+	raw, ok := e.raw[name]
+	if !ok {
+		return "", fmt.Errorf("no pattern found for %%{%s}", name)
+	}
+	expr, err := e.expandWithStack(raw, append(stack, name))
+	if err != nil {
+		return "", err
+	}
+	e.expanded[name] = expr
+	return expr, nil
+}
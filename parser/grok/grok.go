@@ -9,10 +9,9 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/vjeantet/grok"
-
 	log "k8s.io/klog/v2"
 
 	"github.com/longxiucai/logkit/conf"
@@ -50,6 +49,51 @@ func init() {
 	parser.RegisterConstructor(parser.TypeGrok, NewParser)
 }
 
+var _ parser.ExtraStatsParser = &Parser{}
+
+// compiledPattern 为一个编译好的模式串以及它的匹配统计
+type compiledPattern struct {
+	tried    int64
+	matched  int64
+	skipped  int64
+	timeouts int64
+	costNano int64
+
+	pattern string // 用户配置的模式串
+	name    string // 对应的 namedPattern，用于查找 typeMap
+	matcher matcher
+}
+
+// PatternStats 为单个模式串的匹配统计，skipped 为被字面前缀预过滤跳过的次数，timeouts 为 regexp2 匹配超时的次数
+type PatternStats struct {
+	Pattern     string  `json:"pattern"`
+	Engine      string  `json:"engine"`
+	Tried       int64   `json:"tried"`
+	Matched     int64   `json:"matched"`
+	Skipped     int64   `json:"skipped"`
+	Timeouts    int64   `json:"timeouts"`
+	TotalCostMs float64 `json:"total_cost_ms"`
+	AvgCostUs   float64 `json:"avg_cost_us"`
+}
+
+func (cp *compiledPattern) stats() PatternStats {
+	tried := atomic.LoadInt64(&cp.tried)
+	cost := atomic.LoadInt64(&cp.costNano)
+	stats := PatternStats{
+		Pattern:     cp.pattern,
+		Engine:      cp.matcher.engine(),
+		Tried:       tried,
+		Matched:     atomic.LoadInt64(&cp.matched),
+		Skipped:     atomic.LoadInt64(&cp.skipped),
+		Timeouts:    atomic.LoadInt64(&cp.timeouts),
+		TotalCostMs: float64(cost) / float64(time.Millisecond),
+	}
+	if tried > 0 {
+		stats.AvgCostUs = float64(cost) / float64(tried) / float64(time.Microsecond)
+	}
+	return stats
+}
+
 type Parser struct {
 	name                 string
 	labels               []parser.Label
@@ -84,7 +128,18 @@ type Parser struct {
 	//          "RESPONSE_CODE": "%{NUMBER:rc:date}"
 	//       }
 	patterns map[string]string
-	g        *grok.Grok
+
+	// compiled 为 namedPatterns 编译后的表达式，顺序与 Patterns 相同
+	compiled []*compiledPattern
+	// aliases 为表达式中的分组名到字段名的映射
+	aliases map[string]string
+
+	// adaptiveOrder 开启时优先尝试 lastMatched 对应的模式串
+	adaptiveOrder bool
+	lastMatched   int32
+
+	// matchTimeout 为 regexp2 引擎单次匹配的超时时间
+	matchTimeout time.Duration
 
 	numRoutine int
 }
@@ -106,6 +161,15 @@ func NewParser(c conf.MapConf) (parser.Parser, error) {
 	customPatternFiles, _ := c.GetStringListOr(parser.KeyGrokCustomPatternFiles, []string{})
 
 	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	adaptiveOrder, _ := c.GetBoolOr(parser.KeyGrokAdaptiveOrder, false)
+	matchTimeoutStr, _ := c.GetStringOr(parser.KeyGrokMatchTimeout, "")
+	matchTimeout := DefaultMatchTimeout
+	if matchTimeoutStr != "" {
+		matchTimeout, err = time.ParseDuration(matchTimeoutStr)
+		if err != nil || matchTimeout <= 0 {
+			return nil, fmt.Errorf("parse key %v %q error: must be a positive duration", parser.KeyGrokMatchTimeout, matchTimeoutStr)
+		}
+	}
 
 	numRoutine := MaxProcs
 	if numRoutine == 0 {
@@ -121,6 +185,8 @@ func NewParser(c conf.MapConf) (parser.Parser, error) {
 		CustomPatternFiles:   customPatternFiles,
 		timeZoneOffset:       timeZoneOffset,
 		disableRecordErrData: disableRecordErrData,
+		adaptiveOrder:        adaptiveOrder,
+		matchTimeout:         matchTimeout,
 		numRoutine:           numRoutine,
 	}
 	err = p.compile()
@@ -133,11 +199,7 @@ func NewParser(c conf.MapConf) (parser.Parser, error) {
 func (p *Parser) compile() error {
 	p.typeMap = make(map[string]map[string]string)
 	p.patterns = make(map[string]string)
-	gk, err := grok.NewWithConfig(&grok.Config{NamedCapturesOnly: true, RemoveEmptyValues: true})
-	if err != nil {
-		return err
-	}
-	p.g = gk
+	p.compiled = nil
 
 	// Give Patterns fake names so that they can be treated as named
 	// "custom patterns"
@@ -173,7 +235,41 @@ func (p *Parser) compile() error {
 		}
 	}
 
-	return p.compileCustomPatterns()
+	if err := p.compileCustomPatterns(); err != nil {
+		return err
+	}
+	return p.compilePatterns()
+}
+
+// compilePatterns 展开并编译 namedPatterns，相同的表达式在进程内的所有解析器间共享编译结果
+func (p *Parser) compilePatterns() error {
+	e, err := newExpander(p.patterns)
+	if err != nil {
+		return err
+	}
+	if p.matchTimeout <= 0 {
+		p.matchTimeout = DefaultMatchTimeout
+	}
+	compiled := make([]*compiledPattern, 0, len(p.namedPatterns))
+	for i, name := range p.namedPatterns {
+		expr, err := e.expand(name)
+		if err != nil {
+			return fmt.Errorf("expand grok pattern %v error %v", p.Patterns[i], err)
+		}
+		m, err := compileExpanded(expr, p.matchTimeout)
+		if err != nil {
+			return fmt.Errorf("compile grok pattern %v error %v", p.Patterns[i], err)
+		}
+		compiled = append(compiled, &compiledPattern{
+			pattern: p.Patterns[i],
+			name:    name,
+			matcher: m,
+		})
+	}
+	p.compiled = compiled
+	p.aliases = e.aliases
+	atomic.StoreInt32(&p.lastMatched, 0)
+	return nil
 }
 
 func (p *Parser) Name() string {
@@ -256,17 +352,47 @@ func (p *Parser) parse(line string) (Data, error) {
 	if p.mode == ModeMulti {
 		line = strings.Replace(line, "\n", " ", -1)
 	}
+	if len(p.compiled) == 0 {
+		return nil, fmt.Errorf("grok patterns %v are not compiled", p.Patterns)
+	}
 	var err error
 	var values map[string]string
 	var patternName string
-	for _, pattern := range p.namedPatterns {
-		if values, err = p.g.Parse(pattern, line); err != nil {
+	// 先尝试 first，再按配置的顺序尝试其余的模式串，未开启 adaptiveOrder 时 first 为 0，即按配置的顺序
+	first := 0
+	if p.adaptiveOrder {
+		first = int(atomic.LoadInt32(&p.lastMatched))
+	}
+	for i := -1; i < len(p.compiled); i++ {
+		idx := i
+		if i < 0 {
+			idx = first
+		} else if i == first {
+			continue
+		}
+		cp := p.compiled[idx]
+		// 表达式有字面前缀时，不包含该前缀的日志不可能匹配
+		if prefix := cp.matcher.literalPrefix(); prefix != "" && !strings.Contains(line, prefix) {
+			atomic.AddInt64(&cp.skipped, 1)
+			continue
+		}
+		start := time.Now()
+		values, err = cp.matcher.captures(line)
+		atomic.AddInt64(&cp.costNano, int64(time.Since(start)))
+		atomic.AddInt64(&cp.tried, 1)
+		if err != nil {
+			// 只有 regexp2 引擎会返回错误，即匹配超时
+			atomic.AddInt64(&cp.timeouts, 1)
 			log.Infof("E! %v", err)
 			return nil, err
 		}
 		//此处匹配到就break的好处时匹配结果唯一，若要改为不break，那要考虑如果有多个串同时满足时，结果如何选取的问题，应该考虑优先选择匹配的结果多的数据。
 		if len(values) != 0 {
-			patternName = pattern
+			atomic.AddInt64(&cp.matched, 1)
+			if p.adaptiveOrder && idx != first {
+				atomic.StoreInt32(&p.lastMatched, int32(idx))
+			}
+			patternName = cp.name
 			break
 		}
 	}
@@ -275,7 +401,11 @@ func (p *Parser) parse(line string) (Data, error) {
 		return nil, fmt.Errorf("%v no value was parsed after grok pattern %v", TruncateStrSize(line, DefaultTruncateMaxSize), p.Patterns)
 	}
 	data := Data{}
-	for k, v := range values {
+	for group, v := range values {
+		k := group
+		if name, ok := p.aliases[group]; ok {
+			k = name
+		}
 		if k == "" || v == "" {
 			continue
 		}
@@ -334,6 +464,17 @@ func (p *Parser) parse(line string) (Data, error) {
 	return data, nil
 }
 
+// ExtraStats 返回各个模式串的匹配次数与耗时，用于调整模式串的配置
+func (p *Parser) ExtraStats() map[string]interface{} {
+	stats := make([]PatternStats, 0, len(p.compiled))
+	for _, cp := range p.compiled {
+		stats = append(stats, cp.stats())
+	}
+	return map[string]interface{}{
+		"patterns": stats,
+	}
+}
+
 func (p *Parser) addCustomPatterns(scanner *bufio.Scanner) error {
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -351,10 +492,17 @@ func (p *Parser) addCustomPatterns(scanner *bufio.Scanner) error {
 
 func (p *Parser) compileCustomPatterns() error {
 	var err error
+	// 按名称顺序替换，保证相同的配置展开得到相同的表达式，以便共享编译结果
+	names := make([]string, 0, len(p.patterns))
+	for name := range p.patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	// check if the pattern contains a subpattern that is already defined
 	// replace it with the subpattern for modifier inheritance.
 	for i := 0; i < 2; i++ {
-		for name, pattern := range p.patterns {
+		for _, name := range names {
+			pattern := p.patterns[name]
 			subNames := patternOnlyRe.FindAllStringSubmatch(pattern, -1)
 			for _, subName := range subNames {
 				if subPattern, ok := p.patterns[subName[1]]; ok {
@@ -377,7 +525,7 @@ func (p *Parser) compileCustomPatterns() error {
 		}
 	}
 
-	return p.g.AddPatternsFromMap(p.patterns)
+	return nil
 }
 
 func trimInvalidSpace(pattern string) string {
@@ -1,13 +1,17 @@
 package grok
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
 	"github.com/longxiucai/logkit/utils"
 	. "github.com/longxiucai/logkit/utils/models"
 )
@@ -140,19 +144,24 @@ func TestTimeZoneOffsetParse(t *testing.T) {
 	assert.Equal(t, "2017-04-05T18:25:06+08:00", m["ts"])
 }
 
-// Verify that patterns with a regex lookahead fail at compile time.
+// RE2 不支持环视，此时使用 regexp2 引擎
 func TestParsePatternsWithLookahead(t *testing.T) {
 	p := &Parser{
 		Patterns: []string{"%{MYLOG}"},
 		CustomPatterns: `
 			NOBOT ((?!bot|crawl).)*
-			MYLOG %{NUMBER:num:long} %{NOBOT:client}
+			MYLOG ^%{NUMBER:num:long} %{NOBOT:client}$
 		`,
 	}
 	assert.NoError(t, p.compile())
+	assert.Equal(t, EngineRegexp2, p.compiled[0].matcher.engine())
 
 	_, err := p.parse(`1466004605359052000 bot`)
 	assert.Error(t, err)
+
+	m, err := p.parse(`1466004605359052000 curl`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"num": int64(1466004605359052000), "client": "curl"}, m)
 }
 
 func TestParserName(t *testing.T) {
@@ -840,3 +849,109 @@ func TestNagiosLog(t *testing.T) {
 		"nagios_log":   "Auto-save of retention data completed successfully.",
 	}, got)
 }
+
+func TestCompiledCache(t *testing.T) {
+	p1 := &Parser{Patterns: []string{"%{COMMON_LOG_FORMAT}"}}
+	assert.NoError(t, p1.compile())
+	p2 := &Parser{Patterns: []string{"%{NGINX_LOG}", "%{COMMON_LOG_FORMAT}"}}
+	assert.NoError(t, p2.compile())
+	// 展开后相同的表达式共享同一个编译结果
+	assert.True(t, p1.compiled[0].matcher == p2.compiled[1].matcher)
+	assert.False(t, p2.compiled[0].matcher == p2.compiled[1].matcher)
+	assert.Equal(t, EngineRE2, p1.compiled[0].matcher.engine())
+
+	// 超过缓存上限时淘汰最久未使用的编译结果
+	for i := 0; i < maxCompiledCache; i++ {
+		_, err := compileExpanded(fmt.Sprintf("evict%d", i), DefaultMatchTimeout)
+		assert.NoError(t, err)
+	}
+	compiledCache.Lock()
+	assert.Equal(t, maxCompiledCache, compiledCache.lru.Len())
+	assert.Len(t, compiledCache.matchers, maxCompiledCache)
+	compiledCache.Unlock()
+	p3 := &Parser{Patterns: []string{"%{COMMON_LOG_FORMAT}"}}
+	assert.NoError(t, p3.compile())
+	assert.False(t, p1.compiled[0].matcher == p3.compiled[0].matcher)
+}
+
+func TestMatchTimeout(t *testing.T) {
+	p := &Parser{
+		Patterns:       []string{"%{SLOW}"},
+		CustomPatterns: `SLOW ^(?=a)(a+)+$`,
+		matchTimeout:   10 * time.Millisecond,
+	}
+	assert.NoError(t, p.compile())
+	assert.Equal(t, EngineRegexp2, p.compiled[0].matcher.engine())
+
+	_, err := p.parse(strings.Repeat("a", 40) + "b")
+	assert.Error(t, err)
+	stats := p.ExtraStats()["patterns"].([]PatternStats)
+	assert.Equal(t, int64(1), stats[0].Tried)
+	assert.Equal(t, int64(1), stats[0].Timeouts)
+
+	_, err = NewParser(conf.MapConf{
+		parser.KeyParserType:       "grok",
+		parser.KeyGrokPatterns:     "%{COMMON_LOG_FORMAT}",
+		parser.KeyGrokMatchTimeout: "-1s",
+	})
+	assert.Error(t, err)
+}
+
+func TestPrefilterAndStats(t *testing.T) {
+	p := &Parser{
+		Patterns: []string{"%{ACCESS}", "%{ERRORLOG}"},
+		CustomPatterns: `
+			ACCESS access: %{IP:ip} %{NUMBER:status:long}
+			ERRORLOG error: %{GREEDYDATA:msg}
+		`,
+	}
+	assert.NoError(t, p.compile())
+	assert.Equal(t, "access: ", p.compiled[0].matcher.literalPrefix())
+
+	m, err := p.parse(`error: disk full`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"msg": "disk full"}, m)
+	m, err = p.parse(`access: 127.0.0.1 200`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"ip": "127.0.0.1", "status": int64(200)}, m)
+
+	stats := p.ExtraStats()["patterns"].([]PatternStats)
+	require.Len(t, stats, 2)
+	assert.Equal(t, "%{ACCESS}", stats[0].Pattern)
+	assert.Equal(t, EngineRE2, stats[0].Engine)
+	assert.Equal(t, int64(1), stats[0].Tried)
+	assert.Equal(t, int64(1), stats[0].Matched)
+	assert.Equal(t, int64(1), stats[0].Skipped)
+	assert.Equal(t, int64(1), stats[1].Tried)
+	assert.Equal(t, int64(1), stats[1].Matched)
+	assert.Equal(t, int64(0), stats[1].Skipped)
+}
+
+func TestAdaptiveOrder(t *testing.T) {
+	p := &Parser{
+		Patterns:      []string{"%{NUMBER:a:long}", "%{WORD:b}"},
+		adaptiveOrder: true,
+	}
+	assert.NoError(t, p.compile())
+
+	m, err := p.parse(`abc`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"b": "abc"}, m)
+	// 上次匹配的是第二个模式串，优先尝试它
+	m, err = p.parse(`123`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"b": "123"}, m)
+
+	stats := p.ExtraStats()["patterns"].([]PatternStats)
+	assert.Equal(t, int64(1), stats[0].Tried)
+	assert.Equal(t, int64(2), stats[1].Matched)
+
+	// 未开启时按配置的顺序匹配
+	p = &Parser{Patterns: []string{"%{NUMBER:a:long}", "%{WORD:b}"}}
+	assert.NoError(t, p.compile())
+	_, err = p.parse(`abc`)
+	assert.NoError(t, err)
+	m, err = p.parse(`123`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"a": int64(123)}, m)
+}
@@ -0,0 +1,275 @@
+package leef
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/cef"
+	"github.com/longxiucai/logkit/parser/syslog"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	prefix = "LEEF:"
+	// LEEF 1.0 默认以 tab 分隔属性
+	defaultDelimiter = "\t"
+	// attributePrefix 为与头部字段重名的属性加上的前缀
+	attributePrefix = "attr_"
+)
+
+// headerFields 为 LEEF 头部中 Version 之后各个字段的名称，LEEF 2.0 之后还有一个分隔符字段
+var headerFields = []string{"device_vendor", "device_product", "device_version", "event_id"}
+
+func init() {
+	parser.RegisterConstructor(parser.TypeLEEF, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	stripSyslog          bool
+	delimiter            string
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	stripSyslog, _ := c.GetBoolOr(parser.KeyStripSyslogHeader, false)
+	delimiterStr, _ := c.GetStringOr(parser.KeyLEEFDelimiter, "")
+	var delimiter string
+	if delimiterStr != "" {
+		var err error
+		if delimiter, err = parseDelimiter(delimiterStr); err != nil {
+			return nil, err
+		}
+	}
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	return &Parser{
+		name:                 name,
+		stripSyslog:          stripSyslog,
+		delimiter:            delimiter,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+// parseDelimiter 解析分隔符，可以是单个字符，也可以是 x09 或者 0x09 形式的十六进制
+func parseDelimiter(s string) (string, error) {
+	if len([]rune(s)) == 1 {
+		return s, nil
+	}
+	hex := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(hex, "0x"):
+		hex = hex[2:]
+	case strings.HasPrefix(hex, "x"):
+		hex = hex[1:]
+	default:
+		return "", fmt.Errorf("invalid leef delimiter %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid leef delimiter %q: %v", s, err)
+	}
+	return string(rune(v)), nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeLEEF
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, true, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	line = strings.TrimRight(line, "\r\n")
+	data := Data{}
+	idx := strings.Index(line, prefix)
+	switch {
+	case idx < 0:
+		return nil, fmt.Errorf("LEEFParser fail to parse log line [%v], %v not found", TruncateStrSize(line, DefaultTruncateMaxSize), prefix)
+	case idx > 0 && !p.stripSyslog:
+		return nil, fmt.Errorf("LEEFParser fail to parse log line [%v], it should start with %v", TruncateStrSize(line, DefaultTruncateMaxSize), prefix)
+	case idx > 0:
+		header, err := syslog.ParseHeader(line, idx)
+		if err != nil {
+			return nil, fmt.Errorf("LEEFParser fail to parse syslog header of log line [%v]: %v", TruncateStrSize(line, DefaultTruncateMaxSize), err)
+		}
+		for k, v := range header {
+			data["syslog_"+k] = v
+		}
+	}
+
+	event := line[idx+len(prefix):]
+	version := event
+	if i := strings.IndexByte(event, '|'); i >= 0 {
+		version = event[:i]
+	}
+	// 与 CEF 相同输出整数的版本号，LEEF 的版本号为 1.0、2.0 这样的形式，只保留主版本号
+	major, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(version), ".", 2)[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("LEEFParser fail to parse version %q of log line [%v]", version, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	headerCount := len(headerFields) + 1
+	if major != 1 {
+		// LEEF 2.0 的头部多了一个分隔符字段
+		headerCount++
+	}
+	parts := cef.SplitHeader(event, headerCount)
+	if len(parts) < headerCount {
+		return nil, fmt.Errorf("LEEFParser fail to parse log line [%v], header should have %d fields", TruncateStrSize(line, DefaultTruncateMaxSize), headerCount)
+	}
+	data["version"] = major
+	for i, name := range headerFields {
+		data[name] = parts[i+1]
+	}
+
+	delimiter := p.delimiter
+	if delimiter == "" {
+		delimiter = defaultDelimiter
+		if headerCount > len(headerFields)+1 && parts[headerCount-1] != "" {
+			if delimiter, err = parseDelimiter(parts[headerCount-1]); err != nil {
+				return nil, fmt.Errorf("LEEFParser fail to parse log line [%v]: %v", TruncateStrSize(line, DefaultTruncateMaxSize), err)
+			}
+		}
+	}
+	if len(parts) > headerCount {
+		for k, v := range parseAttributes(parts[headerCount], delimiter) {
+			if _, ok := data[k]; ok {
+				// 不覆盖头部字段与 syslog 字段
+				k = attributePrefix + k
+			}
+			data[k] = v
+		}
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// parseAttributes 按照分隔符切分属性，不包含未转义的 = 的部分认为是上一个属性的值中包含了分隔符，值中的 \= 与 \\ 会被还原
+func parseAttributes(s, delimiter string) map[string]string {
+	attrs := make(map[string]string)
+	var last string
+	for _, token := range strings.Split(s, delimiter) {
+		i := indexUnescaped(token, '=')
+		if i <= 0 {
+			if last != "" {
+				attrs[last] += delimiter + unescapeValue(token)
+			}
+			continue
+		}
+		last = strings.TrimSpace(token[:i])
+		attrs[last] = unescapeValue(token[i+1:])
+	}
+	return attrs
+}
+
+// indexUnescaped 返回 s 中第一个未被 \ 转义的 c 的位置
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case c:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeValue 还原值中转义的 = 与 \，其他的 \ 原样保留
+func unescapeValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '=' || s[i+1] == '\\') {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
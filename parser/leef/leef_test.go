@@ -0,0 +1,97 @@
+package leef
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestLEEFParser(t *testing.T) {
+	p, err := NewParser(conf.MapConf{parser.KeyParserName: "leef", parser.KeyStripSyslogHeader: "true"})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{
+		"LEEF:1.0|Microsoft|MSExchange|2013 SP1|15345|src=10.50.1.1\tdst=2.10.20.20\tspt=1200\tmsg=a=b",
+		"LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^sev=5^msg=a^b",
+		"<13>Jan 18 11:07:53 192.168.1.1 LEEF:2.0|Vendor|Product|1.0|Login|x7C|usrName=admin|devTime=Jan 18 2024 11:07:53",
+		"LEEF:2.0|Vendor|Product|1.0|Login||usrName=admin\tsrc=1.1.1.1",
+	})
+	if se, ok := err.(*StatsError); ok {
+		require.NoError(t, se.ErrorDetail)
+	}
+	require.Len(t, datas, 4)
+	assert.Equal(t, Data{
+		"version":        int64(1),
+		"device_vendor":  "Microsoft",
+		"device_product": "MSExchange",
+		"device_version": "2013 SP1",
+		"event_id":       "15345",
+		"src":            "10.50.1.1",
+		"dst":            "2.10.20.20",
+		"spt":            "1200",
+		"msg":            "a=b",
+	}, datas[0])
+	assert.Equal(t, Data{
+		"version":        int64(2),
+		"device_vendor":  "Lancope",
+		"device_product": "StealthWatch",
+		"device_version": "1.0",
+		"event_id":       "41",
+		"src":            "10.0.1.8",
+		"dst":            "10.0.0.5",
+		"sev":            "5",
+		"msg":            "a^b",
+	}, datas[1])
+	assert.Equal(t, "192.168.1.1", datas[2]["syslog_hostname"])
+	assert.Equal(t, "admin", datas[2]["usrName"])
+	assert.Equal(t, "Jan 18 2024 11:07:53", datas[2]["devTime"])
+	assert.Equal(t, "1.1.1.1", datas[3]["src"])
+
+	p, err = NewParser(conf.MapConf{parser.KeyLEEFDelimiter: "0x2C"})
+	require.NoError(t, err)
+	datas, err = p.Parse([]string{"LEEF:1.0|V|P|1|E|a=1,b=2", "LEEF:1.0|V|P"})
+	require.Len(t, datas, 2)
+	assert.Equal(t, "2", datas[0]["b"])
+	assert.Contains(t, datas[1], KeyPandoraStash)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+
+	_, err = NewParser(conf.MapConf{parser.KeyLEEFDelimiter: "09"})
+	assert.Error(t, err)
+}
+
+func TestLEEFParserEscapeAndVersion(t *testing.T) {
+	p, err := NewParser(conf.MapConf{})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{
+		"LEEF:1.0|V|P|1|E|msg=a\\=b\\\\c\tpath=C:\\dir",
+		// 主版本号为 1 时没有分隔符字段
+		"LEEF:1|V|P|1|E|src=1.1.1.1\tdst=2.2.2.2",
+		"LEEF:2|V|P|1|E|^|src=1.1.1.1^dst=2.2.2.2^event_id=x",
+	})
+	if se, ok := err.(*StatsError); ok {
+		require.NoError(t, se.ErrorDetail)
+	}
+	require.Len(t, datas, 3)
+	assert.Equal(t, `a=b\c`, datas[0]["msg"])
+	assert.Equal(t, `C:\dir`, datas[0]["path"])
+	assert.Equal(t, int64(1), datas[1]["version"])
+	assert.Equal(t, "1.1.1.1", datas[1]["src"])
+	assert.Equal(t, "2.2.2.2", datas[1]["dst"])
+	assert.Equal(t, int64(2), datas[2]["version"])
+	assert.Equal(t, "1.1.1.1", datas[2]["src"])
+	assert.Equal(t, "2.2.2.2", datas[2]["dst"])
+	assert.Equal(t, "E", datas[2]["event_id"])
+	assert.Equal(t, "x", datas[2]["attr_event_id"])
+
+	// 默认不去掉 syslog 头
+	_, err = p.Parse([]string{"<13>Jan 18 11:07:53 host LEEF:1.0|V|P|1|E|src=1.1.1.1"})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+}
@@ -244,6 +244,12 @@ func (p *Parser) makeValue(name, raw string) (data interface{}, err error) {
 	}
 }
 
+// nginx 内置的 combined 格式与默认配置文件中的 main 格式
+const (
+	CombinedFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+	MainFormat     = CombinedFormat + ` "$http_x_forwarded_for"`
+)
+
 var (
 	formatRegexp    = regexp.MustCompile(`^\s*log_format\s+(\S+)+\s+(.+)\s*$`)
 	formatEndRegexp = regexp.MustCompile(`^\s*(.*?)\s*(;|$)`)
@@ -293,6 +299,11 @@ func ResolveRegexpFromConf(confPath, name string) (*regexp.Regexp, error) {
 		return nil, fmt.Errorf("`log_format %v` not found in given config", name)
 	}
 
+	return FormatRegexp(format)
+}
+
+// FormatRegexp 根据 log_format 的格式串生成匹配正则表达式
+func FormatRegexp(format string) (*regexp.Regexp, error) {
 	restr := replaceRegexp.ReplaceAllString(regexp.QuoteMeta(format+" "), "(?P<$1>[^$3]*)$2")
 	return regexp.Compile(fmt.Sprintf("^%v$", strings.Trim(restr, " ")))
 }
@@ -329,8 +340,7 @@ func FindAllRegexpsFromConf(confPath string) (map[string]*regexp.Regexp, error)
 			format += lineSplit[1][1 : l-1]
 		}
 		if lineSplit[2] == ";" {
-			restr := replaceRegexp.ReplaceAllString(regexp.QuoteMeta(format+" "), "(?P<$1>[^$3]*)$2")
-			re, err := regexp.Compile(fmt.Sprintf("^%v$", strings.Trim(restr, " ")))
+			re, err := FormatRegexp(format)
 			if err != nil {
 				return nil, fmt.Errorf("compile log format regexp: %v", err)
 			}
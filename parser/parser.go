@@ -24,6 +24,11 @@ type Flushable interface {
 	Flush() (Data, error)
 }
 
+// ExtraStatsParser 代表了一个可以提供额外统计信息的解析器，统计信息会展示在 parserStats 的 extra 中
+type ExtraStatsParser interface {
+	ExtraStats() map[string]interface{}
+}
+
 // conf 字段
 const (
 	KeyParserName           = GlobalKeyName
@@ -47,6 +52,17 @@ const (
 	TypeSyslog     = "syslog"
 	TypeMySQL      = "mysqllog"
 	TypeLogfmt     = "logfmt"
+	TypeApache     = "apache"
+	TypeCEF        = "cef"
+	TypeLEEF       = "leef"
+	TypeDissect    = "dissect"
+	TypeChain      = "chain"
+	TypeAvro       = "avro"
+	TypeProtobuf   = "protobuf"
+	TypeGELF       = "gelf"
+	TypeAuto       = "auto"
+	TypeAuditd     = "auditd"
+	TypeW3C        = "w3c"
 )
 
 // 数据常量类型
@@ -0,0 +1,237 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const timestampName = "google.protobuf.Timestamp"
+
+func init() {
+	parser.RegisterConstructor(parser.TypeProtobuf, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	message              protoreflect.MessageDescriptor
+	labels               []parser.Label
+	disableRecordErrData bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	descriptorFile, err := c.GetString(parser.KeyProtobufDescriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	messageName, err := c.GetString(parser.KeyProtobufMessageName)
+	if err != nil {
+		return nil, err
+	}
+	message, err := loadMessage(descriptorFile, messageName)
+	if err != nil {
+		return nil, err
+	}
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	nameMap := make(map[string]struct{})
+	labels := parser.GetLabels(labelList, nameMap)
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	return &Parser{
+		name:                 name,
+		message:              message,
+		labels:               labels,
+		disableRecordErrData: disableRecordErrData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+// loadMessage 从 FileDescriptorSet 文件中找到指定的消息类型
+func loadMessage(descriptorFile, messageName string) (protoreflect.MessageDescriptor, error) {
+	bs, err := ioutil.ReadFile(descriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(bs, fds); err != nil {
+		return nil, fmt.Errorf("%v is not a valid descriptor set: %v", descriptorFile, err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("load descriptor set %v failed: %v", descriptorFile, err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %v not found in %v: %v", messageName, descriptorFile, err)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v in %v is not a message", messageName, descriptorFile)
+	}
+	return message, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeProtobuf
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas := make([]Data, 0, len(lines))
+	se := &StatsError{}
+
+	numRoutine := p.numRoutine
+	if len(lines) < numRoutine {
+		numRoutine = len(lines)
+	}
+	sendChan := make(chan parser.ParseInfo)
+	resultChan := make(chan parser.ParseResult)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		// 二进制数据不能去掉首尾的空白字符
+		go parser.ParseLine(sendChan, resultChan, wg, false, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+
+	var parseResultSlice = make(parser.ParseResultSlice, 0, len(lines))
+	for resultInfo := range resultChan {
+		parseResultSlice = append(parseResultSlice, resultInfo)
+	}
+	if numRoutine > 1 {
+		sort.Stable(parseResultSlice)
+	}
+
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.ErrorDetail = parseResult.Err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: parseResult.Line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, parseResult.Index)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, parseResult.Data)
+	}
+
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	msg := dynamicpb.NewMessage(p.message)
+	if err := proto.Unmarshal([]byte(line), msg); err != nil {
+		return nil, fmt.Errorf("decode protobuf message %v failed: %v", p.message.FullName(), err)
+	}
+	data := Data(convertMessage(msg))
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// convertMessage 将消息转换为以字段名为 key 的嵌套 map，只输出消息中出现的字段
+func convertMessage(msg protoreflect.Message) map[string]interface{} {
+	data := make(map[string]interface{})
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		data[string(fd.Name())] = convertField(fd, v)
+		return true
+	})
+	return data
+}
+
+func convertField(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]interface{}, list.Len())
+		for i := range values {
+			values[i] = convertValue(fd, list.Get(i))
+		}
+		return values
+	case fd.IsMap():
+		values := make(map[string]interface{}, v.Map().Len())
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			values[k.String()] = convertValue(fd.MapValue(), mv)
+			return true
+		})
+		return values
+	}
+	return convertValue(fd, v)
+}
+
+func convertValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		msg := v.Message()
+		if msg.Descriptor().FullName() == timestampName {
+			fields := msg.Descriptor().Fields()
+			seconds := msg.Get(fields.ByName("seconds")).Int()
+			nanos := msg.Get(fields.ByName("nanos")).Int()
+			return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano)
+		}
+		return convertMessage(msg)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return int64(v.Uint())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	}
+	return v.Interface()
+}
@@ -0,0 +1,154 @@
+package protobuf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    label.Enum(),
+		Type:     typ.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// writeDescriptorSet 生成与 protoc --include_imports --descriptor_set_out 相同格式的文件
+func writeDescriptorSet(t *testing.T, path string) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("event.proto"),
+		Package:    proto.String("example.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Level"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("INFO"), Number: proto.Int32(0)},
+				{Name: proto.String("WARN"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("msg", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+				field("id", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+				field("level", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".example.v1.Level", false),
+				field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+				field("attrs", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".example.v1.Event.AttrsEntry", true),
+				field("host", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".example.v1.Event.Host", false),
+				field("ts", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp", false),
+				field("raw", 8, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "", false),
+				field("port", 9, descriptorpb.FieldDescriptorProto_TYPE_UINT32, "", false),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{
+				{
+					Name: proto.String("AttrsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				},
+				{
+					Name: proto.String("Host"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+					},
+				},
+			},
+		}},
+	}
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		file,
+	}}
+	bs, err := proto.Marshal(fds)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, bs, 0644))
+}
+
+func TestProtobuf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	descFile := filepath.Join(dir, "event.desc")
+	writeDescriptorSet(t, descFile)
+
+	c := conf.MapConf{
+		parser.KeyProtobufDescriptorFile: descFile,
+		parser.KeyProtobufMessageName:    "example.v1.Event",
+		parser.KeyLabels:                 "app web",
+	}
+	p, err := NewParser(c)
+	require.NoError(t, err)
+
+	md := p.(*Parser).message
+	msg := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	msg.Set(fields.ByName("msg"), protoreflect.ValueOfString("disk full"))
+	msg.Set(fields.ByName("id"), protoreflect.ValueOfInt64(42))
+	msg.Set(fields.ByName("level"), protoreflect.ValueOfEnum(1))
+	tags := msg.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("a"))
+	tags.Append(protoreflect.ValueOfString("b"))
+	msg.Mutable(fields.ByName("attrs")).Map().Set(protoreflect.ValueOfString("x").MapKey(), protoreflect.ValueOfInt32(3))
+	msg.Mutable(fields.ByName("host")).Message().Set(fields.ByName("host").Message().Fields().ByName("name"), protoreflect.ValueOfString("web1"))
+	msg.Set(fields.ByName("ts"), protoreflect.ValueOfMessage(timestamppb.New(time.Unix(1500000000, 123)).ProtoReflect()))
+	msg.Set(fields.ByName("raw"), protoreflect.ValueOfBytes([]byte{0xff, 0x00}))
+	msg.Set(fields.ByName("port"), protoreflect.ValueOfUint32(8080))
+	bs, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	datas, err := p.Parse([]string{string(bs), "", "\xff\xff"})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, int64(1), se.Errors)
+	require.Len(t, datas, 2)
+	assert.Equal(t, Data{
+		"msg":   "disk full",
+		"id":    int64(42),
+		"level": "WARN",
+		"tags":  []interface{}{"a", "b"},
+		"attrs": map[string]interface{}{"x": int64(3)},
+		"host":  map[string]interface{}{"name": "web1"},
+		"ts":    "2017-07-14T02:40:00.000000123Z",
+		"raw":   "/wA=",
+		"port":  int64(8080),
+		"app":   "web",
+	}, datas[0])
+	assert.Equal(t, Data{KeyPandoraStash: "\xff\xff"}, datas[1])
+
+	c[parser.KeyProtobufMessageName] = "example.v1.Level"
+	_, err = NewParser(c)
+	assert.Error(t, err)
+	c[parser.KeyProtobufMessageName] = "example.v1.NotExist"
+	_, err = NewParser(c)
+	assert.Error(t, err)
+}
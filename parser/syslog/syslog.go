@@ -305,3 +305,42 @@ func (f *Automatic) IsNewLine(data []byte) bool {
 	}
 	return false
 }
+
+// ParseHeader 使用 syslog 解析器解析 line 中 msgStart 之前的 syslog 头部，用于去掉 cef、leef 等格式外层的 syslog 头，
+// 返回头部中的非空字段
+func ParseHeader(line string, msgStart int) (data Data, err error) {
+	if msgStart <= 0 || msgStart >= len(line) {
+		return nil, fmt.Errorf("parse syslog header of %q failed: no message after header", line)
+	}
+	switch DetectType([]byte(line)) {
+	case detectedRFC3164, detectedRFC5424:
+	default:
+		return nil, fmt.Errorf("parse syslog header %q failed: not a rfc3164 or rfc5424 header", line[:msgStart])
+	}
+	defer func() {
+		// rfc3164、rfc5424 解析器遇到不完整的头部时可能越界
+		if r := recover(); r != nil {
+			data, err = nil, fmt.Errorf("parse syslog header %q failed: %v", line[:msgStart], r)
+		}
+	}()
+	sparser := (&Automatic{}).GetParser([]byte(line))
+	if err = sparser.Parse(); err != nil && err.Error() != "No structured data" {
+		return nil, fmt.Errorf("parse syslog header %q failed: %v", line[:msgStart], err)
+	}
+	message := line[msgStart:]
+	data = Data{}
+	for k, v := range sparser.Dump() {
+		s, isString := v.(string)
+		switch {
+		case k == "content" || k == "message":
+			continue
+		case k == "tag" && strings.HasPrefix(message, s):
+			// rfc3164 头部中没有 tag 时，解析器会把消息的开头当作 tag
+			continue
+		case isString && (s == "" || s == "-"):
+			continue
+		}
+		data[k] = v
+	}
+	return data, nil
+}
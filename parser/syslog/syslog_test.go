@@ -3,6 +3,7 @@ package syslog
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -170,3 +171,40 @@ func TestSyslogParser_NoMatch(t *testing.T) {
 	}
 	assert.Equal(t, errors.New("syslog meet max line 3, try to parse err No start char found for priority, check if this is standard rfc3164/rfc5424 syslog"), err)
 }
+
+func TestParseHeader(t *testing.T) {
+	const message = "CEF:0|V|P|1|E|N|5|a=b"
+	line := "<134>Feb 10 10:00:00 fw01 " + message
+	header, err := ParseHeader(line, strings.Index(line, "CEF:"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fw01", header["hostname"])
+	assert.Equal(t, 134, header["priority"])
+	assert.NotContains(t, header, "content")
+	assert.NotContains(t, header, "tag")
+
+	line = "<134>Feb  1 10:00:00 fw01 app[12]: " + message
+	header, err = ParseHeader(line, strings.Index(line, "CEF:"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app", header["tag"])
+
+	line = "<134>1 2024-01-01T00:00:00Z fw01 app - - - " + message
+	header, err = ParseHeader(line, strings.Index(line, "CEF:"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app", header["app_name"])
+	assert.NotContains(t, header, "proc_id")
+	assert.NotContains(t, header, "message")
+
+	// 不完整的头部返回错误，不会 panic
+	for _, full := range []string{"<134>Feb 10 10:00:00 fw01 ", "<134>Feb  1 10:00:00 fw01 app[12]: ", "<134>1 2024-01-01T00:00:00Z fw01 app - - - "} {
+		for i := 0; i <= len(full); i++ {
+			line := full[:i] + message
+			assert.NotPanics(t, func() { ParseHeader(line, i) }, line)
+		}
+	}
+	_, err = ParseHeader("<134>"+message, 5)
+	assert.Error(t, err)
+	_, err = ParseHeader("Feb 10 10:00:00 fw01 "+message, 21)
+	assert.Error(t, err)
+	_, err = ParseHeader("<134>Feb 10 10:00:00 fw01 ", 26)
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package w3c
+
+import (
+	"github.com/longxiucai/logkit/parser"
+)
+
+const (
+	PresetALB        = "alb"
+	PresetELB        = "elb"
+	PresetCloudFront = "cloudfront"
+)
+
+// preset 为没有 #Fields 指令时使用的字段以及字段类型
+type preset struct {
+	fields []string
+	types  map[string]parser.DataType
+}
+
+// commonTypes 为 W3C 扩展日志中常见字段的类型，字段名为转换后的名称
+var commonTypes = map[string]parser.DataType{
+	"sc_status":          parser.TypeLong,
+	"sc_substatus":       parser.TypeLong,
+	"sc_win32_status":    parser.TypeLong,
+	"sc_bytes":           parser.TypeLong,
+	"cs_bytes":           parser.TypeLong,
+	"s_port":             parser.TypeLong,
+	"c_port":             parser.TypeLong,
+	"time_taken":         parser.TypeFloat,
+	"sc_content_len":     parser.TypeLong,
+	"sc_range_start":     parser.TypeLong,
+	"sc_range_end":       parser.TypeLong,
+	"time_to_first_byte": parser.TypeFloat,
+}
+
+var presets = map[string]preset{
+	// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html
+	PresetALB: {
+		fields: []string{
+			"type", "time", "elb", "client_port", "target_port",
+			"request_processing_time", "target_processing_time", "response_processing_time",
+			"elb_status_code", "target_status_code", "received_bytes", "sent_bytes",
+			"request", "user_agent", "ssl_cipher", "ssl_protocol", "target_group_arn", "trace_id",
+			"domain_name", "chosen_cert_arn", "matched_rule_priority", "request_creation_time",
+			"actions_executed", "redirect_url", "error_reason", "target_port_list",
+			"target_status_code_list", "classification", "classification_reason", "conn_trace_id",
+		},
+		types: map[string]parser.DataType{
+			"request_processing_time":  parser.TypeFloat,
+			"target_processing_time":   parser.TypeFloat,
+			"response_processing_time": parser.TypeFloat,
+			"elb_status_code":          parser.TypeLong,
+			"target_status_code":       parser.TypeLong,
+			"received_bytes":           parser.TypeLong,
+			"sent_bytes":               parser.TypeLong,
+			"matched_rule_priority":    parser.TypeLong,
+		},
+	},
+	// https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/access-log-collection.html
+	PresetELB: {
+		fields: []string{
+			"timestamp", "elb", "client_port", "backend_port",
+			"request_processing_time", "backend_processing_time", "response_processing_time",
+			"elb_status_code", "backend_status_code", "received_bytes", "sent_bytes",
+			"request", "user_agent", "ssl_cipher", "ssl_protocol",
+		},
+		types: map[string]parser.DataType{
+			"request_processing_time":  parser.TypeFloat,
+			"backend_processing_time":  parser.TypeFloat,
+			"response_processing_time": parser.TypeFloat,
+			"elb_status_code":          parser.TypeLong,
+			"backend_status_code":      parser.TypeLong,
+			"received_bytes":           parser.TypeLong,
+			"sent_bytes":               parser.TypeLong,
+		},
+	},
+	// https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/AccessLogs.html
+	PresetCloudFront: {
+		fields: []string{
+			"date", "time", "x_edge_location", "sc_bytes", "c_ip", "cs_method", "cs_Host",
+			"cs_uri_stem", "sc_status", "cs_Referer", "cs_User_Agent", "cs_uri_query", "cs_Cookie",
+			"x_edge_result_type", "x_edge_request_id", "x_host_header", "cs_protocol", "cs_bytes",
+			"time_taken", "x_forwarded_for", "ssl_protocol", "ssl_cipher", "x_edge_response_result_type",
+			"cs_protocol_version", "fle_status", "fle_encrypted_fields", "c_port", "time_to_first_byte",
+			"x_edge_detailed_result_type", "sc_content_type", "sc_content_len", "sc_range_start", "sc_range_end",
+		},
+	},
+}
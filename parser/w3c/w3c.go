@@ -0,0 +1,269 @@
+package w3c
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func init() {
+	parser.RegisterConstructor(parser.TypeW3C, NewParser)
+}
+
+const (
+	directiveFields   = "Fields"
+	directivePrefix   = "w3c_"
+	emptyValue        = "-"
+	directiveMaxCount = 16
+)
+
+// Parser 解析 W3C 扩展日志格式，#Fields 指令出现时使用新的字段解析之后的日志
+type Parser struct {
+	name                 string
+	labels               []parser.Label
+	types                map[string]parser.DataType
+	keepDirectives       bool
+	disableRecordErrData bool
+
+	mux        sync.Mutex
+	fields     []string
+	directives map[string]string
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(parser.KeyParserName, "")
+	presetName, _ := c.GetStringOr(parser.KeyW3CPreset, "")
+	fieldsRaw, _ := c.GetStringOr(parser.KeyW3CFields, "")
+	schema, _ := c.GetStringOr(parser.KeyW3CSchema, "")
+	keepDirectives, _ := c.GetBoolOr(parser.KeyW3CKeepDirectives, false)
+	labelList, _ := c.GetStringListOr(parser.KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(parser.KeyDisableRecordErrData, false)
+
+	types := make(map[string]parser.DataType, len(commonTypes))
+	for k, v := range commonTypes {
+		types[k] = v
+	}
+	var fields []string
+	if presetName != "" {
+		ps, ok := presets[presetName]
+		if !ok {
+			return nil, fmt.Errorf("%v %q is not supported, should be one of %v, %v, %v",
+				parser.KeyW3CPreset, presetName, PresetALB, PresetELB, PresetCloudFront)
+		}
+		fields = ps.fields
+		for k, v := range ps.types {
+			types[k] = v
+		}
+	}
+	if fieldsRaw != "" {
+		fields = normalizeFields(strings.Fields(fieldsRaw))
+	}
+	schemaTypes, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range schemaTypes {
+		types[k] = v
+	}
+
+	nameMap := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		nameMap[f] = struct{}{}
+	}
+	labels := parser.GetLabels(labelList, nameMap)
+
+	return &Parser{
+		name:                 name,
+		labels:               labels,
+		types:                types,
+		keepDirectives:       keepDirectives,
+		disableRecordErrData: disableRecordErrData,
+		fields:               fields,
+		directives:           make(map[string]string),
+	}, nil
+}
+
+// parseSchema 解析 "字段名 类型" 以逗号分隔的字段类型配置
+func parseSchema(schema string) (map[string]parser.DataType, error) {
+	types := make(map[string]parser.DataType)
+	for _, item := range strings.Split(schema, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Fields(item)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v item %q should be like \"sc-status long\"", parser.KeyW3CSchema, item)
+		}
+		typ := parser.DataType(strings.ToLower(parts[1]))
+		switch typ {
+		case parser.TypeLong, parser.TypeFloat, parser.TypeString:
+		default:
+			return nil, fmt.Errorf("%v type %q of %v is not supported, should be long, float or string", parser.KeyW3CSchema, parts[1], parts[0])
+		}
+		types[fieldKey(parts[0])] = typ
+	}
+	return types, nil
+}
+
+// fieldKey 将 cs(User-Agent)、cs-uri-stem 等字段名转换为 cs_User_Agent、cs_uri_stem
+func fieldKey(name string) string {
+	key, _ := PandoraKey(name)
+	return strings.TrimRight(key, "_")
+}
+
+func normalizeFields(names []string) []string {
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, fieldKey(name))
+	}
+	return fields
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return parser.TypeW3C
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	se := &StatsError{}
+	datas := make([]Data, 0, len(lines))
+	for idx, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			p.directive(line[1:])
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		data, err := p.parse(line)
+		if err != nil {
+			se.AddErrors()
+			se.ErrorDetail = err
+			if !p.disableRecordErrData {
+				datas = append(datas, Data{
+					KeyPandoraStash: line,
+				})
+			} else {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			}
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, data)
+	}
+	return datas, se
+}
+
+// directive 处理 #Fields、#Software、#Date 等指令
+func (p *Parser) directive(line string) {
+	idx := strings.IndexByte(line, ':')
+	if idx < 0 {
+		return
+	}
+	name := strings.TrimSpace(line[:idx])
+	value := strings.TrimSpace(line[idx+1:])
+	if name == directiveFields {
+		p.fields = normalizeFields(strings.Fields(value))
+		return
+	}
+	if _, ok := p.directives[name]; !ok && len(p.directives) >= directiveMaxCount {
+		return
+	}
+	p.directives[name] = value
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	if len(p.fields) == 0 {
+		return nil, fmt.Errorf("no fields for %v, need a #Fields directive, %v or %v", TruncateStrSize(line, DefaultTruncateMaxSize), parser.KeyW3CFields, parser.KeyW3CPreset)
+	}
+	values := splitValues(line)
+	data := make(Data, len(p.fields)+len(p.labels))
+	for i, value := range values {
+		// 多出的字段忽略，AWS 会在日志末尾增加新的字段
+		if i >= len(p.fields) {
+			break
+		}
+		if value == emptyValue || value == "" {
+			continue
+		}
+		v, err := p.convert(p.fields[i], value)
+		if err != nil {
+			return nil, err
+		}
+		data[p.fields[i]] = v
+	}
+	if p.keepDirectives {
+		for name, value := range p.directives {
+			data[directivePrefix+strings.ToLower(fieldKey(name))] = value
+		}
+	}
+	for _, l := range p.labels {
+		if _, ok := data[l.Name]; !ok {
+			data[l.Name] = l.Value
+		}
+	}
+	return data, nil
+}
+
+func (p *Parser) convert(field, value string) (interface{}, error) {
+	switch p.types[field] {
+	case parser.TypeLong:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %v value %q is not long: %v", field, value, err)
+		}
+		return v, nil
+	case parser.TypeFloat:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %v value %q is not float: %v", field, value, err)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// splitValues 按空格或制表符切分，双引号括起来的值作为一个整体并去掉引号
+func splitValues(line string) []string {
+	var values []string
+	i := 0
+	for i < len(line) {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		if line[i] == '"' {
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				values = append(values, line[i+1:])
+				break
+			}
+			values = append(values, line[i+1:i+1+end])
+			i += end + 2
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		values = append(values, line[start:i])
+	}
+	return values
+}
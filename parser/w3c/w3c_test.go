@@ -0,0 +1,131 @@
+package w3c
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestIIS(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		parser.KeyParserName:        "iis",
+		parser.KeyW3CKeepDirectives: "true",
+		parser.KeyW3CSchema:         "cs-username string, s-port string",
+		parser.KeyLabels:            "host h1",
+	})
+	require.NoError(t, err)
+
+	datas, err := p.Parse([]string{
+		`2019-06-26 00:00:00 GET /before-fields`,
+		`#Software: Microsoft Internet Information Services 10.0`,
+		`#Version: 1.0`,
+		`#Fields: date time cs-method cs-uri-stem s-port cs(User-Agent) sc-status time-taken`,
+		`2019-06-26 00:00:01 GET /index.html 80 Mozilla/5.0+(Windows+NT+10.0) 200 15`,
+		``,
+		`#Fields: date time cs-method cs-username sc-bytes`,
+		`2019-06-26 00:00:02 POST alice 1024`,
+		`2019-06-26 00:00:03 GET - abc`,
+	})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Success)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, []int{1, 2, 3, 5, 6}, se.DatasourceSkipIndex)
+	require.Len(t, datas, 4)
+	assert.Equal(t, `2019-06-26 00:00:00 GET /before-fields`, datas[0][KeyPandoraStash])
+	assert.Equal(t, Data{
+		"date":          "2019-06-26",
+		"time":          "00:00:01",
+		"cs_method":     "GET",
+		"cs_uri_stem":   "/index.html",
+		"s_port":        "80",
+		"cs_User_Agent": "Mozilla/5.0+(Windows+NT+10.0)",
+		"sc_status":     int64(200),
+		"time_taken":    float64(15),
+		"w3c_software":  "Microsoft Internet Information Services 10.0",
+		"w3c_version":   "1.0",
+		"host":          "h1",
+	}, datas[1])
+	assert.Equal(t, Data{
+		"date":         "2019-06-26",
+		"time":         "00:00:02",
+		"cs_method":    "POST",
+		"cs_username":  "alice",
+		"sc_bytes":     int64(1024),
+		"w3c_software": "Microsoft Internet Information Services 10.0",
+		"w3c_version":  "1.0",
+		"host":         "h1",
+	}, datas[2])
+	assert.Equal(t, `2019-06-26 00:00:03 GET - abc`, datas[3][KeyPandoraStash])
+}
+
+func TestPresets(t *testing.T) {
+	p, err := NewParser(conf.MapConf{parser.KeyW3CPreset: PresetALB})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{
+		`https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/ HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_123 new-field`,
+	})
+	require.NoError(t, err.(*StatsError).ErrorDetail)
+	require.Len(t, datas, 1)
+	d := datas[0]
+	assert.Equal(t, "https", d["type"])
+	assert.Equal(t, "192.168.131.39:2817", d["client_port"])
+	assert.Equal(t, 0.086, d["request_processing_time"])
+	assert.Equal(t, int64(200), d["elb_status_code"])
+	assert.Equal(t, int64(57), d["sent_bytes"])
+	assert.Equal(t, "GET https://www.example.com:443/ HTTP/1.1", d["request"])
+	assert.Equal(t, "curl/7.46.0", d["user_agent"])
+	assert.Equal(t, int64(1), d["matched_rule_priority"])
+	assert.Equal(t, "authenticate,forward", d["actions_executed"])
+	_, ok := d["redirect_url"]
+	assert.False(t, ok)
+	assert.Equal(t, "200", d["target_status_code_list"])
+	assert.Equal(t, "TID_123", d["conn_trace_id"])
+	assert.Len(t, d, 26)
+
+	p, err = NewParser(conf.MapConf{parser.KeyW3CPreset: PresetELB})
+	require.NoError(t, err)
+	datas, _ = p.Parse([]string{
+		`2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 10.0.0.1:80 0.000073 0.001048 0.000057 200 200 0 29 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.38.0" - -`,
+	})
+	require.Len(t, datas, 1)
+	assert.Equal(t, 0.001048, datas[0]["backend_processing_time"])
+	assert.Equal(t, int64(29), datas[0]["sent_bytes"])
+	assert.Len(t, datas[0], 13)
+
+	p, err = NewParser(conf.MapConf{parser.KeyW3CPreset: PresetCloudFront})
+	require.NoError(t, err)
+	datas, _ = p.Parse([]string{
+		"#Version: 1.0",
+		"#Fields: date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer) cs(User-Agent) time-taken",
+		"2019-12-04\t21:02:31\tLAX1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\t-\tMozilla/5.0%20(Windows%20NT%2010.0)\t0.082",
+	})
+	require.Len(t, datas, 1)
+	assert.Equal(t, Data{
+		"date":            "2019-12-04",
+		"time":            "21:02:31",
+		"x_edge_location": "LAX1",
+		"sc_bytes":        int64(392),
+		"c_ip":            "192.0.2.100",
+		"cs_method":       "GET",
+		"cs_Host":         "d111111abcdef8.cloudfront.net",
+		"cs_uri_stem":     "/index.html",
+		"sc_status":       int64(200),
+		"cs_User_Agent":   "Mozilla/5.0%20(Windows%20NT%2010.0)",
+		"time_taken":      0.082,
+	}, datas[0])
+}
+
+func TestNewParserError(t *testing.T) {
+	_, err := NewParser(conf.MapConf{parser.KeyW3CPreset: "nlb"})
+	assert.Error(t, err)
+	_, err = NewParser(conf.MapConf{parser.KeyW3CSchema: "sc-status int"})
+	assert.Error(t, err)
+	_, err = NewParser(conf.MapConf{parser.KeyW3CSchema: "sc-status"})
+	assert.Error(t, err)
+}
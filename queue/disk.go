@@ -379,7 +379,7 @@ func (d *diskQueue) writeOne(data []byte) error {
 	totalBytes := int64(4 + dataLen)
 
 	if d.enableDiskUsedLimit && atomic.LoadInt64(&d.currentDiskUsedBytes)+totalBytes > d.maxDiskUsedBytes {
-		return fmt.Errorf("current disk used bytes has exceeded max disk used bytes: %d", d.maxDiskUsedBytes)
+		return fmt.Errorf("current disk used bytes has exceeded max disk used bytes %d: %w", d.maxDiskUsedBytes, ErrQueueFull)
 	}
 
 	var err error
@@ -1,9 +1,14 @@
 package queue
 
 import (
+	"errors"
+
 	. "github.com/longxiucai/logkit/utils/models"
 )
 
+// ErrQueueFull 表示队列已经达到容量上限，调用方可以稍后重试
+var ErrQueueFull = errors.New("queue is full")
+
 // BackendQueue represents the behavior for the secondary message
 // storage system
 type BackendQueue interface {
@@ -0,0 +1,349 @@
+package amqp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/amqputil"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// retryDelay 为连接失败或者断开后重新连接的等待时间
+const retryDelay = 5 * time.Second
+
+func init() {
+	reader.RegisterConstructor(reader.ModeAMQP, NewReader)
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan amqp091.Delivery
+	errChan  chan error
+	wg       sync.WaitGroup
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	url          string
+	host         string
+	tlsConfig    *tls.Config
+	exchange     string
+	exchangeType string
+	queue        string
+	durable      bool
+	bindingKeys  []string
+	prefetch     int
+
+	connLock sync.Mutex
+	conn     *amqp091.Connection
+
+	// pending 为已经读取、等待 SyncMeta 之后确认的消息
+	pendingLock sync.Mutex
+	pending     []amqp091.Delivery
+	// unread 为停止时已经接收但尚未读取的消息
+	unread []amqp091.Delivery
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	url, err := conf.GetString(reader.KeyAMQPURL)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := amqp091.ParseURI(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", reader.KeyAMQPURL, err)
+	}
+	queue, err := conf.GetString(reader.KeyAMQPQueue)
+	if err != nil {
+		return nil, err
+	}
+	exchange, _ := conf.GetStringOr(reader.KeyAMQPExchange, "")
+	exchangeType, _ := conf.GetStringOr(reader.KeyAMQPExchangeType, amqp091.ExchangeTopic)
+	durable, _ := conf.GetBoolOr(reader.KeyAMQPQueueDurable, true)
+	bindingKeys, _ := conf.GetStringListOr(reader.KeyAMQPBindingKeys, []string{"#"})
+	prefetch, _ := conf.GetIntOr(reader.KeyAMQPPrefetch, 1000)
+	if prefetch < 0 {
+		return nil, fmt.Errorf("%s must not be negative, got %d", reader.KeyAMQPPrefetch, prefetch)
+	}
+	tlsConfig, err := NewClientTLSConfig(conf, TLSKeys{CA: reader.KeyAMQPTLSCA, Cert: reader.KeyAMQPTLSCert, Key: reader.KeyAMQPTLSKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		meta:         meta,
+		status:       reader.StatusInit,
+		stopChan:     make(chan struct{}),
+		readChan:     make(chan amqp091.Delivery),
+		errChan:      make(chan error),
+		url:          url,
+		host:         amqputil.HostOf(uri),
+		tlsConfig:    tlsConfig,
+		exchange:     exchange,
+		exchangeType: exchangeType,
+		queue:        queue,
+		durable:      durable,
+		bindingKeys:  bindingKeys,
+		prefetch:     prefetch,
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "AMQPReader<" + r.host + "," + r.queue + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("amqp reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+// consume 建立连接，声明并绑定队列之后开始消费
+func (r *Reader) consume() (<-chan amqp091.Delivery, error) {
+	var conn *amqp091.Connection
+	var err error
+	if r.tlsConfig != nil {
+		conn, err = amqp091.DialTLS(r.url, r.tlsConfig)
+	} else {
+		conn, err = amqp091.Dial(r.url)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect failed: %v", err)
+	}
+	deliveries, err := r.declareAndConsume(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r.connLock.Lock()
+	r.conn = conn
+	r.connLock.Unlock()
+	return deliveries, nil
+}
+
+func (r *Reader) declareAndConsume(conn *amqp091.Connection) (<-chan amqp091.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("open channel failed: %v", err)
+	}
+	if err = ch.Qos(r.prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("set prefetch failed: %v", err)
+	}
+	if r.exchange != "" {
+		if err = ch.ExchangeDeclare(r.exchange, r.exchangeType, true, false, false, false, nil); err != nil {
+			return nil, fmt.Errorf("declare exchange %v failed: %v", r.exchange, err)
+		}
+	}
+	if _, err = ch.QueueDeclare(r.queue, r.durable, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("declare queue %v failed: %v", r.queue, err)
+	}
+	if r.exchange != "" {
+		for _, key := range r.bindingKeys {
+			if err = ch.QueueBind(r.queue, key, r.exchange, false, nil); err != nil {
+				return nil, fmt.Errorf("bind queue %v to exchange %v with key %q failed: %v", r.queue, r.exchange, key, err)
+			}
+		}
+	}
+	deliveries, err := ch.Consume(r.queue, "logkit_"+r.meta.RunnerName, false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("consume queue %v failed: %v", r.queue, err)
+	}
+	return deliveries, nil
+}
+
+func (r *Reader) closeConn() {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+
+		deliveries, err := r.consume()
+		if err != nil {
+			log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			select {
+			case <-r.stopChan:
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		if !r.forward(deliveries) {
+			return
+		}
+		// 连接或者 channel 断开后服务端会将未确认的消息重新入队，重新连接之后再次投递
+		log.Warningf("Runner[%v] %q channel closed, reconnecting", r.meta.RunnerName, r.Name())
+		r.closeConn()
+	}
+}
+
+// forward 将接收到的消息交给 ReadLine，返回 false 表示 reader 已经停止
+func (r *Reader) forward(deliveries <-chan amqp091.Delivery) bool {
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return true
+			}
+			select {
+			case r.readChan <- d:
+			case <-r.stopChan:
+				r.unread = append(r.unread, d)
+				return false
+			}
+		case <-r.stopChan:
+			return false
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.host + "/" + r.queue
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case d := <-r.readChan:
+		r.pendingLock.Lock()
+		r.pending = append(r.pending, d)
+		r.pendingLock.Unlock()
+		return string(d.Body), nil
+	case err := <-r.errChan:
+		return "", err
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// settle 按 channel 分组，对每组最后一条消息做一次 multiple 的确认或者拒绝，
+// 同一 channel 中的消息按投递顺序读取，因此之前的消息都会被一并处理
+func settle(deliveries []amqp091.Delivery, ack bool) error {
+	var lastErr error
+	for i, d := range deliveries {
+		if i+1 < len(deliveries) && deliveries[i+1].Acknowledger == d.Acknowledger {
+			continue
+		}
+		var err error
+		if ack {
+			err = d.Ack(true)
+		} else {
+			err = d.Nack(true, true)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// SyncMeta 确认已经读取的消息
+func (r *Reader) SyncMeta() {
+	r.pendingLock.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.pendingLock.Unlock()
+	if err := settle(pending, true); err != nil {
+		// 所在的 channel 已经关闭，消息已由服务端重新入队
+		log.Warningf("Runner[%v] %q ack messages failed, they will be redelivered: %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.wg.Wait()
+	// 未确认的消息立即重新入队
+	r.pendingLock.Lock()
+	settle(append(r.pending, r.unread...), false)
+	r.pending, r.unread = nil, nil
+	r.pendingLock.Unlock()
+	r.closeConn()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
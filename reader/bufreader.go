@@ -63,6 +63,7 @@ type BufReader struct {
 
 	Meta            *Meta // 存放offset的元信息
 	multiLineRegexp *regexp.Regexp
+	multiline       *MultilineAssembler
 
 	stats     StatsInfo
 	statsLock sync.RWMutex
@@ -156,6 +157,18 @@ func NewReaderSize(rd FileReader, meta *Meta, size int) (*BufReader, error) {
 }
 
 func (b *BufReader) SetMode(mode string, v interface{}) (err error) {
+	if mode == ReadModeMultiline {
+		mc, ok := v.(*MultilineConfig)
+		if !ok {
+			return fmt.Errorf("%v set mode error: %v is not *MultilineConfig type value", b.Name(), v)
+		}
+		assembler := NewMultilineAssembler(mc)
+		// 从 meta 中恢复的未完成事件交由 assembler 继续组装
+		assembler.Restore(string(b.FormMutiLine()))
+		b.mutiLineCache = make([]string, 0, 16)
+		b.multiline = assembler
+		return nil
+	}
 	b.multiLineRegexp, err = HeadPatternMode(mode, v)
 	if err != nil {
 		err = fmt.Errorf("%v set mode error %v ", b.Name(), err)
@@ -422,7 +435,35 @@ func (b *BufReader) ReadPattern() (string, error) {
 	}
 }
 
+// ReadMultiline 按照多行模式读取一条完整的事件，暂时没有完整的事件时返回空串
+// 未完成的事件超过 flush timeout 没有新的行输入时，作为完整事件返回
+func (b *BufReader) ReadMultiline() (string, error) {
+	for {
+		if event, ok := b.multiline.Next(); ok {
+			return event, nil
+		}
+		line, err := b.ReadString('\n')
+		if len(line) > 0 {
+			b.multiline.Push(line)
+			if err != nil {
+				event, _ := b.multiline.Next()
+				return event, err
+			}
+			continue
+		}
+		if b.multiline.Expired(time.Now()) {
+			b.multiline.Flush()
+			event, _ := b.multiline.Next()
+			return event, err
+		}
+		return "", err
+	}
+}
+
 func (b *BufReader) FormMutiLine() []byte {
+	if b.multiline != nil {
+		return []byte(b.multiline.Pending())
+	}
 	if len(b.mutiLineCache) <= 0 {
 		return make([]byte, 0)
 	}
@@ -448,7 +489,9 @@ func (b *BufReader) calcMutiLineCache() (ret int) {
 
 // ReadLine returns a string line as a normal Reader
 func (b *BufReader) ReadLine() (ret string, err error) {
-	if b.multiLineRegexp == nil {
+	if b.multiline != nil {
+		ret, err = b.ReadMultiline()
+	} else if b.multiLineRegexp == nil {
 		ret, err = b.ReadString('\n')
 		if os.IsNotExist(err) {
 			if b.lastErrShowTime.Add(5 * time.Second).Before(time.Now()) {
@@ -2,13 +2,21 @@
 package builtin
 
 import (
+	_ "github.com/longxiucai/logkit/reader/amqp"
 	_ "github.com/longxiucai/logkit/reader/autofile"
 	_ "github.com/longxiucai/logkit/reader/cloudtrail"
+	_ "github.com/longxiucai/logkit/reader/cloudwatch"
 	_ "github.com/longxiucai/logkit/reader/dirx"
 	_ "github.com/longxiucai/logkit/reader/elastic"
 	_ "github.com/longxiucai/logkit/reader/http"
+	_ "github.com/longxiucai/logkit/reader/k8sevents"
 	_ "github.com/longxiucai/logkit/reader/mongo"
+	_ "github.com/longxiucai/logkit/reader/mqtt"
+	_ "github.com/longxiucai/logkit/reader/nats"
+	_ "github.com/longxiucai/logkit/reader/netflow"
+	_ "github.com/longxiucai/logkit/reader/prometheus"
 	_ "github.com/longxiucai/logkit/reader/redis"
+	_ "github.com/longxiucai/logkit/reader/s3"
 	_ "github.com/longxiucai/logkit/reader/script"
 	_ "github.com/longxiucai/logkit/reader/socket"
 	_ "github.com/longxiucai/logkit/reader/sql"
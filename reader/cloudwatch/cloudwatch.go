@@ -0,0 +1,546 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// pageLimit 为每次请求的日志条数，分页的内容与其相关，不能随意修改，否则记录的读取进度会失效
+	pageLimit = 1000
+	// retryDelay 为列举日志流失败后重试的等待时间
+	retryDelay = 5 * time.Second
+	// recordsFileName 为记录各个日志流读取进度的文件名
+	recordsFileName = reader.DoneFileName + ".streams"
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeCloudWatch, NewReader)
+}
+
+// streamProgress 记录一个日志流的读取进度
+type streamProgress struct {
+	// NextToken 为当前页的分页 token，PageRead 为当前页中已经读取的条数
+	NextToken string `json:"next_token"`
+	PageRead  int    `json:"page_read"`
+	// Timestamp 为最后读取的日志的时间戳(毫秒)，Read 为该时间戳的日志中已经读取的条数
+	Timestamp int64 `json:"timestamp"`
+	Read      int   `json:"read"`
+}
+
+func recordsFile(meta *reader.Meta) string {
+	return filepath.Join(meta.DoneFilePath, recordsFileName)
+}
+
+// readRecords 读取各个日志流的读取进度，文件不存在时返回空记录
+func readRecords(meta *reader.Meta) (records map[string]streamProgress, err error) {
+	err = reader.ReadJSONRecords(recordsFile(meta), &records)
+	if records == nil {
+		records = make(map[string]streamProgress)
+	}
+	return
+}
+
+// item 为读取到的一条日志，line 为空表示只需要更新读取进度
+type item struct {
+	line     string
+	stream   string
+	progress streamProgress
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan item
+	errChan  chan error
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	client        cloudwatchlogsiface.CloudWatchLogsAPI
+	group         string
+	streams       []string
+	prefix        string
+	filterPattern string
+	// startTime 为 read_from 为 newest 时首次读取日志流的起始时间(毫秒)
+	startTime int64
+	interval  time.Duration
+	delay     time.Duration
+	limiter   *time.Ticker
+
+	// progress 为读取协程已经输出的读取进度，records 为已经读取的进度，在 SyncMeta 时写入 meta
+	progress    map[string]streamProgress
+	recordsLock sync.Mutex
+	records     map[string]streamProgress
+
+	source string
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	group, err := conf.GetString(reader.KeyLogGroupName)
+	if err != nil {
+		return nil, err
+	}
+	streams, _ := conf.GetStringListOr(reader.KeyLogStreamNames, nil)
+	prefix, _ := conf.GetStringOr(reader.KeyLogStreamNamePrefix, "")
+	filterPattern, _ := conf.GetStringOr(reader.KeyFilterPattern, "")
+	whence, _ := conf.GetStringOr(reader.KeyWhence, reader.WhenceOldest)
+	intervalStr, _ := conf.GetStringOr(reader.KeyCollectInterval, "1m")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyCollectInterval, intervalStr, err)
+	}
+	delayStr, _ := conf.GetStringOr(reader.KeyDelay, "0s")
+	delay, err := time.ParseDuration(delayStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyDelay, delayStr, err)
+	}
+	rateLimit, _ := conf.GetIntOr(reader.KeyRateLimit, 5)
+	if rateLimit <= 0 {
+		rateLimit = 5
+	}
+	client, err := newClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readRecords(meta)
+	if err != nil {
+		log.Errorf("Runner[%v] %v stream records is corrupted err: %v, omit it", meta.RunnerName, recordsFile(meta), err)
+		records = make(map[string]streamProgress)
+	}
+	progress := make(map[string]streamProgress, len(records))
+	for stream, p := range records {
+		progress[stream] = p
+	}
+
+	r := &Reader{
+		meta:          meta,
+		status:        reader.StatusInit,
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan item),
+		errChan:       make(chan error),
+		client:        client,
+		group:         group,
+		streams:       streams,
+		prefix:        prefix,
+		filterPattern: filterPattern,
+		interval:      interval,
+		delay:         delay,
+		limiter:       time.NewTicker(time.Second / time.Duration(rateLimit)),
+		progress:      progress,
+		records:       records,
+	}
+	if whence == reader.WhenceNewest {
+		r.startTime = toMillis(time.Now())
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r, nil
+}
+
+// newClient 按照 role_arn、ak/sk、profile 与鉴权文件、默认凭证链(环境变量、默认鉴权文件、EC2 instance profile)的顺序鉴权
+func newClient(conf conf.MapConf) (*cloudwatchlogs.CloudWatchLogs, error) {
+	region, err := conf.GetString(reader.KeyRegion)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, _ := conf.GetStringOr(reader.KeyCloudWatchEndpoint, "")
+	roleArn, _ := conf.GetStringOr(reader.KeyRoleArn, "")
+	ak, _ := conf.GetStringOr(reader.KeyAWSAccessKey, "")
+	sk, _ := conf.GetStringOr(reader.KeyAWSSecretKey, "")
+	token, _ := conf.GetStringOr(reader.KeyAWSToken, "")
+	profile, _ := conf.GetStringOr(reader.KeyAWSProfile, "")
+	sharedFile, _ := conf.GetStringOr(reader.KeySharedCredentialFile, "")
+
+	cfg := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint)
+	}
+	switch {
+	case ak != "" || sk != "":
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(ak, sk, token))
+	case profile != "" || sharedFile != "":
+		cfg = cfg.WithCredentials(credentials.NewSharedCredentials(sharedFile, profile))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create cloudwatch session failed: %v", err)
+	}
+	if roleArn != "" {
+		return cloudwatchlogs.New(sess, aws.NewConfig().WithCredentials(stscreds.NewCredentials(sess, roleArn))), nil
+	}
+	return cloudwatchlogs.New(sess), nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "CloudWatchReader<" + r.group + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("cloudwatch reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	for {
+		wait := r.interval
+		streams, err := r.listStreams()
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			log.Errorf("Runner[%v] %q list log streams failed: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			wait = retryDelay
+		}
+		for _, stream := range streams {
+			var err error
+			if r.filterPattern != "" {
+				err = r.filterStream(stream)
+			} else {
+				err = r.getStream(stream)
+			}
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			if err != nil {
+				// 单个日志流读取失败不影响其他日志流
+				log.Errorf("Runner[%v] %q read log stream %v failed: %v", r.meta.RunnerName, r.Name(), stream, err)
+				r.setStatsError(err.Error())
+			}
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// throttle 按照 ratelimit 限制请求频率，返回 false 表示 reader 已经停止
+func (r *Reader) throttle() bool {
+	select {
+	case <-r.limiter.C:
+		return true
+	case <-r.stopChan:
+		return false
+	}
+}
+
+func (r *Reader) listStreams() ([]string, error) {
+	if len(r.streams) > 0 {
+		return r.streams, nil
+	}
+	input := &cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: aws.String(r.group)}
+	if r.prefix != "" {
+		input.LogStreamNamePrefix = aws.String(r.prefix)
+	}
+	var streams []string
+	for {
+		if !r.throttle() {
+			return nil, r.ctx.Err()
+		}
+		out, err := r.client.DescribeLogStreamsWithContext(r.ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range out.LogStreams {
+			streams = append(streams, aws.StringValue(s.LogStreamName))
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return streams, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// getStream 使用 GetLogEvents 按顺序读取日志流，记录当前页的 token 以及页内已经读取的条数
+func (r *Reader) getStream(stream string) error {
+	p := r.progress[stream]
+	end := r.end()
+	for {
+		input := &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(r.group),
+			LogStreamName: aws.String(stream),
+			StartFromHead: aws.Bool(true),
+			Limit:         aws.Int64(pageLimit),
+		}
+		if p.NextToken != "" {
+			input.NextToken = aws.String(p.NextToken)
+		} else if start := r.start(p); start > 0 {
+			input.StartTime = aws.Int64(start)
+		}
+		if end > 0 {
+			input.EndTime = aws.Int64(end)
+		}
+		if !r.throttle() {
+			return nil
+		}
+		out, err := r.client.GetLogEventsWithContext(r.ctx, input)
+		if err != nil {
+			if isInvalidToken(err) && p.NextToken != "" {
+				// token 已经失效，从最后读取的时间戳重新开始，跳过该时间戳中已经读取的日志
+				log.Warningf("Runner[%v] %q token of log stream %v is invalid, restart from timestamp %v", r.meta.RunnerName, r.Name(), stream, p.Timestamp)
+				p = streamProgress{Timestamp: p.Timestamp, Read: p.Read}
+				continue
+			}
+			return err
+		}
+		token, pageRead := p.NextToken, p.PageRead
+		lastTimestamp, read := p.Timestamp, p.Read
+		skipped := 0
+		for _, e := range out.Events {
+			ts := aws.Int64Value(e.Timestamp)
+			if end > 0 && ts > end {
+				// 保留当前页的进度，下次从这条日志继续读取
+				return nil
+			}
+			if token != "" {
+				// 跳过当前页中已经读取的日志
+				if skipped < pageRead {
+					skipped++
+					continue
+				}
+				p.PageRead++
+			} else if ts < lastTimestamp || (ts == lastTimestamp && skipped < read) {
+				if ts == lastTimestamp {
+					skipped++
+				}
+				continue
+			}
+			if ts == p.Timestamp {
+				p.Read++
+			} else {
+				p.Timestamp, p.Read = ts, 1
+			}
+			if !r.send(item{line: aws.StringValue(e.Message), stream: stream, progress: p}) {
+				return nil
+			}
+		}
+		next := aws.StringValue(out.NextForwardToken)
+		if next == "" || next == p.NextToken {
+			// 已经读取到日志流的末尾
+			return nil
+		}
+		p = streamProgress{NextToken: next, Timestamp: p.Timestamp, Read: p.Read}
+		if !r.send(item{stream: stream, progress: p}) {
+			return nil
+		}
+	}
+}
+
+// filterStream 使用 FilterLogEvents 读取日志流中符合条件的日志，记录最后的时间戳以及该时间戳的日志中已经读取的条数
+func (r *Reader) filterStream(stream string) error {
+	p := r.progress[stream]
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:   aws.String(r.group),
+		LogStreamNames: []*string{aws.String(stream)},
+		FilterPattern:  aws.String(r.filterPattern),
+		Limit:          aws.Int64(pageLimit),
+	}
+	if start := r.start(p); start > 0 {
+		input.StartTime = aws.Int64(start)
+	}
+	if end := r.end(); end > 0 {
+		input.EndTime = aws.Int64(end)
+	}
+	lastTimestamp, read := p.Timestamp, p.Read
+	skipped := 0
+	for {
+		if !r.throttle() {
+			return nil
+		}
+		out, err := r.client.FilterLogEventsWithContext(r.ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, e := range out.Events {
+			ts := aws.Int64Value(e.Timestamp)
+			if ts < lastTimestamp || (ts == lastTimestamp && skipped < read) {
+				skipped++
+				continue
+			}
+			if ts == p.Timestamp {
+				p.Read++
+			} else {
+				p.Timestamp, p.Read = ts, 1
+			}
+			if !r.send(item{line: aws.StringValue(e.Message), stream: stream, progress: p}) {
+				return nil
+			}
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// start 返回没有分页 token 时的起始时间
+func (r *Reader) start(p streamProgress) int64 {
+	if p.Timestamp > 0 {
+		return p.Timestamp
+	}
+	return r.startTime
+}
+
+// end 返回设置了 delay 时的结束时间，没有设置时返回 0
+func (r *Reader) end() int64 {
+	if r.delay <= 0 {
+		return 0
+	}
+	return toMillis(time.Now().Add(-r.delay))
+}
+
+// isInvalidToken 判断是否为分页 token 失效的错误，其他参数错误同样返回 InvalidParameterException
+func isInvalidToken(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == cloudwatchlogs.ErrCodeInvalidParameterException &&
+			strings.Contains(strings.ToLower(aerr.Message()), "nexttoken")
+	}
+	return false
+}
+
+// send 将日志交给 ReadLine，并记录读取协程的进度，返回 false 表示 reader 已经停止
+func (r *Reader) send(it item) bool {
+	select {
+	case r.readChan <- it:
+		r.progress[it.stream] = it.progress
+		return true
+	case <-r.stopChan:
+		return false
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.source
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case it := <-r.readChan:
+			r.recordsLock.Lock()
+			r.records[it.stream] = it.progress
+			r.recordsLock.Unlock()
+			if it.line != "" {
+				r.source = r.group + "/" + it.stream
+				return it.line, nil
+			}
+		case err := <-r.errChan:
+			return "", err
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 记录各个日志流已经读取的进度
+func (r *Reader) SyncMeta() {
+	r.recordsLock.Lock()
+	defer r.recordsLock.Unlock()
+	if err := reader.WriteJSONRecords(recordsFile(r.meta), r.records); err != nil {
+		log.Errorf("Runner[%v] %q write stream records failed: %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	defer r.limiter.Stop()
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		r.cancel()
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.cancel()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
@@ -0,0 +1,272 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+type event struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// fakeLogs 模拟 CloudWatch Logs 的 JSON 接口，每页最多返回 pageSize 条日志
+type fakeLogs struct {
+	mu       sync.Mutex
+	pageSize int
+	streams  map[string][]event
+	// expired 为已经失效的分页 token
+	expired map[string]bool
+}
+
+func (f *fakeLogs) add(stream string, ts int64, msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streams[stream] = append(f.streams[stream], event{Timestamp: ts, Message: msg})
+}
+
+func (f *fakeLogs) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var in struct {
+		LogStreamName       string
+		LogStreamNames      []string
+		LogStreamNamePrefix string
+		NextToken           string
+		StartTime           int64
+		EndTime             int64
+		FilterPattern       string
+	}
+	if err := jsoniter.NewDecoder(req.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var out interface{}
+	switch strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "Logs_20140328.") {
+	case "DescribeLogStreams":
+		var streams []map[string]string
+		for name := range f.streams {
+			if strings.HasPrefix(name, in.LogStreamNamePrefix) {
+				streams = append(streams, map[string]string{"logStreamName": name})
+			}
+		}
+		out = map[string]interface{}{"logStreams": streams}
+	case "GetLogEvents":
+		if f.expired[in.NextToken] {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"InvalidParameterException","message":"The specified nextToken is invalid."}`)
+			return
+		}
+		events := f.streams[in.LogStreamName]
+		if in.EndTime > 0 {
+			n := 0
+			for n < len(events) && events[n].Timestamp <= in.EndTime {
+				n++
+			}
+			events = events[:n]
+		}
+		start := 0
+		if in.NextToken != "" {
+			start, _ = strconv.Atoi(strings.TrimPrefix(in.NextToken, "f/"))
+		} else {
+			for start < len(events) && events[start].Timestamp < in.StartTime {
+				start++
+			}
+		}
+		end := start + f.pageSize
+		if end > len(events) {
+			end = len(events)
+		}
+		out = map[string]interface{}{
+			"events":           events[start:end],
+			"nextForwardToken": fmt.Sprintf("f/%d", end),
+		}
+	case "FilterLogEvents":
+		var matched []event
+		for _, e := range f.streams[in.LogStreamNames[0]] {
+			if e.Timestamp >= in.StartTime && (in.EndTime == 0 || e.Timestamp <= in.EndTime) && strings.Contains(e.Message, in.FilterPattern) {
+				matched = append(matched, e)
+			}
+		}
+		start, _ := strconv.Atoi(in.NextToken)
+		end := start + f.pageSize
+		resp := map[string]interface{}{}
+		if end < len(matched) {
+			resp["nextToken"] = strconv.Itoa(end)
+		} else {
+			end = len(matched)
+		}
+		resp["events"] = matched[start:end]
+		out = resp
+	default:
+		http.Error(w, "unknown target", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	jsoniter.NewEncoder(w).Encode(out)
+}
+
+func newConf(t *testing.T, endpoint string) conf.MapConf {
+	return conf.MapConf{
+		reader.KeyMetaPath:            t.TempDir(),
+		reader.KeyMode:                reader.ModeCloudWatch,
+		models.KeyRunnerName:          "TestCloudWatchReader",
+		reader.KeyRegion:              "us-east-1",
+		reader.KeyAWSAccessKey:        "ak",
+		reader.KeyAWSSecretKey:        "sk",
+		reader.KeyCloudWatchEndpoint:  endpoint,
+		reader.KeyLogGroupName:        "group",
+		reader.KeyLogStreamNamePrefix: "app",
+		reader.KeyCollectInterval:     "50ms",
+		reader.KeyRateLimit:           "1000",
+	}
+}
+
+func startReader(t *testing.T, c conf.MapConf) reader.Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(reader.DaemonReader).Start())
+	return r
+}
+
+func readLines(t *testing.T, r reader.Reader, n int) []string {
+	var lines []string
+	for i := 0; i < 20 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestGetLogEvents(t *testing.T) {
+	logs := &fakeLogs{pageSize: 2, streams: map[string][]event{"other": {{1, "x"}}}}
+	for i := 1; i <= 5; i++ {
+		logs.add("app-1", int64(i), strconv.Itoa(i))
+	}
+	server := httptest.NewServer(logs)
+	defer server.Close()
+	c := newConf(t, server.URL)
+
+	r := startReader(t, c)
+	assert.Equal(t, []string{"1", "2", "3"}, readLines(t, r, 3))
+	assert.Equal(t, "group/app-1", r.Source())
+	r.SyncMeta()
+	require.NoError(t, r.Close())
+
+	// 从第二页的第二条继续读取
+	r = startReader(t, c)
+	defer r.Close()
+	assert.Equal(t, []string{"4", "5"}, readLines(t, r, 2))
+	logs.add("app-1", 6, "6")
+	assert.Equal(t, []string{"6"}, readLines(t, r, 1))
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+}
+
+func TestFilterLogEvents(t *testing.T) {
+	logs := &fakeLogs{pageSize: 2, streams: map[string][]event{}}
+	logs.add("app-1", 1, "ERROR a")
+	logs.add("app-1", 2, "INFO b")
+	logs.add("app-1", 2, "ERROR c")
+	logs.add("app-1", 2, "ERROR d")
+	logs.add("app-1", 3, "ERROR e")
+	server := httptest.NewServer(logs)
+	defer server.Close()
+	c := newConf(t, server.URL)
+	c[reader.KeyFilterPattern] = "ERROR"
+
+	r := startReader(t, c)
+	assert.Equal(t, []string{"ERROR a", "ERROR c"}, readLines(t, r, 2))
+	r.SyncMeta()
+	require.NoError(t, r.Close())
+
+	// 相同时间戳中已经读取的日志不会重复读取
+	r = startReader(t, c)
+	defer r.Close()
+	assert.Equal(t, []string{"ERROR d", "ERROR e"}, readLines(t, r, 2))
+	logs.add("app-1", 3, "ERROR f")
+	assert.Equal(t, []string{"ERROR f"}, readLines(t, r, 1))
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+}
+
+func TestReadFromNewest(t *testing.T) {
+	logs := &fakeLogs{pageSize: 2, streams: map[string][]event{}}
+	logs.add("app-1", 1, "old")
+	server := httptest.NewServer(logs)
+	defer server.Close()
+	c := newConf(t, server.URL)
+	c[reader.KeyWhence] = reader.WhenceNewest
+
+	r := startReader(t, c)
+	defer r.Close()
+	logs.add("app-1", 1<<62, "new")
+	assert.Equal(t, []string{"new"}, readLines(t, r, 1))
+}
+
+func TestGetLogEventsInvalidToken(t *testing.T) {
+	logs := &fakeLogs{pageSize: 2, streams: map[string][]event{}, expired: map[string]bool{}}
+	logs.add("app-1", 1, "a")
+	logs.add("app-1", 2, "b")
+	logs.add("app-1", 2, "c")
+	logs.add("app-1", 2, "d")
+	logs.add("app-1", 3, "e")
+	server := httptest.NewServer(logs)
+	defer server.Close()
+	c := newConf(t, server.URL)
+
+	r := startReader(t, c)
+	assert.Equal(t, []string{"a", "b", "c"}, readLines(t, r, 3))
+	r.SyncMeta()
+	require.NoError(t, r.Close())
+
+	// token 失效后从最后的时间戳重新读取，该时间戳中已经读取的日志不会重复读取
+	logs.mu.Lock()
+	logs.expired["f/2"] = true
+	logs.mu.Unlock()
+	r = startReader(t, c)
+	defer r.Close()
+	assert.Equal(t, []string{"d", "e"}, readLines(t, r, 2))
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+}
+
+func TestGetLogEventsDelay(t *testing.T) {
+	logs := &fakeLogs{pageSize: 2, streams: map[string][]event{}}
+	logs.add("app-1", 1, "old")
+	logs.add("app-1", toMillis(time.Now()), "new")
+	server := httptest.NewServer(logs)
+	defer server.Close()
+	c := newConf(t, server.URL)
+	c[reader.KeyDelay] = "1h"
+
+	r := startReader(t, c)
+	defer r.Close()
+	assert.Equal(t, []string{"old"}, readLines(t, r, 1))
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -21,10 +20,11 @@ import (
 )
 
 var (
-	_ reader.DaemonReader = &Reader{}
-	_ reader.StatsReader  = &Reader{}
-	_ reader.Reader       = &Reader{}
-	_ Resetable           = &Reader{}
+	_ reader.DaemonReader     = &Reader{}
+	_ reader.StatsReader      = &Reader{}
+	_ reader.Reader           = &Reader{}
+	_ reader.ExtraStatsReader = &Reader{}
+	_ Resetable               = &Reader{}
 )
 
 func init() {
@@ -49,8 +49,11 @@ type Reader struct {
 	statsLock sync.RWMutex
 
 	headRegexp  *regexp.Regexp
+	multiline   *reader.MultilineConfig
 	currentFile string
 	dirReaders  *dirReaders
+	discoverer  *reader.PathDiscoverer
+	matcher     *reader.PathMatcher
 
 	// 以下为传入参数
 	logPathPattern     string
@@ -65,6 +68,8 @@ type Reader struct {
 	validFilesRegex    string
 	whence             string
 	bufferSize         int
+	discoveryMode      string
+	maxWatches         int
 }
 
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
@@ -102,6 +107,18 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	whence, _ := conf.GetStringOr(reader.KeyWhence, reader.WhenceOldest)
 	bufferSize, _ := conf.GetIntOr(reader.KeyBufSize, reader.DefaultBufSize)
 
+	discoveryMode, _ := conf.GetStringOr(reader.KeyDiscoveryMode, reader.DiscoveryModePoll)
+	if discoveryMode != reader.DiscoveryModePoll && discoveryMode != reader.DiscoveryModeInotify {
+		return nil, fmt.Errorf("%q value %q is not supported", reader.KeyDiscoveryMode, discoveryMode)
+	}
+	maxWatches, _ := conf.GetIntOr(reader.KeyMaxWatches, reader.DefaultMaxWatches)
+
+	logPathPattern = strings.TrimSuffix(logPathPattern, "/")
+	matcher, err := reader.NewPathMatcherWithConf(logPathPattern, conf)
+	if err != nil {
+		return nil, err
+	}
+
 	_, _, bufsize, err := meta.ReadBufMeta()
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -136,7 +153,7 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		msgChan:            make(chan message),
 		errChan:            make(chan error),
 		dirReaders:         newDirReaders(meta, expire, cachedLines),
-		logPathPattern:     strings.TrimSuffix(logPathPattern, "/"),
+		logPathPattern:     logPathPattern,
 		statInterval:       statInterval,
 		expire:             expire,
 		submetaExpire:      submetaExpire,
@@ -148,6 +165,9 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		validFilesRegex:    validFilesRegex,
 		whence:             whence,
 		bufferSize:         bufferSize,
+		discoveryMode:      discoveryMode,
+		maxWatches:         maxWatches,
+		matcher:            matcher,
 	}, nil
 }
 
@@ -164,6 +184,14 @@ func (r *Reader) Name() string {
 }
 
 func (r *Reader) SetMode(mode string, v interface{}) error {
+	if mode == reader.ReadModeMultiline {
+		mc, ok := v.(*reader.MultilineConfig)
+		if !ok {
+			return fmt.Errorf("%v is not *reader.MultilineConfig type value", v)
+		}
+		r.multiline = mc
+		return nil
+	}
 	reg, err := reader.HeadPatternMode(mode, v)
 	if err != nil {
 		return fmt.Errorf("get head pattern mode: %v", err)
@@ -192,14 +220,21 @@ func (r *Reader) sendError(err error) {
 	r.errChan <- err
 }
 
-func (r *Reader) statLogPath() {
-	// 达到最大打开文件数时不再追踪
+func (r *Reader) reachMaxOpenFiles() bool {
 	if r.dirReaders.Num() >= r.maxOpenFiles {
 		log.Warningf("Runner[%v] has met 'maxOpenFiles' limit %v, stat new log ignored", r.meta.RunnerName, r.maxOpenFiles)
+		return true
+	}
+	return false
+}
+
+func (r *Reader) statLogPath() {
+	// 达到最大打开文件数时不再追踪
+	if r.reachMaxOpenFiles() {
 		return
 	}
 
-	matches, err := filepath.Glob(r.logPathPattern)
+	matches, err := r.matcher.Glob()
 	if err != nil {
 		errMsg := fmt.Sprintf("Runner[%v] stat log path failed: %v", r.meta.RunnerName, err)
 		log.Error(errMsg)
@@ -207,11 +242,21 @@ func (r *Reader) statLogPath() {
 		return
 	}
 	if len(matches) == 0 {
-		log.Infof("Runner[%v] no match found after stated log path %q", r.meta.RunnerName, r.logPathPattern)
+		log.Infof("Runner[%v] no match found after stated log path %q", r.meta.RunnerName, r.matcher)
 		return
 	}
-	log.Infof("Runner[%v] %d matches found after stated log path %q: %v", r.meta.RunnerName, len(matches), r.logPathPattern, matches)
+	log.Infof("Runner[%v] %d matches found after stated log path %q: %v", r.meta.RunnerName, len(matches), r.matcher, matches)
+
+	newPaths := r.addLogPaths(matches)
+	if len(newPaths) > 0 {
+		log.Infof("Runner[%v] stat log path found %d new log paths: %v", r.meta.RunnerName, len(newPaths), newPaths)
+	} else {
+		log.Infof("Runner[%v] stat log path has not found any new log path", r.meta.RunnerName)
+	}
+}
 
+// addLogPaths 为尚未追踪的匹配目录创建 dirReader，返回新增的目录
+func (r *Reader) addLogPaths(matches []string) []string {
 	var newPaths []string
 	for _, m := range matches {
 		logPath, fi, err := GetRealPath(m)
@@ -263,6 +308,14 @@ func (r *Reader) statLogPath() {
 				r.setStatsError(errMsg)
 			}
 		}
+		if r.multiline != nil {
+			err = dr.br.SetMode(reader.ReadModeMultiline, r.multiline)
+			if err != nil {
+				errMsg := fmt.Sprintf("Runner[%v] set mode for log path %q failed: %v", r.meta.RunnerName, logPath, err)
+				log.Error(errMsg)
+				r.setStatsError(errMsg)
+			}
+		}
 		newPaths = append(newPaths, logPath)
 
 		if r.hasStopped() {
@@ -272,11 +325,49 @@ func (r *Reader) statLogPath() {
 
 		go dr.Run()
 	}
-	if len(newPaths) > 0 {
-		log.Infof("Runner[%v] stat log path found %d new log paths: %v", r.meta.RunnerName, len(newPaths), newPaths)
-	} else {
-		log.Infof("Runner[%v] stat log path has not found any new log path", r.meta.RunnerName)
+	return newPaths
+}
+
+// waitForStat 等待下一次全量扫描，期间处理文件系统事件感知到的新目录，reader 停止时返回 false
+func (r *Reader) waitForStat(ticker *time.Ticker) bool {
+	var found <-chan string
+	var rescan <-chan struct{}
+	if r.discoverer != nil {
+		found = r.discoverer.Found()
+		rescan = r.discoverer.Rescan()
+	}
+	for {
+		select {
+		case <-r.stopChan:
+			return false
+		case <-ticker.C:
+			return true
+		case <-rescan:
+			return true
+		case path := <-found:
+			if r.reachMaxOpenFiles() {
+				continue
+			}
+			if newPaths := r.addLogPaths([]string{path}); len(newPaths) > 0 {
+				log.Infof("Runner[%v] inotify discovery found new log path: %v", r.meta.RunnerName, newPaths)
+			}
+		}
+	}
+}
+
+// startDiscoverer 启动事件驱动的目录发现，失败时退化为按 stat_interval 轮询
+func (r *Reader) startDiscoverer() {
+	discoverer, err := reader.NewPathDiscoverer(r.matcher, r.maxWatches)
+	if err == nil {
+		err = discoverer.Start()
 	}
+	if err != nil {
+		errMsg := fmt.Sprintf("Runner[%v] start inotify discovery failed: %v, fallback to poll every %v", r.meta.RunnerName, err, r.statInterval)
+		log.Error(errMsg)
+		r.setStatsError(errMsg)
+		return
+	}
+	r.discoverer = discoverer
 }
 
 func (r *Reader) Start() error {
@@ -287,6 +378,10 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
+	if r.discoveryMode == reader.DiscoveryModeInotify {
+		r.startDiscoverer()
+	}
+
 	go func() {
 		ticker := time.NewTicker(r.statInterval)
 		defer ticker.Stop()
@@ -294,12 +389,10 @@ func (r *Reader) Start() error {
 			r.dirReaders.checkExpiredDirs()
 			r.statLogPath()
 
-			select {
-			case <-r.stopChan:
+			if !r.waitForStat(ticker) {
 				atomic.StoreInt32(&r.status, reader.StatusStopped)
 				log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
 				return
-			case <-ticker.C:
 			}
 		}
 	}()
@@ -357,6 +450,15 @@ func (r *Reader) Status() StatsInfo {
 	return r.stats
 }
 
+func (r *Reader) ExtraStats() map[string]interface{} {
+	if r.discoverer == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		reader.StatsKeyWatches: r.discoverer.WatchCount(),
+	}
+}
+
 // SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复
 func (r *Reader) SyncMeta() {
 	data, err := r.dirReaders.SyncMeta()
@@ -383,6 +485,9 @@ func (r *Reader) Close() error {
 	}
 	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
 	close(r.stopChan)
+	if r.discoverer != nil {
+		r.discoverer.Close()
+	}
 
 	r.dirReaders.Close()
 	r.SyncMeta()
@@ -0,0 +1,262 @@
+package reader
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	log "k8s.io/klog/v2"
+)
+
+// KeyDiscoveryMode 的可选项
+const (
+	// DiscoveryModePoll 每隔 stat_interval 展开一次 log_path 模式串感知新文件
+	DiscoveryModePoll = "poll"
+	// DiscoveryModeInotify 通过文件系统事件感知新文件，stat_interval 仅作为兜底的全量扫描间隔
+	DiscoveryModeInotify = "inotify"
+)
+
+const (
+	DefaultMaxWatches = 8192
+
+	// StatsKeyWatches 为事件驱动模式下正在使用的 watch 数量在 reader 额外统计信息中的键名
+	StatsKeyWatches = "watches"
+)
+
+var ErrMaxWatchesExceeded = errors.New("max watches exceeded")
+
+// watchEvent 是不同平台文件系统事件的统一表示
+type watchEvent struct {
+	path     string
+	isDir    bool
+	created  bool // 新建或者移入
+	removed  bool // 被监听的目录本身被删除或者移走
+	overflow bool // 事件队列溢出，有事件丢失
+}
+
+// watchBackend 负责监听目录的直接子项变化，Linux 下直接使用 inotify，其他平台使用 fsnotify
+type watchBackend interface {
+	add(dir string) error
+	remove(dir string) error
+	count() int
+	events() <-chan watchEvent
+	errors() <-chan error
+	close() error
+}
+
+// PathDiscoverer 通过文件系统事件感知与 PathMatcher 匹配的新路径，用于替代 tailx 和 dirx 的定时 stat 轮询
+// 仅对可能包含匹配路径的目录添加 watch，当事件队列溢出或者 watch 数量达到上限时，通过 Rescan 通知上层进行全量扫描
+type PathDiscoverer struct {
+	matcher    *PathMatcher
+	roots      []string
+	maxWatches int
+
+	backend watchBackend
+	status  int32
+
+	foundChan  chan string
+	rescanChan chan struct{}
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewPathDiscoverer 创建一个针对 matcher 的 PathDiscoverer，maxWatches 小于等于 0 时使用默认值
+func NewPathDiscoverer(matcher *PathMatcher, maxWatches int) (*PathDiscoverer, error) {
+	if maxWatches <= 0 {
+		maxWatches = DefaultMaxWatches
+	}
+	var roots []string
+	for _, root := range matcher.Roots() {
+		// 根目录不存在时向上找到已存在的目录进行监听，以便感知根目录的创建
+		for !isDir(root) {
+			parent := filepath.Dir(root)
+			if parent == root {
+				break
+			}
+			root = parent
+		}
+		roots = append(roots, root)
+	}
+	backend, err := newWatchBackend()
+	if err != nil {
+		return nil, err
+	}
+	return &PathDiscoverer{
+		matcher:    matcher,
+		roots:      roots,
+		maxWatches: maxWatches,
+		backend:    backend,
+		status:     StatusInit,
+		foundChan:  make(chan string, 1024),
+		rescanChan: make(chan struct{}, 1),
+		stopChan:   make(chan struct{}),
+	}, nil
+}
+
+// Start 添加初始的 watch 并开始处理事件，已存在的路径需要由上层自行扫描
+func (d *PathDiscoverer) Start() error {
+	if !atomic.CompareAndSwapInt32(&d.status, StatusInit, StatusRunning) {
+		return errors.New("path discoverer has already started")
+	}
+	d.watchRoots()
+	d.wg.Add(1)
+	go d.run()
+	return nil
+}
+
+// Found 返回新感知到的匹配路径
+func (d *PathDiscoverer) Found() <-chan string {
+	return d.foundChan
+}
+
+// Rescan 在有事件可能丢失时收到通知，上层此时应进行一次全量扫描
+func (d *PathDiscoverer) Rescan() <-chan struct{} {
+	return d.rescanChan
+}
+
+// WatchCount 返回当前正在使用的 watch 数量
+func (d *PathDiscoverer) WatchCount() int {
+	return d.backend.count()
+}
+
+func (d *PathDiscoverer) Close() error {
+	if !atomic.CompareAndSwapInt32(&d.status, StatusRunning, StatusStopped) {
+		atomic.StoreInt32(&d.status, StatusStopped)
+		return d.backend.close()
+	}
+	close(d.stopChan)
+	err := d.backend.close()
+	d.wg.Wait()
+	return err
+}
+
+func (d *PathDiscoverer) run() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stopChan:
+			return
+		case ev, ok := <-d.backend.events():
+			if !ok {
+				return
+			}
+			d.handle(ev)
+		case err, ok := <-d.backend.errors():
+			if !ok {
+				return
+			}
+			log.Warningf("path discoverer of %q got error: %v, will rescan", d.matcher, err)
+			d.notifyRescan()
+		}
+	}
+}
+
+func (d *PathDiscoverer) handle(ev watchEvent) {
+	switch {
+	case ev.overflow:
+		log.Warningf("path discoverer of %q event queue overflowed, will rescan", d.matcher)
+		d.watchRoots()
+		d.notifyRescan()
+	case ev.removed:
+		d.backend.remove(ev.path)
+	case ev.created:
+		isDir, realDir, ok := d.matcher.statEntry(ev.path)
+		if !ok {
+			return
+		}
+		if isDir && d.matcher.CanContain(ev.path) {
+			// 目录中可能在添加 watch 之前就已经有了新文件，需要一并扫描
+			visited := make(map[string]struct{})
+			if realDir != "" {
+				visited[realDir] = struct{}{}
+			}
+			if err := d.watchTree(ev.path, true, visited); err != nil {
+				log.Warningf("path discoverer of %q add watches failed: %v", d.matcher, err)
+				d.notifyRescan()
+			}
+		}
+		if d.matcher.Match(ev.path) {
+			d.emit(ev.path)
+		}
+	}
+}
+
+func (d *PathDiscoverer) watchRoots() {
+	visited := make(map[string]struct{})
+	for _, root := range d.roots {
+		if err := d.watchTree(root, false, visited); err != nil {
+			log.Warningf("path discoverer of %q add watches failed: %v", d.matcher, err)
+			if err == ErrMaxWatchesExceeded {
+				d.notifyRescan()
+				return
+			}
+		}
+	}
+}
+
+// watchTree 对 dir 及其下可能包含匹配路径的目录添加 watch，emit 为 true 时同时发送其中已存在的匹配路径
+func (d *PathDiscoverer) watchTree(dir string, emit bool, visited map[string]struct{}) error {
+	if !d.matcher.CanContain(dir) {
+		return nil
+	}
+	if d.backend.count() >= d.maxWatches {
+		return ErrMaxWatchesExceeded
+	}
+	if err := d.backend.add(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range files {
+		path := filepath.Join(dir, fi.Name())
+		isDir, realDir, ok := d.matcher.statEntry(path)
+		if !ok {
+			continue
+		}
+		if isDir {
+			if realDir != "" {
+				if _, ok := visited[realDir]; ok {
+					continue
+				}
+				visited[realDir] = struct{}{}
+			}
+			if err = d.watchTree(path, emit, visited); err != nil {
+				return err
+			}
+		}
+		if emit && d.matcher.Match(path) {
+			d.emit(path)
+		}
+	}
+	return nil
+}
+
+func (d *PathDiscoverer) emit(path string) {
+	select {
+	case d.foundChan <- path:
+	case <-d.stopChan:
+	}
+}
+
+func (d *PathDiscoverer) notifyRescan() {
+	select {
+	case d.rescanChan <- struct{}{}:
+	default:
+	}
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,185 @@
+//go:build linux
+
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask 只关心目录下的新建、移入以及目录自身的删除和移走
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotifyBackend 直接使用 inotify 实现，fsnotify 会丢弃 IN_Q_OVERFLOW 事件，无法感知事件丢失
+type inotifyBackend struct {
+	file *os.File
+	fd   int
+
+	mux   sync.Mutex
+	paths map[int]string
+	wds   map[string]int
+
+	eventChan chan watchEvent
+	errChan   chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWatchBackend() (watchBackend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	b := &inotifyBackend{
+		// 非阻塞的 fd 交由 runtime poller 管理，Close 时可以唤醒阻塞中的 Read
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		fd:        fd,
+		paths:     make(map[int]string),
+		wds:       make(map[string]int),
+		eventChan: make(chan watchEvent),
+		errChan:   make(chan error),
+		done:      make(chan struct{}),
+	}
+	go b.readEvents()
+	return b, nil
+}
+
+func (b *inotifyBackend) add(dir string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.wds[dir]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(b.fd, dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	// 同一个目录通过不同路径添加时会得到同一个 wd，以最后一次的路径为准
+	if old, ok := b.paths[wd]; ok {
+		delete(b.wds, old)
+	}
+	b.paths[wd] = dir
+	b.wds[dir] = wd
+	return nil
+}
+
+func (b *inotifyBackend) remove(dir string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	wd, ok := b.wds[dir]
+	if !ok {
+		return nil
+	}
+	delete(b.wds, dir)
+	delete(b.paths, wd)
+	if _, err := syscall.InotifyRmWatch(b.fd, uint32(wd)); err != nil {
+		return &os.PathError{Op: "inotify_rm_watch", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func (b *inotifyBackend) count() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.wds)
+}
+
+func (b *inotifyBackend) events() <-chan watchEvent {
+	return b.eventChan
+}
+
+func (b *inotifyBackend) errors() <-chan error {
+	return b.errChan
+}
+
+func (b *inotifyBackend) close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.file.Close()
+	})
+	return err
+}
+
+func (b *inotifyBackend) readEvents() {
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := b.file.Read(buf[:])
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			if !b.sendError(err) {
+				return
+			}
+			continue
+		}
+		if n < syscall.SizeofInotifyEvent {
+			if !b.sendError(errors.New("inotify: short read")) {
+				return
+			}
+			continue
+		}
+
+		var offset int
+		for offset <= n-syscall.SizeofInotifyEvent {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+
+			ev, ok := b.convert(raw, buf[nameStart:offset])
+			if !ok {
+				continue
+			}
+			select {
+			case b.eventChan <- ev:
+			case <-b.done:
+				return
+			}
+		}
+	}
+}
+
+func (b *inotifyBackend) convert(raw *syscall.InotifyEvent, name []byte) (watchEvent, bool) {
+	if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		return watchEvent{overflow: true}, true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	dir, ok := b.paths[int(raw.Wd)]
+	if !ok {
+		return watchEvent{}, false
+	}
+	// 目录被删除后内核会自动移除 watch
+	if raw.Mask&syscall.IN_IGNORED != 0 {
+		delete(b.paths, int(raw.Wd))
+		if b.wds[dir] == int(raw.Wd) {
+			delete(b.wds, dir)
+		}
+		return watchEvent{}, false
+	}
+	if raw.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+		return watchEvent{path: dir, isDir: true, removed: true}, true
+	}
+	return watchEvent{
+		path:    filepath.Join(dir, strings.TrimRight(string(name), "\x00")),
+		isDir:   raw.Mask&syscall.IN_ISDIR != 0,
+		created: raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0,
+	}, true
+}
+
+func (b *inotifyBackend) sendError(err error) bool {
+	select {
+	case b.errChan <- err:
+		return true
+	case <-b.done:
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !linux
+
+package reader
+
+import (
+	"os"
+	"sync"
+
+	"github.com/howeyc/fsnotify"
+)
+
+// fsnotifyBackend 在非 Linux 平台上基于 fsnotify 实现，无法感知事件队列溢出，依赖 stat_interval 的全量扫描兜底
+type fsnotifyBackend struct {
+	watcher *fsnotify.Watcher
+
+	mux     sync.Mutex
+	watches map[string]struct{}
+
+	eventChan chan watchEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWatchBackend() (watchBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	b := &fsnotifyBackend{
+		watcher:   watcher,
+		watches:   make(map[string]struct{}),
+		eventChan: make(chan watchEvent),
+		done:      make(chan struct{}),
+	}
+	go b.readEvents()
+	return b, nil
+}
+
+func (b *fsnotifyBackend) add(dir string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.watches[dir]; ok {
+		return nil
+	}
+	if err := b.watcher.WatchFlags(dir, fsnotify.FSN_CREATE|fsnotify.FSN_DELETE|fsnotify.FSN_RENAME); err != nil {
+		return err
+	}
+	b.watches[dir] = struct{}{}
+	return nil
+}
+
+func (b *fsnotifyBackend) remove(dir string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.watches[dir]; !ok {
+		return nil
+	}
+	delete(b.watches, dir)
+	return b.watcher.RemoveWatch(dir)
+}
+
+func (b *fsnotifyBackend) count() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.watches)
+}
+
+func (b *fsnotifyBackend) events() <-chan watchEvent {
+	return b.eventChan
+}
+
+func (b *fsnotifyBackend) errors() <-chan error {
+	return b.watcher.Error
+}
+
+func (b *fsnotifyBackend) close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.watcher.Close()
+	})
+	return err
+}
+
+func (b *fsnotifyBackend) readEvents() {
+	for ev := range b.watcher.Event {
+		var we watchEvent
+		switch {
+		case ev.IsCreate():
+			fi, err := os.Stat(ev.Name)
+			if err != nil {
+				continue
+			}
+			we = watchEvent{path: ev.Name, isDir: fi.IsDir(), created: true}
+		case ev.IsDelete() || ev.IsRename():
+			b.mux.Lock()
+			_, watched := b.watches[ev.Name]
+			b.mux.Unlock()
+			if !watched {
+				continue
+			}
+			we = watchEvent{path: ev.Name, isDir: true, removed: true}
+		default:
+			continue
+		}
+		select {
+		case b.eventChan <- we:
+		case <-b.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func receivePaths(t *testing.T, d *PathDiscoverer, num int) []string {
+	var paths []string
+	timeout := time.After(5 * time.Second)
+	for len(paths) < num {
+		select {
+		case path := <-d.Found():
+			paths = append(paths, path)
+		case <-timeout:
+			t.Fatalf("expect %d paths but got %v", num, paths)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestPathDiscoverer(t *testing.T) {
+	dir := "TestPathDiscoverer"
+	os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), DefaultDirPerm))
+	defer os.RemoveAll(dir)
+
+	matcher, err := NewPathMatcher([]string{filepath.Join(dir, "**", "*.log")}, []string{"*.txt"}, 0, SymlinkFollow)
+	assert.NoError(t, err)
+	d, err := NewPathDiscoverer(matcher, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Start())
+	defer d.Close()
+	assert.Equal(t, 2, d.WatchCount())
+
+	// 已监听目录下新建的文件
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", "1.log"), []byte("abc\n"), DefaultFilePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", "1.txt"), []byte("abc\n"), DefaultFilePerm))
+	assert.Equal(t, []string{filepath.Join(dir, "a", "1.log")}, receivePaths(t, d, 1))
+
+	// 新建的多层目录及其中已有的文件
+	tmp := "TestPathDiscovererTmp"
+	os.RemoveAll(tmp)
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmp, "c"), DefaultDirPerm))
+	defer os.RemoveAll(tmp)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmp, "c", "2.log"), []byte("abc\n"), DefaultFilePerm))
+	assert.NoError(t, os.Rename(tmp, filepath.Join(dir, "b")))
+	assert.Equal(t, []string{filepath.Join(dir, "b", "c", "2.log")}, receivePaths(t, d, 1))
+	assert.Equal(t, 4, d.WatchCount())
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "b")))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, d.WatchCount())
+}
+
+func TestPathDiscovererMaxWatches(t *testing.T) {
+	dir := "TestPathDiscovererMaxWatches"
+	os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(dir, DefaultDirPerm))
+	defer os.RemoveAll(dir)
+
+	matcher, err := NewPathMatcher([]string{filepath.Join(dir, "*", "*.log")}, nil, 0, SymlinkFollow)
+	assert.NoError(t, err)
+	d, err := NewPathDiscoverer(matcher, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Start())
+	defer d.Close()
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "a"), DefaultDirPerm))
+	select {
+	case <-d.Rescan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect rescan notification when max watches exceeded")
+	}
+	assert.Equal(t, 1, d.WatchCount())
+}
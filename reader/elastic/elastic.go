@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,6 +16,8 @@ import (
 	elasticV3 "gopkg.in/olivere/elastic.v3"
 	elasticV5 "gopkg.in/olivere/elastic.v5"
 
+	"github.com/robfig/cron"
+
 	log "k8s.io/klog/v2"
 
 	"github.com/longxiucai/logkit/conf"
@@ -53,7 +57,20 @@ type Reader struct {
 	readBatch int    // 每次读取的数据量
 	keepAlive string //scrollID 保留时间
 	esVersion string //ElasticSearch version
-	offset    string // 当前处理es的offset
+
+	// 7.x 及以上版本使用 point in time 与 search_after 读取，offset 记录最后一条数据在 sortFields 上的排序值，
+	// 不包含 ES 为 point in time 自动追加的 _shard_doc，它在新的 point in time 中没有意义
+	query      json.RawMessage
+	sortFields []string
+	client     *http.Client
+
+	isLoop       bool
+	loopDuration time.Duration
+	execOnStart  bool
+	Cron         *cron.Cron //定时任务
+
+	offsetLock sync.RWMutex
+	offset     string // 当前处理es的offset
 }
 
 func init() {
@@ -62,8 +79,10 @@ func init() {
 
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	readBatch, _ := conf.GetIntOr(reader.KeyESReadBatch, 100)
+	esVersion, _ := conf.GetStringOr(reader.KeyESVersion, reader.ElasticVersion3)
+	usePIT := esVersion == reader.ElasticVersion7 || esVersion == reader.ElasticVersion8
 	estype, err := conf.GetString(reader.KeyESType)
-	if err != nil {
+	if err != nil && !usePIT {
 		return nil, err
 	}
 	esindex, err := conf.GetString(reader.KeyESIndex)
@@ -74,14 +93,53 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	if !strings.HasPrefix(eshost, "http://") && !strings.HasPrefix(eshost, "https://") {
 		eshost = "http://" + eshost
 	}
-	esVersion, _ := conf.GetStringOr(reader.KeyESVersion, reader.ElasticVersion3)
-	keepAlive, _ := conf.GetStringOr(reader.KeyESKeepAlive, "6h")
+	keepAlive, _ := conf.GetStringOr(reader.KeyESKeepAlive, "")
+	if keepAlive == "" {
+		// point in time 只需要在两次查询之间保持，读取结束后会主动关闭
+		if usePIT {
+			keepAlive = "5m"
+		} else {
+			keepAlive = "6h"
+		}
+	}
+	query, _ := conf.GetStringOr(reader.KeyESQuery, "")
+	sortFields, _ := conf.GetStringListOr(reader.KeyESSortFields, nil)
+	tiebreaker, _ := conf.GetStringOr(reader.KeyESTiebreaker, "")
+	cronSched, _ := conf.GetStringOr(reader.KeyESCron, "")
+	execOnStart, _ := conf.GetBoolOr(reader.KeyESExecOnStart, true)
+	if usePIT && len(sortFields) == 0 {
+		return nil, fmt.Errorf("%s is required for elasticsearch %s", reader.KeyESSortFields, esVersion)
+	}
+	if tiebreaker != "" {
+		if !usePIT {
+			return nil, fmt.Errorf("%s is only supported by elasticsearch %s and above", reader.KeyESTiebreaker, reader.ElasticVersion7)
+		}
+		exist := false
+		for _, field := range sortFields {
+			if field == tiebreaker {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			sortFields = append(sortFields, tiebreaker)
+		}
+	} else if usePIT {
+		log.Warningf("Runner[%v] %s is not set, make sure the last of %s %v is unique, otherwise documents with the same sort values may be skipped after restart",
+			meta.RunnerName, reader.KeyESTiebreaker, reader.KeyESSortFields, sortFields)
+	}
+	if query != "" && !usePIT {
+		return nil, fmt.Errorf("%s is only supported by elasticsearch %s and above", reader.KeyESQuery, reader.ElasticVersion7)
+	}
+	if query != "" && !json.Valid([]byte(query)) {
+		return nil, fmt.Errorf("%s %q is not valid json", reader.KeyESQuery, query)
+	}
 
 	offset, _, err := meta.ReadOffset()
 	if err != nil {
 		log.Errorf("Runner[%v] %v -meta data is corrupted err:%v, omit meta data", meta.RunnerName, meta.MetaFile(), err)
 	}
-	return &Reader{
+	r := &Reader{
 		meta:          meta,
 		status:        reader.StatusInit,
 		routineStatus: reader.StatusInit,
@@ -94,8 +152,47 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		esVersion:     esVersion,
 		readBatch:     readBatch,
 		keepAlive:     keepAlive,
+		query:         json.RawMessage(query),
+		sortFields:    sortFields,
+		client:        &http.Client{Timeout: 5 * time.Minute},
+		isLoop:        true,
+		loopDuration:  3 * time.Second,
+		execOnStart:   execOnStart,
+		Cron:          cron.New(),
 		offset:        offset,
-	}, nil
+	}
+	if len(cronSched) > 0 {
+		cronSched = strings.ToLower(cronSched)
+		if strings.HasPrefix(cronSched, reader.Loop) {
+			r.loopDuration, err = reader.ParseLoopDuration(cronSched)
+			if err != nil {
+				log.Errorf("Runner[%v] %v %v", r.meta.RunnerName, r.Name(), err)
+			}
+			if r.loopDuration.Nanoseconds() <= 0 {
+				r.loopDuration = 1 * time.Second
+			}
+		} else {
+			r.isLoop = false
+			err = r.Cron.AddFunc(cronSched, r.run)
+			if err != nil {
+				return nil, err
+			}
+			log.Infof("Runner[%v] %v Cron added with schedule <%v>", r.meta.RunnerName, r.Name(), cronSched)
+		}
+	}
+	return r, nil
+}
+
+func (r *Reader) getOffset() string {
+	r.offsetLock.RLock()
+	defer r.offsetLock.RUnlock()
+	return r.offset
+}
+
+func (r *Reader) setOffset(offset string) {
+	r.offsetLock.Lock()
+	defer r.offsetLock.Unlock()
+	r.offset = offset
 }
 
 func (r *Reader) isStopping() bool {
@@ -156,6 +253,8 @@ func (r *Reader) exec() error {
 
 	// Create a client
 	switch r.esVersion {
+	case reader.ElasticVersion7, reader.ElasticVersion8:
+		return r.execPIT()
 	case reader.ElasticVersion6:
 		client, err := elasticV6.NewClient(elasticV6.SetURL(r.eshost))
 		if err != nil {
@@ -164,7 +263,7 @@ func (r *Reader) exec() error {
 		scroll := client.Scroll(r.esindex).Type(r.estype).Size(r.readBatch).KeepAlive(r.keepAlive)
 		for {
 			ctx := context.Background()
-			results, err := scroll.ScrollId(r.offset).Do(ctx)
+			results, err := scroll.ScrollId(r.getOffset()).Do(ctx)
 			if err == io.EOF {
 				return nil // all results retrieved
 			}
@@ -176,7 +275,7 @@ func (r *Reader) exec() error {
 			for _, hit := range results.Hits.Hits {
 				r.readChan <- *hit.Source
 			}
-			r.offset = results.ScrollId
+			r.setOffset(results.ScrollId)
 			if r.isStopping() || r.hasStopped() {
 				return nil
 			}
@@ -189,7 +288,7 @@ func (r *Reader) exec() error {
 		scroll := client.Scroll(r.esindex).Type(r.estype).Size(r.readBatch).KeepAlive(r.keepAlive)
 		for {
 			ctx := context.Background()
-			results, err := scroll.ScrollId(r.offset).Do(ctx)
+			results, err := scroll.ScrollId(r.getOffset()).Do(ctx)
 			if err == io.EOF {
 				return nil // all results retrieved
 			}
@@ -201,7 +300,7 @@ func (r *Reader) exec() error {
 			for _, hit := range results.Hits.Hits {
 				r.readChan <- *hit.Source
 			}
-			r.offset = results.ScrollId
+			r.setOffset(results.ScrollId)
 			if r.isStopping() || r.hasStopped() {
 				return nil
 			}
@@ -213,7 +312,7 @@ func (r *Reader) exec() error {
 		}
 		scroll := client.Scroll(r.esindex).Type(r.estype).Size(r.readBatch).KeepAlive(r.keepAlive)
 		for {
-			results, err := scroll.ScrollId(r.offset).Do()
+			results, err := scroll.ScrollId(r.getOffset()).Do()
 			if err == io.EOF {
 				return nil // all results retrieved
 			}
@@ -225,7 +324,7 @@ func (r *Reader) exec() error {
 			for _, hit := range results.Hits.Hits {
 				r.readChan <- *hit.Source
 			}
-			r.offset = results.ScrollId
+			r.setOffset(results.ScrollId)
 			if r.isStopping() || r.hasStopped() {
 				return nil
 			}
@@ -242,29 +341,40 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
-	go func() {
-		ticker := time.NewTicker(3 * time.Second)
-		defer ticker.Stop()
-		for {
-			if err := r.exec(); err != nil {
-				log.Errorf("Runner[%v] %q exec failed: %v ", r.meta.RunnerName, r.Name(), err)
-				r.setStatsError(err.Error())
-				r.sendError(err)
-			}
-
-			select {
-			case <-r.stopChan:
-				atomic.StoreInt32(&r.status, reader.StatusStopped)
-				log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
-				return
-			case <-ticker.C:
+	if r.isLoop {
+		go func() {
+			ticker := time.NewTicker(r.loopDuration)
+			defer ticker.Stop()
+			for {
+				r.run()
+
+				select {
+				case <-r.stopChan:
+					atomic.StoreInt32(&r.status, reader.StatusStopped)
+					log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+					return
+				case <-ticker.C:
+				}
 			}
+		}()
+	} else {
+		if r.execOnStart {
+			go r.run()
 		}
-	}()
+		r.Cron.Start()
+	}
 	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
 	return nil
 }
 
+func (r *Reader) run() {
+	if err := r.exec(); err != nil {
+		log.Errorf("Runner[%v] %q exec failed: %v ", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		r.sendError(err)
+	}
+}
+
 func (r *Reader) Source() string {
 	return r.eshost + "_" + r.esindex + "_" + r.estype
 }
@@ -291,7 +401,7 @@ func (r *Reader) Status() StatsInfo {
 
 // SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复
 func (r *Reader) SyncMeta() {
-	if err := r.meta.WriteOffset(r.offset, 0); err != nil {
+	if err := r.meta.WriteOffset(r.getOffset(), 0); err != nil {
 		log.Errorf("Runner[%v] reader %q sync meta failed: %v", r.meta.RunnerName, r.Name(), err)
 	}
 	return
@@ -304,6 +414,12 @@ func (r *Reader) Close() error {
 	}
 	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
 	close(r.stopChan)
+	r.Cron.Stop()
+	// 定时任务模式没有常驻的 routine 负责设置 StatusStopped
+	if !r.isLoop {
+		atomic.CompareAndSwapInt32(&r.status, reader.StatusStopping, reader.StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}
 
 	// 如果此时没有 routine 正在运行，则在此处关闭数据管道，否则由 routine 在退出时负责关闭
 	if atomic.CompareAndSwapInt32(&r.routineStatus, reader.StatusInit, reader.StatusStopping) {
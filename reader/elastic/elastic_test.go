@@ -2,9 +2,14 @@ package elastic
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/longxiucai/logkit/conf"
 	"github.com/longxiucai/logkit/reader"
@@ -40,3 +45,227 @@ func TestElasticReader(t *testing.T) {
 	sts := er.Status()
 	assert.Equal(t, StatsInfo{}, sts)
 }
+
+func TestElasticReaderPIT(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"@timestamp": 1, "seq": 1, "msg": "a"},
+		{"@timestamp": 1, "seq": 2, "msg": "b"},
+		{"@timestamp": 2, "seq": 3, "msg": "c"},
+	}
+	var closed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/app/_pit":
+			w.Write([]byte(`{"id":"pit-1"}`))
+		case req.Method == http.MethodDelete && req.URL.Path == "/_pit":
+			atomic.AddInt32(&closed, 1)
+			w.Write([]byte(`{"succeeded":true}`))
+		case req.Method == http.MethodPost && req.URL.Path == "/_search":
+			var body struct {
+				Size        int                    `json:"size"`
+				Query       map[string]interface{} `json:"query"`
+				Sort        []map[string]string    `json:"sort"`
+				SearchAfter []int                  `json:"search_after"`
+				Pit         map[string]interface{} `json:"pit"`
+			}
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			assert.Equal(t, "pit-1", body.Pit["id"])
+			assert.Equal(t, []map[string]string{{"@timestamp": "asc"}, {"seq": "asc"}}, body.Sort)
+			assert.NotNil(t, body.Query["range"])
+			var hits []map[string]interface{}
+			for _, doc := range docs {
+				seq := doc["seq"].(int)
+				if len(body.SearchAfter) == 2 && seq <= body.SearchAfter[1] {
+					continue
+				}
+				if len(hits) < body.Size {
+					hits = append(hits, map[string]interface{}{"_source": doc, "sort": []interface{}{doc["@timestamp"], seq}})
+				}
+			}
+			resp, _ := json.Marshal(map[string]interface{}{"pit_id": "pit-1", "hits": map[string]interface{}{"hits": hits}})
+			w.Write(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:     MetaDir,
+		reader.KeyFileDone:     MetaDir,
+		reader.KeyMode:         reader.ModeElastic,
+		reader.KeyESHost:       server.URL,
+		reader.KeyESIndex:      "app",
+		reader.KeyESVersion:    reader.ElasticVersion7,
+		reader.KeyESReadBatch:  "2",
+		reader.KeyESQuery:      `{"range":{"@timestamp":{"gte":0}}}`,
+		reader.KeyESSortFields: "@timestamp,seq",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer DestroyDir()
+
+	_, err = NewReader(meta, conf.MapConf{reader.KeyESIndex: "app", reader.KeyESVersion: reader.ElasticVersion8})
+	assert.Error(t, err)
+
+	rd, err := NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	er := rd.(*Reader)
+	var got []string
+	go func() {
+		assert.NoError(t, er.execPIT())
+		close(er.readChan)
+	}()
+	for data := range er.readChan {
+		got = append(got, string(data))
+	}
+	assert.Equal(t, []string{
+		`{"@timestamp":1,"msg":"a","seq":1}`,
+		`{"@timestamp":1,"msg":"b","seq":2}`,
+		`{"@timestamp":2,"msg":"c","seq":3}`,
+	}, got)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closed))
+
+	// 排序值记录在 meta 中，下次启动后增量读取
+	er.SyncMeta()
+	docs = append(docs, map[string]interface{}{"@timestamp": 3, "seq": 4, "msg": "d"})
+	rd, err = NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	er = rd.(*Reader)
+	got = got[:0]
+	go func() {
+		assert.NoError(t, er.execPIT())
+		close(er.readChan)
+	}()
+	for data := range er.readChan {
+		got = append(got, string(data))
+	}
+	assert.Equal(t, []string{`{"@timestamp":3,"msg":"d","seq":4}`}, got)
+}
+
+// 排序值相同的数据跨两个 point in time 续读时不丢失也不重复
+func TestElasticReaderPITResume(t *testing.T) {
+	type doc struct {
+		ts int
+		id string
+	}
+	docs := []doc{{1, "a"}, {1, "b"}}
+	var pits int32
+	var firstAfter [][]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/app/_pit":
+			n := atomic.AddInt32(&pits, 1)
+			firstAfter = append(firstAfter, nil)
+			fmt.Fprintf(w, `{"id":"pit-%d"}`, n)
+		case req.Method == http.MethodDelete && req.URL.Path == "/_pit":
+			w.Write([]byte(`{"succeeded":true}`))
+		case req.Method == http.MethodPost && req.URL.Path == "/_search":
+			var body struct {
+				Size        int                 `json:"size"`
+				Sort        []map[string]string `json:"sort"`
+				SearchAfter []interface{}       `json:"search_after"`
+			}
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			assert.Equal(t, []map[string]string{{"@timestamp": "asc"}, {"event_id": "asc"}}, body.Sort)
+			pit := int(atomic.LoadInt32(&pits))
+			if firstAfter[pit-1] == nil {
+				firstAfter[pit-1] = append([]interface{}{}, body.SearchAfter...)
+			}
+			// 模拟 ES 自动追加的 _shard_doc，不同的 point in time 中取值不同
+			shardDoc := func(i int) float64 { return float64(pit*1000 + len(docs) - i) }
+			var hits []map[string]interface{}
+			for i, d := range docs {
+				switch len(body.SearchAfter) {
+				case 3:
+					ts, id := body.SearchAfter[0].(float64), body.SearchAfter[1].(string)
+					if float64(d.ts) < ts || (float64(d.ts) == ts && d.id <= id) {
+						continue
+					}
+					assert.Equal(t, shardDoc(i-1), body.SearchAfter[2])
+				case 2:
+					ts, id := body.SearchAfter[0].(float64), body.SearchAfter[1].(string)
+					if float64(d.ts) < ts || (float64(d.ts) == ts && d.id <= id) {
+						continue
+					}
+				}
+				if len(hits) < body.Size {
+					hits = append(hits, map[string]interface{}{
+						"_source": map[string]interface{}{"@timestamp": d.ts, "event_id": d.id},
+						"sort":    []interface{}{d.ts, d.id, shardDoc(i)},
+					})
+				}
+			}
+			resp, _ := json.Marshal(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+			w.Write(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:     MetaDir,
+		reader.KeyFileDone:     MetaDir,
+		reader.KeyMode:         reader.ModeElastic,
+		reader.KeyESHost:       server.URL,
+		reader.KeyESIndex:      "app",
+		reader.KeyESVersion:    reader.ElasticVersion8,
+		reader.KeyESReadBatch:  "1",
+		reader.KeyESSortFields: "@timestamp",
+		reader.KeyESTiebreaker: "event_id",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	require.NoError(t, err)
+	defer DestroyDir()
+
+	read := func() []string {
+		rd, err := NewReader(meta, logkitConf)
+		require.NoError(t, err)
+		er := rd.(*Reader)
+		var got []string
+		go func() {
+			assert.NoError(t, er.execPIT())
+			close(er.readChan)
+		}()
+		for data := range er.readChan {
+			got = append(got, string(data))
+		}
+		er.SyncMeta()
+		return got
+	}
+	assert.Equal(t, []string{`{"@timestamp":1,"event_id":"a"}`, `{"@timestamp":1,"event_id":"b"}`}, read())
+
+	// 记录的 offset 不包含 _shard_doc
+	offset, _, err := meta.ReadOffset()
+	require.NoError(t, err)
+	values, err := decodeSortValues(offset)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{json.Number("1"), "b"}, values)
+
+	docs = []doc{{1, "a"}, {1, "b"}, {1, "c"}, {2, "d"}}
+	assert.Equal(t, []string{`{"@timestamp":1,"event_id":"c"}`, `{"@timestamp":2,"event_id":"d"}`}, read())
+	assert.Equal(t, []interface{}{float64(1), "b"}, firstAfter[1])
+}
+
+func TestElasticReaderCronClose(t *testing.T) {
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:      MetaDir,
+		reader.KeyFileDone:      MetaDir,
+		reader.KeyMode:          reader.ModeElastic,
+		reader.KeyESIndex:       "app",
+		reader.KeyESType:        "type",
+		reader.KeyESCron:        "0 0 0 * * *",
+		reader.KeyESExecOnStart: "false",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	require.NoError(t, err)
+	defer DestroyDir()
+	rd, err := NewReader(meta, logkitConf)
+	require.NoError(t, err)
+	er := rd.(*Reader)
+	assert.NoError(t, er.Start())
+	assert.NoError(t, er.Close())
+	assert.True(t, er.hasStopped())
+	assert.Error(t, er.Start())
+}
@@ -0,0 +1,184 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/reader"
+)
+
+// ES 7.x 及以上版本不再依赖 olivere/elastic 客户端，直接通过 REST 接口使用 point in time 与 search_after 读取数据
+
+type pitResponse struct {
+	ID string `json:"id"`
+}
+
+type searchHit struct {
+	Source json.RawMessage `json:"_source"`
+	Sort   []interface{}   `json:"sort"`
+}
+
+type searchResponse struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// encodeSortValues 将排序值编码为可以写入 meta 的字符串
+func encodeSortValues(values []interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// decodeSortValues 解析 encodeSortValues 编码的排序值，数字保持 json.Number 以免丢失精度
+func decodeSortValues(offset string) ([]interface{}, error) {
+	if offset == "" {
+		return nil, nil
+	}
+	data, err := base64.URLEncoding.DecodeString(offset)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (r *Reader) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(r.eshost, "/")+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if result == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(respBody))
+	dec.UseNumber()
+	return dec.Decode(result)
+}
+
+func (r *Reader) openPIT(ctx context.Context) (string, error) {
+	var resp pitResponse
+	path := "/" + url.PathEscape(r.esindex) + "/_pit?keep_alive=" + url.QueryEscape(r.keepAlive)
+	if err := r.doRequest(ctx, http.MethodPost, path, nil, &resp); err != nil {
+		return "", fmt.Errorf("open point in time failed: %v", err)
+	}
+	return resp.ID, nil
+}
+
+func (r *Reader) closePIT(ctx context.Context, pitID string) error {
+	return r.doRequest(ctx, http.MethodDelete, "/_pit", map[string]interface{}{"id": pitID}, nil)
+}
+
+func (r *Reader) searchAfter(ctx context.Context, pitID string, after []interface{}) (*searchResponse, error) {
+	sort := make([]interface{}, 0, len(r.sortFields))
+	for _, field := range r.sortFields {
+		sort = append(sort, map[string]interface{}{field: "asc"})
+	}
+	body := map[string]interface{}{
+		"size":             r.readBatch,
+		"pit":              map[string]interface{}{"id": pitID, "keep_alive": r.keepAlive},
+		"sort":             sort,
+		"track_total_hits": false,
+	}
+	if len(r.query) > 0 {
+		body["query"] = r.query
+	}
+	if len(after) > 0 {
+		body["search_after"] = after
+	}
+	var resp searchResponse
+	if err := r.doRequest(ctx, http.MethodPost, "/_search", body, &resp); err != nil {
+		return nil, fmt.Errorf("search after %v failed: %v", after, err)
+	}
+	return &resp, nil
+}
+
+// execPIT 在一个新的 point in time 中从上次记录的排序值之后读取所有匹配的数据
+func (r *Reader) execPIT() error {
+	ctx := context.Background()
+	after, err := decodeSortValues(r.getOffset())
+	if err != nil {
+		log.Warningf("Runner[%v] %q offset %q is not valid sort values, will read from beginning: %v", r.meta.RunnerName, r.Name(), r.getOffset(), err)
+		after = nil
+	}
+	pitID, err := r.openPIT(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.closePIT(ctx, pitID); err != nil {
+			log.Warningf("Runner[%v] %q close point in time failed: %v", r.meta.RunnerName, r.Name(), err)
+		}
+	}()
+
+	for {
+		result, err := r.searchAfter(ctx, pitID, after)
+		if err != nil {
+			return err
+		}
+		if result.PitID != "" {
+			pitID = result.PitID
+		}
+		if len(result.Hits.Hits) == 0 {
+			return nil
+		}
+		for _, hit := range result.Hits.Hits {
+			if len(hit.Sort) < len(r.sortFields) {
+				return fmt.Errorf("hit has %d sort values, less than %s %v", len(hit.Sort), reader.KeyESSortFields, r.sortFields)
+			}
+			// 同一个 point in time 内用完整的排序值翻页，记录的 offset 只保留 sortFields 的值
+			offset, err := encodeSortValues(hit.Sort[:len(r.sortFields)])
+			if err != nil {
+				return err
+			}
+			r.readChan <- hit.Source
+			r.setOffset(offset)
+			after = hit.Sort
+		}
+		if r.isStopping() || r.hasStopped() {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package reader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/longxiucai/logkit/conf"
+)
+
+// globDoubleStar 表示匹配任意层级（包括零层）目录的模式段
+const globDoubleStar = "**"
+
+// KeySymlinkPolicy 的可选项
+const (
+	// SymlinkFollow 匹配指向文件的软链接，并进入指向目录的软链接
+	SymlinkFollow = "follow"
+	// SymlinkFile 只匹配指向文件的软链接，不进入指向目录的软链接
+	SymlinkFile = "file"
+	// SymlinkIgnore 忽略所有软链接
+	SymlinkIgnore = "ignore"
+)
+
+// hasGlobMeta 判断路径段中是否包含通配符
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// splitPathSegments 将路径按分隔符切分为路径段，"." 与空路径返回空切片
+func splitPathSegments(path string) []string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || path == "" {
+		return nil
+	}
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// splitGlobRoot 将模式串切分为不含通配符的根目录与根目录之下的模式段
+// 如 /var/log/*/app/*.log 切分为 /var/log 与 [* app *.log]
+func splitGlobRoot(pattern string) (root string, segments []string) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	parts := strings.Split(pattern, "/")
+	i := 0
+	// 最后一段总是作为文件名模式保留
+	for ; i < len(parts)-1; i++ {
+		if hasGlobMeta(parts[i]) {
+			break
+		}
+	}
+	root = strings.Join(parts[:i], "/")
+	if root == "" {
+		if strings.HasPrefix(pattern, "/") {
+			root = "/"
+		} else {
+			root = "."
+		}
+	}
+	return filepath.FromSlash(root), parts[i:]
+}
+
+// matchSegments 判断路径段 names 是否完整匹配模式段 patterns，模式段 ** 可匹配任意层级目录
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == globDoubleStar {
+			if len(patterns) == 1 {
+				return true
+			}
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(patterns[0], names[0]); err != nil || !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// matchSegmentsPrefix 判断以 names 为路径段的目录之下是否可能存在匹配 patterns 的路径
+func matchSegmentsPrefix(patterns, names []string) bool {
+	for len(names) > 0 {
+		if len(patterns) == 0 {
+			return false
+		}
+		if patterns[0] == globDoubleStar {
+			return true
+		}
+		if ok, err := filepath.Match(patterns[0], names[0]); err != nil || !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(patterns) > 0
+}
+
+// relSegments 返回 path 相对于 root 的路径段，path 不在 root 之下时 ok 为 false
+func relSegments(root, path string) (names []string, ok bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return nil, false
+	}
+	return splitPathSegments(rel), true
+}
+
+type globPattern struct {
+	root     string
+	segments []string
+}
+
+// PathMatcher 根据多个包含模式串与排除模式串展开和匹配路径
+// 模式串除了支持 filepath.Match 的语法外，还支持以 ** 匹配任意层级的目录
+// 排除模式串中不含路径分隔符时匹配文件名，否则匹配完整路径
+type PathMatcher struct {
+	patterns      []string
+	includes      []globPattern
+	excludes      [][]string
+	excludeNames  []string
+	maxDepth      int
+	symlinkPolicy string
+}
+
+// NewPathMatcher 创建 PathMatcher，maxDepth 为根目录之下最多进入的目录层数，0 表示不限制
+func NewPathMatcher(includes, excludes []string, maxDepth int, symlinkPolicy string) (*PathMatcher, error) {
+	if len(includes) == 0 {
+		return nil, fmt.Errorf("no include pattern specified")
+	}
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("max depth %d is invalid", maxDepth)
+	}
+	switch symlinkPolicy {
+	case "":
+		symlinkPolicy = SymlinkFollow
+	case SymlinkFollow, SymlinkFile, SymlinkIgnore:
+	default:
+		return nil, fmt.Errorf("symlink policy %q is not supported", symlinkPolicy)
+	}
+
+	m := &PathMatcher{
+		patterns:      includes,
+		maxDepth:      maxDepth,
+		symlinkPolicy: symlinkPolicy,
+	}
+	for _, pattern := range includes {
+		root, segments := splitGlobRoot(pattern)
+		if err := checkSegments(segments); err != nil {
+			return nil, fmt.Errorf("pattern %q is invalid: %v", pattern, err)
+		}
+		m.includes = append(m.includes, globPattern{root: root, segments: segments})
+	}
+	for _, pattern := range excludes {
+		segments := splitPathSegments(pattern)
+		if err := checkSegments(segments); err != nil {
+			return nil, fmt.Errorf("exclude pattern %q is invalid: %v", pattern, err)
+		}
+		if len(segments) == 1 {
+			m.excludeNames = append(m.excludeNames, segments[0])
+		} else {
+			m.excludes = append(m.excludes, segments)
+		}
+	}
+	return m, nil
+}
+
+// NewPathMatcherWithConf 根据 log_path 及其相关配置创建 PathMatcher
+func NewPathMatcherWithConf(logPath string, conf conf.MapConf) (*PathMatcher, error) {
+	includes := []string{logPath}
+	more, _ := conf.GetStringListOr(KeyLogPathIncludes, nil)
+	includes = append(includes, more...)
+	excludes, _ := conf.GetStringListOr(KeyLogPathExcludes, nil)
+	maxDepth, _ := conf.GetIntOr(KeyMaxDepth, 0)
+	symlinkPolicy, _ := conf.GetStringOr(KeySymlinkPolicy, SymlinkFollow)
+	return NewPathMatcher(includes, excludes, maxDepth, symlinkPolicy)
+}
+
+func checkSegments(segments []string) error {
+	for _, segment := range segments {
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *PathMatcher) String() string {
+	return strings.Join(m.patterns, ",")
+}
+
+// Roots 返回所有包含模式串中不含通配符的根目录
+func (m *PathMatcher) Roots() []string {
+	roots := make([]string, 0, len(m.includes))
+	for _, include := range m.includes {
+		roots = append(roots, include.root)
+	}
+	return roots
+}
+
+// Excluded 判断 path 是否被排除
+func (m *PathMatcher) Excluded(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range m.excludeNames {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	if len(m.excludes) == 0 {
+		return false
+	}
+	names := splitPathSegments(path)
+	for _, segments := range m.excludes {
+		if matchSegments(segments, names) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 判断 path 是否匹配任一包含模式串且未被排除
+func (m *PathMatcher) Match(path string) bool {
+	for _, include := range m.includes {
+		names, ok := relSegments(include.root, path)
+		if !ok || len(names) == 0 || !m.depthAllowed(len(names)-1) {
+			continue
+		}
+		if matchSegments(include.segments, names) {
+			return !m.Excluded(path)
+		}
+	}
+	return false
+}
+
+// CanContain 判断目录 dir 之下是否可能存在匹配的路径，dir 为某个根目录的上级目录时同样返回 true
+func (m *PathMatcher) CanContain(dir string) bool {
+	if m.Excluded(dir) {
+		return false
+	}
+	for _, include := range m.includes {
+		if _, ok := relSegments(dir, include.root); ok {
+			return true
+		}
+		names, ok := relSegments(include.root, dir)
+		if ok && m.depthAllowed(len(names)) && matchSegmentsPrefix(include.segments, names) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *PathMatcher) depthAllowed(depth int) bool {
+	return m.maxDepth == 0 || depth <= m.maxDepth
+}
+
+// statEntry 按照软链接策略获取 path 的信息，ok 为 false 表示应当忽略该路径
+// 对于进入的软链接目录，realDir 返回其真实路径，用于避免循环
+func (m *PathMatcher) statEntry(path string) (isDir bool, realDir string, ok bool) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false, "", false
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fi.IsDir(), "", true
+	}
+	if m.symlinkPolicy == SymlinkIgnore {
+		return false, "", false
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		return false, "", false
+	}
+	if !fi.IsDir() {
+		return false, "", true
+	}
+	if m.symlinkPolicy == SymlinkFile {
+		return false, "", false
+	}
+	realDir, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, "", false
+	}
+	return true, realDir, true
+}
+
+// Glob 展开所有包含模式串，返回排序去重后的匹配路径
+func (m *PathMatcher) Glob() ([]string, error) {
+	found := make(map[string]struct{})
+	for _, include := range m.includes {
+		isDir, _, ok := m.statEntry(include.root)
+		if !ok || !isDir {
+			continue
+		}
+		if err := m.walk(include, include.root, nil, make(map[string]struct{}), found); err != nil {
+			return nil, err
+		}
+	}
+	matches := make([]string, 0, len(found))
+	for path := range found {
+		matches = append(matches, path)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m *PathMatcher) walk(include globPattern, dir string, names []string, visited map[string]struct{}, found map[string]struct{}) error {
+	var entries []string
+	if idx := len(names); idx < len(include.segments) && !hasGlobMeta(include.segments[idx]) &&
+		!containsDoubleStar(include.segments[:idx+1]) {
+		// 模式段为普通字符串时不需要列出整个目录
+		entries = []string{include.segments[idx]}
+	} else {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		for _, fi := range files {
+			entries = append(entries, fi.Name())
+		}
+	}
+
+	for _, name := range entries {
+		path := filepath.Join(dir, name)
+		isDir, realDir, ok := m.statEntry(path)
+		if !ok {
+			continue
+		}
+		subNames := append(names[:len(names):len(names)], name)
+		if m.depthAllowed(len(subNames)-1) && matchSegments(include.segments, subNames) && !m.Excluded(path) {
+			found[path] = struct{}{}
+		}
+		if !isDir || !m.depthAllowed(len(subNames)) || !matchSegmentsPrefix(include.segments, subNames) || m.Excluded(path) {
+			continue
+		}
+		if realDir != "" {
+			if _, ok := visited[realDir]; ok {
+				continue
+			}
+			visited[realDir] = struct{}{}
+		}
+		if err := m.walk(include, path, subNames, visited, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsDoubleStar(segments []string) bool {
+	for _, segment := range segments {
+		if segment == globDoubleStar {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestSplitGlobRoot(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		root     string
+		segments []string
+	}{
+		{"/var/log/*/app/*.log", "/var/log", []string{"*", "app", "*.log"}},
+		{"/var/log/app.log", "/var/log", []string{"app.log"}},
+		{"/*.log", "/", []string{"*.log"}},
+		{"logs/**/*.log", "logs", []string{"**", "*.log"}},
+		{"*.log", ".", []string{"*.log"}},
+	}
+	for _, test := range tests {
+		root, segments := splitGlobRoot(test.pattern)
+		assert.Equal(t, filepath.FromSlash(test.root), root, test.pattern)
+		assert.Equal(t, test.segments, segments, test.pattern)
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+		prefix  bool
+	}{
+		{"*/app/*.log", "a/app/x.log", true, false},
+		{"*/app/*.log", "a/app", false, true},
+		{"*/app/*.log", "a/other", false, false},
+		{"**/*.log", "x.log", true, true},
+		{"**/*.log", "a/b/c/x.log", true, true},
+		{"**/*.log", "a/b/c", false, true},
+		{"a/**/b/*.log", "a/b/x.log", true, true},
+		{"a/**/b/*.log", "a/x/y/b/x.log", true, true},
+		{"a/**/b/*.log", "c/b/x.log", false, false},
+		{"**", "a/b", true, true},
+	}
+	for _, test := range tests {
+		patterns := splitPathSegments(test.pattern)
+		names := splitPathSegments(test.path)
+		assert.Equal(t, test.match, matchSegments(patterns, names), test.pattern+" "+test.path)
+		assert.Equal(t, test.prefix, matchSegmentsPrefix(patterns, names), test.pattern+" "+test.path)
+	}
+}
+
+func TestPathMatcherGlob(t *testing.T) {
+	dir := "TestPathMatcherGlob"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	for _, path := range []string{
+		"a.log", "b.txt", "x/a.log", "x/debug/a.log", "x/y/a.log", "x/y/z/a.log", "x/y/z/a.log.gz", "other/c.log",
+	} {
+		path = filepath.Join(dir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), DefaultDirPerm))
+		assert.NoError(t, os.WriteFile(path, []byte("abc\n"), DefaultFilePerm))
+	}
+	assert.NoError(t, os.Symlink("x", filepath.Join(dir, "link")))
+	assert.NoError(t, os.Symlink(filepath.Join("y", "a.log"), filepath.Join(dir, "x", "linked.log")))
+	// 指向上级目录的软链接，不能导致死循环
+	assert.NoError(t, os.Symlink("..", filepath.Join(dir, "x", "y", "loop")))
+
+	join := func(paths ...string) []string {
+		for i := range paths {
+			paths[i] = filepath.Join(dir, paths[i])
+		}
+		return paths
+	}
+	tests := []struct {
+		includes []string
+		excludes []string
+		maxDepth int
+		policy   string
+		expect   []string
+	}{
+		{
+			includes: []string{"x/*/*.log"},
+			policy:   SymlinkIgnore,
+			expect:   join("x/debug/a.log", "x/y/a.log"),
+		},
+		{
+			includes: []string{"x/**/*.log"},
+			excludes: []string{filepath.Join(dir, "x", "debug", "**")},
+			policy:   SymlinkIgnore,
+			expect:   join("x/a.log", "x/y/a.log", "x/y/z/a.log"),
+		},
+		{
+			includes: []string{"x/**/*.log", "other/*.log"},
+			maxDepth: 1,
+			policy:   SymlinkFile,
+			expect:   join("other/c.log", "x/a.log", "x/debug/a.log", "x/linked.log", "x/y/a.log"),
+		},
+		{
+			includes: []string{"link/*/*.log"},
+			excludes: []string{"debug"},
+			policy:   SymlinkFollow,
+			expect:   join("link/y/a.log"),
+		},
+		{
+			includes: []string{"link/*/*.log"},
+			policy:   SymlinkFile,
+		},
+		{
+			includes: []string{"**/z/*.log"},
+			policy:   SymlinkFollow,
+			expect:   join("x/y/z/a.log", "link/y/z/a.log"),
+		},
+	}
+	for _, test := range tests {
+		m, err := NewPathMatcher(join(test.includes...), test.excludes, test.maxDepth, test.policy)
+		assert.NoError(t, err)
+		matches, err := m.Glob()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, test.expect, matches, "%v", test.includes)
+		for _, path := range matches {
+			assert.True(t, m.Match(path), path)
+		}
+	}
+
+	_, err := NewPathMatcher([]string{"[a-"}, nil, 0, "")
+	assert.Error(t, err)
+	_, err = NewPathMatcher([]string{"*.log"}, nil, 0, "unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/labstack/echo"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/queue"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	queueName    = "http_reader"
+	inflightFile = "http_reader.inflight"
+)
+
+// entry 为一次请求的请求体，Offset 表示已经读取的行数或者对象数
+type entry struct {
+	Path   string `json:"path"`
+	Body   []byte `json:"body"`
+	Offset int    `json:"offset,omitempty"`
+
+	lines []string
+	datas []jsonData
+}
+
+func (e *entry) len() int {
+	if e.datas != nil {
+		return len(e.datas)
+	}
+	return len(e.lines)
+}
+
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string {
+	return e.err.Error()
+}
+
+type queueFullError struct {
+	err error
+}
+
+func (e *queueFullError) Error() string {
+	return e.err.Error()
+}
+
+// inflightLog 记录已经从磁盘队列中取出但尚未被 SyncMeta 确认的请求体，重启后优先读取
+type inflightLog struct {
+	path string
+	file *os.File
+}
+
+func openInflightLog(path string) (*inflightLog, []*entry, error) {
+	var entries []*entry
+	if f, err := os.Open(path); err == nil {
+		br := bufio.NewReader(f)
+		for {
+			line, err := readLine(br)
+			if line != "" {
+				e := &entry{}
+				if jerr := jsoniter.Unmarshal([]byte(line), e); jerr != nil {
+					log.Errorf("inflight log %v is corrupted, skip entry: %v", path, jerr)
+				} else {
+					entries = append(entries, e)
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	l := &inflightLog{path: path}
+	if err := l.rewrite(entries); err != nil {
+		return nil, nil, err
+	}
+	return l, entries, nil
+}
+
+// append 在请求体从磁盘队列中取出后立即落盘，避免进程崩溃时丢失已经出队但未发送的数据
+func (l *inflightLog) append(data []byte) error {
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// rewrite 以 entries 替换 inflight 文件的内容
+func (l *inflightLog) rewrite(entries []*entry) error {
+	tmpPath := fmt.Sprintf("%s.%d.tmp", l.path, rand.Int())
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		data, err := jsoniter.Marshal(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, l.path); err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+	return err
+}
+
+func (l *inflightLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (r *Reader) openQueue(maxSize int64) error {
+	inflight, entries, err := openInflightLog(filepath.Join(r.meta.BufFile(), inflightFile))
+	if err != nil {
+		return fmt.Errorf("open inflight log failed: %v", err)
+	}
+	for _, e := range entries {
+		if err = r.parseEntry(e); err != nil {
+			log.Errorf("Runner[%v] %q drop invalid inflight entry of %v: %v", r.meta.RunnerName, r.Name(), e.Path, err)
+			continue
+		}
+		r.recovered = append(r.recovered, e)
+	}
+	r.inflight = inflight
+
+	// 磁盘占用只在整个文件读完删除后才会释放，单个文件不宜超过上限的四分之一
+	maxBytesPerFile := int64(DefaultMaxBytesPerFile)
+	if maxBytesPerFile > maxSize/4 {
+		maxBytesPerFile = maxSize / 4
+	}
+	r.queue = queue.NewDiskQueue(queue.NewDiskQueueOptions{
+		Name:            queueName,
+		DataPath:        r.meta.BufFile(),
+		MaxBytesPerFile: maxBytesPerFile,
+		MaxMsgSize:      DefaultMaxBodySize/3*4 + 4096,
+		// 请求体写入队列后才响应，每次写入都落盘
+		SyncEveryWrite:   1,
+		SyncEveryRead:    DefaultSyncEvery,
+		SyncTimeout:      2 * time.Second,
+		WriteRateLimit:   DefaultWriteSpeedLimit,
+		MaxDiskUsedBytes: maxSize,
+	})
+	return nil
+}
+
+func (r *Reader) closeQueue() error {
+	if r.queue == nil {
+		return nil
+	}
+	err := r.queue.Close()
+	if cerr := r.inflight.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// parseEntry 将请求体切分为行或者解析为数据
+func (r *Reader) parseEntry(e *entry) error {
+	if r.bodyFormat == reader.HTTPBodyFormatRaw {
+		e.lines = splitLines(string(e.Body))
+		return nil
+	}
+	datas, err := parseJSONBody(e.Body)
+	if err != nil {
+		return err
+	}
+	for _, data := range datas {
+		r.tagData(e.Path, data.Data)
+	}
+	e.datas = datas
+	return nil
+}
+
+func (r *Reader) putEntry(e *entry) error {
+	data, err := jsoniter.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = r.queue.Put(data); err != nil {
+		if errors.Is(err, queue.ErrQueueFull) {
+			return &queueFullError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) queueError(c echo.Context, err error) error {
+	switch err.(type) {
+	case *badRequestError:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case *queueFullError:
+		c.Response().Header().Set("Retry-After", strconv.Itoa(DefaultRetryAfter))
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	}
+	log.Errorf("Runner[%v] %q put request body into queue failed: %v", r.meta.RunnerName, r.Name(), err)
+	c.Response().Header().Set("Retry-After", strconv.Itoa(DefaultRetryAfter))
+	return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+}
+
+// readDurable 依次读取上次未确认的数据与磁盘队列中的数据，取出的请求体先记录到 inflight 文件中
+func (r *Reader) readDurable() (Details, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		if e := r.current; e != nil && e.Offset < e.len() {
+			e.Offset++
+			if e.datas != nil {
+				data := e.datas[e.Offset-1]
+				return Details{Data: data.Data, Size: data.Size, Path: e.Path}, nil
+			}
+			return Details{Content: e.lines[e.Offset-1], Path: e.Path}, nil
+		}
+		if len(r.recovered) > 0 {
+			r.current = r.recovered[0]
+			r.recovered = r.recovered[1:]
+			continue
+		}
+
+		var msg []byte
+		select {
+		case msg = <-r.queue.ReadChan():
+		case <-timer.C:
+			return Details{}, nil
+		}
+		e := &entry{}
+		if err := jsoniter.Unmarshal(msg, e); err != nil {
+			log.Errorf("Runner[%v] %q drop invalid queue entry: %v", r.meta.RunnerName, r.Name(), err)
+			continue
+		}
+		if err := r.inflight.append(msg); err != nil {
+			return Details{}, fmt.Errorf("write inflight log failed: %v", err)
+		}
+		if err := r.parseEntry(e); err != nil {
+			log.Errorf("Runner[%v] %q drop invalid queue entry of %v: %v", r.meta.RunnerName, r.Name(), e.Path, err)
+			continue
+		}
+		r.current = e
+	}
+}
+
+// commit 确认已经读取的数据，inflight 文件中只保留尚未读完的请求体
+func (r *Reader) commit() error {
+	var pending []*entry
+	if r.current != nil && r.current.Offset < r.current.len() {
+		pending = append(pending, r.current)
+	}
+	pending = append(pending, r.recovered...)
+	return r.inflight.rewrite(pending)
+}
@@ -2,22 +2,29 @@ package http
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/labstack/echo"
 
 	log "k8s.io/klog/v2"
 
 	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/queue"
 	"github.com/longxiucai/logkit/reader"
 	. "github.com/longxiucai/logkit/utils/models"
 )
@@ -25,6 +32,7 @@ import (
 var (
 	_ reader.DaemonReader = &Reader{}
 	_ reader.Reader       = &Reader{}
+	_ reader.DataReader   = &JSONReader{}
 )
 
 const (
@@ -32,6 +40,9 @@ const (
 	DefaultMaxBodySize     = 100 * 1024 * 1024
 	DefaultMaxBytesPerFile = 500 * 1024 * 1024
 	DefaultWriteSpeedLimit = 10 * 1024 * 1024 // 默认写速限制为10MB
+	DefaultQueueMaxSize    = 1024 * 1024 * 1024
+	// DefaultRetryAfter 为队列已满或者服务停止时建议客户端重试的间隔，单位为秒
+	DefaultRetryAfter = 5
 )
 
 func init() {
@@ -41,6 +52,9 @@ func init() {
 type Details struct {
 	Content string
 	Path    string
+	// Data 为 json 格式请求体中的一个对象
+	Data Data
+	Size int64
 }
 
 type Reader struct {
@@ -55,9 +69,27 @@ type Reader struct {
 	paths       []string
 	wg          sync.WaitGroup
 
+	bodyFormat string
+	authToken  string
+	tlsCert    string
+	tlsKey     string
+	pathTags   map[string]map[string]interface{}
+
+	// durable 模式下请求体先写入磁盘队列，队列中取出的数据在 SyncMeta 之前保存在 inflight 文件中
+	durable   bool
+	queue     queue.BackendQueue
+	inflight  *inflightLog
+	current   *entry
+	recovered []*entry
+
 	server *http.Server
 }
 
+// JSONReader 用于 json 格式的请求体，每个对象直接作为一条数据输出，无需再经过解析器
+type JSONReader struct {
+	*Reader
+}
+
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	address, _ := conf.GetStringOr(reader.KeyHTTPServiceAddress, reader.DefaultHTTPServiceAddress)
 	path, _ := conf.GetStringOr(reader.KeyHTTPServicePath, reader.DefaultHTTPServicePath)
@@ -73,17 +105,56 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	}
 	address, _ = RemoveHttpProtocal(address)
 
+	bodyFormat, _ := conf.GetStringOr(reader.KeyHTTPBodyFormat, reader.HTTPBodyFormatRaw)
+	if bodyFormat != reader.HTTPBodyFormatRaw && bodyFormat != reader.HTTPBodyFormatJSON {
+		return nil, fmt.Errorf("%s %q is not supported", reader.KeyHTTPBodyFormat, bodyFormat)
+	}
+	authToken, _ := conf.GetStringOr(reader.KeyHTTPAuthToken, "")
+	tlsCert, _ := conf.GetStringOr(reader.KeyHTTPTLSCert, "")
+	tlsKey, _ := conf.GetStringOr(reader.KeyHTTPTLSKey, "")
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", reader.KeyHTTPTLSCert, reader.KeyHTTPTLSKey)
+	}
+	if tlsCert != "" {
+		if _, err := tls.LoadX509KeyPair(tlsCert, tlsKey); err != nil {
+			return nil, fmt.Errorf("load tls certificate failed: %v", err)
+		}
+	}
+	var pathTags map[string]map[string]interface{}
+	if tags, _ := conf.GetStringOr(reader.KeyHTTPPathTags, ""); tags != "" {
+		if err := jsoniter.Unmarshal([]byte(tags), &pathTags); err != nil {
+			return nil, fmt.Errorf("parse %s failed: %v", reader.KeyHTTPPathTags, err)
+		}
+	}
+	durable, _ := conf.GetBoolOr(reader.KeyHTTPDurable, false)
+	queueMaxSize, _ := conf.GetInt64Or(reader.KeyHTTPQueueMaxSize, DefaultQueueMaxSize)
+
 	err := CreateDirIfNotExist(meta.BufFile())
 	if err != nil {
 		return nil, err
 	}
-	return &Reader{
-		meta:     meta,
-		status:   reader.StatusInit,
-		readChan: make(chan Details, len(paths)),
-		address:  address,
-		paths:    paths,
-	}, nil
+	r := &Reader{
+		meta:       meta,
+		status:     reader.StatusInit,
+		readChan:   make(chan Details, len(paths)),
+		address:    address,
+		paths:      paths,
+		bodyFormat: bodyFormat,
+		authToken:  authToken,
+		tlsCert:    tlsCert,
+		tlsKey:     tlsKey,
+		pathTags:   pathTags,
+		durable:    durable,
+	}
+	if durable {
+		if err = r.openQueue(queueMaxSize); err != nil {
+			return nil, err
+		}
+	}
+	if bodyFormat == reader.HTTPBodyFormatJSON {
+		return &JSONReader{Reader: r}, nil
+	}
+	return r, nil
 }
 
 func (r *Reader) isStopping() bool {
@@ -120,7 +191,13 @@ func (r *Reader) Start() error {
 		Addr:    r.address,
 	}
 	go func() {
-		if err := r.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if r.tlsCert != "" {
+			err = r.server.ListenAndServeTLS(r.tlsCert, r.tlsKey)
+		} else {
+			err = r.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Errorf("Runner[%v] %q daemon start HTTP server failed: %v", r.meta.RunnerName, r.Name(), err)
 		}
 	}()
@@ -132,7 +209,10 @@ func (r *Reader) Source() string {
 	return r.address + r.currentPath
 }
 
-func (r *Reader) ReadLine() (string, error) {
+func (r *Reader) read() (Details, error) {
+	if r.durable {
+		return r.readDurable()
+	}
 	timer := time.NewTimer(time.Second)
 	defer timer.Stop()
 	select {
@@ -142,23 +222,54 @@ func (r *Reader) ReadLine() (string, error) {
 			//Note：确保所有数据被读取后，再关闭channel
 			r.wg.Done()
 		}
-		r.currentPath = data.Path
-		return data.Content, nil
+		return data, nil
 	case <-timer.C:
 	}
 
-	return "", nil
+	return Details{}, nil
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	data, err := r.read()
+	if err != nil {
+		return "", err
+	}
+	if data.Path != "" {
+		r.currentPath = data.Path
+	}
+	return data.Content, nil
+}
+
+func (r *JSONReader) ReadData() (Data, int64, error) {
+	data, err := r.read()
+	if err != nil || data.Data == nil {
+		return nil, 0, err
+	}
+	r.currentPath = data.Path
+	return data.Data, data.Size, nil
 }
 
-func (_ *Reader) SyncMeta() {}
+// SyncMeta 在 durable 模式下确认已经读取的数据，只保留尚未读取完的数据
+func (r *Reader) SyncMeta() {
+	if !r.durable {
+		return
+	}
+	if err := r.commit(); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
 
 func (r *Reader) Close() error {
 	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
 		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
-		return nil
+		return r.closeQueue()
 	}
 	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
 	r.server.Shutdown(context.Background())
+	if r.durable {
+		atomic.StoreInt32(&r.status, reader.StatusStopped)
+		return r.closeQueue()
+	}
 	//Note：确保所有数据被读取后，再关闭channel
 	r.wg.Wait()
 	close(r.readChan)
@@ -168,13 +279,34 @@ func (r *Reader) Close() error {
 
 func (r *Reader) postData() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !r.authorized(c.Request()) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid bearer token"})
+		}
+		if r.isStopping() || r.hasStopped() {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(DefaultRetryAfter))
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "reader is stopping"})
+		}
 		if err := r.pickUpData(c.Request()); err != nil {
+			if r.durable {
+				return r.queueError(c, err)
+			}
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 		}
 		return c.JSON(http.StatusOK, nil)
 	}
 }
 
+func (r *Reader) authorized(req *http.Request) bool {
+	if r.authToken == "" {
+		return true
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(r.authToken)) == 1
+}
+
 func (r *Reader) pickUpData(req *http.Request) (err error) {
 	if req.ContentLength > DefaultMaxBodySize {
 		return errors.New("the request body is too large")
@@ -189,13 +321,39 @@ func (r *Reader) pickUpData(req *http.Request) (err error) {
 			return fmt.Errorf("read gzip body error %v", err)
 		}
 	}
-	br := bufio.NewReader(reqBody)
-	return r.storageData(br, req.RequestURI)
+	if !r.durable && r.bodyFormat == reader.HTTPBodyFormatRaw {
+		br := bufio.NewReader(reqBody)
+		return r.storageData(br, req.RequestURI)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(reqBody, DefaultMaxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("read body error %v", err)
+	}
+	if len(body) > DefaultMaxBodySize {
+		return errors.New("the request body is too large")
+	}
+	e := &entry{Path: req.RequestURI, Body: body}
+	// 在响应之前校验请求体，避免无法解析的数据进入队列
+	if err = r.parseEntry(e); err != nil {
+		return &badRequestError{err}
+	}
+	if r.durable {
+		return r.putEntry(e)
+	}
+	for _, data := range e.datas {
+		if r.isStopping() || r.hasStopped() {
+			return nil
+		}
+		r.wg.Add(1)
+		r.readChan <- Details{Data: data.Data, Size: data.Size, Path: e.Path}
+	}
+	return nil
 }
 
 func (r *Reader) storageData(br *bufio.Reader, path string) (err error) {
 	for {
-		line, err := r.readLine(br)
+		line, err := readLine(br)
 		if err != nil {
 			if err != io.EOF {
 				log.Errorf("runner[%v] Reader[%v] read data from http request error, %v\n", r.meta.RunnerName, r.Name(), err)
@@ -217,7 +375,7 @@ func (r *Reader) storageData(br *bufio.Reader, path string) (err error) {
 	return
 }
 
-func (r *Reader) readLine(br *bufio.Reader) (str string, err error) {
+func readLine(br *bufio.Reader) (str string, err error) {
 	isPrefix := true
 	var line, fragment []byte
 	for isPrefix && err == nil {
@@ -226,3 +384,77 @@ func (r *Reader) readLine(br *bufio.Reader) (str string, err error) {
 	}
 	return string(line), err
 }
+
+// splitLines 将请求体按行切分，忽略空行
+func splitLines(body string) []string {
+	var lines []string
+	br := bufio.NewReader(strings.NewReader(body))
+	for {
+		line, err := readLine(br)
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+type jsonData struct {
+	Data Data
+	Size int64
+}
+
+// parseJSONBody 解析 NDJSON 或者 JSON 数组格式的请求体
+func parseJSONBody(body []byte) ([]jsonData, error) {
+	trimmed := bytes.TrimSpace(body)
+	var raws []jsoniter.RawMessage
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := jsoniter.Unmarshal(trimmed, &raws); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := jsoniter.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var raw jsoniter.RawMessage
+			err := dec.Decode(&raw)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			raws = append(raws, raw)
+		}
+	}
+	datas := make([]jsonData, 0, len(raws))
+	for _, raw := range raws {
+		data := make(Data)
+		if err := jsoniter.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("%s is not a json object: %v", TruncateStrSize(string(raw), DefaultTruncateMaxSize), err)
+		}
+		datas = append(datas, jsonData{Data: data, Size: int64(len(raw))})
+	}
+	return datas, nil
+}
+
+// LineTags 返回最近一次读取的行所属请求路径对应的字段，用于 raw 格式的请求体
+func (r *Reader) LineTags() map[string]interface{} {
+	return r.pathTags[trimQuery(r.currentPath)]
+}
+
+func trimQuery(path string) string {
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		return path[:idx]
+	}
+	return path
+}
+
+// tagData 为数据添加请求路径对应的字段，数据中已有的字段不会被覆盖
+func (r *Reader) tagData(path string, data Data) {
+	for k, v := range r.pathTags[trimQuery(path)] {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+}
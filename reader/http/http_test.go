@@ -133,3 +133,142 @@ func init() {
 		"asdfghjkl;';lkjhgfdsa",
 	}
 }
+
+func newDurableReader(t *testing.T, c conf.MapConf) *JSONReader {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		reader.KeyMetaPath: MetaDir,
+		reader.KeyFileDone: MetaDir,
+		reader.KeyMode:     reader.ModeHTTP,
+		KeyRunnerName:      "TestHttpReaderDurable",
+	})
+	assert.NoError(t, err)
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	jr, ok := r.(*JSONReader)
+	assert.True(t, ok)
+	assert.NoError(t, jr.Start())
+	return jr
+}
+
+func post(t *testing.T, url, token, body string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	var resp *http.Response
+	// 等待监听启动
+	for i := 0; i < 50; i++ {
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+	}
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestHttpReaderDurable(t *testing.T) {
+	defer os.RemoveAll("./meta")
+	c := conf.MapConf{
+		reader.KeyHTTPServiceAddress: "127.0.0.1:7113",
+		reader.KeyHTTPServicePath:    "/logkit/aaa",
+		reader.KeyHTTPBodyFormat:     reader.HTTPBodyFormatJSON,
+		reader.KeyHTTPDurable:        "true",
+		reader.KeyHTTPAuthToken:      "secret",
+		reader.KeyHTTPPathTags:       `{"/logkit/aaa":{"app":"a"}}`,
+	}
+	r := newDurableReader(t, c)
+
+	url := "http://127.0.0.1:7113/logkit/aaa"
+	assert.Equal(t, http.StatusUnauthorized, post(t, url, "", `{"a":1}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, post(t, url, "secret", `{"a":`).StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, url, "secret", "{\"a\":1}\n{\"a\":2,\"app\":\"b\"}\n").StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, url, "secret", `[{"a":3},{"a":4}]`).StatusCode)
+
+	data, _, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"a": float64(1), "app": "a"}, data)
+	assert.Equal(t, "127.0.0.1:7113/logkit/aaa", r.Source())
+	r.SyncMeta()
+	data, _, err = r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"a": float64(2), "app": "b"}, data)
+	data, _, err = r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, Data{"a": float64(3), "app": "a"}, data)
+	// 未 SyncMeta 的数据重启后重新读取
+	assert.NoError(t, r.Close())
+
+	r = newDurableReader(t, c)
+	defer r.Close()
+	var got []interface{}
+	for i := 0; i < 5 && len(got) < 3; i++ {
+		data, _, err = r.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			got = append(got, data["a"])
+		}
+	}
+	assert.Equal(t, []interface{}{float64(2), float64(3), float64(4)}, got)
+	r.SyncMeta()
+	data, _, err = r.ReadData()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestHttpReaderQueueFull(t *testing.T) {
+	defer os.RemoveAll("./meta")
+	r := newDurableReader(t, conf.MapConf{
+		reader.KeyHTTPServiceAddress: "127.0.0.1:7114",
+		reader.KeyHTTPServicePath:    "/logkit/aaa",
+		reader.KeyHTTPBodyFormat:     reader.HTTPBodyFormatJSON,
+		reader.KeyHTTPDurable:        "true",
+		reader.KeyHTTPQueueMaxSize:   "64",
+	})
+	defer r.Close()
+
+	url := "http://127.0.0.1:7114/logkit/aaa"
+	assert.Equal(t, http.StatusOK, post(t, url, "", `{"a":1}`).StatusCode)
+	resp := post(t, url, "", `{"a":"`+strings.Repeat("x", 64)+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+}
+
+func TestHttpReaderRawPathTags(t *testing.T) {
+	defer os.RemoveAll("./meta")
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		reader.KeyMetaPath: MetaDir,
+		reader.KeyFileDone: MetaDir,
+		reader.KeyMode:     reader.ModeHTTP,
+		KeyRunnerName:      "TestHttpReaderRawPathTags",
+	})
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, conf.MapConf{
+		reader.KeyHTTPServiceAddress: "127.0.0.1:7115",
+		reader.KeyHTTPServicePath:    "/logkit/aaa,/logkit/bbb",
+		reader.KeyHTTPDurable:        "true",
+		reader.KeyHTTPPathTags:       `{"/logkit/aaa":{"app":"a"}}`,
+	})
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	assert.Equal(t, http.StatusOK, post(t, "http://127.0.0.1:7115/logkit/aaa?x=1", "", "line1\nline2").StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, "http://127.0.0.1:7115/logkit/bbb", "", "line3").StatusCode)
+	var lines []string
+	var tags []map[string]interface{}
+	for i := 0; i < 10 && len(lines) < 3; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+			tags = append(tags, r.LineTags())
+		}
+	}
+	assert.Equal(t, []string{"line1", "line2", "line3"}, lines)
+	assert.Equal(t, []map[string]interface{}{{"app": "a"}, {"app": "a"}, nil}, tags)
+}
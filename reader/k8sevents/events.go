@@ -0,0 +1,195 @@
+package k8sevents
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/longxiucai/logkit/reader"
+)
+
+// event 为两种 Event API 统一后的结果，count 为该 Event 已经发生的次数
+type event struct {
+	uid             string
+	resourceVersion string
+	count           int32
+	data            map[string]interface{}
+}
+
+// eventsAPI 封装不同版本的 Event API 的 list/watch 以及转换
+type eventsAPI interface {
+	list(ctx context.Context, opts metav1.ListOptions) (events []*event, resourceVersion, continueToken string, err error)
+	watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	convert(obj runtime.Object) (*event, bool)
+}
+
+func newEventsAPI(client kubernetes.Interface, version, namespace string) (eventsAPI, error) {
+	switch version {
+	case reader.K8sEventsAPIEventsV1:
+		return &eventsV1API{client: client, namespace: namespace}, nil
+	case reader.K8sEventsAPICoreV1:
+		return &coreV1API{client: client, namespace: namespace}, nil
+	}
+	return nil, fmt.Errorf("%s %q is not supported", reader.KeyK8sEventsAPI, version)
+}
+
+type eventsV1API struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (a *eventsV1API) list(ctx context.Context, opts metav1.ListOptions) ([]*event, string, string, error) {
+	list, err := a.client.EventsV1().Events(a.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, "", "", err
+	}
+	events := make([]*event, 0, len(list.Items))
+	for i := range list.Items {
+		events = append(events, convertEventsV1(&list.Items[i]))
+	}
+	return events, list.ResourceVersion, list.Continue, nil
+}
+
+func (a *eventsV1API) watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return a.client.EventsV1().Events(a.namespace).Watch(ctx, opts)
+}
+
+func (a *eventsV1API) convert(obj runtime.Object) (*event, bool) {
+	e, ok := obj.(*eventsv1.Event)
+	if !ok {
+		return nil, false
+	}
+	return convertEventsV1(e), true
+}
+
+func convertEventsV1(e *eventsv1.Event) *event {
+	count := e.DeprecatedCount
+	if e.Series != nil && e.Series.Count > count {
+		count = e.Series.Count
+	}
+	if count < 1 {
+		count = 1
+	}
+	data := map[string]interface{}{
+		"namespace":            e.Namespace,
+		"name":                 e.Name,
+		"uid":                  string(e.UID),
+		"reason":               e.Reason,
+		"message":              e.Note,
+		"type":                 e.Type,
+		"action":               e.Action,
+		"count":                count,
+		"reporting_controller": e.ReportingController,
+		"reporting_instance":   e.ReportingInstance,
+		"source_component":     e.DeprecatedSource.Component,
+		"source_host":          e.DeprecatedSource.Host,
+	}
+	setTime(data, "event_time", e.EventTime.Time)
+	setTime(data, "first_timestamp", e.DeprecatedFirstTimestamp.Time)
+	setTime(data, "last_timestamp", e.DeprecatedLastTimestamp.Time)
+	if e.Series != nil {
+		setTime(data, "last_observed_time", e.Series.LastObservedTime.Time)
+	}
+	flattenObjectReference(data, "involved_object_", &e.Regarding)
+	if e.Related != nil {
+		flattenObjectReference(data, "related_", e.Related)
+	}
+	return newEvent(string(e.UID), e.ResourceVersion, count, data)
+}
+
+type coreV1API struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (a *coreV1API) list(ctx context.Context, opts metav1.ListOptions) ([]*event, string, string, error) {
+	list, err := a.client.CoreV1().Events(a.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, "", "", err
+	}
+	events := make([]*event, 0, len(list.Items))
+	for i := range list.Items {
+		events = append(events, convertCoreV1(&list.Items[i]))
+	}
+	return events, list.ResourceVersion, list.Continue, nil
+}
+
+func (a *coreV1API) watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return a.client.CoreV1().Events(a.namespace).Watch(ctx, opts)
+}
+
+func (a *coreV1API) convert(obj runtime.Object) (*event, bool) {
+	e, ok := obj.(*corev1.Event)
+	if !ok {
+		return nil, false
+	}
+	return convertCoreV1(e), true
+}
+
+func convertCoreV1(e *corev1.Event) *event {
+	count := e.Count
+	if e.Series != nil && e.Series.Count > count {
+		count = e.Series.Count
+	}
+	if count < 1 {
+		count = 1
+	}
+	data := map[string]interface{}{
+		"namespace":            e.Namespace,
+		"name":                 e.Name,
+		"uid":                  string(e.UID),
+		"reason":               e.Reason,
+		"message":              e.Message,
+		"type":                 e.Type,
+		"action":               e.Action,
+		"count":                count,
+		"reporting_controller": e.ReportingController,
+		"reporting_instance":   e.ReportingInstance,
+		"source_component":     e.Source.Component,
+		"source_host":          e.Source.Host,
+	}
+	setTime(data, "event_time", e.EventTime.Time)
+	setTime(data, "first_timestamp", e.FirstTimestamp.Time)
+	setTime(data, "last_timestamp", e.LastTimestamp.Time)
+	if e.Series != nil {
+		setTime(data, "last_observed_time", e.Series.LastObservedTime.Time)
+	}
+	flattenObjectReference(data, "involved_object_", &e.InvolvedObject)
+	if e.Related != nil {
+		flattenObjectReference(data, "related_", e.Related)
+	}
+	return newEvent(string(e.UID), e.ResourceVersion, count, data)
+}
+
+// newEvent 去掉为空的字段
+func newEvent(uid, resourceVersion string, count int32, data map[string]interface{}) *event {
+	for k, v := range data {
+		if s, ok := v.(string); ok && s == "" {
+			delete(data, k)
+		}
+	}
+	return &event{uid: uid, resourceVersion: resourceVersion, count: count, data: data}
+}
+
+func setTime(data map[string]interface{}, key string, t time.Time) {
+	if !t.IsZero() {
+		data[key] = t.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func flattenObjectReference(data map[string]interface{}, prefix string, ref *corev1.ObjectReference) {
+	data[prefix+"kind"] = ref.Kind
+	data[prefix+"namespace"] = ref.Namespace
+	data[prefix+"name"] = ref.Name
+	data[prefix+"uid"] = string(ref.UID)
+	data[prefix+"api_version"] = ref.APIVersion
+	data[prefix+"resource_version"] = ref.ResourceVersion
+	data[prefix+"field_path"] = ref.FieldPath
+}
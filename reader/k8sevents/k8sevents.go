@@ -0,0 +1,443 @@
+package k8sevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// listPageSize 为 list 时每页的 Event 数
+	listPageSize = 500
+	// retryDelay 为 list/watch 失败后重试的等待时间
+	retryDelay = 5 * time.Second
+	// recordsFileName 为记录 watch 进度的文件名
+	recordsFileName = reader.DoneFileName + ".watch"
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeK8sEvents, NewReader)
+}
+
+// item 为 watch 到的一次变化，line 为空表示只需要更新 watch 进度
+type item struct {
+	line            string
+	uid             string
+	count           int32
+	deleted         bool
+	resourceVersion string
+	// listed 不为 nil 表示一次完整的 list 结束，不在其中的 Event 已经被删除
+	listed map[string]struct{}
+}
+
+// watchRecords 记录 resourceVersion，以及已经读取的 Event 及其重复次数
+type watchRecords struct {
+	ResourceVersion string           `json:"resource_version"`
+	Counts          map[string]int32 `json:"counts"`
+}
+
+func recordsFile(m *reader.Meta) string {
+	return filepath.Join(m.DoneFilePath, recordsFileName)
+}
+
+// readRecords 读取 watch 进度，文件不存在时返回空记录
+func readRecords(m *reader.Meta) (records watchRecords, err error) {
+	err = reader.ReadJSONRecords(recordsFile(m), &records)
+	if records.Counts == nil {
+		records.Counts = make(map[string]int32)
+	}
+	return
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan item
+	errChan  chan error
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	api           eventsAPI
+	host          string
+	namespace     string
+	fieldSelector string
+
+	// seen 为 watch 协程已经输出的 Event 及其次数，用于去重
+	seen map[string]int32
+	// records 为已经读取的 watch 进度，在 SyncMeta 时写入 meta
+	recordsLock sync.Mutex
+	records     watchRecords
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	kubeconfig, _ := conf.GetStringOr(reader.KeyK8sKubeconfig, "")
+	config, err := restConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(meta, conf, client, config.Host)
+}
+
+// restConfig 优先使用指定的 kubeconfig，其次为 in-cluster 配置，最后为 KUBECONFIG 或者 ~/.kube/config
+func restConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		return config, nil
+	}
+	config, err2 := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err2 != nil {
+		return nil, fmt.Errorf("load in-cluster config failed: %v, load kubeconfig failed: %v", err, err2)
+	}
+	return config, nil
+}
+
+func newReader(meta *reader.Meta, conf conf.MapConf, client kubernetes.Interface, host string) (*Reader, error) {
+	version, _ := conf.GetStringOr(reader.KeyK8sEventsAPI, reader.K8sEventsAPIEventsV1)
+	namespace, _ := conf.GetStringOr(reader.KeyK8sNamespace, "")
+	fieldSelector, _ := conf.GetStringOr(reader.KeyK8sFieldSelector, "")
+	api, err := newEventsAPI(client, version, namespace)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readRecords(meta)
+	if err != nil {
+		return nil, fmt.Errorf("read watch records failed: %v", err)
+	}
+	seen := make(map[string]int32, len(records.Counts))
+	for uid, count := range records.Counts {
+		seen[uid] = count
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reader{
+		meta:          meta,
+		status:        reader.StatusInit,
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan item),
+		errChan:       make(chan error),
+		ctx:           ctx,
+		cancel:        cancel,
+		api:           api,
+		host:          host,
+		namespace:     namespace,
+		fieldSelector: fieldSelector,
+		seen:          seen,
+		records:       records,
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "K8sEventsReader<" + r.host + "," + r.namespace + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("k8s events reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	r.recordsLock.Lock()
+	resourceVersion := r.records.ResourceVersion
+	r.recordsLock.Unlock()
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = r.list()
+		}
+		if err == nil {
+			resourceVersion, err = r.watch(resourceVersion)
+		}
+		if err == nil {
+			continue
+		}
+		if isExpired(err) {
+			// resourceVersion 已经过期，重新 list
+			log.Warningf("Runner[%v] %q resource version %v expired, relisting", r.meta.RunnerName, r.Name(), resourceVersion)
+			resourceVersion = ""
+			continue
+		}
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		r.sendError(err)
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+func isExpired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
+
+// list 分页读取所有 Event，返回用于 watch 的 resourceVersion
+func (r *Reader) list() (string, error) {
+	opts := metav1.ListOptions{FieldSelector: r.fieldSelector, Limit: listPageSize}
+	listed := make(map[string]struct{})
+	var resourceVersion string
+	for {
+		events, rv, continueToken, err := r.api.list(r.ctx, opts)
+		if err != nil {
+			return "", fmt.Errorf("list events failed: %v", err)
+		}
+		for _, e := range events {
+			listed[e.uid] = struct{}{}
+			if !r.emit(e, "") {
+				return "", r.ctx.Err()
+			}
+		}
+		resourceVersion = rv
+		if continueToken == "" {
+			break
+		}
+		opts.Continue = continueToken
+	}
+	for uid := range r.seen {
+		if _, ok := listed[uid]; !ok {
+			delete(r.seen, uid)
+		}
+	}
+	if !r.send(item{resourceVersion: resourceVersion, listed: listed}) {
+		return "", r.ctx.Err()
+	}
+	return resourceVersion, nil
+}
+
+// watch 从 resourceVersion 开始 watch，在连接正常结束时返回最新的 resourceVersion 以便继续 watch
+func (r *Reader) watch(resourceVersion string) (string, error) {
+	w, err := r.api.watch(r.ctx, metav1.ListOptions{
+		FieldSelector:       r.fieldSelector,
+		ResourceVersion:     resourceVersion,
+		AllowWatchBookmarks: true,
+	})
+	if err != nil {
+		return resourceVersion, fmt.Errorf("watch events failed: %v", err)
+	}
+	defer w.Stop()
+	for {
+		var ev watch.Event
+		var ok bool
+		select {
+		case ev, ok = <-w.ResultChan():
+		case <-r.stopChan:
+			return resourceVersion, nil
+		}
+		if !ok {
+			return resourceVersion, nil
+		}
+		switch ev.Type {
+		case watch.Error:
+			return resourceVersion, apierrors.FromObject(ev.Object)
+		case watch.Bookmark:
+			if m, err := meta.Accessor(ev.Object); err == nil {
+				resourceVersion = m.GetResourceVersion()
+				r.send(item{resourceVersion: resourceVersion})
+			}
+			continue
+		}
+		e, ok := r.api.convert(ev.Object)
+		if !ok {
+			continue
+		}
+		resourceVersion = e.resourceVersion
+		if ev.Type == watch.Deleted {
+			delete(r.seen, e.uid)
+			r.send(item{uid: e.uid, deleted: true, resourceVersion: resourceVersion})
+			continue
+		}
+		if !r.emit(e, resourceVersion) {
+			return resourceVersion, nil
+		}
+	}
+}
+
+// emit 输出次数增加了的 Event，重复的 Event 只更新 watch 进度，返回 false 表示 reader 已经停止
+func (r *Reader) emit(e *event, resourceVersion string) bool {
+	it := item{uid: e.uid, count: e.count, resourceVersion: resourceVersion}
+	if e.count > r.seen[e.uid] {
+		line, err := jsoniter.MarshalToString(e.data)
+		if err != nil {
+			log.Errorf("Runner[%v] %q marshal event %v failed: %v", r.meta.RunnerName, r.Name(), e.uid, err)
+		} else {
+			it.line = line
+			r.seen[e.uid] = e.count
+		}
+	}
+	if it.line == "" && resourceVersion == "" {
+		return true
+	}
+	return r.send(it)
+}
+
+func (r *Reader) send(it item) bool {
+	select {
+	case r.readChan <- it:
+		return true
+	case <-r.stopChan:
+		return false
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.host + "/" + r.namespace
+}
+
+// apply 将读取到的变化更新到 watch 进度中
+func (r *Reader) apply(it item) {
+	r.recordsLock.Lock()
+	defer r.recordsLock.Unlock()
+	if it.listed != nil {
+		for uid := range r.records.Counts {
+			if _, ok := it.listed[uid]; !ok {
+				delete(r.records.Counts, uid)
+			}
+		}
+	}
+	if it.deleted {
+		delete(r.records.Counts, it.uid)
+	} else if it.line != "" {
+		r.records.Counts[it.uid] = it.count
+	}
+	if it.resourceVersion != "" {
+		r.records.ResourceVersion = it.resourceVersion
+	}
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case it := <-r.readChan:
+			r.apply(it)
+			if it.line != "" {
+				return it.line, nil
+			}
+		case err := <-r.errChan:
+			return "", err
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 记录已经读取的 Event 的 resourceVersion 以及各个 Event 的次数
+func (r *Reader) SyncMeta() {
+	r.recordsLock.Lock()
+	defer r.recordsLock.Unlock()
+	if err := reader.WriteJSONRecords(recordsFile(r.meta), &r.records); err != nil {
+		log.Errorf("Runner[%v] %q write watch records failed: %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		r.cancel()
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.cancel()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
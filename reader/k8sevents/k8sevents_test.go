@@ -0,0 +1,168 @@
+package k8sevents
+
+import (
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+func newFakeClient(objects ...runtime.Object) (*fake.Clientset, *watch.FakeWatcher) {
+	client := fake.NewSimpleClientset(objects...)
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("events", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+	return client, watcher
+}
+
+func startReader(t *testing.T, c conf.MapConf, client *fake.Clientset) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := newReader(meta, c, client, "https://127.0.0.1:6443")
+	require.NoError(t, err)
+	require.NoError(t, r.Start())
+	return r
+}
+
+func readEvent(t *testing.T, r reader.Reader) map[string]interface{} {
+	line, err := r.ReadLine()
+	require.NoError(t, err)
+	require.NotEmpty(t, line)
+	data := make(map[string]interface{})
+	require.NoError(t, jsoniter.Unmarshal([]byte(line), &data))
+	return data
+}
+
+func TestK8sEventsReader(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	oom := &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.1", Namespace: "default", UID: "uid-1", ResourceVersion: "10"},
+		EventTime:  metav1.NewMicroTime(created),
+		Reason:     "OOMKilling",
+		Note:       "Memory cgroup out of memory",
+		Type:       corev1.EventTypeWarning,
+		Regarding: corev1.ObjectReference{
+			Kind: "Pod", Namespace: "default", Name: "web", UID: "pod-uid", APIVersion: "v1",
+		},
+		ReportingController: "kubelet",
+	}
+	client, watcher := newFakeClient(oom)
+	c := conf.MapConf{
+		reader.KeyMetaPath:   t.TempDir(),
+		reader.KeyMode:       reader.ModeK8sEvents,
+		models.KeyRunnerName: "TestK8sEventsReader",
+	}
+	r := startReader(t, c, client)
+
+	data := readEvent(t, r)
+	assert.Equal(t, map[string]interface{}{
+		"namespace":                   "default",
+		"name":                        "web.1",
+		"uid":                         "uid-1",
+		"reason":                      "OOMKilling",
+		"message":                     "Memory cgroup out of memory",
+		"type":                        "Warning",
+		"count":                       float64(1),
+		"event_time":                  "2026-01-02T03:04:05Z",
+		"reporting_controller":        "kubelet",
+		"involved_object_kind":        "Pod",
+		"involved_object_namespace":   "default",
+		"involved_object_name":        "web",
+		"involved_object_uid":         "pod-uid",
+		"involved_object_api_version": "v1",
+	}, data)
+
+	// 次数增加时输出，次数不变的更新被去重
+	repeated := oom.DeepCopy()
+	repeated.ResourceVersion = "11"
+	repeated.Series = &eventsv1.EventSeries{Count: 3, LastObservedTime: metav1.NewMicroTime(created.Add(time.Minute))}
+	go watcher.Modify(repeated)
+	data = readEvent(t, r)
+	assert.Equal(t, float64(3), data["count"])
+	assert.Equal(t, "2026-01-02T03:05:05Z", data["last_observed_time"])
+
+	unchanged := repeated.DeepCopy()
+	unchanged.ResourceVersion = "12"
+	go watcher.Modify(unchanged)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+
+	r.SyncMeta()
+	records, err := readRecords(r.meta)
+	require.NoError(t, err)
+	assert.Equal(t, watchRecords{ResourceVersion: "12", Counts: map[string]int32{"uid-1": 3}}, records)
+
+	go watcher.Delete(unchanged)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	records, err = readRecords(r.meta)
+	require.NoError(t, err)
+	assert.Empty(t, records.Counts)
+	assert.Equal(t, "12", records.ResourceVersion)
+}
+
+func TestK8sEventsReaderRelist(t *testing.T) {
+	pull := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "web.2", Namespace: "kube-system", UID: "uid-2"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web", FieldPath: "spec.containers{web}"},
+		Reason:         "Pulling",
+		Message:        `Pulling image "nginx"`,
+		Type:           corev1.EventTypeNormal,
+		Count:          2,
+		Source:         corev1.EventSource{Component: "kubelet", Host: "node-1"},
+	}
+	client, watcher := newFakeClient(pull)
+	c := conf.MapConf{
+		reader.KeyK8sEventsAPI: reader.K8sEventsAPICoreV1,
+		reader.KeyK8sNamespace: "kube-system",
+		reader.KeyMetaPath:     t.TempDir(),
+		reader.KeyMode:         reader.ModeK8sEvents,
+		models.KeyRunnerName:   "TestK8sEventsReaderRelist",
+	}
+	r := startReader(t, c, client)
+	data := readEvent(t, r)
+	assert.Equal(t, "Pulling", data["reason"])
+	assert.Equal(t, float64(2), data["count"])
+	assert.Equal(t, "node-1", data["source_host"])
+	assert.Equal(t, "spec.containers{web}", data["involved_object_field_path"])
+	assert.Equal(t, "https://127.0.0.1:6443/kube-system", r.Source())
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	watcher.Stop()
+
+	// 重启后重新 list，已经读取过的 Event 不再输出
+	pull.Count = 3
+	client2, _ := newFakeClient(pull, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.3", Namespace: "kube-system", UID: "uid-3"},
+		Reason:     "Started",
+	})
+	r = startReader(t, c, client2)
+	defer r.Close()
+	reasons := map[string]float64{}
+	for i := 0; i < 2; i++ {
+		data = readEvent(t, r)
+		reasons[data["reason"].(string)] = data["count"].(float64)
+	}
+	assert.Equal(t, map[string]float64{"Pulling": 3, "Started": 1}, reasons)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+}
@@ -753,6 +753,32 @@ func (m *Meta) Reset() error {
 	return nil
 }
 
+// ReadJSONRecords 读取以 JSON 格式保存的记录文件到 v 中，文件不存在时不修改 v 并返回 nil
+// 各 reader 自己的记录文件放在 DoneFilePath 中并以 DoneFileName 为前缀，Reset 时一同清理
+func ReadJSONRecords(file string, v interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return jsoniter.Unmarshal(data, v)
+}
+
+// WriteJSONRecords 将 v 以 JSON 格式写入记录文件，先写入临时文件再重命名，保证记录文件的完整性
+func WriteJSONRecords(file string, v interface{}) error {
+	data, err := jsoniter.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmpFileName := fmt.Sprintf("%s.%d.tmp", file, rand.Int())
+	if err = ioutil.WriteFile(tmpFileName, data, DefaultFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, file)
+}
+
 func (m *Meta) ReadStatistic() (stat Statistic, err error) {
 	statData, err := ioutil.ReadFile(m.StatisticFile())
 	if statData == nil || err != nil {
@@ -0,0 +1,316 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	paho3 "github.com/eclipse/paho.mqtt.golang"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/reader"
+)
+
+// receiveMaximum 为 MQTT 5 连接中未确认的 QoS 1/2 消息数上限
+const receiveMaximum = 1024
+
+// v3Subscriber 为 MQTT 3.1.1 的订阅客户端，断线重连由 paho 客户端自动完成
+type v3Subscriber struct {
+	opts    *options
+	client  paho3.Client
+	onError func(error)
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	// gen 为当前连接的序号，断线重连后 packet id 会被复用，之前连接中接收的消息不能再确认
+	gen uint64
+}
+
+func newV3Subscriber(opts *options, tlsConfig *tls.Config, handle func(*message), onError func(error)) *v3Subscriber {
+	s := &v3Subscriber{
+		opts:     opts,
+		onError:  onError,
+		stopChan: make(chan struct{}),
+	}
+	co := paho3.NewClientOptions()
+	for _, broker := range opts.brokers {
+		co.AddBroker(broker)
+	}
+	co.SetClientID(opts.clientID)
+	co.SetUsername(opts.username)
+	co.SetPassword(opts.password)
+	co.SetProtocolVersion(4)
+	co.SetCleanSession(opts.cleanSession)
+	co.SetKeepAlive(time.Duration(opts.keepAlive) * time.Second)
+	co.SetConnectTimeout(connectTimeout)
+	co.SetAutoReconnect(true)
+	co.SetMaxReconnectInterval(time.Minute)
+	co.SetAutoAckDisabled(true)
+	if tlsConfig != nil {
+		co.SetTLSConfig(tlsConfig)
+	}
+	// 持久会话中 broker 在连接建立后立即投递离线消息，此时尚未重新订阅，需要由默认的处理函数接收
+	co.SetDefaultPublishHandler(func(_ paho3.Client, m paho3.Message) {
+		handle(s.newMessage(m))
+	})
+	co.SetReconnectingHandler(func(paho3.Client, *paho3.ClientOptions) {
+		atomic.AddUint64(&s.gen, 1)
+	})
+	co.SetConnectionLostHandler(func(_ paho3.Client, err error) {
+		onError(fmt.Errorf("connection lost: %v", err))
+	})
+	co.SetOnConnectHandler(func(c paho3.Client) {
+		filters := make(map[string]byte, len(opts.topics))
+		for _, topic := range opts.topics {
+			filters[topic] = opts.qos
+		}
+		token := c.SubscribeMultiple(filters, nil)
+		if token.Wait() && token.Error() != nil {
+			onError(fmt.Errorf("subscribe %v failed: %v", opts.topics, token.Error()))
+		}
+	})
+	s.client = paho3.NewClient(co)
+	return s
+}
+
+func (s *v3Subscriber) newMessage(m paho3.Message) *message {
+	msg := &message{topic: m.Topic(), payload: m.Payload()}
+	if m.Qos() > 0 {
+		gen := atomic.LoadUint64(&s.gen)
+		msg.ack = func() {
+			if atomic.LoadUint64(&s.gen) == gen {
+				m.Ack()
+			}
+		}
+	}
+	return msg
+}
+
+// start 在后台连接 broker，首次连接失败时按 connectRetryDelay 重试
+func (s *v3Subscriber) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			token := s.client.Connect()
+			token.Wait()
+			if token.Error() == nil {
+				return
+			}
+			s.onError(fmt.Errorf("connect to %v failed: %v", s.opts.brokers, token.Error()))
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(connectRetryDelay):
+			}
+		}
+	}()
+}
+
+func (s *v3Subscriber) close() error {
+	close(s.stopChan)
+	s.wg.Wait()
+	s.client.Disconnect(250)
+	return nil
+}
+
+// v5Subscriber 为 MQTT 5 的订阅客户端，每次连接创建新的 paho 客户端
+type v5Subscriber struct {
+	opts      *options
+	brokers   []*url.URL
+	tlsConfig *tls.Config
+	handle    func(*message)
+	onError   func(error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newV5Subscriber(opts *options, tlsConfig *tls.Config, handle func(*message), onError func(error)) (*v5Subscriber, error) {
+	brokers := make([]*url.URL, 0, len(opts.brokers))
+	for _, broker := range opts.brokers {
+		u, err := url.Parse(broker)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%s %q is invalid", reader.KeyMQTTBrokers, broker)
+		}
+		switch u.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts":
+		default:
+			return nil, fmt.Errorf("scheme %q of broker %q is not supported", u.Scheme, broker)
+		}
+		brokers = append(brokers, u)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &v5Subscriber{
+		opts:      opts,
+		brokers:   brokers,
+		tlsConfig: tlsConfig,
+		handle:    handle,
+		onError:   onError,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+func (s *v5Subscriber) start() {
+	go s.run()
+}
+
+// run 依次连接各个 broker，连接断开后重新连接，直到 close 被调用
+func (s *v5Subscriber) run() {
+	defer close(s.done)
+	for i := 0; ; i++ {
+		broker := s.brokers[i%len(s.brokers)]
+		client, down, err := s.connect(broker)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.onError(fmt.Errorf("connect to %v failed: %v", broker.Host, err))
+		} else {
+			select {
+			case <-s.ctx.Done():
+				client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+				return
+			case err = <-down:
+				s.onError(fmt.Errorf("connection to %v lost: %v", broker.Host, err))
+			}
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(connectRetryDelay):
+		}
+	}
+}
+
+func (s *v5Subscriber) dial(broker *url.URL) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	switch broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		config := s.tlsConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName = broker.Hostname()
+		}
+		return tls.DialWithDialer(dialer, "tcp", broker.Host, config)
+	}
+	return dialer.Dial("tcp", broker.Host)
+}
+
+// connect 建立连接并订阅主题，连接断开时 down 中会收到断开的原因
+func (s *v5Subscriber) connect(broker *url.URL) (*paho.Client, <-chan error, error) {
+	conn, err := s.dial(broker)
+	if err != nil {
+		return nil, nil, err
+	}
+	down := make(chan error, 1)
+	notify := func(err error) {
+		select {
+		case down <- err:
+		default:
+		}
+	}
+	var client *paho.Client
+	client = paho.NewClient(paho.ClientConfig{
+		ClientID: s.opts.clientID,
+		Conn:     packets.NewThreadSafeConn(conn),
+		Router: paho.NewSingleHandlerRouter(func(p *paho.Publish) {
+			s.handle(s.newMessage(client, p))
+		}),
+		EnableManualAcknowledgment: true,
+		OnClientError:              notify,
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			notify(fmt.Errorf("server disconnected with reason code %d", d.ReasonCode))
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(s.ctx, connectTimeout)
+	defer cancel()
+	expiry, maximum := s.opts.sessionExpiry, uint16(receiveMaximum)
+	cp := &paho.Connect{
+		ClientID:   s.opts.clientID,
+		KeepAlive:  s.opts.keepAlive,
+		CleanStart: s.opts.cleanSession,
+		// 与协议的默认值保持一致，部分 broker 在未请求问题信息时不转发用户属性
+		Properties: &paho.ConnectProperties{ReceiveMaximum: &maximum, RequestProblemInfo: true},
+	}
+	if !s.opts.cleanSession {
+		cp.Properties.SessionExpiryInterval = &expiry
+	}
+	if s.opts.username != "" {
+		cp.Username, cp.UsernameFlag = s.opts.username, true
+	}
+	if s.opts.password != "" {
+		cp.Password, cp.PasswordFlag = []byte(s.opts.password), true
+	}
+	connack, err := client.Connect(ctx, cp)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	sub := &paho.Subscribe{Subscriptions: make(map[string]paho.SubscribeOptions, len(s.opts.topics))}
+	for _, topic := range s.opts.topics {
+		sub.Subscriptions[topic] = paho.SubscribeOptions{QoS: s.opts.qos}
+	}
+	suback, err := client.Subscribe(ctx, sub)
+	if err == nil {
+		for _, reason := range suback.Reasons {
+			if reason >= 0x80 {
+				err = fmt.Errorf("subscribe %v failed with reason code %d", s.opts.topics, reason)
+				break
+			}
+		}
+	}
+	if err != nil {
+		if !connack.SessionPresent {
+			client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+			return nil, nil, err
+		}
+		// 恢复的会话中仍然保留着之前的订阅，继续接收消息
+		log.Warningf("mqtt client %v resubscribe in present session failed: %v", s.opts.clientID, err)
+	}
+	return client, down, nil
+}
+
+func (s *v5Subscriber) newMessage(client *paho.Client, p *paho.Publish) *message {
+	msg := &message{topic: p.Topic, payload: p.Payload}
+	if p.QoS > 0 {
+		// 断线之后旧客户端中的消息不会再发送确认，由 broker 在重新连接后重新投递
+		msg.ack = func() { client.Ack(p) }
+	}
+	if s.opts.userProperties && p.Properties != nil && len(p.Properties.User) > 0 {
+		msg.tags = make(map[string]interface{}, len(p.Properties.User)+1)
+		for _, prop := range p.Properties.User {
+			switch v := msg.tags[prop.Key].(type) {
+			case nil:
+				msg.tags[prop.Key] = prop.Value
+			case string:
+				msg.tags[prop.Key] = []string{v, prop.Value}
+			case []string:
+				msg.tags[prop.Key] = append(v, prop.Value)
+			}
+		}
+	}
+	return msg
+}
+
+func (s *v5Subscriber) close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
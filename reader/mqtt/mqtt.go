@@ -0,0 +1,311 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader     = &Reader{}
+	_ reader.StatsReader      = &Reader{}
+	_ reader.ExtraStatsReader = &Reader{}
+	_ reader.LineTagsReader   = &Reader{}
+	_ reader.Reader           = &Reader{}
+)
+
+const (
+	// maxQueuedMessages 为已接收但尚未读取的消息数上限，队列满时丢弃 QoS 0 的消息并计数，
+	// QoS 1/2 的消息阻塞客户端直到队列有空位，由 broker 保留未投递的消息
+	maxQueuedMessages = 10000
+	// StatsKeyDropped 为队列满时丢弃的 QoS 0 消息数在 reader 额外统计信息中的键名
+	StatsKeyDropped = "dropped"
+	// connectRetryDelay 为连接失败或者断开后重新连接的等待时间
+	connectRetryDelay = 5 * time.Second
+	connectTimeout    = 10 * time.Second
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeMQTT, NewReader)
+}
+
+// message 为从 broker 接收的一条消息，ack 为 nil 表示该消息不需要确认
+type message struct {
+	topic   string
+	payload []byte
+	tags    map[string]interface{}
+	ack     func()
+}
+
+// subscriber 为不同协议版本的订阅客户端
+type subscriber interface {
+	start()
+	close() error
+}
+
+type options struct {
+	brokers        []string
+	topics         []string
+	qos            byte
+	clientID       string
+	username       string
+	password       string
+	cleanSession   bool
+	sessionExpiry  uint32
+	keepAlive      uint16
+	topicField     string
+	userProperties bool
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	opts    options
+	version string
+	client  subscriber
+
+	// msgChan 为已接收但尚未读取的消息，dropped 为队列满时丢弃的 QoS 0 消息数，原子操作
+	msgChan chan *message
+	dropped int64
+
+	// pending 为已经读取、等待 SyncMeta 之后确认的消息，按接收顺序排列
+	pendingLock sync.Mutex
+	pending     []*message
+
+	lineTags map[string]interface{}
+	source   atomic.Value
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	brokers, err := conf.GetStringList(reader.KeyMQTTBrokers)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := conf.GetStringList(reader.KeyMQTTTopics)
+	if err != nil {
+		return nil, err
+	}
+	qos, _ := conf.GetIntOr(reader.KeyMQTTQoS, 1)
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("%s must be 0, 1 or 2, got %d", reader.KeyMQTTQoS, qos)
+	}
+	version, _ := conf.GetStringOr(reader.KeyMQTTProtocolVersion, reader.MQTTProtocolV311)
+	clientID, _ := conf.GetStringOr(reader.KeyMQTTClientID, "")
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "logkit_" + hostname + "_" + meta.RunnerName
+	}
+	username, _ := conf.GetStringOr(reader.KeyMQTTUsername, "")
+	password, _ := conf.GetStringOr(reader.KeyMQTTPassword, "")
+	cleanSession, _ := conf.GetBoolOr(reader.KeyMQTTCleanSession, false)
+	expiryStr, _ := conf.GetStringOr(reader.KeyMQTTSessionExpiry, "24h")
+	expiry, err := time.ParseDuration(expiryStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyMQTTSessionExpiry, expiryStr, err)
+	}
+	keepAliveStr, _ := conf.GetStringOr(reader.KeyMQTTKeepAlive, "30s")
+	keepAlive, err := time.ParseDuration(keepAliveStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyMQTTKeepAlive, keepAliveStr, err)
+	}
+	topicField, _ := conf.GetStringOr(reader.KeyMQTTTopicField, "topic")
+	userProperties, _ := conf.GetBoolOr(reader.KeyMQTTUserProperties, true)
+	tlsConfig, err := NewClientTLSConfig(conf, TLSKeys{
+		CA:                 reader.KeyMQTTTLSCA,
+		Cert:               reader.KeyMQTTTLSCert,
+		Key:                reader.KeyMQTTTLSKey,
+		InsecureSkipVerify: reader.KeyMQTTTLSInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{
+		meta:     meta,
+		status:   reader.StatusInit,
+		stopChan: make(chan struct{}),
+		errChan:  make(chan error),
+		msgChan:  make(chan *message, maxQueuedMessages),
+		version:  version,
+		opts: options{
+			brokers:        brokers,
+			topics:         topics,
+			qos:            byte(qos),
+			clientID:       clientID,
+			username:       username,
+			password:       password,
+			cleanSession:   cleanSession,
+			sessionExpiry:  uint32(expiry / time.Second),
+			keepAlive:      uint16(keepAlive / time.Second),
+			topicField:     topicField,
+			userProperties: userProperties,
+		},
+	}
+	switch version {
+	case reader.MQTTProtocolV311:
+		r.client = newV3Subscriber(&r.opts, tlsConfig, r.receive, r.onError)
+	case reader.MQTTProtocolV5:
+		if r.client, err = newV5Subscriber(&r.opts, tlsConfig, r.receive, r.onError); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s %q is not supported", reader.KeyMQTTProtocolVersion, version)
+	}
+	r.source.Store(strings.Join(brokers, ","))
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return fmt.Sprintf("MQTTReader<%s>", strings.Join(r.opts.topics, ","))
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("mqtt reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) onError(err error) {
+	log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+	r.setStatsError(err.Error())
+	// 不阻塞客户端的回调，ReadLine 未在等待时错误只记录在 stats 中
+	select {
+	case r.errChan <- err:
+	default:
+	}
+}
+
+// receive 由客户端在收到消息时调用。队列满时 QoS 0 的消息直接丢弃，避免影响客户端的心跳与断线重连；
+// QoS 1/2 的消息阻塞等待，客户端停止读取连接，由 broker 保留未投递的消息，直到 reader 关闭
+func (r *Reader) receive(msg *message) {
+	if r.opts.topicField != "" {
+		if msg.tags == nil {
+			msg.tags = make(map[string]interface{}, 1)
+		}
+		msg.tags[r.opts.topicField] = msg.topic
+	}
+
+	if msg.ack == nil {
+		select {
+		case r.msgChan <- msg:
+		default:
+			if dropped := atomic.AddInt64(&r.dropped, 1); dropped%maxQueuedMessages == 1 {
+				log.Warningf("Runner[%v] %q too many messages queued, drop QoS 0 message of topic %v, %d dropped in total", r.meta.RunnerName, r.Name(), msg.topic, dropped)
+			}
+		}
+		return
+	}
+	select {
+	case r.msgChan <- msg:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.client.start()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) Source() string {
+	return r.source.Load().(string)
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case msg := <-r.msgChan:
+		r.source.Store(msg.topic)
+		r.lineTags = msg.tags
+		if msg.ack != nil {
+			r.pendingLock.Lock()
+			r.pending = append(r.pending, msg)
+			r.pendingLock.Unlock()
+		}
+		return string(msg.payload), nil
+	case err := <-r.errChan:
+		return "", err
+	case <-timer.C:
+		return "", nil
+	}
+}
+
+// LineTags 返回最近一次读取的消息的主题与用户属性
+func (r *Reader) LineTags() map[string]interface{} {
+	return r.lineTags
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) ExtraStats() map[string]interface{} {
+	return map[string]interface{}{
+		StatsKeyDropped: atomic.LoadInt64(&r.dropped),
+	}
+}
+
+// SyncMeta 按接收顺序确认已经读取的 QoS 1/2 消息
+func (r *Reader) SyncMeta() {
+	r.pendingLock.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.pendingLock.Unlock()
+	for _, msg := range pending {
+		msg.ack()
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	// 未确认的消息在重新连接之后由 broker 重新投递
+	err := r.client.close()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return err
+}
@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	server := mochi.New(nil)
+	require.NoError(t, server.AddHook(new(auth.AllowHook), nil))
+	require.NoError(t, server.AddListener(listeners.NewTCP("t1", addr, nil)))
+	require.NoError(t, server.Serve())
+	return server, "tcp://" + addr
+}
+
+func waitSubscribed(t *testing.T, server *mochi.Server, topic string) {
+	for i := 0; i < 100; i++ {
+		if len(server.Topics.Subscribers(topic).Subscriptions) > 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no subscriber of %v", topic)
+}
+
+func publish(t *testing.T, server *mochi.Server, topic, payload string, props ...packets.UserProperty) {
+	cl := server.NewClient(nil, "local", "inline", true)
+	cl.Properties.ProtocolVersion = 5
+	err := server.InjectPacket(cl, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		TopicName:   topic,
+		Payload:     []byte(payload),
+		PacketID:    1,
+		Properties:  packets.Properties{User: props},
+	})
+	require.NoError(t, err)
+}
+
+func readLine(t *testing.T, r reader.Reader) string {
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line != "" {
+			return line
+		}
+	}
+	t.Fatal("no line read")
+	return ""
+}
+
+func newReader(t *testing.T, c conf.MapConf) reader.Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(reader.DaemonReader).Start())
+	return r
+}
+
+func TestMQTTReaderV311(t *testing.T) {
+	metaDir := "TestMQTTReaderV311"
+	os.RemoveAll(metaDir)
+	defer os.RemoveAll(metaDir)
+
+	server, broker := startBroker(t)
+	defer server.Close()
+
+	c := conf.MapConf{
+		reader.KeyMQTTBrokers:      broker,
+		reader.KeyMQTTTopics:       "devices/+/logs, telemetry/#",
+		reader.KeyMQTTClientID:     "TestMQTTReaderV311",
+		reader.KeyMQTTCleanSession: "true",
+		reader.KeyMetaPath:         metaDir,
+		reader.KeyMode:             reader.ModeMQTT,
+		models.KeyRunnerName:       "TestMQTTReaderV311",
+	}
+	r := newReader(t, c)
+	defer r.Close()
+	waitSubscribed(t, server, "telemetry/a")
+
+	publish(t, server, "devices/d1/logs", "log1")
+	publish(t, server, "telemetry/a/b", "t1")
+	publish(t, server, "other", "ignored")
+
+	assert.Equal(t, "log1", readLine(t, r))
+	assert.Equal(t, map[string]interface{}{"topic": "devices/d1/logs"}, r.(reader.LineTagsReader).LineTags())
+	assert.Equal(t, "devices/d1/logs", r.Source())
+	assert.Equal(t, "t1", readLine(t, r))
+	assert.Equal(t, map[string]interface{}{"topic": "telemetry/a/b"}, r.(reader.LineTagsReader).LineTags())
+	r.SyncMeta()
+
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+}
+
+func TestMQTTReaderV5(t *testing.T) {
+	metaDir := "TestMQTTReaderV5"
+	os.RemoveAll(metaDir)
+	defer os.RemoveAll(metaDir)
+
+	server, broker := startBroker(t)
+	defer server.Close()
+
+	c := conf.MapConf{
+		reader.KeyMQTTBrokers:         broker,
+		reader.KeyMQTTTopics:          "sensors/#",
+		reader.KeyMQTTProtocolVersion: reader.MQTTProtocolV5,
+		reader.KeyMQTTClientID:        "TestMQTTReaderV5",
+		reader.KeyMQTTTopicField:      "mqtt_topic",
+		reader.KeyMetaPath:            metaDir,
+		reader.KeyMode:                reader.ModeMQTT,
+		models.KeyRunnerName:          "TestMQTTReaderV5",
+	}
+	r := newReader(t, c)
+	waitSubscribed(t, server, "sensors/temp")
+
+	publish(t, server, "sensors/temp", "21.5",
+		packets.UserProperty{Key: "device", Val: "d1"},
+		packets.UserProperty{Key: "tag", Val: "a"},
+		packets.UserProperty{Key: "tag", Val: "b"})
+	expectTags := map[string]interface{}{"mqtt_topic": "sensors/temp", "device": "d1", "tag": []string{"a", "b"}}
+	assert.Equal(t, "21.5", readLine(t, r))
+	assert.Equal(t, expectTags, r.(reader.LineTagsReader).LineTags())
+	// 未经 SyncMeta 确认的消息在重新连接后由 broker 重新投递
+	assert.NoError(t, r.Close())
+
+	r = newReader(t, c)
+	assert.Equal(t, "21.5", readLine(t, r))
+	assert.Equal(t, expectTags, r.(reader.LineTagsReader).LineTags())
+	r.SyncMeta()
+	// 等待客户端按顺序发送确认
+	time.Sleep(500 * time.Millisecond)
+	assert.NoError(t, r.Close())
+
+	r = newReader(t, c)
+	defer r.Close()
+	waitSubscribed(t, server, "sensors/temp")
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+}
+
+func TestMQTTReaderQueueFull(t *testing.T) {
+	r := &Reader{
+		meta:     &reader.Meta{RunnerName: "test"},
+		stopChan: make(chan struct{}),
+		errChan:  make(chan error),
+		msgChan:  make(chan *message, 1),
+		opts:     options{topics: []string{"t"}},
+	}
+
+	// QoS 0 的消息在队列满时丢弃并计数
+	r.receive(&message{topic: "t", payload: []byte("a")})
+	r.receive(&message{topic: "t", payload: []byte("b")})
+	assert.Equal(t, map[string]interface{}{StatsKeyDropped: int64(1)}, r.ExtraStats())
+
+	// QoS 1/2 的消息阻塞直到队列有空位
+	done := make(chan struct{})
+	go func() {
+		r.receive(&message{topic: "t", payload: []byte("c"), ack: func() {}})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("receive should block when queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, "a", readLine(t, r))
+	<-done
+	assert.Equal(t, "c", readLine(t, r))
+
+	// reader 关闭时不再阻塞
+	r.receive(&message{topic: "t", payload: []byte("d"), ack: func() {}})
+	close(r.stopChan)
+	r.receive(&message{topic: "t", payload: []byte("e"), ack: func() {}})
+	assert.Equal(t, int64(1), r.ExtraStats()[StatsKeyDropped])
+}
@@ -0,0 +1,378 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+// KeyMultilinePreset 的可选项
+const (
+	MultilinePresetJava   = "java"
+	MultilinePresetPython = "python"
+	MultilinePresetGo     = "go"
+)
+
+const (
+	DefaultMultilineMaxLines     = 500
+	DefaultMultilineMaxBytes     = MaxHeadPatternBufferSize
+	DefaultMultilineFlushTimeout = 5 * time.Second
+)
+
+type multilinePreset struct {
+	start        string
+	end          string
+	continuation string
+}
+
+// multilinePresets 为内置的多行日志模式，均以 continuation 的方式将堆栈信息拼接到前一行日志之后
+var multilinePresets = map[string]multilinePreset{
+	// Java 异常堆栈，如 "\tat com.foo.Bar.baz(Bar.java:10)"、"\t... 5 more"、"Caused by: ..."
+	MultilinePresetJava: {
+		continuation: `^(\s+at\s|\s+\.\.\.\s+\d+\s+(more|common frames omitted)|\s*Caused by:|\s+Suppressed:)`,
+	},
+	// Python traceback，包括缩进的调用栈、链式异常提示以及最后一行的异常信息
+	MultilinePresetPython: {
+		continuation: `^(\s|Traceback \(most recent call last\):|During handling of the above exception|The above exception was the direct cause|[A-Za-z_][\w.]*(Error|Exception|Warning|Exit|Interrupt)(:|$))`,
+	},
+	// Go panic，包括 goroutine 信息、函数调用、缩进的文件位置以及空行
+	MultilinePresetGo: {
+		continuation: `^(\s|$|goroutine \d+ \[.*\]:$|\S+\(.*\)$|created by |\[signal |exit status \d+$)`,
+	},
+}
+
+// MultilineConfig 描述如何将多行日志组装为一条事件
+// Start 匹配事件的第一行，Continuation 匹配需要拼接到上一行之后的行，两者不能同时指定
+// End 匹配事件的最后一行，可以单独使用或者与 Start 组合使用
+// Negate 对 Start 与 Continuation 的匹配结果取反
+type MultilineConfig struct {
+	Start        *regexp.Regexp
+	End          *regexp.Regexp
+	Continuation *regexp.Regexp
+	Negate       bool
+	MaxLines     int
+	MaxBytes     int
+	FlushTimeout time.Duration
+}
+
+// NewMultilineConfig 根据 multiline_* 配置项创建 MultilineConfig，未配置多行模式时返回 nil
+func NewMultilineConfig(c conf.MapConf) (*MultilineConfig, error) {
+	presetName, _ := c.GetStringOr(KeyMultilinePreset, "")
+	start, _ := c.GetStringOr(KeyMultilineStart, "")
+	end, _ := c.GetStringOr(KeyMultilineEnd, "")
+	continuation, _ := c.GetStringOr(KeyMultilineContinuation, "")
+	if presetName != "" {
+		preset, ok := multilinePresets[presetName]
+		if !ok {
+			return nil, fmt.Errorf("multiline preset %q is not supported", presetName)
+		}
+		if start == "" && continuation == "" {
+			start, continuation = preset.start, preset.continuation
+		}
+		if end == "" {
+			end = preset.end
+		}
+	}
+	if start == "" && end == "" && continuation == "" {
+		return nil, nil
+	}
+	if start != "" && continuation != "" {
+		return nil, errors.New("multiline start and continuation pattern can not be specified at the same time")
+	}
+
+	mc := &MultilineConfig{}
+	var err error
+	if mc.Start, err = compileMultilinePattern(KeyMultilineStart, start); err != nil {
+		return nil, err
+	}
+	if mc.End, err = compileMultilinePattern(KeyMultilineEnd, end); err != nil {
+		return nil, err
+	}
+	if mc.Continuation, err = compileMultilinePattern(KeyMultilineContinuation, continuation); err != nil {
+		return nil, err
+	}
+	mc.Negate, _ = c.GetBoolOr(KeyMultilineNegate, false)
+	mc.MaxLines, _ = c.GetIntOr(KeyMultilineMaxLines, DefaultMultilineMaxLines)
+	mc.MaxBytes, _ = c.GetIntOr(KeyMultilineMaxBytes, DefaultMultilineMaxBytes)
+	if mc.MaxLines < 0 || mc.MaxBytes < 0 {
+		return nil, errors.New("multiline max lines and max bytes must not be negative")
+	}
+	flushTimeout, _ := c.GetStringOr(KeyMultilineFlushTimeout, DefaultMultilineFlushTimeout.String())
+	if mc.FlushTimeout, err = time.ParseDuration(flushTimeout); err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", KeyMultilineFlushTimeout, flushTimeout, err)
+	}
+	return mc, nil
+}
+
+func compileMultilinePattern(key, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q compile error: %v", key, pattern, err)
+	}
+	return reg, nil
+}
+
+// MultilineAssembler 按照 MultilineConfig 将逐行输入组装为事件，非并发安全
+type MultilineAssembler struct {
+	conf     *MultilineConfig
+	lines    []string
+	size     int
+	lastPush time.Time
+	// tags 为缓存中未完成的事件第一行的标签
+	tags  map[string]interface{}
+	ready []multilineEvent
+}
+
+type multilineEvent struct {
+	text string
+	tags map[string]interface{}
+}
+
+func NewMultilineAssembler(mc *MultilineConfig) *MultilineAssembler {
+	return &MultilineAssembler{conf: mc}
+}
+
+func (a *MultilineAssembler) match(reg *regexp.Regexp, text string) bool {
+	return reg.MatchString(text) != a.conf.Negate
+}
+
+// Push 输入一行数据，组装完成的事件通过 Next 获取
+// 与 ReadPattern 相同，行尾的换行符保留在事件中，没有换行符的行之间以 "\n" 连接
+func (a *MultilineAssembler) Push(line string) {
+	a.PushWithTags(line, nil)
+}
+
+// PushWithTags 输入一行数据及其标签，事件使用其第一行的标签，通过 NextWithTags 获取
+func (a *MultilineAssembler) PushWithTags(line string, tags map[string]interface{}) {
+	text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	switch {
+	case a.conf.Continuation != nil:
+		if !a.match(a.conf.Continuation, text) {
+			a.flush()
+		}
+	case a.conf.Start != nil:
+		if a.match(a.conf.Start, text) {
+			a.flush()
+		}
+	}
+	if len(a.lines) == 0 {
+		a.tags = tags
+	}
+	a.lines = append(a.lines, line)
+	a.size += len(line)
+	a.lastPush = time.Now()
+
+	if a.conf.End != nil && a.conf.End.MatchString(text) {
+		a.flush()
+		return
+	}
+	if (a.conf.MaxLines > 0 && len(a.lines) >= a.conf.MaxLines) || (a.conf.MaxBytes > 0 && a.size >= a.conf.MaxBytes) {
+		a.flush()
+	}
+}
+
+// Next 返回下一条组装完成的事件
+func (a *MultilineAssembler) Next() (string, bool) {
+	event, _, ok := a.NextWithTags()
+	return event, ok
+}
+
+// NextWithTags 返回下一条组装完成的事件及其第一行的标签
+func (a *MultilineAssembler) NextWithTags() (string, map[string]interface{}, bool) {
+	if len(a.ready) == 0 {
+		return "", nil, false
+	}
+	event := a.ready[0]
+	a.ready[0] = multilineEvent{}
+	a.ready = a.ready[1:]
+	return event.text, event.tags, true
+}
+
+// Expired 判断缓存中未完成的事件是否已经超过 flush timeout 没有新的行输入
+func (a *MultilineAssembler) Expired(now time.Time) bool {
+	return len(a.lines) > 0 && a.conf.FlushTimeout > 0 && now.Sub(a.lastPush) >= a.conf.FlushTimeout
+}
+
+// Flush 将缓存中未完成的事件作为完整事件输出
+func (a *MultilineAssembler) Flush() {
+	a.flush()
+}
+
+// Pending 返回缓存中未完成的事件内容，用于持久化
+func (a *MultilineAssembler) Pending() string {
+	return joinLines(a.lines)
+}
+
+// Restore 恢复之前通过 Pending 持久化的内容
+func (a *MultilineAssembler) Restore(pending string) {
+	if pending == "" {
+		return
+	}
+	for _, line := range strings.SplitAfter(pending, "\n") {
+		if line == "" {
+			continue
+		}
+		a.lines = append(a.lines, line)
+		a.size += len(line)
+	}
+	a.lastPush = time.Now()
+}
+
+func (a *MultilineAssembler) flush() {
+	if len(a.lines) == 0 {
+		return
+	}
+	a.ready = append(a.ready, multilineEvent{text: joinLines(a.lines), tags: a.tags})
+	a.lines = nil
+	a.size = 0
+	a.tags = nil
+}
+
+// joinLines 拼接多行，行尾已有换行符时直接拼接，否则补充 "\n"
+func joinLines(lines []string) string {
+	var sb strings.Builder
+	for i, line := range lines {
+		sb.WriteString(line)
+		if i < len(lines)-1 && !strings.HasSuffix(line, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// MultilineReader 为自身不支持多行模式的逐行读取器提供多行事件组装，未完成的事件随 SyncMeta 一起持久化
+// 内部读取器未实现 LagReader、StatsReader 等可选接口时，按 runner 对未实现这些接口的读取器的处理方式返回
+type MultilineReader struct {
+	Reader
+	meta      *Meta
+	assembler *MultilineAssembler
+	mux       sync.Mutex
+	lastCache string
+	// lineTags 为最近一次返回的事件第一行的标签
+	lineTags map[string]interface{}
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewMultilineReader(rd Reader, meta *Meta, mc *MultilineConfig) *MultilineReader {
+	r := &MultilineReader{
+		Reader:    rd,
+		meta:      meta,
+		assembler: NewMultilineAssembler(mc),
+	}
+	if meta != nil {
+		if cache, err := meta.ReadCacheLine(); err == nil && len(cache) > 0 {
+			r.assembler.Restore(string(cache))
+			r.lastCache = string(cache)
+			log.Infof("Runner[%v] %v restore multiline cache success: [%v]", meta.RunnerName, rd.Name(), string(cache))
+		}
+	}
+	return r
+}
+
+func (r *MultilineReader) ReadLine() (string, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if event, tags, ok := r.assembler.NextWithTags(); ok {
+		r.lineTags = tags
+		return event, nil
+	}
+	line, err := r.Reader.ReadLine()
+	if err != nil && err != io.EOF {
+		r.statsLock.Lock()
+		r.stats.LastError = err.Error()
+		r.statsLock.Unlock()
+	}
+	if line != "" {
+		var tags map[string]interface{}
+		if ltr, ok := r.Reader.(LineTagsReader); ok {
+			tags = ltr.LineTags()
+		}
+		r.assembler.PushWithTags(line, tags)
+	} else if r.assembler.Expired(time.Now()) {
+		r.assembler.Flush()
+	}
+	event, tags, _ := r.assembler.NextWithTags()
+	r.lineTags = tags
+	return event, err
+}
+
+func (r *MultilineReader) SyncMeta() {
+	r.mux.Lock()
+	cache := r.assembler.Pending()
+	r.mux.Unlock()
+	if r.meta != nil && cache != r.lastCache {
+		if err := r.meta.WriteCacheLine(cache); err != nil {
+			log.Errorf("Runner[%v] %s cannot write multiline cache, err :%v", r.meta.RunnerName, r.Name(), err)
+		} else {
+			r.lastCache = cache
+		}
+	}
+	r.Reader.SyncMeta()
+}
+
+func (r *MultilineReader) Start() error {
+	if dr, ok := r.Reader.(DaemonReader); ok {
+		return dr.Start()
+	}
+	return nil
+}
+
+// Status 内部读取器未实现 StatsReader 时，返回读取过程中记录的错误
+func (r *MultilineReader) Status() StatsInfo {
+	if sr, ok := r.Reader.(StatsReader); ok {
+		return sr.Status()
+	}
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *MultilineReader) ExtraStats() map[string]interface{} {
+	if esr, ok := r.Reader.(ExtraStatsReader); ok {
+		return esr.ExtraStats()
+	}
+	return nil
+}
+
+// LineTags 返回最近一次读取的事件第一行的标签，而不是内部读取器最近读取的行的标签
+func (r *MultilineReader) LineTags() map[string]interface{} {
+	return r.lineTags
+}
+
+// Lag 内部读取器未实现 LagReader 时，与 runner 相同返回空的 LagInfo
+func (r *MultilineReader) Lag() (*LagInfo, error) {
+	if lr, ok := r.Reader.(LagReader); ok {
+		return lr.Lag()
+	}
+	return &LagInfo{}, nil
+}
+
+// Reset 清空未完成的事件，内部读取器实现 Resetable 时一并重置
+func (r *MultilineReader) Reset() error {
+	r.mux.Lock()
+	r.assembler.lines = nil
+	r.assembler.size = 0
+	r.assembler.ready = nil
+	r.assembler.tags = nil
+	r.lastCache = ""
+	r.lineTags = nil
+	r.mux.Unlock()
+	if rr, ok := r.Reader.(Resetable); ok {
+		return rr.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longxiucai/logkit/conf"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func assemble(t *testing.T, c conf.MapConf, lines []string) []string {
+	mc, err := NewMultilineConfig(c)
+	assert.NoError(t, err)
+	a := NewMultilineAssembler(mc)
+	var events []string
+	for _, line := range lines {
+		a.Push(line)
+		for event, ok := a.Next(); ok; event, ok = a.Next() {
+			events = append(events, event)
+		}
+	}
+	a.Flush()
+	for event, ok := a.Next(); ok; event, ok = a.Next() {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestMultilineAssembler(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   conf.MapConf
+		lines  []string
+		expect []string
+	}{
+		{
+			name:   "start",
+			conf:   conf.MapConf{KeyMultilineStart: `^\d{4}-`},
+			lines:  []string{"x", "2018-01 a", "b", "2018-02 c"},
+			expect: []string{"x", "2018-01 a\nb", "2018-02 c"},
+		},
+		{
+			name:   "continuation",
+			conf:   conf.MapConf{KeyMultilineContinuation: `^\s`},
+			lines:  []string{"a\n", " b\n", "\tc\n", "d\n"},
+			expect: []string{"a\n b\n\tc\n", "d\n"},
+		},
+		{
+			name:   "negate continuation",
+			conf:   conf.MapConf{KeyMultilineContinuation: `^\[`, KeyMultilineNegate: "true"},
+			lines:  []string{"[1] a", "b", "[2] c", "d", "e"},
+			expect: []string{"[1] a\nb", "[2] c\nd\ne"},
+		},
+		{
+			name:   "end",
+			conf:   conf.MapConf{KeyMultilineEnd: `;$`},
+			lines:  []string{"select *", "from t;", "select 1;", "x"},
+			expect: []string{"select *\nfrom t;", "select 1;", "x"},
+		},
+		{
+			name:   "start and end",
+			conf:   conf.MapConf{KeyMultilineStart: `^BEGIN`, KeyMultilineEnd: `^END`},
+			lines:  []string{"BEGIN", "a", "END", "BEGIN", "b", "BEGIN", "END"},
+			expect: []string{"BEGIN\na\nEND", "BEGIN\nb", "BEGIN\nEND"},
+		},
+		{
+			name:   "max lines",
+			conf:   conf.MapConf{KeyMultilineContinuation: `^\s`, KeyMultilineMaxLines: "2"},
+			lines:  []string{"a", " b", " c", "d"},
+			expect: []string{"a\n b", " c", "d"},
+		},
+		{
+			name:   "max bytes",
+			conf:   conf.MapConf{KeyMultilineContinuation: `^\s`, KeyMultilineMaxBytes: "4"},
+			lines:  []string{"ab", " c", " d"},
+			expect: []string{"ab\n c", " d"},
+		},
+		{
+			name: "java preset",
+			conf: conf.MapConf{KeyMultilinePreset: MultilinePresetJava},
+			lines: []string{
+				"2018-01-01 ERROR failed",
+				"java.lang.IllegalStateException: boom",
+				"\tat com.foo.Bar.baz(Bar.java:10)",
+				"Caused by: java.io.IOException: closed",
+				"\tat com.foo.Bar.qux(Bar.java:20)",
+				"\t... 5 more",
+				"2018-01-01 INFO ok",
+			},
+			expect: []string{
+				"2018-01-01 ERROR failed",
+				"java.lang.IllegalStateException: boom\n\tat com.foo.Bar.baz(Bar.java:10)\nCaused by: java.io.IOException: closed\n\tat com.foo.Bar.qux(Bar.java:20)\n\t... 5 more",
+				"2018-01-01 INFO ok",
+			},
+		},
+		{
+			name: "python preset",
+			conf: conf.MapConf{KeyMultilinePreset: MultilinePresetPython},
+			lines: []string{
+				"ERROR:root:failed",
+				"Traceback (most recent call last):",
+				`  File "x.py", line 1, in <module>`,
+				"    1/0",
+				"ZeroDivisionError: division by zero",
+				"INFO:root:ok",
+			},
+			expect: []string{
+				"ERROR:root:failed\nTraceback (most recent call last):\n  File \"x.py\", line 1, in <module>\n    1/0\nZeroDivisionError: division by zero",
+				"INFO:root:ok",
+			},
+		},
+		{
+			name: "go preset",
+			conf: conf.MapConf{KeyMultilinePreset: MultilinePresetGo},
+			lines: []string{
+				"panic: runtime error: index out of range",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/tmp/x.go:8 +0x1d",
+				"exit status 2",
+				"2018/01/01 started",
+			},
+			expect: []string{
+				"panic: runtime error: index out of range\n\ngoroutine 1 [running]:\nmain.main()\n\t/tmp/x.go:8 +0x1d\nexit status 2",
+				"2018/01/01 started",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, assemble(t, test.conf, test.lines))
+		})
+	}
+}
+
+func TestNewMultilineConfig(t *testing.T) {
+	mc, err := NewMultilineConfig(conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, mc)
+
+	_, err = NewMultilineConfig(conf.MapConf{KeyMultilinePreset: "ruby"})
+	assert.Error(t, err)
+	_, err = NewMultilineConfig(conf.MapConf{KeyMultilineStart: "a", KeyMultilineContinuation: "b"})
+	assert.Error(t, err)
+	_, err = NewMultilineConfig(conf.MapConf{KeyMultilineStart: "("})
+	assert.Error(t, err)
+
+	mc, err = NewMultilineConfig(conf.MapConf{KeyMultilineEnd: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMultilineMaxLines, mc.MaxLines)
+	assert.Equal(t, DefaultMultilineFlushTimeout, mc.FlushTimeout)
+}
+
+func TestMultilineFlushTimeout(t *testing.T) {
+	dir := "TestMultilineFlushTimeout"
+	os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(dir, DefaultDirPerm))
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, os.WriteFile(logPath, []byte("a\n b\nc\n d\n"), DefaultFilePerm))
+
+	c := conf.MapConf{
+		KeyLogPath:               logPath,
+		KeyMetaPath:              filepath.Join(dir, "meta"),
+		KeyMode:                  ModeFile,
+		KeyWhence:                WhenceOldest,
+		KeyMultilineContinuation: `^\s`,
+		KeyMultilineFlushTimeout: "200ms",
+	}
+	r, err := NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	var events []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(events) < 2 && time.Now().Before(deadline) {
+		line, _ := r.ReadLine()
+		if line == "" {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		events = append(events, line)
+	}
+	// 与 ReadPattern 相同，保留每行的换行符
+	assert.Equal(t, []string{"a\n b\n", "c\n d\n"}, events)
+}
+
+type mockLineReader struct {
+	lines []string
+}
+
+func (m *mockLineReader) Name() string                      { return "mock" }
+func (m *mockLineReader) SetMode(string, interface{}) error { return ErrStopped }
+func (m *mockLineReader) Source() string                    { return "mock" }
+func (m *mockLineReader) SyncMeta()                         {}
+func (m *mockLineReader) Close() error                      { return nil }
+func (m *mockLineReader) ReadLine() (string, error) {
+	if len(m.lines) == 0 {
+		return "", nil
+	}
+	line := m.lines[0]
+	m.lines = m.lines[1:]
+	return line, nil
+}
+
+func TestMultilineReader(t *testing.T) {
+	dir := "TestMultilineReader"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	meta, err := NewMetaWithConf(conf.MapConf{KeyMetaPath: dir, KeyMode: ModeSocket, KeyRunnerName: "TestMultilineReader"})
+	assert.NoError(t, err)
+
+	mc, err := NewMultilineConfig(conf.MapConf{KeyMultilineStart: `^\[`, KeyMultilineFlushTimeout: "0s"})
+	assert.NoError(t, err)
+	r := NewMultilineReader(&mockLineReader{lines: []string{"[1] a", "b", "[2] c"}}, meta, mc)
+	var events []string
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			events = append(events, line)
+		}
+	}
+	assert.Equal(t, []string{"[1] a\nb"}, events)
+
+	// 未完成的事件随 SyncMeta 持久化，重启后继续组装
+	r.SyncMeta()
+	r = NewMultilineReader(&mockLineReader{lines: []string{"d", "[3] e"}}, meta, mc)
+	events = events[:0]
+	for i := 0; i < 5; i++ {
+		line, _ := r.ReadLine()
+		if line != "" {
+			events = append(events, line)
+		}
+	}
+	assert.Equal(t, []string{"[2] c\nd"}, events)
+
+	// 内部读取器未实现的可选接口按 runner 的方式处理
+	lag, err := r.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, &LagInfo{}, lag)
+	assert.Equal(t, StatsInfo{}, r.Status())
+	r = NewMultilineReader(&mockLineReader{lines: []string{"[4] f", "g"}}, meta, mc)
+	r.ReadLine()
+	r.ReadLine()
+	assert.NotEmpty(t, r.assembler.Pending())
+	assert.NoError(t, r.Reset())
+	assert.Empty(t, r.assembler.Pending())
+}
+
+type mockTagsReader struct {
+	mockLineReader
+	tags     []map[string]interface{}
+	lineTags map[string]interface{}
+}
+
+func (m *mockTagsReader) ReadLine() (string, error) {
+	if len(m.lines) == 0 {
+		return "", nil
+	}
+	m.lineTags = m.tags[0]
+	m.tags = m.tags[1:]
+	return m.mockLineReader.ReadLine()
+}
+
+func (m *mockTagsReader) LineTags() map[string]interface{} {
+	return m.lineTags
+}
+
+func TestMultilineReaderLineTags(t *testing.T) {
+	mc, err := NewMultilineConfig(conf.MapConf{KeyMultilineStart: `^\[`, KeyMultilineFlushTimeout: "0s"})
+	assert.NoError(t, err)
+	rd := &mockTagsReader{
+		mockLineReader: mockLineReader{lines: []string{"[1] a", "b", "[2] c", "d"}},
+		tags: []map[string]interface{}{
+			{"client": "1"}, {"client": "1"}, {"client": "2"}, {"client": "2"},
+		},
+	}
+	r := NewMultilineReader(rd, nil, mc)
+
+	// 事件在下一个事件的第一行读取后才输出，标签仍为该事件第一行的标签
+	var events []string
+	var tags []map[string]interface{}
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			events = append(events, line)
+			tags = append(tags, r.LineTags())
+		}
+	}
+	r.assembler.Flush()
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	events = append(events, line)
+	tags = append(tags, r.LineTags())
+	assert.Equal(t, []string{"[1] a\nb", "[2] c\nd"}, events)
+	assert.Equal(t, []map[string]interface{}{{"client": "1"}, {"client": "2"}}, tags)
+}
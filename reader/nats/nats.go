@@ -0,0 +1,369 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gonats "github.com/nats-io/nats.go"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.LagReader    = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// fetchWait 为单次拉取等待消息的最长时间
+	fetchWait = time.Second
+	// retryDelay 为订阅或者拉取失败之后重试的等待时间
+	retryDelay = 5 * time.Second
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeNATS, NewReader)
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan *gonats.Msg
+	errChan  chan error
+	wg       sync.WaitGroup
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	servers   string
+	stream    string
+	subject   string
+	durable   string
+	batchSize int
+	ackWait   time.Duration
+
+	conn   *gonats.Conn
+	js     gonats.JetStreamContext
+	subMux sync.RWMutex
+	sub    *gonats.Subscription
+
+	// pending 为已经读取、等待 SyncMeta 之后确认的消息
+	pendingLock sync.Mutex
+	pending     []*gonats.Msg
+	// unread 为停止时已经拉取但尚未读取的消息
+	unread []*gonats.Msg
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	servers, err := conf.GetStringListOr(reader.KeyNATSServers, []string{gonats.DefaultURL})
+	if err != nil {
+		return nil, err
+	}
+	subject, err := conf.GetString(reader.KeyNATSSubject)
+	if err != nil {
+		return nil, err
+	}
+	stream, _ := conf.GetStringOr(reader.KeyNATSStream, "")
+	durable, _ := conf.GetStringOr(reader.KeyNATSDurable, "")
+	if durable == "" {
+		durable = "logkit_" + meta.RunnerName
+	}
+	// consumer 名称中不能包含 . * > 以及空白字符
+	durable = strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, durable)
+	batchSize, _ := conf.GetIntOr(reader.KeyNATSBatchSize, 100)
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	ackWaitStr, _ := conf.GetStringOr(reader.KeyNATSAckWait, "5m")
+	ackWait, err := time.ParseDuration(ackWaitStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyNATSAckWait, ackWaitStr, err)
+	}
+
+	r := &Reader{
+		meta:      meta,
+		status:    reader.StatusInit,
+		stopChan:  make(chan struct{}),
+		readChan:  make(chan *gonats.Msg),
+		errChan:   make(chan error),
+		servers:   strings.Join(servers, ","),
+		stream:    stream,
+		subject:   subject,
+		durable:   durable,
+		batchSize: batchSize,
+		ackWait:   ackWait,
+	}
+	opts, err := connectOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		gonats.Name("logkit_"+meta.RunnerName),
+		gonats.MaxReconnects(-1),
+		// 服务端暂时不可用时不影响 runner 的启动，由客户端在后台重连
+		gonats.RetryOnFailedConnect(true),
+		gonats.DisconnectErrHandler(func(_ *gonats.Conn, err error) {
+			if err != nil {
+				log.Warningf("Runner[%v] %q disconnected: %v", meta.RunnerName, r.Name(), err)
+			}
+		}),
+	)
+	if r.conn, err = gonats.Connect(r.servers, opts...); err != nil {
+		return nil, fmt.Errorf("connect to %v failed: %v", r.servers, err)
+	}
+	if r.js, err = r.conn.JetStream(); err != nil {
+		r.conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// connectOptions 根据认证与 TLS 配置生成连接选项
+func connectOptions(conf conf.MapConf) ([]gonats.Option, error) {
+	var opts []gonats.Option
+	username, _ := conf.GetStringOr(reader.KeyNATSUsername, "")
+	password, _ := conf.GetStringOr(reader.KeyNATSPassword, "")
+	if username != "" {
+		opts = append(opts, gonats.UserInfo(username, password))
+	}
+	if token, _ := conf.GetStringOr(reader.KeyNATSToken, ""); token != "" {
+		opts = append(opts, gonats.Token(token))
+	}
+	if creds, _ := conf.GetStringOr(reader.KeyNATSCredsFile, ""); creds != "" {
+		opts = append(opts, gonats.UserCredentials(creds))
+	}
+	if ca, _ := conf.GetStringOr(reader.KeyNATSTLSCA, ""); ca != "" {
+		opts = append(opts, gonats.RootCAs(ca))
+	}
+	certFile, _ := conf.GetStringOr(reader.KeyNATSTLSCert, "")
+	keyFile, _ := conf.GetStringOr(reader.KeyNATSTLSKey, "")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("%s and %s must be set together", reader.KeyNATSTLSCert, reader.KeyNATSTLSKey)
+		}
+		opts = append(opts, gonats.ClientCert(certFile, keyFile))
+	}
+	return opts, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "NATSReader<" + r.subject + "," + r.durable + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("nats reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+// subscribe 创建或者绑定 durable pull consumer，consumer 已存在时沿用其消费进度
+func (r *Reader) subscribe() (*gonats.Subscription, error) {
+	opts := []gonats.SubOpt{gonats.ManualAck(), gonats.AckWait(r.ackWait)}
+	if r.stream != "" {
+		opts = append(opts, gonats.BindStream(r.stream))
+	}
+	sub, err := r.js.PullSubscribe(r.subject, r.durable, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create pull consumer %v failed: %v", r.durable, err)
+	}
+	r.subMux.Lock()
+	r.sub = sub
+	r.subMux.Unlock()
+	return sub, nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	var sub *gonats.Subscription
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+
+		var err error
+		if sub == nil {
+			sub, err = r.subscribe()
+		}
+		var msgs []*gonats.Msg
+		if err == nil {
+			msgs, err = sub.Fetch(r.batchSize, gonats.MaxWait(fetchWait))
+			if err == gonats.ErrTimeout {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			select {
+			case <-r.stopChan:
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		for i, msg := range msgs {
+			select {
+			case r.readChan <- msg:
+			case <-r.stopChan:
+				r.unread = msgs[i:]
+				return
+			}
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.servers + "/" + r.subject
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case msg := <-r.readChan:
+		r.pendingLock.Lock()
+		r.pending = append(r.pending, msg)
+		r.pendingLock.Unlock()
+		return string(msg.Data), nil
+	case err := <-r.errChan:
+		return "", err
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// Lag 返回 consumer 中尚未投递的消息数
+func (r *Reader) Lag() (*LagInfo, error) {
+	r.subMux.RLock()
+	sub := r.sub
+	r.subMux.RUnlock()
+	if sub == nil {
+		return nil, errors.New("nats consumer is not ready")
+	}
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return nil, err
+	}
+	return &LagInfo{Size: int64(info.NumPending), SizeUnit: "records"}, nil
+}
+
+// SyncMeta 确认已经读取的消息，消费进度由服务端的 durable consumer 保存
+func (r *Reader) SyncMeta() {
+	r.pendingLock.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.pendingLock.Unlock()
+	var failed int
+	var lastErr error
+	for _, msg := range pending {
+		if err := msg.Ack(); err != nil {
+			// 确认失败的消息会在 ack_wait 之后重新投递，继续确认其余的消息
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		log.Errorf("Runner[%v] %q ack %d of %d messages failed: %v", r.meta.RunnerName, r.Name(), failed, len(pending), lastErr)
+	}
+}
+
+func nakMessages(msgs []*gonats.Msg) {
+	for _, msg := range msgs {
+		msg.Nak()
+	}
+}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		r.conn.Close()
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.wg.Wait()
+	// 未确认的消息交还给服务端立即重新投递，无需等待 ack_wait，
+	// 先交还已经读取的消息以保持重新投递的顺序
+	r.pendingLock.Lock()
+	nakMessages(r.pending)
+	r.pending = nil
+	r.pendingLock.Unlock()
+	nakMessages(r.unread)
+	r.unread = nil
+	// 不能取消订阅，否则会删除 durable consumer
+	if err := r.conn.Flush(); err != nil {
+		log.Warningf("Runner[%v] %q flush before close failed: %v", r.meta.RunnerName, r.Name(), err)
+	}
+	r.conn.Close()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
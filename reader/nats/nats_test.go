@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	gonats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+func startServer(t *testing.T, storeDir string) (*server.Server, gonats.JetStreamContext) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: storeDir})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+
+	nc, err := gonats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&gonats.StreamConfig{Name: "LOGS", Subjects: []string{"logs.>"}})
+	require.NoError(t, err)
+	return s, js
+}
+
+func readLine(t *testing.T, r reader.Reader) string {
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line != "" {
+			return line
+		}
+	}
+	t.Fatal("no line read")
+	return ""
+}
+
+func newReader(t *testing.T, c conf.MapConf) reader.Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(reader.DaemonReader).Start())
+	return r
+}
+
+func TestNATSReader(t *testing.T) {
+	metaDir := "TestNATSReader"
+	os.RemoveAll(metaDir)
+	defer os.RemoveAll(metaDir)
+
+	s, js := startServer(t, t.TempDir())
+	defer s.Shutdown()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		_, err := js.Publish("logs.app", []byte(msg))
+		require.NoError(t, err)
+	}
+
+	c := conf.MapConf{
+		reader.KeyNATSServers:   s.ClientURL(),
+		reader.KeyNATSStream:    "LOGS",
+		reader.KeyNATSSubject:   "logs.>",
+		reader.KeyNATSBatchSize: "2",
+		reader.KeyMetaPath:      metaDir,
+		reader.KeyMode:          reader.ModeNATS,
+		models.KeyRunnerName:    "TestNATSReader",
+	}
+	r := newReader(t, c)
+	assert.Equal(t, "a", readLine(t, r))
+	// 未经 SyncMeta 确认的消息在关闭后重新投递
+	assert.NoError(t, r.Close())
+
+	r = newReader(t, c)
+	defer r.Close()
+	assert.Equal(t, "a", readLine(t, r))
+	assert.Equal(t, "b", readLine(t, r))
+	r.SyncMeta()
+	assert.Equal(t, "c", readLine(t, r))
+	r.SyncMeta()
+
+	lag, err := r.(reader.LagReader).Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, &models.LagInfo{Size: 0, SizeUnit: "records"}, lag)
+
+	_, err = js.Publish("logs.app", []byte("d"))
+	require.NoError(t, err)
+	assert.Equal(t, "d", readLine(t, r))
+	r.SyncMeta()
+
+	// ack 为异步发送，等待服务端处理
+	assert.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo("LOGS", "logkit_TestNATSReader")
+		return err == nil && info.NumAckPending == 0
+	}, 2*time.Second, 50*time.Millisecond)
+}
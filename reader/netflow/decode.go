@@ -0,0 +1,603 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+const (
+	versionV5    = 5
+	versionV9    = 9
+	versionIPFIX = 10
+
+	v5HeaderLen    = 24
+	v5RecordLen    = 48
+	v9HeaderLen    = 20
+	ipfixHeaderLen = 16
+
+	// 变长字段的长度，仅用于 IPFIX
+	variableLength = 65535
+	// systemInitTime 为 IPFIX 中 systemInitTimeMilliseconds 字段的编号，用于计算相对于启动时间的字段
+	systemInitTime = 160
+)
+
+var errTruncated = errors.New("packet is truncated")
+
+// 字段值的类型
+const (
+	kindUint = iota
+	kindIP
+	kindMAC
+	// kindUptime 为相对于设备启动时间的毫秒数
+	kindUptime
+	kindSeconds
+	kindMillis
+	kindString
+)
+
+type fieldDef struct {
+	name string
+	kind int
+}
+
+// fieldDefs 为 NetFlow v9 与 IPFIX 中常用字段的输出名称，v9 的字段编号与 IPFIX 的 information element 编号一致，
+// IPv4 与 IPv6 的地址输出为相同的字段
+var fieldDefs = map[uint16]fieldDef{
+	1:   {"bytes", kindUint},
+	2:   {"packets", kindUint},
+	4:   {"protocol", kindUint},
+	5:   {"tos", kindUint},
+	6:   {"tcp_flags", kindUint},
+	7:   {"src_port", kindUint},
+	8:   {"src_addr", kindIP},
+	9:   {"src_mask", kindUint},
+	10:  {"input_if", kindUint},
+	11:  {"dst_port", kindUint},
+	12:  {"dst_addr", kindIP},
+	13:  {"dst_mask", kindUint},
+	14:  {"output_if", kindUint},
+	15:  {"next_hop", kindIP},
+	16:  {"src_as", kindUint},
+	17:  {"dst_as", kindUint},
+	18:  {"bgp_next_hop", kindIP},
+	21:  {"flow_end", kindUptime},
+	22:  {"flow_start", kindUptime},
+	23:  {"out_bytes", kindUint},
+	24:  {"out_packets", kindUint},
+	27:  {"src_addr", kindIP},
+	28:  {"dst_addr", kindIP},
+	29:  {"src_mask", kindUint},
+	30:  {"dst_mask", kindUint},
+	31:  {"flow_label", kindUint},
+	32:  {"icmp_type_code", kindUint},
+	34:  {"sampling_interval", kindUint},
+	35:  {"sampling_algorithm", kindUint},
+	56:  {"src_mac", kindMAC},
+	58:  {"vlan_id", kindUint},
+	60:  {"ip_version", kindUint},
+	61:  {"direction", kindUint},
+	62:  {"next_hop", kindIP},
+	63:  {"bgp_next_hop", kindIP},
+	80:  {"dst_mac", kindMAC},
+	82:  {"interface_name", kindString},
+	85:  {"total_bytes", kindUint},
+	86:  {"total_packets", kindUint},
+	136: {"end_reason", kindUint},
+	139: {"icmp_type_code", kindUint},
+	148: {"flow_id", kindUint},
+	150: {"flow_start", kindSeconds},
+	151: {"flow_end", kindSeconds},
+	152: {"flow_start", kindMillis},
+	153: {"flow_end", kindMillis},
+	160: {"system_init_time", kindMillis},
+	176: {"icmp_type", kindUint},
+	177: {"icmp_code", kindUint},
+	225: {"post_nat_src_addr", kindIP},
+	226: {"post_nat_dst_addr", kindIP},
+	227: {"post_napt_src_port", kindUint},
+	228: {"post_napt_dst_port", kindUint},
+}
+
+// header 为报文头中每条流记录都会带上的信息
+type header struct {
+	exporter   string
+	version    uint16
+	domain     uint32
+	sequence   uint32
+	exportTime time.Time
+	// uptime 为 v9 报文头中的设备启动时间(毫秒)，用于计算流的开始与结束时间
+	uptime uint32
+}
+
+func (h *header) data() Data {
+	data := Data{
+		"exporter":    h.exporter,
+		"version":     int64(h.version),
+		"sequence":    int64(h.sequence),
+		"export_time": formatTime(h.exportTime),
+	}
+	if h.version != versionV5 {
+		data["observation_domain"] = int64(h.domain)
+	}
+	return data
+}
+
+// uptimeToTime 将相对于设备启动时间的毫秒数转换为绝对时间，uint32 的减法可以正确处理计数器回绕
+func (h *header) uptimeToTime(ms uint32) time.Time {
+	return h.exportTime.Add(-time.Duration(h.uptime-ms) * time.Millisecond)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// FlowTemplate 记录一个 NetFlow v9 或 IPFIX exporter 发送的模板，随 SyncMeta 持久化
+type FlowTemplate struct {
+	Exporter string `json:"exporter"`
+	Version  uint16 `json:"version"`
+	// Domain 为 NetFlow v9 的 source id 或者 IPFIX 的 observation domain id
+	Domain uint32 `json:"domain"`
+	ID     uint16 `json:"id"`
+	// ScopeCount 为 options 模板中 scope 字段的个数，普通模板为 0
+	ScopeCount int                 `json:"scope_count"`
+	Options    bool                `json:"options"`
+	Fields     []FlowTemplateField `json:"fields"`
+}
+
+// FlowTemplateField 为模板中的一个字段，Length 为 65535 表示变长字段
+type FlowTemplateField struct {
+	Type       uint16 `json:"type"`
+	Length     uint16 `json:"length"`
+	Enterprise uint32 `json:"enterprise,omitempty"`
+}
+
+type templateKey struct {
+	exporter string
+	version  uint16
+	domain   uint32
+	id       uint16
+}
+
+// decoder 解析 NetFlow v5、v9 与 IPFIX 报文，并按照 exporter 与 observation domain 缓存模板
+type decoder struct {
+	mu        sync.Mutex
+	templates map[templateKey]*FlowTemplate
+	// dirty 表示模板有更新，尚未写入 meta
+	dirty bool
+	// missing 记录已经报告过缺少模板的数据，避免在收到模板前重复报错
+	missing map[templateKey]bool
+}
+
+func newDecoder(templates []FlowTemplate) *decoder {
+	d := &decoder{
+		templates: make(map[templateKey]*FlowTemplate, len(templates)),
+		missing:   make(map[templateKey]bool),
+	}
+	for i := range templates {
+		t := templates[i]
+		d.templates[templateKey{t.Exporter, t.Version, t.Domain, t.ID}] = &t
+	}
+	return d
+}
+
+// snapshot 返回模板有更新时的全部模板
+func (d *decoder) snapshot() ([]FlowTemplate, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.dirty {
+		return nil, false
+	}
+	d.dirty = false
+	templates := make([]FlowTemplate, 0, len(d.templates))
+	for _, t := range d.templates {
+		templates = append(templates, *t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		a, b := templates[i], templates[j]
+		if a.Exporter != b.Exporter {
+			return a.Exporter < b.Exporter
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		return a.ID < b.ID
+	})
+	return templates, true
+}
+
+// decode 解析一个报文，返回其中的流记录，出错时仍然返回出错之前已经解析的记录
+func (d *decoder) decode(exporter string, packet []byte) ([]Data, error) {
+	if len(packet) < 2 {
+		return nil, errTruncated
+	}
+	switch version := binary.BigEndian.Uint16(packet); version {
+	case versionV5:
+		return decodeV5(exporter, packet)
+	case versionV9:
+		if len(packet) < v9HeaderLen {
+			return nil, errTruncated
+		}
+		h := &header{
+			exporter:   exporter,
+			version:    version,
+			uptime:     binary.BigEndian.Uint32(packet[4:]),
+			exportTime: time.Unix(int64(binary.BigEndian.Uint32(packet[8:])), 0),
+			sequence:   binary.BigEndian.Uint32(packet[12:]),
+			domain:     binary.BigEndian.Uint32(packet[16:]),
+		}
+		return d.decodeSets(h, packet[v9HeaderLen:])
+	case versionIPFIX:
+		if len(packet) < ipfixHeaderLen {
+			return nil, errTruncated
+		}
+		if length := int(binary.BigEndian.Uint16(packet[2:])); length < ipfixHeaderLen || length > len(packet) {
+			return nil, errTruncated
+		} else {
+			packet = packet[:length]
+		}
+		h := &header{
+			exporter:   exporter,
+			version:    version,
+			exportTime: time.Unix(int64(binary.BigEndian.Uint32(packet[4:])), 0),
+			sequence:   binary.BigEndian.Uint32(packet[8:]),
+			domain:     binary.BigEndian.Uint32(packet[12:]),
+		}
+		return d.decodeSets(h, packet[ipfixHeaderLen:])
+	default:
+		return nil, fmt.Errorf("unsupported netflow version %d", version)
+	}
+}
+
+func decodeV5(exporter string, p []byte) ([]Data, error) {
+	if len(p) < v5HeaderLen {
+		return nil, errTruncated
+	}
+	count := int(binary.BigEndian.Uint16(p[2:]))
+	if len(p) < v5HeaderLen+count*v5RecordLen {
+		return nil, errTruncated
+	}
+	h := &header{
+		exporter:   exporter,
+		version:    versionV5,
+		uptime:     binary.BigEndian.Uint32(p[4:]),
+		exportTime: time.Unix(int64(binary.BigEndian.Uint32(p[8:])), int64(binary.BigEndian.Uint32(p[12:]))),
+		sequence:   binary.BigEndian.Uint32(p[16:]),
+	}
+	engineType, engineID := p[20], p[21]
+	// 高 2 位为采样方式
+	sampling := binary.BigEndian.Uint16(p[22:]) & 0x3fff
+
+	datas := make([]Data, 0, count)
+	for i := 0; i < count; i++ {
+		rec := p[v5HeaderLen+i*v5RecordLen:]
+		data := h.data()
+		data["engine_type"] = int64(engineType)
+		data["engine_id"] = int64(engineID)
+		if sampling > 0 {
+			data["sampling_interval"] = int64(sampling)
+		}
+		data["src_addr"] = net.IP(rec[0:4]).String()
+		data["dst_addr"] = net.IP(rec[4:8]).String()
+		data["next_hop"] = net.IP(rec[8:12]).String()
+		data["input_if"] = int64(binary.BigEndian.Uint16(rec[12:]))
+		data["output_if"] = int64(binary.BigEndian.Uint16(rec[14:]))
+		data["packets"] = int64(binary.BigEndian.Uint32(rec[16:]))
+		data["bytes"] = int64(binary.BigEndian.Uint32(rec[20:]))
+		data["flow_start"] = formatTime(h.uptimeToTime(binary.BigEndian.Uint32(rec[24:])))
+		data["flow_end"] = formatTime(h.uptimeToTime(binary.BigEndian.Uint32(rec[28:])))
+		data["src_port"] = int64(binary.BigEndian.Uint16(rec[32:]))
+		data["dst_port"] = int64(binary.BigEndian.Uint16(rec[34:]))
+		data["tcp_flags"] = int64(rec[37])
+		data["protocol"] = int64(rec[38])
+		data["tos"] = int64(rec[39])
+		data["src_as"] = int64(binary.BigEndian.Uint16(rec[40:]))
+		data["dst_as"] = int64(binary.BigEndian.Uint16(rec[42:]))
+		data["src_mask"] = int64(rec[44])
+		data["dst_mask"] = int64(rec[45])
+		datas = append(datas, data)
+	}
+	return datas, nil
+}
+
+// decodeSets 解析 v9 的 flowset 或者 IPFIX 的 set，两者的结构相同，只有模板的编号与格式不同
+func (d *decoder) decodeSets(h *header, p []byte) ([]Data, error) {
+	var datas []Data
+	for len(p) >= 4 {
+		id := binary.BigEndian.Uint16(p)
+		length := int(binary.BigEndian.Uint16(p[2:]))
+		if length < 4 || length > len(p) {
+			return datas, errTruncated
+		}
+		body := p[4:length]
+		p = p[length:]
+
+		var err error
+		switch {
+		case h.version == versionV9 && id == 0:
+			err = d.parseTemplates(h, body, false)
+		case h.version == versionV9 && id == 1:
+			err = d.parseV9OptionsTemplates(h, body)
+		case h.version == versionIPFIX && id == 2:
+			err = d.parseTemplates(h, body, false)
+		case h.version == versionIPFIX && id == 3:
+			err = d.parseTemplates(h, body, true)
+		case id >= 256:
+			var records []Data
+			records, err = d.decodeDataSet(h, id, body)
+			datas = append(datas, records...)
+		}
+		if err != nil {
+			return datas, err
+		}
+	}
+	return datas, nil
+}
+
+func (d *decoder) putTemplate(t *FlowTemplate) {
+	key := templateKey{t.Exporter, t.Version, t.Domain, t.ID}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.missing, key)
+	if old, ok := d.templates[key]; ok && templateEqual(old, t) {
+		return
+	}
+	d.templates[key] = t
+	d.dirty = true
+}
+
+func templateEqual(a, b *FlowTemplate) bool {
+	if a.Options != b.Options || a.ScopeCount != b.ScopeCount || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i] != b.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withdrawTemplate 删除 IPFIX 中撤回的模板，编号为 2 或 3 时撤回该 observation domain 下对应类型的全部模板
+func (d *decoder) withdrawTemplate(h *header, id uint16, options bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, t := range d.templates {
+		if key.exporter != h.exporter || key.version != h.version || key.domain != h.domain {
+			continue
+		}
+		if key.id == id || (id < 256 && t.Options == options) {
+			delete(d.templates, key)
+			d.dirty = true
+		}
+	}
+}
+
+// parseTemplates 解析 v9 的模板以及 IPFIX 的模板与 options 模板
+func (d *decoder) parseTemplates(h *header, p []byte, options bool) error {
+	headerLen := 4
+	if options {
+		headerLen = 6
+	}
+	for len(p) >= headerLen {
+		id := binary.BigEndian.Uint16(p)
+		count := int(binary.BigEndian.Uint16(p[2:]))
+		if count == 0 && h.version == versionIPFIX && (id >= 256 || id == 2 || id == 3) {
+			d.withdrawTemplate(h, id, options)
+			p = p[4:]
+			continue
+		}
+		if id < 256 {
+			// 剩余的为填充
+			return nil
+		}
+		t := &FlowTemplate{Exporter: h.exporter, Version: h.version, Domain: h.domain, ID: id, Options: options}
+		if options {
+			t.ScopeCount = int(binary.BigEndian.Uint16(p[4:]))
+		}
+		p = p[headerLen:]
+		for i := 0; i < count; i++ {
+			if len(p) < 4 {
+				return errTruncated
+			}
+			f := FlowTemplateField{Type: binary.BigEndian.Uint16(p), Length: binary.BigEndian.Uint16(p[2:])}
+			p = p[4:]
+			if h.version == versionIPFIX && f.Type&0x8000 != 0 {
+				if len(p) < 4 {
+					return errTruncated
+				}
+				f.Type &= 0x7fff
+				f.Enterprise = binary.BigEndian.Uint32(p)
+				p = p[4:]
+			}
+			t.Fields = append(t.Fields, f)
+		}
+		d.putTemplate(t)
+	}
+	return nil
+}
+
+// parseV9OptionsTemplates 解析 v9 的 options 模板，其 scope 与 option 字段以字节数而不是个数给出
+func (d *decoder) parseV9OptionsTemplates(h *header, p []byte) error {
+	for len(p) >= 6 {
+		id := binary.BigEndian.Uint16(p)
+		scopeLen := int(binary.BigEndian.Uint16(p[2:]))
+		optionLen := int(binary.BigEndian.Uint16(p[4:]))
+		if id < 256 {
+			return nil
+		}
+		if len(p) < 6+scopeLen+optionLen {
+			return errTruncated
+		}
+		t := &FlowTemplate{Exporter: h.exporter, Version: h.version, Domain: h.domain, ID: id, Options: true, ScopeCount: scopeLen / 4}
+		fields := p[6 : 6+(scopeLen+optionLen)/4*4]
+		for ; len(fields) >= 4; fields = fields[4:] {
+			t.Fields = append(t.Fields, FlowTemplateField{Type: binary.BigEndian.Uint16(fields), Length: binary.BigEndian.Uint16(fields[2:])})
+		}
+		d.putTemplate(t)
+		p = p[6+scopeLen+optionLen:]
+	}
+	return nil
+}
+
+func (d *decoder) template(h *header, id uint16) (*FlowTemplate, error) {
+	key := templateKey{h.exporter, h.version, h.domain, id}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.templates[key]; ok {
+		return t, nil
+	}
+	if d.missing[key] {
+		return nil, nil
+	}
+	d.missing[key] = true
+	return nil, fmt.Errorf("template %d of exporter %v observation domain %d not found, drop data until it is received", id, h.exporter, h.domain)
+}
+
+// decodeDataSet 按照模板解析数据记录，options 模板的数据只解析长度，不输出
+func (d *decoder) decodeDataSet(h *header, id uint16, p []byte) ([]Data, error) {
+	t, err := d.template(h, id)
+	if t == nil {
+		return nil, err
+	}
+	minLen := 0
+	for _, f := range t.Fields {
+		if f.Length == variableLength {
+			minLen++
+		} else {
+			minLen += int(f.Length)
+		}
+	}
+	if minLen == 0 {
+		return nil, nil
+	}
+	var datas []Data
+	for len(p) >= minLen {
+		data, n, err := decodeRecord(h, t, p)
+		if err != nil {
+			return datas, err
+		}
+		p = p[n:]
+		if !t.Options {
+			datas = append(datas, data)
+		}
+	}
+	return datas, nil
+}
+
+// decodeRecord 解析一条数据记录，返回记录的长度
+func decodeRecord(h *header, t *FlowTemplate, p []byte) (Data, int, error) {
+	data := h.data()
+	var uptimes map[string]uint32
+	var initTime time.Time
+	offset := 0
+	for _, f := range t.Fields {
+		length := int(f.Length)
+		if f.Length == variableLength {
+			if offset >= len(p) {
+				return nil, 0, errTruncated
+			}
+			length = int(p[offset])
+			offset++
+			if length == 255 {
+				if offset+2 > len(p) {
+					return nil, 0, errTruncated
+				}
+				length = int(binary.BigEndian.Uint16(p[offset:]))
+				offset += 2
+			}
+		}
+		if offset+length > len(p) {
+			return nil, 0, errTruncated
+		}
+		value := p[offset : offset+length]
+		offset += length
+		if t.Options {
+			continue
+		}
+
+		def, ok := fieldDefs[f.Type]
+		if !ok || f.Enterprise != 0 {
+			data[unknownFieldName(f)] = unknownValue(value)
+			continue
+		}
+		switch def.kind {
+		case kindUint:
+			if length > 8 {
+				data[def.name] = hex.EncodeToString(value)
+			} else {
+				data[def.name] = int64(toUint(value))
+			}
+		case kindIP:
+			if length == net.IPv4len || length == net.IPv6len {
+				data[def.name] = net.IP(value).String()
+			} else {
+				data[def.name] = hex.EncodeToString(value)
+			}
+		case kindMAC:
+			data[def.name] = net.HardwareAddr(value).String()
+		case kindUptime:
+			if uptimes == nil {
+				uptimes = make(map[string]uint32)
+			}
+			uptimes[def.name] = uint32(toUint(value))
+		case kindSeconds:
+			data[def.name] = formatTime(time.Unix(int64(toUint(value)), 0))
+		case kindMillis:
+			ts := time.Unix(0, int64(toUint(value))*int64(time.Millisecond))
+			if f.Type == systemInitTime {
+				initTime = ts
+			}
+			data[def.name] = formatTime(ts)
+		case kindString:
+			data[def.name] = strings.TrimRight(string(value), "\x00")
+		}
+	}
+	for name, ms := range uptimes {
+		switch {
+		case h.version == versionV9:
+			data[name] = formatTime(h.uptimeToTime(ms))
+		case !initTime.IsZero():
+			data[name] = formatTime(initTime.Add(time.Duration(ms) * time.Millisecond))
+		default:
+			// 没有设备启动时间时无法换算为绝对时间，输出原始的毫秒数
+			data[name+"_uptime"] = int64(ms)
+		}
+	}
+	return data, offset, nil
+}
+
+// toUint 解析大端序的无符号整数，支持 IPFIX 的缩减长度编码
+func toUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func unknownFieldName(f FlowTemplateField) string {
+	if f.Enterprise != 0 {
+		return "field_" + strconv.FormatUint(uint64(f.Enterprise), 10) + "_" + strconv.Itoa(int(f.Type))
+	}
+	return "field_" + strconv.Itoa(int(f.Type))
+}
+
+func unknownValue(b []byte) interface{} {
+	if len(b) > 0 && len(b) <= 4 {
+		return int64(toUint(b))
+	}
+	return hex.EncodeToString(b)
+}
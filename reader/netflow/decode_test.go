@@ -0,0 +1,191 @@
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+// packet 按照大端序依次写入各个值
+func packet(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		if b, ok := v.([]byte); ok {
+			buf.Write(b)
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// set 生成一个 flowset 或者 set，长度包含 4 字节的头
+func set(id uint16, body ...interface{}) []byte {
+	b := packet(body...)
+	return packet(id, uint16(len(b)+4), b)
+}
+
+func TestDecodeV5(t *testing.T) {
+	record := packet(
+		[]byte{10, 0, 0, 1}, []byte{8, 8, 8, 8}, []byte{10, 0, 0, 254},
+		uint16(1), uint16(2), uint32(10), uint32(1500),
+		uint32(9000), uint32(9500), // first, last
+		uint16(51000), uint16(53), uint8(0), uint8(0x18), uint8(17), uint8(0),
+		uint16(64512), uint16(15169), uint8(24), uint8(0), uint16(0),
+	)
+	p := packet(uint16(5), uint16(1), uint32(10000), uint32(1700000000), uint32(0), uint32(42), uint8(1), uint8(2), uint16(0x4000|100), record)
+
+	datas, err := newDecoder(nil).decode("192.0.2.1", p)
+	require.NoError(t, err)
+	require.Len(t, datas, 1)
+	assert.Equal(t, Data{
+		"exporter":          "192.0.2.1",
+		"version":           int64(5),
+		"sequence":          int64(42),
+		"export_time":       "2023-11-14T22:13:20Z",
+		"engine_type":       int64(1),
+		"engine_id":         int64(2),
+		"sampling_interval": int64(100),
+		"src_addr":          "10.0.0.1",
+		"dst_addr":          "8.8.8.8",
+		"next_hop":          "10.0.0.254",
+		"input_if":          int64(1),
+		"output_if":         int64(2),
+		"packets":           int64(10),
+		"bytes":             int64(1500),
+		"flow_start":        "2023-11-14T22:13:19Z",
+		"flow_end":          "2023-11-14T22:13:19.5Z",
+		"src_port":          int64(51000),
+		"dst_port":          int64(53),
+		"tcp_flags":         int64(0x18),
+		"protocol":          int64(17),
+		"tos":               int64(0),
+		"src_as":            int64(64512),
+		"dst_as":            int64(15169),
+		"src_mask":          int64(24),
+		"dst_mask":          int64(0),
+	}, datas[0])
+
+	_, err = newDecoder(nil).decode("192.0.2.1", p[:len(p)-1])
+	assert.Equal(t, errTruncated, err)
+}
+
+func v9Packet(sets ...interface{}) []byte {
+	return packet(uint16(9), uint16(0), uint32(10000), uint32(1700000000), uint32(7), uint32(3), packet(sets...))
+}
+
+func TestDecodeV9(t *testing.T) {
+	d := newDecoder(nil)
+	data := set(256,
+		[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, uint16(443), uint16(40000), uint8(6), uint32(5000), uint32(9000),
+		[]byte{0, 0, 0}, // 填充
+	)
+
+	// 没有模板时丢弃数据，只报错一次
+	datas, err := d.decode("192.0.2.1", v9Packet(data))
+	assert.Error(t, err)
+	assert.Empty(t, datas)
+	datas, err = d.decode("192.0.2.1", v9Packet(data))
+	assert.NoError(t, err)
+	assert.Empty(t, datas)
+
+	template := set(0, uint16(256), uint16(7),
+		uint16(8), uint16(4), uint16(12), uint16(4), uint16(7), uint16(2), uint16(11), uint16(2),
+		uint16(4), uint16(1), uint16(1), uint16(4), uint16(21), uint16(4))
+	options := set(1, uint16(257), uint16(4), uint16(4), uint16(1), uint16(4), uint16(34), uint16(4))
+	optionsData := set(257, uint32(1), uint32(100))
+	datas, err = d.decode("192.0.2.1", v9Packet(template, options, optionsData, data))
+	require.NoError(t, err)
+	require.Len(t, datas, 1)
+	assert.Equal(t, Data{
+		"exporter":           "192.0.2.1",
+		"version":            int64(9),
+		"sequence":           int64(7),
+		"observation_domain": int64(3),
+		"export_time":        "2023-11-14T22:13:20Z",
+		"src_addr":           "10.0.0.1",
+		"dst_addr":           "10.0.0.2",
+		"src_port":           int64(443),
+		"dst_port":           int64(40000),
+		"protocol":           int64(6),
+		"bytes":              int64(5000),
+		"flow_end":           "2023-11-14T22:13:19Z",
+	}, datas[0])
+
+	// 模板按照 exporter 与 source id 区分
+	datas, err = d.decode("192.0.2.2", v9Packet(data))
+	assert.Error(t, err)
+	assert.Empty(t, datas)
+
+	templates, changed := d.snapshot()
+	assert.True(t, changed)
+	require.Len(t, templates, 2)
+	assert.Equal(t, uint16(256), templates[0].ID)
+	assert.True(t, templates[1].Options)
+	assert.Equal(t, 1, templates[1].ScopeCount)
+	_, changed = d.snapshot()
+	assert.False(t, changed)
+
+	// 重复收到相同的模板不需要重新记录
+	_, err = d.decode("192.0.2.1", v9Packet(template))
+	require.NoError(t, err)
+	_, changed = d.snapshot()
+	assert.False(t, changed)
+
+	// 从记录的模板恢复
+	datas, err = newDecoder(templates).decode("192.0.2.1", v9Packet(data))
+	require.NoError(t, err)
+	assert.Len(t, datas, 1)
+}
+
+func TestDecodeIPFIX(t *testing.T) {
+	d := newDecoder(nil)
+	template := set(2, uint16(300), uint16(6),
+		uint16(27), uint16(16), uint16(28), uint16(16), uint16(82), uint16(65535),
+		uint16(152), uint16(8), uint16(22), uint16(4),
+		uint16(0x8000|1), uint16(4), uint32(9))
+	sysInit := set(2, uint16(301), uint16(1), uint16(160), uint16(8))
+	src := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	dst := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	data := set(300,
+		src, dst, uint8(4), []byte("eth0"), uint64(1700000000123), uint32(2000), uint32(77),
+		src, dst, uint8(255), uint16(3), []byte("lo0"), uint64(1700000000123), uint32(2000), uint32(78),
+	)
+	body := packet(template, sysInit, data)
+	p := packet(uint16(10), uint16(16+len(body)), uint32(1700000000), uint32(11), uint32(5), body)
+
+	datas, err := d.decode("192.0.2.1", p)
+	require.NoError(t, err)
+	require.Len(t, datas, 2)
+	assert.Equal(t, Data{
+		"exporter":           "192.0.2.1",
+		"version":            int64(10),
+		"sequence":           int64(11),
+		"observation_domain": int64(5),
+		"export_time":        "2023-11-14T22:13:20Z",
+		"src_addr":           "2001:db8::1",
+		"dst_addr":           "2001:db8::2",
+		"interface_name":     "eth0",
+		"flow_start":         "2023-11-14T22:13:20.123Z",
+		"flow_start_uptime":  int64(2000),
+		"field_9_1":          int64(77),
+	}, datas[0])
+	assert.Equal(t, "lo0", datas[1]["interface_name"])
+
+	// 撤回模板
+	withdraw := set(2, uint16(300), uint16(0))
+	p = packet(uint16(10), uint16(16+len(withdraw)), uint32(1700000000), uint32(12), uint32(5), withdraw)
+	_, err = d.decode("192.0.2.1", p)
+	require.NoError(t, err)
+	templates, _ := d.snapshot()
+	require.Len(t, templates, 1)
+	assert.Equal(t, uint16(301), templates[0].ID)
+
+	_, err = d.decode("192.0.2.1", []byte{0, 1, 2, 3})
+	assert.EqualError(t, err, "unsupported netflow version 1")
+}
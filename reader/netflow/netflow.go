@@ -0,0 +1,227 @@
+package netflow
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// maxPacketSize 为 UDP 报文的最大长度
+	maxPacketSize = 65535
+	// templatesFileName 为记录 flow 模板的文件名
+	templatesFileName = reader.DoneFileName + ".templates"
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeNetFlow, NewReader)
+}
+
+type flow struct {
+	data     Data
+	bytes    int64
+	exporter string
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan flow
+	wg       sync.WaitGroup
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	address        string
+	readBufferSize int
+	conn           *net.UDPConn
+	decoder        *decoder
+
+	source string
+}
+
+func templatesFile(meta *reader.Meta) string {
+	return filepath.Join(meta.DoneFilePath, templatesFileName)
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	address, _ := conf.GetStringOr(reader.KeyNetFlowServiceAddress, "0.0.0.0:2055")
+	// 兼容 socket reader 的 udp:// 写法
+	address = strings.TrimPrefix(address, "udp://")
+	readBufferSize, _ := conf.GetIntOr(reader.KeyNetFlowReadBufferSize, 0)
+
+	var templates []FlowTemplate
+	if err := reader.ReadJSONRecords(templatesFile(meta), &templates); err != nil {
+		log.Errorf("Runner[%v] %v templates is corrupted err: %v, omit it", meta.RunnerName, templatesFile(meta), err)
+		templates = nil
+	}
+	return &Reader{
+		meta:           meta,
+		status:         reader.StatusInit,
+		stopChan:       make(chan struct{}),
+		readChan:       make(chan flow),
+		address:        address,
+		readBufferSize: readBufferSize,
+		decoder:        newDecoder(templates),
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "NetFlowReader<" + r.address + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("netflow reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", r.address)
+	if err == nil {
+		r.conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		atomic.StoreInt32(&r.status, reader.StatusInit)
+		return err
+	}
+	if r.readBufferSize > 0 {
+		if err := r.conn.SetReadBuffer(r.readBufferSize); err != nil {
+			log.Warningf("Runner[%v] %q set read buffer size %d failed: %v", r.meta.RunnerName, r.Name(), r.readBufferSize, err)
+		}
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			log.Errorf("Runner[%v] %q read packet failed: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			continue
+		}
+		exporter := addr.IP.String()
+		datas, err := r.decoder.decode(exporter, buf[:n])
+		if err != nil {
+			log.Warningf("Runner[%v] %q decode packet from %v failed: %v", r.meta.RunnerName, r.Name(), exporter, err)
+			r.setStatsError(err.Error())
+		}
+		if len(datas) == 0 {
+			continue
+		}
+		bytes := int64(n / len(datas))
+		for _, data := range datas {
+			select {
+			case r.readChan <- flow{data: data, bytes: bytes, exporter: exporter}:
+			case <-r.stopChan:
+				return
+			}
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.source
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case f := <-r.readChan:
+		r.source = f.exporter
+		return f.data, f.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 在模板有更新时记录模板，重启后无需等待 exporter 重新发送模板即可解析数据
+func (r *Reader) SyncMeta() {
+	templates, changed := r.decoder.snapshot()
+	if !changed {
+		return
+	}
+	if err := reader.WriteJSONRecords(templatesFile(r.meta), templates); err != nil {
+		log.Errorf("Runner[%v] %q write templates failed: %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.conn.Close()
+	r.wg.Wait()
+	// 模板只影响解析，停止时即使还有数据未发送也可以记录
+	r.SyncMeta()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
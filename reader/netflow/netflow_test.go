@@ -0,0 +1,62 @@
+package netflow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+func startReader(t *testing.T, c conf.MapConf) (*Reader, net.Conn) {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	require.NoError(t, err)
+	r := rd.(*Reader)
+	require.NoError(t, r.Start())
+	conn, err := net.Dial("udp", r.conn.LocalAddr().String())
+	require.NoError(t, err)
+	return r, conn
+}
+
+func TestNetFlowReader(t *testing.T) {
+	c := conf.MapConf{
+		reader.KeyMetaPath:              t.TempDir(),
+		reader.KeyMode:                  reader.ModeNetFlow,
+		models.KeyRunnerName:            "TestNetFlowReader",
+		reader.KeyNetFlowServiceAddress: "udp://127.0.0.1:0",
+	}
+	template := set(0, uint16(256), uint16(2), uint16(8), uint16(4), uint16(1), uint16(4))
+	data := set(256, []byte{10, 0, 0, 1}, uint32(100), []byte{10, 0, 0, 2}, uint32(200))
+
+	r, conn := startReader(t, c)
+	_, err := conn.Write(v9Packet(template, data))
+	require.NoError(t, err)
+	for _, expect := range []string{"10.0.0.1", "10.0.0.2"} {
+		d, bytes, err := r.ReadData()
+		require.NoError(t, err)
+		assert.Equal(t, expect, d["src_addr"])
+		assert.NotZero(t, bytes)
+	}
+	assert.Equal(t, "127.0.0.1", r.Source())
+	_, err = r.ReadLine()
+	assert.Error(t, err)
+	conn.Close()
+	require.NoError(t, r.Close())
+
+	// 重启后使用记录的模板解析数据
+	r, conn = startReader(t, c)
+	defer r.Close()
+	defer conn.Close()
+	_, err = conn.Write(v9Packet(data))
+	require.NoError(t, err)
+	d, _, err := r.ReadData()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), d["bytes"])
+	assert.Empty(t, r.Status().LastError)
+}
@@ -0,0 +1,181 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sample 为 metrics 接口中的一个样本，histogram 与 summary 在文本格式中本身就是按 bucket 与 quantile 展开的样本
+type sample struct {
+	name      string
+	family    string
+	typ       string
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+// familySuffixes 为样本名称相对于 metric family 名称可能带有的后缀
+var familySuffixes = []string{"_bucket", "_count", "_sum", "_total", "_created", "_gcount", "_gsum", "_info"}
+
+// parseExposition 解析 Prometheus text(0.0.4) 或者 OpenMetrics 格式的内容，
+// 两者的区别在于时间戳的单位，前者为毫秒，后者为秒，未带时间戳的样本使用 now
+func parseExposition(r io.Reader, openMetrics bool, now time.Time, fn func(*sample)) error {
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[1] == "EOF" {
+				break
+			}
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parseSample(line, openMetrics, now)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		s.family, s.typ = familyOf(s.name, types)
+		fn(s)
+	}
+	return scanner.Err()
+}
+
+// familyOf 根据 TYPE 注释找到样本所属的 metric family 及其类型
+func familyOf(name string, types map[string]string) (string, string) {
+	if typ, ok := types[name]; ok {
+		return name, typ
+	}
+	for _, suffix := range familySuffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		family := strings.TrimSuffix(name, suffix)
+		if typ, ok := types[family]; ok {
+			return family, typ
+		}
+	}
+	return name, "untyped"
+}
+
+func parseSample(line string, openMetrics bool, now time.Time) (*sample, error) {
+	s := &sample{labels: make(map[string]string), timestamp: now}
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return nil, fmt.Errorf("no value found in %q", line)
+	}
+	s.name = line[:end]
+	rest := line[end:]
+	if rest[0] == '{' {
+		var err error
+		if rest, err = parseLabels(rest[1:], s.labels); err != nil {
+			return nil, err
+		}
+	}
+	// OpenMetrics 的 exemplar 以 # 开头，位于值与时间戳之后
+	if idx := strings.Index(rest, "#"); idx >= 0 {
+		rest = rest[:idx]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid value and timestamp %q", rest)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.value = value
+	if len(fields) == 2 {
+		var ts time.Time
+		if openMetrics {
+			ts, err = parseSeconds(fields[1])
+		} else {
+			var ms int64
+			ms, err = strconv.ParseInt(fields[1], 10, 64)
+			ts = time.Unix(0, ms*int64(time.Millisecond))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		s.timestamp = ts
+	}
+	return s, nil
+}
+
+// parseSeconds 解析以秒为单位的小数时间戳，分别解析整数与小数部分以避免浮点数的精度损失
+func parseSeconds(str string) (time.Time, error) {
+	secStr, fracStr := str, ""
+	if idx := strings.IndexByte(str, '.'); idx >= 0 {
+		secStr, fracStr = str[:idx], str[idx+1:]
+	}
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(fracStr) > 9 {
+		fracStr = fracStr[:9]
+	}
+	var nsec int64
+	if fracStr != "" {
+		if nsec, err = strconv.ParseInt(fracStr+strings.Repeat("0", 9-len(fracStr)), 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// parseLabels 解析 { 之后的标签，返回 } 之后的内容
+func parseLabels(in string, labels map[string]string) (string, error) {
+	for {
+		in = strings.TrimLeft(in, " \t,")
+		if in == "" {
+			return "", fmt.Errorf("unterminated label set")
+		}
+		if in[0] == '}' {
+			return in[1:], nil
+		}
+		eq := strings.IndexByte(in, '=')
+		if eq < 0 {
+			return "", fmt.Errorf("invalid label %q", in)
+		}
+		name := strings.TrimSpace(in[:eq])
+		in = strings.TrimLeft(in[eq+1:], " \t")
+		if in == "" || in[0] != '"' {
+			return "", fmt.Errorf("label %v value is not quoted", name)
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(in) && in[i] != '"'; i++ {
+			if in[i] == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(in[i])
+				}
+				continue
+			}
+			value.WriteByte(in[i])
+		}
+		if i >= len(in) {
+			return "", fmt.Errorf("unterminated value of label %v", name)
+		}
+		labels[name] = value.String()
+		in = in[i+1:]
+	}
+}
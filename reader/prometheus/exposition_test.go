@@ -0,0 +1,79 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, content string, openMetrics bool, now time.Time) []*sample {
+	var samples []*sample
+	require.NoError(t, parseExposition(strings.NewReader(content), openMetrics, now, func(s *sample) {
+		samples = append(samples, s)
+	}))
+	return samples
+}
+
+func TestParseText(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	content := `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.1"} 33444
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.99"} 76656
+rpc_duration_seconds_count 2693
+`
+	samples := parse(t, content, false, now)
+	require.Len(t, samples, 9)
+
+	assert.Equal(t, "http_requests_total", samples[0].name)
+	assert.Equal(t, "counter", samples[0].typ)
+	assert.Equal(t, map[string]string{"method": "post", "code": "200"}, samples[0].labels)
+	assert.Equal(t, float64(1027), samples[0].value)
+	assert.Equal(t, time.Unix(1395066363, 0), samples[0].timestamp)
+	assert.Equal(t, float64(3), samples[1].value)
+
+	assert.Equal(t, map[string]string{"path": `C:\DIR\FILE.TXT`, "error": "Cannot find file:\n\"FILE.TXT\""}, samples[2].labels)
+	assert.Equal(t, "untyped", samples[2].typ)
+	assert.Equal(t, now, samples[2].timestamp)
+
+	assert.Equal(t, "http_request_duration_seconds", samples[4].family)
+	assert.Equal(t, "histogram", samples[4].typ)
+	assert.Equal(t, map[string]string{"le": "+Inf"}, samples[4].labels)
+	assert.Equal(t, "histogram", samples[6].typ)
+	assert.Equal(t, map[string]string{"quantile": "0.99"}, samples[7].labels)
+	assert.Equal(t, "summary", samples[8].typ)
+
+	err := parseExposition(strings.NewReader(`metric{a="b} 1`), false, now, func(*sample) {})
+	assert.Error(t, err)
+	err = parseExposition(strings.NewReader(`metric abc`), false, now, func(*sample) {})
+	assert.Error(t, err)
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	content := `# TYPE foo counter
+# UNIT foo seconds
+foo_total{a="1"} 17.0 1520879607.789 # {trace_id="KOO5S4vxi0o"} 0.67
+foo_created{a="1"} 1520430000.123
+# EOF
+ignored 1
+`
+	samples := parse(t, content, true, time.Now())
+	require.Len(t, samples, 2)
+	assert.Equal(t, "foo", samples[0].family)
+	assert.Equal(t, "counter", samples[0].typ)
+	assert.Equal(t, 17.0, samples[0].value)
+	assert.Equal(t, int64(1520879607789), samples[0].timestamp.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, "foo_created", samples[1].name)
+}
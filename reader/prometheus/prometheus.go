@@ -0,0 +1,395 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// acceptHeader 优先请求 OpenMetrics 格式，与 Prometheus 的抓取请求一致
+const acceptHeader = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
+func init() {
+	reader.RegisterConstructor(reader.ModePrometheus, NewReader)
+}
+
+// target 为一个抓取目标，labels 会添加到该目标的所有样本中
+type target struct {
+	url    string
+	labels map[string]string
+}
+
+// line 为一个样本对应的 json 及其来源
+type line struct {
+	data   string
+	source string
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan line
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	targets       []string
+	fileSD        []string
+	scheme        string
+	metricsPath   string
+	job           string
+	interval      time.Duration
+	concurrency   int
+	flattenLabels bool
+	client        *http.Client
+
+	source string
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	targets, _ := conf.GetStringListOr(reader.KeyPrometheusTargets, nil)
+	fileSD, _ := conf.GetStringListOr(reader.KeyPrometheusFileSD, nil)
+	if len(targets) == 0 && len(fileSD) == 0 {
+		return nil, fmt.Errorf("one of %s and %s is required", reader.KeyPrometheusTargets, reader.KeyPrometheusFileSD)
+	}
+	for _, t := range targets {
+		if _, err := url.ParseRequestURI(t); err != nil {
+			return nil, fmt.Errorf("invalid target %q: %v", t, err)
+		}
+	}
+	scheme, _ := conf.GetStringOr(reader.KeyPrometheusScheme, "http")
+	metricsPath, _ := conf.GetStringOr(reader.KeyPrometheusMetricsPath, "/metrics")
+	job, _ := conf.GetStringOr(reader.KeyPrometheusJob, "logkit")
+	intervalStr, _ := conf.GetStringOr(reader.KeyPrometheusInterval, "30s")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid %s %q", reader.KeyPrometheusInterval, intervalStr)
+	}
+	timeoutStr, _ := conf.GetStringOr(reader.KeyPrometheusTimeout, "10s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyPrometheusTimeout, timeoutStr, err)
+	}
+	concurrency, _ := conf.GetIntOr(reader.KeyPrometheusConcurrency, 16)
+	if concurrency <= 0 {
+		concurrency = 16
+	}
+	flattenLabels, _ := conf.GetBoolOr(reader.KeyPrometheusFlattenLabels, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reader{
+		meta:          meta,
+		status:        reader.StatusInit,
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan line),
+		ctx:           ctx,
+		cancel:        cancel,
+		targets:       targets,
+		fileSD:        fileSD,
+		scheme:        scheme,
+		metricsPath:   metricsPath,
+		job:           job,
+		interval:      interval,
+		concurrency:   concurrency,
+		flattenLabels: flattenLabels,
+		client:        &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "PrometheusReader<" + r.job + ">"
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("prometheus reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.scrapeAll()
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadTargets 返回静态配置与 file_sd 文件中的所有目标
+func (r *Reader) loadTargets() []target {
+	var targets []target
+	for _, t := range r.targets {
+		u, _ := url.Parse(t)
+		targets = append(targets, target{url: t, labels: map[string]string{"job": r.job, "instance": u.Host}})
+	}
+	for _, pattern := range r.fileSD {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			r.onError(fmt.Errorf("invalid %s %q: %v", reader.KeyPrometheusFileSD, pattern, err))
+			continue
+		}
+		for _, file := range files {
+			fileTargets, err := r.loadFileSD(file)
+			if err != nil {
+				r.onError(err)
+				continue
+			}
+			targets = append(targets, fileTargets...)
+		}
+	}
+	return targets
+}
+
+// loadFileSD 读取 Prometheus file_sd 格式的文件，以 __ 开头的标签只用于生成抓取地址
+func (r *Reader) loadFileSD(file string) ([]target, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+	if err = jsoniter.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("parse file_sd %v failed: %v", file, err)
+	}
+	var targets []target
+	for _, group := range groups {
+		scheme, metricsPath := r.scheme, r.metricsPath
+		if v := group.Labels["__scheme__"]; v != "" {
+			scheme = v
+		}
+		if v := group.Labels["__metrics_path__"]; v != "" {
+			metricsPath = v
+		}
+		for _, host := range group.Targets {
+			labels := map[string]string{"job": r.job, "instance": host}
+			for k, v := range group.Labels {
+				if !strings.HasPrefix(k, "__") {
+					labels[k] = v
+				}
+			}
+			targets = append(targets, target{url: scheme + "://" + host + metricsPath, labels: labels})
+		}
+	}
+	return targets, nil
+}
+
+// onError 记录抓取失败，单个目标的失败不影响其他目标，因此不返回给 ReadLine
+func (r *Reader) onError(err error) {
+	log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+	r.setStatsError(err.Error())
+}
+
+// scrapeAll 并发抓取所有目标，每个目标的样本按顺序输出
+func (r *Reader) scrapeAll() {
+	targets := r.loadTargets()
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		select {
+		case sem <- struct{}{}:
+		case <-r.stopChan:
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(t target) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			lines, err := r.scrape(t)
+			if err != nil {
+				r.onError(fmt.Errorf("scrape %v failed: %v", t.url, err))
+			}
+			for _, data := range lines {
+				select {
+				case r.readChan <- line{data: data, source: t.url}:
+				case <-r.stopChan:
+					return
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// scrape 抓取一个目标，返回每个样本对应的 json，以及表示目标是否可用的 up 样本
+func (r *Reader) scrape(t target) ([]string, error) {
+	now := time.Now()
+	var lines []string
+	err := r.fetch(t, now, func(s *sample) {
+		if data, ok := r.marshal(s, t.labels); ok {
+			lines = append(lines, data)
+		}
+	})
+	up := &sample{name: "up", family: "up", typ: "gauge", labels: map[string]string{}, value: 1, timestamp: now}
+	if err != nil {
+		up.value = 0
+		lines = nil
+	}
+	data, _ := r.marshal(up, t.labels)
+	return append(lines, data), err
+}
+
+func (r *Reader) fetch(t target, now time.Time, fn func(*sample)) error {
+	req, err := http.NewRequest(http.MethodGet, t.url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(r.ctx)
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%g", r.client.Timeout.Seconds()))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	openMetrics := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text")
+	return parseExposition(resp.Body, openMetrics, now, fn)
+}
+
+// marshal 将样本转换为 json，目标的标签与样本的标签冲突时，样本的标签改名为 exported_标签名，
+// NaN 与 Inf 无法表示为 json 数字，这样的样本会被忽略
+func (r *Reader) marshal(s *sample, targetLabels map[string]string) (string, bool) {
+	if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+		return "", false
+	}
+	labels := make(map[string]string, len(s.labels)+len(targetLabels))
+	for k, v := range s.labels {
+		if _, ok := targetLabels[k]; ok {
+			k = "exported_" + k
+		}
+		labels[k] = v
+	}
+	for k, v := range targetLabels {
+		labels[k] = v
+	}
+	data := map[string]interface{}{
+		"name":      s.name,
+		"family":    s.family,
+		"type":      s.typ,
+		"value":     s.value,
+		"timestamp": s.timestamp.Format(time.RFC3339Nano),
+	}
+	if r.flattenLabels {
+		for k, v := range labels {
+			data["label_"+k] = v
+		}
+	} else {
+		data["labels"] = labels
+	}
+	content, err := jsoniter.MarshalToString(data)
+	if err != nil {
+		return "", false
+	}
+	return content, true
+}
+
+func (r *Reader) Source() string {
+	return r.source
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case l := <-r.readChan:
+		r.source = l.source
+		return l.data, nil
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 抓取的数据不需要记录读取位置
+func (_ *Reader) SyncMeta() {}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusStopped) {
+		r.cancel()
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.cancel()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, reader.StatusStopped)
+	log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	return nil
+}
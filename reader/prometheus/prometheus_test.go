@@ -0,0 +1,106 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+func readAll(t *testing.T, r reader.Reader, n int) []map[string]interface{} {
+	var datas []map[string]interface{}
+	for i := 0; i < n+3 && len(datas) < n; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line == "" {
+			continue
+		}
+		data := make(map[string]interface{})
+		require.NoError(t, jsoniter.Unmarshal([]byte(line), &data))
+		datas = append(datas, data)
+	}
+	require.Len(t, datas, n)
+	return datas
+}
+
+func TestPrometheusReader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/metrics":
+			w.Write([]byte("# TYPE requests counter\nrequests{job=\"app\"} 5\n"))
+		case "/custom":
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			w.Write([]byte("# TYPE temp gauge\ntemp 21.5 1700000000\nnan NaN\n# EOF\n"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dir := t.TempDir()
+	sd := `[{"targets": ["` + host + `"], "labels": {"__metrics_path__": "/custom", "env": "prod"}}]`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "targets.json"), []byte(sd), 0644))
+
+	c := conf.MapConf{
+		reader.KeyPrometheusTargets:     server.URL + "/metrics",
+		reader.KeyPrometheusInterval:    "1h",
+		reader.KeyPrometheusConcurrency: "1",
+		reader.KeyMetaPath:              t.TempDir(),
+		reader.KeyMode:                  reader.ModePrometheus,
+		models.KeyRunnerName:            "TestPrometheusReader",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(reader.DaemonReader).Start())
+	datas := readAll(t, r, 2)
+	assert.Equal(t, server.URL+"/metrics", r.Source())
+	assert.NoError(t, r.Close())
+
+	assert.Equal(t, "requests", datas[0]["name"])
+	assert.Equal(t, "counter", datas[0]["type"])
+	assert.Equal(t, float64(5), datas[0]["value"])
+	assert.Equal(t, map[string]interface{}{"job": "logkit", "exported_job": "app", "instance": host}, datas[0]["labels"])
+	assert.Equal(t, "up", datas[1]["name"])
+	assert.Equal(t, float64(1), datas[1]["value"])
+
+	// file_sd 目标，并将标签展开为顶层字段
+	c[reader.KeyPrometheusTargets] = server.URL + "/missing"
+	c[reader.KeyPrometheusFileSD] = filepath.Join(dir, "*.json")
+	c[reader.KeyPrometheusFlattenLabels] = "true"
+	r, err = NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(reader.DaemonReader).Start())
+	defer r.Close()
+	datas = readAll(t, r, 3)
+
+	assert.Equal(t, "up", datas[0]["name"])
+	assert.Equal(t, float64(0), datas[0]["value"])
+	assert.Contains(t, r.(reader.StatsReader).Status().LastError, "404")
+
+	assert.Equal(t, "temp", datas[1]["name"])
+	assert.Equal(t, "gauge", datas[1]["type"])
+	assert.Equal(t, 21.5, datas[1]["value"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", datas[1]["timestamp"])
+	assert.Equal(t, "prod", datas[1]["label_env"])
+	assert.Equal(t, host, datas[1]["label_instance"])
+	assert.Nil(t, datas[1]["labels"])
+	// NaN 样本被忽略
+	assert.Equal(t, "up", datas[2]["name"])
+	assert.Equal(t, float64(1), datas[2]["value"])
+
+	_, err = NewReader(meta, conf.MapConf{})
+	assert.Error(t, err)
+}
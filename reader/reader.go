@@ -45,6 +45,17 @@ type StatsReader interface {
 	Status() StatsInfo
 }
 
+// ExtraStatsReader 代表了一个可以提供额外统计信息的读取器，统计信息会展示在 readerStats 的 extra 中
+type ExtraStatsReader interface {
+	ExtraStats() map[string]interface{}
+}
+
+// LineTagsReader 代表了一个可以为每一行提供附加字段的读取器，runner 在解析之后将其添加到该行对应的数据中
+type LineTagsReader interface {
+	// LineTags 返回最近一次 ReadLine 读取的行对应的字段
+	LineTags() map[string]interface{}
+}
+
 // 获取数据lag的接口
 type LagReader interface {
 	Lag() (*LagInfo, error)
@@ -85,6 +96,22 @@ const (
 	KeySubmetaExpire = "submeta_expire"
 	KeyMaxOpenFiles  = "max_open_files"
 	KeyStatInterval  = "stat_interval"
+	KeyDiscoveryMode = "discovery_mode"
+	KeyMaxWatches    = "max_watches"
+
+	KeyLogPathIncludes = "log_path_includes"
+	KeyLogPathExcludes = "log_path_excludes"
+	KeyMaxDepth        = "max_depth"
+	KeySymlinkPolicy   = "symlink_policy"
+
+	KeyMultilinePreset       = "multiline_preset"
+	KeyMultilineStart        = "multiline_start"
+	KeyMultilineEnd          = "multiline_end"
+	KeyMultilineContinuation = "multiline_continuation"
+	KeyMultilineNegate       = "multiline_negate"
+	KeyMultilineMaxLines     = "multiline_max_lines"
+	KeyMultilineMaxBytes     = "multiline_max_bytes"
+	KeyMultilineFlushTimeout = "multiline_flush_timeout"
 
 	KeyMysqlOffsetKey   = "mysql_offset_key"
 	KeyMysqlReadBatch   = "mysql_limit_batch"
@@ -124,6 +151,12 @@ const (
 	KeyESKeepAlive = "es_keepalive"
 	KeyESVersion   = "es_version"
 
+	KeyESQuery       = "es_query"
+	KeyESSortFields  = "es_sort_fields"
+	KeyESTiebreaker  = "es_tiebreaker_field"
+	KeyESCron        = "es_cron"
+	KeyESExecOnStart = "es_exec_onstart"
+
 	KeyMongoHost        = "mongo_host"
 	KeyMongoDatabase    = "mongo_database"
 	KeyMongoCollection  = "mongo_collection"
@@ -143,6 +176,13 @@ const (
 	KeyExecInterpreter   = "script_exec_interprepter"
 	KeyScriptCron        = "script_cron"
 	KeyScriptExecOnStart = "script_exec_onstart"
+	// 流式模式下脚本只启动一次，按行读取其输出，进程退出后按退避时间重新启动
+	KeyScriptStreaming         = "script_streaming"
+	KeyScriptReadStderr        = "script_read_stderr"
+	KeyScriptEnv               = "script_env"
+	KeyScriptWorkDir           = "script_work_dir"
+	KeyScriptRestartBackoff    = "script_restart_backoff"
+	KeyScriptRestartMaxBackoff = "script_restart_max_backoff"
 
 	KeyErrDirectReturn = "errDirectReturn"
 )
@@ -171,11 +211,20 @@ const (
 	ModeSnmp       = "snmp"
 	ModeCloudWatch = "cloudwatch"
 	ModeCloudTrail = "cloudtrail"
+	ModeS3         = "s3"
+	ModeMQTT       = "mqtt"
+	ModeNATS       = "nats"
+	ModeAMQP       = "amqp"
+	ModePrometheus = "prometheus"
+	ModeK8sEvents  = "k8s_events"
+	ModeNetFlow    = "netflow"
 )
 
 const (
 	ReadModeHeadPatternString = "mode_head_pattern_string"
 	ReadModeHeadPatternRegexp = "mode_head_pattern_regexp"
+	// ReadModeMultiline 对应的值为 *MultilineConfig
+	ReadModeMultiline = "mode_multiline"
 )
 
 // KeyWhence 的可选项
@@ -275,6 +324,22 @@ func (reg *Registry) NewReaderWithMeta(conf conf.MapConf, meta *Meta, errDirectR
 			return nil, err
 		}
 	}
+	multiline, err := NewMultilineConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	if multiline != nil {
+		if headPattern != "" {
+			return nil, fmt.Errorf("%s can not be used together with multiline options", KeyHeadPattern)
+		}
+		// 读取器自身不支持多行模式时，在其外层进行组装
+		if err = reader.SetMode(ReadModeMultiline, multiline); err != nil {
+			if _, ok := reader.(DataReader); ok {
+				return nil, fmt.Errorf("reader type %v does not support multiline: %v", mode, err)
+			}
+			reader = NewMultilineReader(reader, meta, multiline)
+		}
+	}
 
 	return reader, nil
 }
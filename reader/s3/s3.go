@@ -0,0 +1,610 @@
+package s3
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	jsoniter "github.com/json-iterator/go"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+	_ Resetable           = &Reader{}
+)
+
+const (
+	// sqsWaitTimeSeconds 为 SQS 长轮询的最大等待时间
+	sqsWaitTimeSeconds = 20
+	sqsMaxMessages     = 10
+	// recordsFileName 为记录已处理对象的文件名
+	recordsFileName = reader.DoneFileName + ".objects"
+)
+
+func init() {
+	reader.RegisterConstructor(reader.ModeS3, NewReader)
+}
+
+type objectLine struct {
+	text   string
+	source string
+	// key、eTag 与 lines 为该行所在的对象及其在对象中的行号，由 ReadLine 记录为当前对象的读取进度
+	key   string
+	eTag  string
+	lines int64
+}
+
+// objectRecords 记录已经处理完成的对象及其 ETag，以及正在处理的对象的进度
+type objectRecords struct {
+	Done    map[string]string `json:"done"`
+	Current objectProgress    `json:"current"`
+}
+
+// objectProgress 记录正在处理的对象已经读取的行数
+type objectProgress struct {
+	Key   string `json:"key"`
+	ETag  string `json:"etag"`
+	Lines int64  `json:"lines"`
+}
+
+func recordsFile(meta *reader.Meta) string {
+	return filepath.Join(meta.DoneFilePath, recordsFileName)
+}
+
+// readRecords 读取已处理对象的记录，文件不存在时返回空记录
+func readRecords(meta *reader.Meta) (records objectRecords, err error) {
+	err = reader.ReadJSONRecords(recordsFile(meta), &records)
+	if records.Done == nil {
+		records.Done = make(map[string]string)
+	}
+	return
+}
+
+// s3Object 为待读取的对象，eTag 为空时以读取对象时返回的 ETag 为准
+type s3Object struct {
+	key  string
+	eTag string
+}
+
+// sqsReceipt 为等待删除的 SQS 消息及其中的对象，消息删除之后不会再重复投递，对象的记录随之清理
+type sqsReceipt struct {
+	handle *string
+	keys   []string
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan objectLine
+	errChan  chan error
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	client    s3iface.S3API
+	sqsClient sqsiface.SQSAPI
+	bucket    string
+	prefix    string
+	suffixes  []string
+	discovery string
+	queueURL  string
+	interval  time.Duration
+
+	// records 记录已处理完成的对象及正在处理的对象的进度，随 SyncMeta 持久化
+	recordsLock sync.Mutex
+	records     objectRecords
+	// receipts 为对象已全部读取、等待 SyncMeta 之后删除的 SQS 消息
+	receipts []sqsReceipt
+
+	source atomic.Value
+}
+
+func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	bucket, err := conf.GetString(reader.KeyS3Bucket)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := conf.GetStringOr(reader.KeyS3Prefix, "")
+	suffixes, _ := conf.GetStringListOr(reader.KeyS3Suffixes, nil)
+	endpoint, _ := conf.GetStringOr(reader.KeyS3Endpoint, "")
+	region, _ := conf.GetStringOr(reader.KeyS3Region, "us-east-1")
+	ak, _ := conf.GetStringOr(reader.KeyS3AccessKey, "")
+	sk, _ := conf.GetStringOr(reader.KeyS3SecretKey, "")
+	pathStyle, _ := conf.GetBoolOr(reader.KeyS3PathStyle, true)
+	discovery, _ := conf.GetStringOr(reader.KeyS3Discovery, reader.S3DiscoveryList)
+	queueURL, _ := conf.GetStringOr(reader.KeyS3SQSQueueURL, "")
+	intervalStr, _ := conf.GetStringOr(reader.KeyS3ListInterval, "1m")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", reader.KeyS3ListInterval, intervalStr, err)
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	cfg := aws.NewConfig().WithRegion(region).WithS3ForcePathStyle(pathStyle)
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint)
+	}
+	if ak != "" || sk != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(ak, sk, ""))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create s3 session failed: %v", err)
+	}
+
+	records, err := readRecords(meta)
+	if err != nil {
+		log.Errorf("Runner[%v] %v object records is corrupted err: %v, omit it", meta.RunnerName, recordsFile(meta), err)
+		records = objectRecords{Done: make(map[string]string)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Reader{
+		meta:      meta,
+		status:    reader.StatusInit,
+		stopChan:  make(chan struct{}),
+		readChan:  make(chan objectLine),
+		errChan:   make(chan error),
+		ctx:       ctx,
+		cancel:    cancel,
+		client:    awss3.New(sess),
+		bucket:    bucket,
+		prefix:    prefix,
+		suffixes:  suffixes,
+		discovery: discovery,
+		queueURL:  queueURL,
+		interval:  interval,
+		records:   records,
+	}
+	switch discovery {
+	case reader.S3DiscoveryList:
+	case reader.S3DiscoverySQS:
+		if queueURL == "" {
+			cancel()
+			return nil, fmt.Errorf("%s is required when %s is %s", reader.KeyS3SQSQueueURL, reader.KeyS3Discovery, reader.S3DiscoverySQS)
+		}
+		u, err := url.Parse(queueURL)
+		if err != nil || u.Host == "" {
+			cancel()
+			return nil, fmt.Errorf("%s %q is invalid", reader.KeyS3SQSQueueURL, queueURL)
+		}
+		// 以队列地址作为 SQS 服务地址，以便支持兼容 SQS 协议的其他服务
+		r.sqsClient = sqs.New(sess, aws.NewConfig().WithEndpoint(u.Scheme+"://"+u.Host))
+	default:
+		cancel()
+		return nil, fmt.Errorf("%s %q is not supported", reader.KeyS3Discovery, discovery)
+	}
+	r.source.Store(r.bucketSource())
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == reader.StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "S3Reader<" + r.bucketSource() + ">"
+}
+
+func (r *Reader) bucketSource() string {
+	return "s3://" + r.bucket + "/" + r.prefix
+}
+
+func (_ *Reader) SetMode(_ string, _ interface{}) error {
+	return errors.New("s3 reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, reader.StatusInit, reader.StatusRunning) {
+		log.Warningf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer r.wg.Done()
+	for {
+		var err error
+		wait := r.interval
+		if r.discovery == reader.S3DiscoverySQS {
+			// 长轮询本身会等待新的消息，仅在出错时等待
+			err = r.pollQueue()
+			wait = 0
+		} else {
+			err = r.listObjects()
+		}
+		if err != nil && r.ctx.Err() == nil {
+			log.Errorf("Runner[%v] %q discover objects failed: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			wait = r.interval
+		}
+
+		select {
+		case <-r.stopChan:
+			atomic.StoreInt32(&r.status, reader.StatusStopped)
+			log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// matchKey 判断对象是否满足前缀与后缀的过滤条件
+func (r *Reader) matchKey(key string) bool {
+	if !strings.HasPrefix(key, r.prefix) || strings.HasSuffix(key, "/") {
+		return false
+	}
+	if len(r.suffixes) == 0 {
+		return true
+	}
+	for _, suffix := range r.suffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reader) isDone(key, eTag string) bool {
+	r.recordsLock.Lock()
+	defer r.recordsLock.Unlock()
+	done, ok := r.records.Done[key]
+	return ok && (eTag == "" || done == eTag)
+}
+
+// listObjects 列举前缀下的所有对象，按字典序读取未处理或者 ETag 发生变化的对象
+func (r *Reader) listObjects() error {
+	var objects []s3Object
+	input := &awss3.ListObjectsV2Input{
+		Bucket: aws.String(r.bucket),
+	}
+	if r.prefix != "" {
+		input.Prefix = aws.String(r.prefix)
+	}
+	err := r.client.ListObjectsV2PagesWithContext(r.ctx, input, func(page *awss3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if r.matchKey(key) {
+				objects = append(objects, s3Object{key: key, eTag: normalizeETag(aws.StringValue(obj.ETag))})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("list objects of %s failed: %v", r.bucketSource(), err)
+	}
+	// 优先读取上次未读完的对象，以免读取其他对象时覆盖其进度
+	r.recordsLock.Lock()
+	current := r.records.Current.Key
+	r.recordsLock.Unlock()
+	for i, obj := range objects {
+		if i > 0 && obj.key == current {
+			copy(objects[1:i+1], objects[:i])
+			objects[0] = obj
+			break
+		}
+	}
+
+	existing := make(map[string]struct{}, len(objects))
+	for _, obj := range objects {
+		existing[obj.key] = struct{}{}
+		if r.isDone(obj.key, obj.eTag) {
+			continue
+		}
+		if err = r.readObject(obj); err != nil {
+			return err
+		}
+		if r.ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	// 清理已经不存在的对象的记录，避免记录无限增长
+	r.recordsLock.Lock()
+	for key := range r.records.Done {
+		if _, ok := existing[key]; !ok {
+			delete(r.records.Done, key)
+		}
+	}
+	r.recordsLock.Unlock()
+	return nil
+}
+
+// s3Event 为 S3 事件通知的消息格式，经由 SNS 转发时原始消息位于 Message 字段
+type s3Event struct {
+	Message string `json:"Message"`
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// parseS3Event 解析事件通知中新建的对象，测试事件等其他消息返回空
+func parseS3Event(body string) (bucket string, objects []s3Object, err error) {
+	var event s3Event
+	if err = jsoniter.Unmarshal([]byte(body), &event); err != nil {
+		return "", nil, err
+	}
+	if len(event.Records) == 0 && event.Message != "" {
+		return parseS3Event(event.Message)
+	}
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		// 事件中的对象名经过 URL 编码
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return "", nil, err
+		}
+		bucket = record.S3.Bucket.Name
+		objects = append(objects, s3Object{key: key, eTag: normalizeETag(record.S3.Object.ETag)})
+	}
+	return bucket, objects, nil
+}
+
+// pollQueue 接收一批事件通知并读取其中的对象，消息在对象读取完成且 SyncMeta 之后删除
+func (r *Reader) pollQueue() error {
+	out, err := r.sqsClient.ReceiveMessageWithContext(r.ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(r.queueURL),
+		MaxNumberOfMessages: aws.Int64(sqsMaxMessages),
+		WaitTimeSeconds:     aws.Int64(sqsWaitTimeSeconds),
+	})
+	if err != nil {
+		return fmt.Errorf("receive message from %s failed: %v", r.queueURL, err)
+	}
+	for _, msg := range out.Messages {
+		bucket, objects, err := parseS3Event(aws.StringValue(msg.Body))
+		if err != nil {
+			// 无法解析的消息保留在队列中，由队列的重试策略处理
+			log.Warningf("Runner[%v] %q ignore malformed message %v: %v", r.meta.RunnerName, r.Name(), aws.StringValue(msg.MessageId), err)
+			continue
+		}
+		receipt := sqsReceipt{handle: msg.ReceiptHandle}
+		for _, obj := range objects {
+			if bucket != r.bucket || !r.matchKey(obj.key) {
+				continue
+			}
+			receipt.keys = append(receipt.keys, obj.key)
+			if r.isDone(obj.key, obj.eTag) {
+				continue
+			}
+			if err = r.readObject(obj); err != nil {
+				return err
+			}
+			if r.ctx.Err() != nil {
+				return nil
+			}
+		}
+		r.recordsLock.Lock()
+		r.receipts = append(r.receipts, receipt)
+		r.recordsLock.Unlock()
+	}
+	return nil
+}
+
+// readObject 按行读取对象内容，上次未读完的同一对象从记录的行数之后继续读取
+func (r *Reader) readObject(obj s3Object) error {
+	out, err := r.client.GetObjectWithContext(r.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(obj.key),
+	})
+	if err != nil {
+		return fmt.Errorf("get object %q failed: %v", obj.key, err)
+	}
+	defer out.Body.Close()
+	if eTag := normalizeETag(aws.StringValue(out.ETag)); eTag != "" {
+		obj.eTag = eTag
+	}
+
+	rd, err := decompress(bufio.NewReader(out.Body))
+	if err != nil {
+		return fmt.Errorf("decompress object %q failed: %v", obj.key, err)
+	}
+	var skip int64
+	r.recordsLock.Lock()
+	if r.records.Current.Key == obj.key && r.records.Current.ETag == obj.eTag {
+		skip = r.records.Current.Lines
+	}
+	r.records.Current = objectProgress{Key: obj.key, ETag: obj.eTag, Lines: skip}
+	r.recordsLock.Unlock()
+
+	source := "s3://" + r.bucket + "/" + obj.key
+	br := bufio.NewReader(rd)
+	var lines int64
+	for {
+		text, err := br.ReadString('\n')
+		if len(text) > 0 {
+			lines++
+			text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+			if lines > skip && text != "" {
+				select {
+				case r.readChan <- objectLine{text: text, source: source, key: obj.key, eTag: obj.eTag, lines: lines}:
+				case <-r.stopChan:
+					return nil
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read object %q failed: %v", obj.key, err)
+		}
+	}
+
+	r.recordsLock.Lock()
+	r.records.Done[obj.key] = obj.eTag
+	r.records.Current = objectProgress{}
+	r.recordsLock.Unlock()
+	log.Infof("Runner[%v] %q finished reading object %q with %d lines", r.meta.RunnerName, r.Name(), obj.key, lines)
+	return nil
+}
+
+// decompress 根据内容的魔数判断是否为 gzip 压缩
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+func normalizeETag(eTag string) string {
+	return strings.Trim(eTag, `"`)
+}
+
+func (r *Reader) Source() string {
+	return r.source.Load().(string)
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case line := <-r.readChan:
+		r.source.Store(line.source)
+		r.recordsLock.Lock()
+		// readObject 可能已经读完该行所在的对象并开始读取下一个对象，此时不能更新下一个对象的进度
+		if r.records.Current.Key == line.key && r.records.Current.ETag == line.eTag {
+			r.records.Current.Lines = line.lines
+		}
+		r.recordsLock.Unlock()
+		return line.text, nil
+	case err := <-r.errChan:
+		return "", err
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 持久化已处理对象的记录，并删除对象已经全部读取的 SQS 消息
+func (r *Reader) SyncMeta() {
+	r.recordsLock.Lock()
+	err := reader.WriteJSONRecords(recordsFile(r.meta), &r.records)
+	receipts := r.receipts
+	if err == nil {
+		r.receipts = nil
+	}
+	r.recordsLock.Unlock()
+	if err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+
+	for _, receipt := range receipts {
+		_, err = r.sqsClient.DeleteMessage(&sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(r.queueURL),
+			ReceiptHandle: receipt.handle,
+		})
+		if err != nil {
+			// 删除失败的消息会在可见性超时后重新投递，其中的对象已有记录不会重复读取
+			log.Warningf("Runner[%v] %v delete message failed: %v", r.meta.RunnerName, r.Name(), err)
+			continue
+		}
+		// SQS 模式下由消息本身去重，消息删除之后清理其中对象的记录，避免记录无限增长，下次 SyncMeta 时持久化
+		r.recordsLock.Lock()
+		for _, key := range receipt.keys {
+			delete(r.records.Done, key)
+		}
+		r.recordsLock.Unlock()
+	}
+}
+
+func (r *Reader) Reset() error {
+	if err := os.Remove(recordsFile(r.meta)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, reader.StatusRunning, reader.StatusStopping) {
+		log.Warningf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
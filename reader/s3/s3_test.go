@@ -0,0 +1,293 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	"github.com/longxiucai/logkit/utils/models"
+)
+
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+}
+
+func eTagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if path == f.bucket || path == f.bucket+"/" {
+		prefix := req.URL.Query().Get("prefix")
+		var keys []string
+		for key := range f.objects {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		buf := bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>` + f.bucket + `</Name><IsTruncated>false</IsTruncated>`)
+		for _, key := range keys {
+			fmt.Fprintf(buf, "<Contents><Key>%s</Key><ETag>%s</ETag><Size>%d</Size></Contents>", key, eTagOf(f.objects[key]), len(f.objects[key]))
+		}
+		buf.WriteString("</ListBucketResult>")
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(buf.Bytes())
+		return
+	}
+	data, ok := f.objects[strings.TrimPrefix(path, f.bucket+"/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+		return
+	}
+	w.Header().Set("ETag", eTagOf(data))
+	w.Write(data)
+}
+
+func gzipData(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func readLines(t *testing.T, r reader.Reader, n int) (lines, sources []string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for len(lines) < n && time.Now().Before(deadline) {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		sources = append(sources, r.Source())
+	}
+	return lines, sources
+}
+
+func TestS3Reader(t *testing.T) {
+	metaDir := "TestS3Reader"
+	os.RemoveAll(metaDir)
+	defer os.RemoveAll(metaDir)
+
+	fake := &fakeS3{bucket: "logs", objects: map[string][]byte{
+		"app/a.log":    []byte("a1\r\na2\n"),
+		"app/b.log.gz": gzipData(t, "b1\nb2\n"),
+		"app/c.txt":    []byte("ignored\n"),
+		"other/d.log":  []byte("ignored\n"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := conf.MapConf{
+		reader.KeyS3Endpoint:     server.URL,
+		reader.KeyS3AccessKey:    "ak",
+		reader.KeyS3SecretKey:    "sk",
+		reader.KeyS3Bucket:       "logs",
+		reader.KeyS3Prefix:       "app/",
+		reader.KeyS3Suffixes:     ".log, .log.gz",
+		reader.KeyS3ListInterval: "100ms",
+		reader.KeyMetaPath:       metaDir,
+		reader.KeyMode:           reader.ModeS3,
+		models.KeyRunnerName:     "TestS3Reader",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.NoError(t, r.(reader.DaemonReader).Start())
+
+	lines, sources := readLines(t, r, 4)
+	assert.Equal(t, []string{"a1", "a2", "b1", "b2"}, lines)
+	assert.Equal(t, "s3://logs/app/a.log", sources[0])
+	assert.Equal(t, "s3://logs/app/b.log.gz", sources[3])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 重启后已读取的对象不再重复读取，内容变化的对象重新读取
+	fake.put("app/a.log", []byte("a3\n"))
+	fake.put("app/e.log", []byte("e1\n"))
+	r, err = NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.NoError(t, r.(reader.DaemonReader).Start())
+	lines, _ = readLines(t, r, 2)
+	assert.Equal(t, []string{"a3", "e1"}, lines)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	assert.NoError(t, r.Close())
+
+	assert.NoError(t, r.(*Reader).Reset())
+	_, err = os.Stat(recordsFile(meta))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseS3Event(t *testing.T) {
+	body := `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"logs"},"object":{"key":"app/my+file%3D1.log","eTag":"abc"}}},
+{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"logs"},"object":{"key":"app/x.log"}}}]}`
+	bucket, objects, err := parseS3Event(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "logs", bucket)
+	assert.Equal(t, []s3Object{{key: "app/my file=1.log", eTag: "abc"}}, objects)
+
+	// 经由 SNS 转发的通知
+	sns := fmt.Sprintf(`{"Type":"Notification","Message":%q}`, body)
+	_, objects, err = parseS3Event(sns)
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	_, objects, err = parseS3Event(`{"Event":"s3:TestEvent"}`)
+	assert.NoError(t, err)
+	assert.Len(t, objects, 0)
+}
+
+type fakeSQS struct {
+	mu       sync.Mutex
+	bodies   []string
+	deleted  []string
+	received int
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	switch req.Header.Get("X-Amz-Target") {
+	case "AmazonSQS.ReceiveMessage":
+		var messages []map[string]string
+		for _, body := range f.bodies {
+			f.received++
+			sum := md5.Sum([]byte(body))
+			messages = append(messages, map[string]string{
+				"MessageId":     fmt.Sprintf("m%d", f.received),
+				"ReceiptHandle": fmt.Sprintf("r%d", f.received),
+				"Body":          body,
+				"MD5OfBody":     hex.EncodeToString(sum[:]),
+			})
+		}
+		f.bodies = nil
+		data, _ := json.Marshal(map[string]interface{}{"Messages": messages})
+		w.Write(data)
+	case "AmazonSQS.DeleteMessage":
+		var input struct{ ReceiptHandle string }
+		json.NewDecoder(req.Body).Decode(&input)
+		f.deleted = append(f.deleted, input.ReceiptHandle)
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestS3ReaderSQS(t *testing.T) {
+	metaDir := "TestS3ReaderSQS"
+	os.RemoveAll(metaDir)
+	defer os.RemoveAll(metaDir)
+
+	fake := &fakeS3{bucket: "logs", objects: map[string][]byte{
+		"app/a b.log": []byte("a1\n"),
+		"app/c.log":   []byte("ignored\n"),
+	}}
+	s3Server := httptest.NewServer(fake)
+	defer s3Server.Close()
+	queue := &fakeSQS{bodies: []string{
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"logs"},"object":{"key":"app/a+b.log"}}}]}`,
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"other"},"object":{"key":"app/c.log"}}}]}`,
+	}}
+	sqsServer := httptest.NewServer(queue)
+	defer sqsServer.Close()
+
+	c := conf.MapConf{
+		reader.KeyS3Endpoint:    s3Server.URL,
+		reader.KeyS3AccessKey:   "ak",
+		reader.KeyS3SecretKey:   "sk",
+		reader.KeyS3Bucket:      "logs",
+		reader.KeyS3Discovery:   reader.S3DiscoverySQS,
+		reader.KeyS3SQSQueueURL: sqsServer.URL + "/123456/logs",
+		reader.KeyMetaPath:      metaDir,
+		reader.KeyMode:          reader.ModeS3,
+		models.KeyRunnerName:    "TestS3ReaderSQS",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.NoError(t, r.(reader.DaemonReader).Start())
+	defer r.Close()
+
+	lines, sources := readLines(t, r, 1)
+	assert.Equal(t, []string{"a1"}, lines)
+	assert.Equal(t, []string{"s3://logs/app/a b.log"}, sources)
+
+	// 消息在对象读取完成并 SyncMeta 之后删除
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.SyncMeta()
+		queue.mu.Lock()
+		deleted := len(queue.deleted)
+		queue.mu.Unlock()
+		if deleted == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	queue.mu.Lock()
+	assert.Equal(t, []string{"r1", "r2"}, queue.deleted)
+	queue.mu.Unlock()
+
+	// 消息删除之后清理对象的记录，记录不会随处理的对象无限增长
+	r.SyncMeta()
+	records, err := readRecords(meta)
+	assert.NoError(t, err)
+	assert.Empty(t, records.Done)
+}
+
+func TestS3ReaderStaleLine(t *testing.T) {
+	r := &Reader{
+		readChan: make(chan objectLine, 1),
+		errChan:  make(chan error),
+	}
+	r.records.Current = objectProgress{Key: "b.log", ETag: "etag-b"}
+
+	// 上一个对象的行在 readObject 开始读取下一个对象之后才被读取，不能记为下一个对象的进度
+	r.readChan <- objectLine{text: "a3", source: "s3://bucket/a.log", key: "a.log", eTag: "etag-a", lines: 3}
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "a3", line)
+	assert.Equal(t, int64(0), r.records.Current.Lines)
+
+	r.readChan <- objectLine{text: "b1", source: "s3://bucket/b.log", key: "b.log", eTag: "etag-b", lines: 1}
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "b1", line)
+	assert.Equal(t, int64(1), r.records.Current.Lines)
+}
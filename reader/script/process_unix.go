@@ -0,0 +1,21 @@
+//go:build !windows
+
+package script
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 使脚本运行在独立的进程组中，以便结束脚本时一并结束其子进程
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminateProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
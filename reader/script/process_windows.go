@@ -0,0 +1,18 @@
+//go:build windows
+
+package script
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcess Windows 不支持 SIGTERM，直接结束进程
+func terminateProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
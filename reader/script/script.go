@@ -3,6 +3,7 @@ package script
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
@@ -55,6 +56,14 @@ type Reader struct {
 	loopDuration time.Duration
 	execOnStart  bool
 	Cron         *cron.Cron //定时任务
+
+	env     []string
+	workDir string
+
+	streaming      bool
+	readStderr     bool
+	restartBackoff time.Duration
+	maxBackoff     time.Duration
 }
 
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
@@ -70,6 +79,26 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	cronSchedule, _ := conf.GetStringOr(reader.KeyScriptCron, "")
 	execOnStart, _ := conf.GetBoolOr(reader.KeyScriptExecOnStart, true)
 	scriptType, _ := conf.GetStringOr(reader.KeyExecInterpreter, "bash")
+	env, _ := conf.GetStringListOr(reader.KeyScriptEnv, nil)
+	for _, kv := range env {
+		if !strings.Contains(kv, "=") {
+			return nil, fmt.Errorf("%s %q is not in KEY=VALUE format", reader.KeyScriptEnv, kv)
+		}
+	}
+	workDir, _ := conf.GetStringOr(reader.KeyScriptWorkDir, "")
+	streaming, _ := conf.GetBoolOr(reader.KeyScriptStreaming, false)
+	readStderr, _ := conf.GetBoolOr(reader.KeyScriptReadStderr, false)
+	restartBackoff, err := parseDuration(conf, reader.KeyScriptRestartBackoff, "1s")
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := parseDuration(conf, reader.KeyScriptRestartMaxBackoff, "1m")
+	if err != nil {
+		return nil, err
+	}
+	if maxBackoff < restartBackoff {
+		maxBackoff = restartBackoff
+	}
 	r := &Reader{
 		meta:          meta,
 		status:        reader.StatusInit,
@@ -82,10 +111,17 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		scripttype:    scriptType,
 		execOnStart:   execOnStart,
 		Cron:          cron.New(),
+
+		env:            env,
+		workDir:        workDir,
+		streaming:      streaming,
+		readStderr:     readStderr,
+		restartBackoff: restartBackoff,
+		maxBackoff:     maxBackoff,
 	}
 
-	// 定时任务配置串
-	if len(cronSchedule) > 0 {
+	// 定时任务配置串，流式模式下不需要定时执行
+	if len(cronSchedule) > 0 && !streaming {
 		cronSchedule = strings.ToLower(cronSchedule)
 		if strings.HasPrefix(cronSchedule, reader.Loop) {
 			r.isLoop = true
@@ -149,7 +185,9 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
-	if r.isLoop {
+	if r.streaming {
+		go r.stream()
+	} else if r.isLoop {
 		go func() {
 			ticker := time.NewTicker(r.loopDuration)
 			defer ticker.Stop()
@@ -273,8 +311,17 @@ func (r *Reader) run() {
 	log.Errorf("Runner[%v] %q task execution failed and gave up after 10 tries", r.meta.RunnerName, r.Name())
 }
 
+func (r *Reader) command() *exec.Cmd {
+	cmd := exec.Command(r.scripttype, r.realpath)
+	if len(r.env) > 0 {
+		cmd.Env = append(os.Environ(), r.env...)
+	}
+	cmd.Dir = r.workDir
+	return cmd
+}
+
 func (r *Reader) exec() error {
-	res, err := exec.Command(r.scripttype, r.realpath).Output()
+	res, err := r.command().Output()
 	if err != nil {
 		return err
 	}
@@ -282,6 +329,18 @@ func (r *Reader) exec() error {
 	return nil
 }
 
+func parseDuration(conf conf.MapConf, key, deft string) (time.Duration, error) {
+	value, _ := conf.GetStringOr(key, deft)
+	dur, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s %q failed: %v", key, value, err)
+	}
+	if dur <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return dur, nil
+}
+
 func checkPath(meta *reader.Meta, path string) (string, error) {
 	for {
 		realPath, fileInfo, err := GetRealPath(path)
@@ -1,8 +1,12 @@
 package script
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -43,3 +47,97 @@ func Test_scriptFile(t *testing.T) {
 	}
 	assert.Equal(t, "hello world\n", data)
 }
+
+func newStreamingReader(t *testing.T, script string, c conf.MapConf) *Reader {
+	fileName := filepath.Join(os.TempDir(), t.Name()+".sh")
+	CreateFile(fileName, script)
+	t.Cleanup(func() { DeleteFile(fileName) })
+
+	c[reader.KeyExecInterpreter] = "bash"
+	c[reader.KeyLogPath] = fileName
+	c[reader.KeyScriptStreaming] = "true"
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll("./meta") })
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	sr := r.(*Reader)
+	assert.NoError(t, sr.Start())
+	return sr
+}
+
+func TestScriptStreaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestScriptStreaming")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sr := newStreamingReader(t, "echo \"$GREETING from $(pwd)\"\necho oops >&2\nexit 3", conf.MapConf{
+		reader.KeyScriptEnv:            "GREETING=hello",
+		reader.KeyScriptWorkDir:        dir,
+		reader.KeyScriptReadStderr:     "true",
+		reader.KeyScriptRestartBackoff: "100ms",
+	})
+	defer sr.Close()
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	var lines []string
+	var errs []string
+	for i := 0; i < 20 && (len(lines) < 4 || len(errs) < 1); i++ {
+		line, err := sr.ReadLine()
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	// 进程退出后重新启动，再次输出
+	assert.Subset(t, lines, []string{"hello from " + realDir, "oops"})
+	assert.Equal(t, 2, strings.Count(strings.Join(lines, "\n"), "hello from"))
+	assert.Equal(t, "script exited with code 3", errs[0])
+	assert.Equal(t, "script exited with code 3", sr.Status().LastError)
+}
+
+func TestScriptStreamingReadAllBeforeExit(t *testing.T) {
+	sr := newStreamingReader(t, "seq 1 5000\nexit 1", conf.MapConf{
+		reader.KeyScriptRestartBackoff: "1s",
+	})
+	defer sr.Close()
+
+	// 进程退出之前的输出全部读取之后才报告退出错误
+	var lines []string
+	for {
+		line, err := sr.ReadLine()
+		if err != nil {
+			assert.Equal(t, "script exited with code 1", err.Error())
+			break
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Len(t, lines, 5000)
+	assert.Equal(t, "1", lines[0])
+	assert.Equal(t, "5000", lines[len(lines)-1])
+}
+
+func TestScriptStreamingStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestScriptStreamingStop")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "terminated")
+
+	sr := newStreamingReader(t, "trap 'touch "+marker+"; exit 0' TERM\necho started\nwhile true; do sleep 0.1; done", conf.MapConf{})
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "started", line)
+
+	assert.NoError(t, sr.Close())
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !sr.hasStopped() {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.True(t, sr.hasStopped())
+	_, err = os.Stat(marker)
+	assert.NoError(t, err)
+}
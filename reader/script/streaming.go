@@ -0,0 +1,161 @@
+package script
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/reader"
+)
+
+// stopTimeout 为发送 SIGTERM 之后等待进程退出的时间，超时后强制结束进程
+const stopTimeout = 10 * time.Second
+
+// stream 以流式模式运行脚本，进程退出后按退避时间重新启动，直到 reader 关闭
+func (r *Reader) stream() {
+	if !atomic.CompareAndSwapInt32(&r.routineStatus, reader.StatusInit, reader.StatusRunning) {
+		return
+	}
+	defer func() {
+		atomic.StoreInt32(&r.status, reader.StatusStopped)
+		if atomic.CompareAndSwapInt32(&r.routineStatus, reader.StatusRunning, reader.StatusStopping) {
+			close(r.readChan)
+			close(r.errChan)
+		}
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	backoff := r.restartBackoff
+	for {
+		startTime := time.Now()
+		err := r.runProcess()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %q %v, restart after %v", r.meta.RunnerName, r.Name(), err, backoff)
+			r.setStatsError(err.Error())
+			r.sendStreamError(err)
+		} else {
+			log.Warningf("Runner[%v] %q process exited, restart after %v", r.meta.RunnerName, r.Name(), backoff)
+		}
+
+		// 进程持续运行的时间足够长，说明不是连续的启动失败，重新计算退避时间
+		if time.Since(startTime) > r.maxBackoff {
+			backoff = r.restartBackoff
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// runProcess 启动一次脚本并按行读取输出，直到进程退出或者 reader 关闭
+func (r *Reader) runProcess() error {
+	cmd := r.command()
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr io.Reader
+	if r.readStderr {
+		if stderr, err = cmd.StderrPipe(); err != nil {
+			return err
+		}
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("start script failed: %v", err)
+	}
+	log.Infof("Runner[%v] %q process %d started", r.meta.RunnerName, r.Name(), cmd.Process.Pid)
+
+	done := make(chan struct{})
+	go r.terminateOnStop(cmd, done)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go r.readLines(stdout, &wg)
+	if stderr != nil {
+		wg.Add(1)
+		go r.readLines(stderr, &wg)
+	}
+	// 必须读取完所有输出之后才能调用 Wait，Wait 会关闭管道，先调用会丢失进程退出前尚未读取的输出
+	wg.Wait()
+	err = cmd.Wait()
+	close(done)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("script exited with code %d", exitErr.ExitCode())
+	}
+	return err
+}
+
+// terminateOnStop 在 reader 关闭时向脚本的进程组发送 SIGTERM，超时仍未退出则强制结束
+func (r *Reader) terminateOnStop(cmd *exec.Cmd, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-r.stopChan:
+	}
+	if err := terminateProcess(cmd); err != nil {
+		killProcess(cmd)
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		log.Warningf("Runner[%v] %q process %d did not exit after SIGTERM, kill it", r.meta.RunnerName, r.Name(), cmd.Process.Pid)
+		killProcess(cmd)
+	}
+}
+
+func (r *Reader) readLines(rd io.Reader, wg *sync.WaitGroup) {
+	defer wg.Done()
+	br := bufio.NewReader(rd)
+	stopped := false
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && !stopped {
+			line = trimNewline(line)
+			if len(line) > 0 {
+				select {
+				case r.readChan <- line:
+				case <-r.stopChan:
+					// 继续读取并丢弃剩余的输出，避免进程因输出阻塞而无法退出
+					stopped = true
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *Reader) sendStreamError(err error) {
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func trimNewline(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
@@ -0,0 +1,112 @@
+package socket
+
+import (
+	"bytes"
+	"time"
+)
+
+const (
+	// GELF 分块的头部：2 字节的 magic，8 字节的消息 ID，1 字节的序号与 1 字节的分块总数
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+	// tcp 下单条 GELF 消息的最大长度
+	gelfMaxMessageSize = 8 * 1024 * 1024
+
+	defaultGELFChunkTimeout = 5 * time.Second
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+type gelfMessage struct {
+	chunks   [][]byte
+	received int
+	first    time.Time
+}
+
+// gelfAssembler 按照来源地址与消息 ID 重组 GELF 分块消息，仅在 packet socket 的读取协程中使用，无需加锁
+type gelfAssembler struct {
+	timeout   time.Duration
+	messages  map[string]*gelfMessage
+	lastPurge time.Time
+}
+
+func newGELFAssembler(timeout time.Duration) *gelfAssembler {
+	if timeout <= 0 {
+		timeout = defaultGELFChunkTimeout
+	}
+	return &gelfAssembler{
+		timeout:  timeout,
+		messages: make(map[string]*gelfMessage),
+	}
+}
+
+// add 处理收到的一个包，消息完整时返回消息内容，否则返回 nil；未分块的包直接返回
+func (a *gelfAssembler) add(address string, packet []byte, now time.Time) ([]byte, error) {
+	if !bytes.HasPrefix(packet, gelfChunkMagic) {
+		return packet, nil
+	}
+	if len(packet) < gelfChunkHeaderSize {
+		return nil, errGELFChunk("chunk is too short")
+	}
+	seq, count := int(packet[10]), int(packet[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, errGELFChunk("invalid chunk sequence")
+	}
+	key := address + "/" + string(packet[2:10])
+	msg, ok := a.messages[key]
+	if !ok {
+		msg = &gelfMessage{chunks: make([][]byte, count), first: now}
+		a.messages[key] = msg
+	}
+	if len(msg.chunks) != count {
+		delete(a.messages, key)
+		return nil, errGELFChunk("chunk count mismatch")
+	}
+	if msg.chunks[seq] != nil {
+		return nil, nil
+	}
+	// packet 的底层缓冲区会被复用，需要拷贝
+	msg.chunks[seq] = append([]byte(nil), packet[gelfChunkHeaderSize:]...)
+	msg.received++
+	if msg.received < count {
+		return nil, nil
+	}
+	delete(a.messages, key)
+	return bytes.Join(msg.chunks, nil), nil
+}
+
+// purge 丢弃超时仍未收齐的消息，返回丢弃的消息数
+func (a *gelfAssembler) purge(now time.Time) int {
+	if now.Sub(a.lastPurge) < a.timeout/2 {
+		return 0
+	}
+	a.lastPurge = now
+	var dropped int
+	for key, msg := range a.messages {
+		if now.Sub(msg.first) > a.timeout {
+			delete(a.messages, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+type errGELFChunk string
+
+func (e errGELFChunk) Error() string {
+	return "invalid GELF chunk: " + string(e)
+}
+
+// splitGELF 为 tcp 下按 \0 分隔 GELF 消息的 bufio.SplitFunc
+func splitGELF(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,105 @@
+package socket
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/reader/test"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func gelfChunk(id string, seq, count int, data string) []byte {
+	chunk := append([]byte{0x1e, 0x0f}, id...)
+	chunk = append(chunk, byte(seq), byte(count))
+	return append(chunk, data...)
+}
+
+func TestGELFAssembler(t *testing.T) {
+	a := newGELFAssembler(time.Second)
+	now := time.Now()
+
+	msg, err := a.add("a", []byte(`{"plain":1}`), now)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"plain":1}`, string(msg))
+
+	// 乱序与重复的分块
+	for _, c := range [][]byte{gelfChunk("id000001", 2, 3, "c"), gelfChunk("id000001", 0, 3, "a"), gelfChunk("id000001", 0, 3, "x")} {
+		msg, err = a.add("a", c, now)
+		assert.NoError(t, err)
+		assert.Nil(t, msg)
+	}
+	// 相同的消息 ID 来自不同的地址
+	msg, err = a.add("b", gelfChunk("id000001", 1, 2, "y"), now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+	msg, err = a.add("a", gelfChunk("id000001", 1, 3, "b"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(msg))
+
+	_, err = a.add("a", []byte{0x1e, 0x0f, 1}, now)
+	assert.Error(t, err)
+	_, err = a.add("a", gelfChunk("id000002", 3, 3, "x"), now)
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, a.purge(now.Add(500*time.Millisecond)))
+	assert.Equal(t, 1, a.purge(now.Add(2*time.Second)))
+	assert.Empty(t, a.messages)
+}
+
+func newGELFReader(t *testing.T, address string) *Reader {
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:               MetaDir,
+		reader.KeyFileDone:               MetaDir,
+		KeyRunnerName:                    "TestGELFSocketReader",
+		reader.KeyMode:                   reader.ModeSocket,
+		reader.KeySocketServiceAddress:   address,
+		reader.KeySocketRule:             reader.SocketRuleGELF,
+		reader.KeySocketGELFChunkTimeout: "1s",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	require.NoError(t, err)
+	ssr, err := NewReader(meta, logkitConf)
+	require.NoError(t, err)
+	sr := ssr.(*Reader)
+	require.NoError(t, sr.Start())
+	return sr
+}
+
+func TestGELFSocketReader(t *testing.T) {
+	defer os.RemoveAll(MetaDir)
+
+	sr := newGELFReader(t, "udp://127.0.0.1:5141")
+	conn, err := net.Dial("udp", "127.0.0.1:5141")
+	require.NoError(t, err)
+	for _, c := range [][]byte{gelfChunk("msgid001", 1, 2, `message":"hi"}`), gelfChunk("msgid001", 0, 2, `{"short_`)} {
+		_, err = conn.Write(c)
+		require.NoError(t, err)
+	}
+	conn.Close()
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"short_message":"hi"}`, line)
+	assert.Contains(t, sr.Source(), "127.0.0.1")
+	assert.NoError(t, sr.Close())
+
+	sr = newGELFReader(t, "tcp://127.0.0.1:5142")
+	conn, err = net.Dial("tcp", "127.0.0.1:5142")
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("{\"short_message\":\"a\nb\"}\x00{\"short_message\":\"c\"}\x00"))
+	require.NoError(t, err)
+	conn.Close()
+	line, err = sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"short_message\":\"a\nb\"}", line)
+	line, err = sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"short_message":"c"}`, line)
+	assert.NoError(t, sr.Close())
+}
@@ -0,0 +1,90 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultHandshakeTimeout 为未设置读超时时 TLS 握手的超时时间
+const defaultHandshakeTimeout = 10 * time.Second
+
+// parseCIDRs 解析网段列表，单个 IP 视为只包含该地址的网段
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed 判断客户端是否允许连接，unix 套接字等没有 IP 的客户端不受限制
+func (r *Reader) allowed(addr net.Addr) bool {
+	if len(r.allowNets) == 0 && len(r.denyNets) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return true
+	}
+	if containsIP(r.denyNets, ip) {
+		return false
+	}
+	return len(r.allowNets) == 0 || containsIP(r.allowNets, ip)
+}
+
+// connTags 生成需要添加到该连接每条数据中的字段
+func (r *Reader) connTags(remote, local net.Addr, subject string) map[string]interface{} {
+	if r.clientAddrField == "" && r.certSubjectField == "" && r.listenerAddrField == "" {
+		return nil
+	}
+	tags := make(map[string]interface{}, 3)
+	if r.clientAddrField != "" && remote != nil && remote.String() != "" {
+		tags[r.clientAddrField] = remote.String()
+	}
+	if r.certSubjectField != "" && subject != "" {
+		tags[r.certSubjectField] = subject
+	}
+	if r.listenerAddrField != "" && local != nil {
+		tags[r.listenerAddrField] = local.String()
+	}
+	return tags
+}
@@ -0,0 +1,178 @@
+package socket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/reader"
+	. "github.com/longxiucai/logkit/reader/test"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"logkit"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSSocketReader(t *testing.T) {
+	dir := "TestTLSSocketReader"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(MetaDir)
+
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := server.write(t, dir, "server")
+
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:                MetaDir,
+		reader.KeyFileDone:                MetaDir,
+		KeyRunnerName:                     "TestTLSSocketReader",
+		reader.KeyMode:                    reader.ModeSocket,
+		reader.KeySocketServiceAddress:    "tcp://127.0.0.1:5143",
+		reader.KeySocketRule:              reader.SocketRuleLine,
+		reader.KeySocketTLSCert:           certFile,
+		reader.KeySocketTLSKey:            keyFile,
+		reader.KeySocketTLSClientCA:       caFile,
+		reader.KeySocketClientAddrField:   "client_addr",
+		reader.KeySocketCertSubjectField:  "cert_subject",
+		reader.KeySocketListenerAddrField: "listener_addr",
+		reader.KeySocketConnRateLimit:     "1024",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := r.(*Reader)
+	assert.NoError(t, sr.Start())
+	defer sr.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	// 未提供客户端证书的连接被拒绝
+	conn, err := tls.Dial("tcp", "127.0.0.1:5143", &tls.Config{RootCAs: pool})
+	if err == nil {
+		conn.Write([]byte("rejected\n"))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.Error(t, err)
+
+	conn, err = tls.Dial("tcp", "127.0.0.1:5143", &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{client.tlsCert()}})
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	var line string
+	for i := 0; i < 5 && line == ""; i++ {
+		line, err = sr.ReadLine()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "hello", line)
+	assert.Equal(t, map[string]interface{}{
+		"client_addr":   conn.LocalAddr().String(),
+		"cert_subject":  "CN=client,O=logkit",
+		"listener_addr": "127.0.0.1:5143",
+	}, sr.LineTags())
+}
+
+func TestSocketReaderDenyCIDR(t *testing.T) {
+	defer os.RemoveAll(MetaDir)
+	logkitConf := conf.MapConf{
+		reader.KeyMetaPath:             MetaDir,
+		reader.KeyFileDone:             MetaDir,
+		KeyRunnerName:                  "TestSocketReaderDenyCIDR",
+		reader.KeyMode:                 reader.ModeSocket,
+		reader.KeySocketServiceAddress: "udp://127.0.0.1:5144",
+		reader.KeySocketAllowCIDRs:     "10.0.0.0/8",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := r.(*Reader)
+	assert.NoError(t, sr.Start())
+	defer sr.Close()
+
+	conn, err := net.Dial("udp", "127.0.0.1:5144")
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("denied"))
+	assert.NoError(t, err)
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"})
+	assert.NoError(t, err)
+	assert.Len(t, nets, 3)
+	assert.True(t, containsIP(nets, net.ParseIP("10.1.2.3")))
+	assert.True(t, containsIP(nets, net.ParseIP("192.168.1.1")))
+	assert.False(t, containsIP(nets, net.ParseIP("192.168.1.2")))
+	assert.True(t, containsIP(nets, net.ParseIP("::1")))
+
+	_, err = parseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = parseCIDRs([]string{"localhost"})
+	assert.Error(t, err)
+}
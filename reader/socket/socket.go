@@ -2,6 +2,7 @@ package socket
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,13 +20,15 @@ import (
 	log "k8s.io/klog/v2"
 
 	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/rateio"
 	"github.com/longxiucai/logkit/reader"
 	. "github.com/longxiucai/logkit/utils/models"
 )
 
 var (
-	_ reader.DaemonReader = &Reader{}
-	_ reader.Reader       = &Reader{}
+	_ reader.DaemonReader   = &Reader{}
+	_ reader.Reader         = &Reader{}
+	_ reader.LineTagsReader = &Reader{}
 )
 
 type setReadBufferer interface {
@@ -57,6 +60,11 @@ func (ssr *streamSocketReader) listen() {
 			}
 			break
 		}
+		if !ssr.allowed(c.RemoteAddr()) {
+			log.Warningf("runner[%v] Reader %q reject connection from %v", ssr.meta.RunnerName, ssr.Name(), c.RemoteAddr())
+			c.Close()
+			continue
+		}
 
 		ssr.connectionsMtx.Lock()
 		if ssr.MaxConnections > 0 && len(ssr.connections) >= ssr.MaxConnections {
@@ -72,6 +80,10 @@ func (ssr *streamSocketReader) listen() {
 				log.Errorf("runner[%v] Reader %q unable to configure keep alive (%s): %s", ssr.meta.RunnerName, ssr.Name(), ssr.ServiceAddress, err)
 			}
 		}
+		// 在设置 keep alive 之后再包装为 TLS 连接，以便仍能取到底层的 TCP 连接
+		if ssr.tlsConfig != nil {
+			c = tls.Server(c, ssr.tlsConfig)
+		}
 
 		go ssr.read(c)
 	}
@@ -106,27 +118,67 @@ func (ssr *streamSocketReader) removeConnection(c net.Conn) {
 type socketInfo struct {
 	address string
 	data    string
+	tags    map[string]interface{}
 }
 
 func (ssr *streamSocketReader) read(c net.Conn) {
 	defer ssr.removeConnection(c)
 	defer c.Close()
 
+	var subject string
+	if tc, ok := c.(*tls.Conn); ok {
+		var err error
+		if subject, err = ssr.handshake(tc); err != nil {
+			log.Errorf("runner[%v] Reader %q TLS handshake with %v failed: %v", ssr.meta.RunnerName, ssr.Name(), c.RemoteAddr(), err)
+			return
+		}
+	}
+	tags := ssr.connTags(c.RemoteAddr(), c.LocalAddr(), subject)
+
+	var rd io.Reader = c
+	if ssr.connRateLimit > 0 {
+		ctrl := rateio.NewController(ssr.connRateLimit)
+		defer ctrl.Close()
+		rd = ctrl.Reader(c)
+	}
+
 	if ssr.IsSplitByLine ||
 		ssr.SocketRule == reader.SocketRuleLine ||
-		ssr.SocketRule == reader.SocketRulePacket {
-		ssr.packetAndLineRead(c)
+		ssr.SocketRule == reader.SocketRulePacket ||
+		ssr.SocketRule == reader.SocketRuleGELF {
+		ssr.packetAndLineRead(c, rd, tags)
 	} else {
-		ssr.jsonRead(c)
+		ssr.jsonRead(c, rd, tags)
 	}
 
 	return
 }
 
-func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
+// handshake 完成 TLS 握手并返回客户端证书的 subject，客户端未提供证书时为空
+func (ssr *streamSocketReader) handshake(c *tls.Conn) (string, error) {
+	timeout := ssr.ReadTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	if err := c.Handshake(); err != nil {
+		return "", err
+	}
+	c.SetDeadline(time.Time{})
+	if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0].Subject.String(), nil
+	}
+	return "", nil
+}
+
+func (ssr *streamSocketReader) packetAndLineRead(c net.Conn, rd io.Reader, tags map[string]interface{}) {
 	var err error
 	defer ssr.sendError(err)
-	scnr := bufio.NewScanner(c)
+	scnr := bufio.NewScanner(rd)
+	if ssr.SocketRule == reader.SocketRuleGELF {
+		scnr.Split(splitGELF)
+		scnr.Buffer(make([]byte, 64*1024), gelfMaxMessageSize)
+	}
 	for {
 		if atomic.LoadInt32(&ssr.status) == reader.StatusStopped || atomic.LoadInt32(&ssr.status) == reader.StatusStopping {
 			return
@@ -160,11 +212,11 @@ func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
 			vals := strings.Split(val, "\n")
 			for _, value := range vals {
 				if value = strings.TrimSpace(value); value != "" {
-					ssr.readChan <- socketInfo{address: address, data: value}
+					ssr.readChan <- socketInfo{address: address, data: value, tags: tags}
 				}
 			}
-		} else {
-			ssr.readChan <- socketInfo{address: address, data: val}
+		} else if ssr.SocketRule != reader.SocketRuleGELF || strings.TrimSpace(val) != "" {
+			ssr.readChan <- socketInfo{address: address, data: val, tags: tags}
 		}
 	}
 
@@ -183,10 +235,10 @@ func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
 	}
 }
 
-func (ssr *streamSocketReader) jsonRead(c net.Conn) {
+func (ssr *streamSocketReader) jsonRead(c net.Conn, rd io.Reader, tags map[string]interface{}) {
 	var err error
 	defer ssr.sendError(err)
-	bufioReader := bufio.NewReader(c)
+	bufioReader := bufio.NewReader(rd)
 	decoder := json.NewDecoder(bufioReader)
 
 	for {
@@ -246,13 +298,15 @@ func (ssr *streamSocketReader) jsonRead(c net.Conn) {
 			log.Errorf("runner[%v] Reader %q json marshal error %v", ssr.meta.RunnerName, ssr.Name(), err)
 			return
 		}
-		ssr.readChan <- socketInfo{address: address, data: string(bytes)}
+		ssr.readChan <- socketInfo{address: address, data: string(bytes), tags: tags}
 	}
 }
 
 type packetSocketReader struct {
 	PacketConn net.PacketConn
 	*Reader
+
+	gelf *gelfAssembler
 }
 
 func (psr *packetSocketReader) listen() {
@@ -281,6 +335,10 @@ func (psr *packetSocketReader) listen() {
 		if atomic.LoadInt32(&psr.status) == reader.StatusStopped || atomic.LoadInt32(&psr.status) == reader.StatusStopping {
 			return
 		}
+		if !psr.allowed(remoteAddr) {
+			continue
+		}
+		tags := psr.connTags(remoteAddr, psr.PacketConn.LocalAddr(), "")
 
 		var address string
 		// get remote addr
@@ -293,17 +351,33 @@ func (psr *packetSocketReader) listen() {
 				address = localAddr.String()
 			}
 		}
+		if psr.gelf != nil {
+			now := time.Now()
+			if dropped := psr.gelf.purge(now); dropped > 0 {
+				log.Warningf("runner[%v] Reader %q dropped %d incomplete GELF messages after %v", psr.meta.RunnerName, psr.Name(), dropped, psr.gelf.timeout)
+			}
+			msg, err := psr.gelf.add(address, buf[:n], now)
+			if err != nil {
+				log.Warningf("runner[%v] Reader %q receive from %v: %v", psr.meta.RunnerName, psr.Name(), address, err)
+				continue
+			}
+			if msg != nil {
+				psr.readChan <- socketInfo{address: address, data: string(msg), tags: tags}
+			}
+			continue
+		}
+
 		val := string(buf[:n])
 
 		if psr.IsSplitByLine || psr.SocketRule == reader.SocketRuleLine {
 			vals := strings.Split(val, "\n")
 			for _, value := range vals {
 				if value = strings.TrimSpace(value); value != "" {
-					psr.readChan <- socketInfo{address: address, data: value}
+					psr.readChan <- socketInfo{address: address, data: value, tags: tags}
 				}
 			}
 		} else {
-			psr.readChan <- socketInfo{address: address, data: val}
+			psr.readChan <- socketInfo{address: address, data: val, tags: tags}
 		}
 	}
 }
@@ -330,6 +404,16 @@ type Reader struct {
 	IsSplitByLine   bool
 	SocketRule      string
 
+	tlsConfig         *tls.Config
+	clientAddrField   string
+	certSubjectField  string
+	listenerAddrField string
+	allowNets         []*net.IPNet
+	denyNets          []*net.IPNet
+	connRateLimit     int
+	gelfChunkTimeout  time.Duration
+	lineTags          map[string]interface{}
+
 	closer io.Closer
 }
 
@@ -354,6 +438,30 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	}
 	IsSplitByLine, _ := conf.GetBoolOr(reader.KeySocketSplitByLine, false)
 	socketRule, _ := conf.GetStringOr(reader.KeySocketRule, reader.SocketRulePacket)
+
+	tlsConfig, err := NewServerTLSConfig(conf, TLSKeys{CA: reader.KeySocketTLSClientCA, Cert: reader.KeySocketTLSCert, Key: reader.KeySocketTLSKey})
+	if err != nil {
+		return nil, err
+	}
+	allowCIDRs, _ := conf.GetStringListOr(reader.KeySocketAllowCIDRs, nil)
+	allowNets, err := parseCIDRs(allowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", reader.KeySocketAllowCIDRs, err)
+	}
+	denyCIDRs, _ := conf.GetStringListOr(reader.KeySocketDenyCIDRs, nil)
+	denyNets, err := parseCIDRs(denyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", reader.KeySocketDenyCIDRs, err)
+	}
+	clientAddrField, _ := conf.GetStringOr(reader.KeySocketClientAddrField, "")
+	certSubjectField, _ := conf.GetStringOr(reader.KeySocketCertSubjectField, "")
+	listenerAddrField, _ := conf.GetStringOr(reader.KeySocketListenerAddrField, "")
+	connRateLimit, _ := conf.GetIntOr(reader.KeySocketConnRateLimit, 0)
+	gelfChunkTimeout, _ := conf.GetStringOr(reader.KeySocketGELFChunkTimeout, "5s")
+	gelfChunkTimeoutDur, err := time.ParseDuration(gelfChunkTimeout)
+	if err != nil {
+		return nil, err
+	}
 	return &Reader{
 		meta:            meta,
 		status:          reader.StatusInit,
@@ -366,6 +474,15 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		KeepAlivePeriod: KeepAlivePeriodDur,
 		IsSplitByLine:   IsSplitByLine,
 		SocketRule:      socketRule,
+
+		tlsConfig:         tlsConfig,
+		clientAddrField:   clientAddrField,
+		certSubjectField:  certSubjectField,
+		listenerAddrField: listenerAddrField,
+		allowNets:         allowNets,
+		denyNets:          denyNets,
+		connRateLimit:     connRateLimit,
+		gelfChunkTimeout:  gelfChunkTimeoutDur,
 	}, nil
 }
 
@@ -438,6 +555,9 @@ func (r *Reader) Start() error {
 		r.closer = l
 		go ssr.listen()
 	case "udp", "udp4", "udp6", "ip", "ip4", "ip6", "unixgram":
+		if r.tlsConfig != nil {
+			return fmt.Errorf("TLS is not supported for protocol '%s'", spl[0])
+		}
 		pc, err := net.ListenPacket(spl[0], spl[1])
 		if err != nil {
 			return err
@@ -456,6 +576,9 @@ func (r *Reader) Start() error {
 			PacketConn: pc,
 			Reader:     r,
 		}
+		if r.SocketRule == reader.SocketRuleGELF {
+			psr.gelf = newGELFAssembler(r.gelfChunkTimeout)
+		}
 
 		r.closer = pc
 		go psr.listen()
@@ -481,6 +604,7 @@ func (r *Reader) ReadLine() (string, error) {
 	select {
 	case info := <-r.readChan:
 		r.sourceIp = info.address
+		r.lineTags = info.tags
 		return info.data, nil
 	case err := <-r.errChan:
 		return "", err
@@ -490,6 +614,11 @@ func (r *Reader) ReadLine() (string, error) {
 	return "", nil
 }
 
+// LineTags 返回最近一次读取的行所属连接的客户端地址、证书 subject 与监听地址
+func (r *Reader) LineTags() map[string]interface{} {
+	return r.lineTags
+}
+
 func (r *Reader) SyncMeta() {
 	//FIXME 网络监听存在丢包可能性，无法保证不丢包
 }
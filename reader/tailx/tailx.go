@@ -23,11 +23,12 @@ import (
 )
 
 var (
-	_ reader.DaemonReader = &Reader{}
-	_ reader.StatsReader  = &Reader{}
-	_ reader.LagReader    = &Reader{}
-	_ reader.Reader       = &Reader{}
-	_ Resetable           = &Reader{}
+	_ reader.DaemonReader     = &Reader{}
+	_ reader.StatsReader      = &Reader{}
+	_ reader.LagReader        = &Reader{}
+	_ reader.Reader           = &Reader{}
+	_ reader.ExtraStatsReader = &Reader{}
+	_ Resetable               = &Reader{}
 )
 
 func init() {
@@ -50,7 +51,10 @@ type Reader struct {
 	armapmux    sync.Mutex
 	currentFile string
 	headRegexp  *regexp.Regexp
+	multiline   *reader.MultilineConfig
 	cacheMap    map[string]string
+	discoverer  *reader.PathDiscoverer
+	matcher     *reader.PathMatcher
 
 	//以下为传入参数
 	logPathPattern string
@@ -59,6 +63,8 @@ type Reader struct {
 	statInterval   time.Duration
 	maxOpenFiles   int
 	whence         string
+	discoveryMode  string
+	maxWatches     int
 }
 
 type ActiveReader struct {
@@ -298,6 +304,18 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	discoveryMode, _ := conf.GetStringOr(reader.KeyDiscoveryMode, reader.DiscoveryModePoll)
+	if discoveryMode != reader.DiscoveryModePoll && discoveryMode != reader.DiscoveryModeInotify {
+		return nil, fmt.Errorf("%q value %q is not supported", reader.KeyDiscoveryMode, discoveryMode)
+	}
+	maxWatches, _ := conf.GetIntOr(reader.KeyMaxWatches, reader.DefaultMaxWatches)
+
+	matcher, err := reader.NewPathMatcherWithConf(logPathPattern, conf)
+	if err != nil {
+		return nil, err
+	}
+
 	_, _, bufsize, err := meta.ReadBufMeta()
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -339,6 +357,9 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		submetaExpire:  submetaExpire,
 		statInterval:   statInterval,
 		maxOpenFiles:   maxOpenFiles,
+		discoveryMode:  discoveryMode,
+		maxWatches:     maxWatches,
+		matcher:        matcher,
 		fileReaders:    make(map[string]*ActiveReader), //armapmux
 		cacheMap:       cacheMap,                       //armapmux
 	}, nil
@@ -357,6 +378,14 @@ func (r *Reader) Name() string {
 }
 
 func (r *Reader) SetMode(mode string, value interface{}) error {
+	if mode == reader.ReadModeMultiline {
+		mc, ok := value.(*reader.MultilineConfig)
+		if !ok {
+			return fmt.Errorf("%v is not *reader.MultilineConfig type value", value)
+		}
+		r.multiline = mc
+		return nil
+	}
 	reg, err := reader.HeadPatternMode(mode, value)
 	if err != nil {
 		return fmt.Errorf("get head pattern mode: %v", err)
@@ -405,21 +434,36 @@ func (r *Reader) checkExpiredFiles() {
 	}
 }
 
+func (r *Reader) reachMaxOpenFiles() bool {
+	r.armapmux.Lock()
+	num := len(r.fileReaders)
+	r.armapmux.Unlock()
+	if num >= r.maxOpenFiles {
+		log.Warningf("Runner[%v] %v meet maxOpenFiles limit %v, ignore Stat new log...", r.meta.RunnerName, r.Name(), r.maxOpenFiles)
+		return true
+	}
+	return false
+}
+
 func (r *Reader) statLogPath() {
 	//达到最大打开文件数，不再追踪
-	if len(r.fileReaders) >= r.maxOpenFiles {
-		log.Warningf("Runner[%v] %v meet maxOpenFiles limit %v, ignore Stat new log...", r.meta.RunnerName, r.Name(), r.maxOpenFiles)
+	if r.reachMaxOpenFiles() {
 		return
 	}
-	matches, err := filepath.Glob(r.logPathPattern)
+	matches, err := r.matcher.Glob()
 	if err != nil {
 		log.Errorf("Runner[%v] stat logPathPattern error %v", r.meta.RunnerName, err)
 		r.setStatsError("Runner[" + r.meta.RunnerName + "] stat logPathPattern error " + err.Error())
 		return
 	}
 	if len(matches) > 0 {
-		log.Infof("Runner[%v] statLogPath %v find matches: %v", r.meta.RunnerName, r.logPathPattern, strings.Join(matches, ", "))
+		log.Infof("Runner[%v] statLogPath %v find matches: %v", r.meta.RunnerName, r.matcher, strings.Join(matches, ", "))
 	}
+	r.addLogPaths(matches)
+}
+
+// addLogPaths 为尚未追踪的匹配路径创建 ActiveReader
+func (r *Reader) addLogPaths(matches []string) {
 	var newaddsPath []string
 	for _, mc := range matches {
 		rp, fi, err := GetRealPath(mc)
@@ -463,6 +507,13 @@ func (r *Reader) statLogPath() {
 				r.setStatsError("Runner[" + r.meta.RunnerName + "] NewActiveReader for matches " + rp + " SetMode error " + err.Error())
 			}
 		}
+		if r.multiline != nil {
+			err = ar.br.SetMode(reader.ReadModeMultiline, r.multiline)
+			if err != nil {
+				log.Errorf("Runner[%v] NewActiveReader for matches %v SetMode error %v", r.meta.RunnerName, rp, err)
+				r.setStatsError("Runner[" + r.meta.RunnerName + "] NewActiveReader for matches " + rp + " SetMode error " + err.Error())
+			}
+		}
 		newaddsPath = append(newaddsPath, rp)
 		r.armapmux.Lock()
 		if atomic.LoadInt32(&r.status) != reader.StatusStopped {
@@ -485,6 +536,30 @@ func (r *Reader) statLogPath() {
 	}
 }
 
+// waitForStat 等待下一次全量扫描，期间处理文件系统事件感知到的新文件，reader 停止时返回 false
+func (r *Reader) waitForStat(ticker *time.Ticker) bool {
+	var found <-chan string
+	var rescan <-chan struct{}
+	if r.discoverer != nil {
+		found = r.discoverer.Found()
+		rescan = r.discoverer.Rescan()
+	}
+	for {
+		select {
+		case <-r.stopChan:
+			return false
+		case <-ticker.C:
+			return true
+		case <-rescan:
+			return true
+		case path := <-found:
+			if !r.reachMaxOpenFiles() {
+				r.addLogPaths([]string{path})
+			}
+		}
+	}
+}
+
 func (r *Reader) Start() error {
 	if r.isStopping() || r.hasStopped() {
 		return errors.New("reader is stopping or has stopped")
@@ -493,6 +568,10 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
+	if r.discoveryMode == reader.DiscoveryModeInotify {
+		r.startDiscoverer()
+	}
+
 	go func() {
 		ticker := time.NewTicker(r.statInterval)
 		defer ticker.Stop()
@@ -500,12 +579,10 @@ func (r *Reader) Start() error {
 			r.checkExpiredFiles()
 			r.statLogPath()
 
-			select {
-			case <-r.stopChan:
+			if !r.waitForStat(ticker) {
 				atomic.StoreInt32(&r.status, reader.StatusStopped)
 				log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
 				return
-			case <-ticker.C:
 			}
 		}
 	}()
@@ -530,6 +607,20 @@ func (r *Reader) Start() error {
 	return nil
 }
 
+// startDiscoverer 启动事件驱动的文件发现，失败时退化为按 stat_interval 轮询
+func (r *Reader) startDiscoverer() {
+	discoverer, err := reader.NewPathDiscoverer(r.matcher, r.maxWatches)
+	if err == nil {
+		err = discoverer.Start()
+	}
+	if err != nil {
+		log.Errorf("Runner[%v] %q start inotify discovery failed: %v, fallback to poll every %v", r.meta.RunnerName, r.Name(), err, r.statInterval)
+		r.setStatsError("Runner[" + r.meta.RunnerName + "] start inotify discovery failed: " + err.Error())
+		return
+	}
+	r.discoverer = discoverer
+}
+
 func (r *Reader) getActiveReaders() []*ActiveReader {
 	r.armapmux.Lock()
 	defer r.armapmux.Unlock()
@@ -574,6 +665,15 @@ func (r *Reader) Status() StatsInfo {
 	return r.stats
 }
 
+func (r *Reader) ExtraStats() map[string]interface{} {
+	if r.discoverer == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		reader.StatsKeyWatches: r.discoverer.WatchCount(),
+	}
+}
+
 func (r *Reader) Lag() (*LagInfo, error) {
 	lagInfo := &LagInfo{SizeUnit: "bytes"}
 	var errStr string
@@ -633,6 +733,9 @@ func (r *Reader) Close() error {
 	}
 	log.Infof("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
 	close(r.stopChan)
+	if r.discoverer != nil {
+		r.discoverer.Close()
+	}
 
 	// 停10ms为了管道中的数据传递完毕，确认reader run函数已经结束不会再读取，保证syncMeta的正确性
 	time.Sleep(10 * time.Millisecond)
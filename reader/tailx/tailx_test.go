@@ -764,3 +764,89 @@ func TestReaderErrMiddle(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestInotifyDiscovery(t *testing.T) {
+	dirname := "TestInotifyDiscovery"
+	dir1 := filepath.Join(dirname, "abc")
+	os.RemoveAll(dirname)
+	createDirWithName(dirname)
+	defer os.RemoveAll(dirname)
+
+	c := conf.MapConf{
+		"log_path":       filepath.Join(dirname, "*", "*.log"),
+		"meta_path":      dirname + "_meta",
+		"mode":           reader.ModeTailx,
+		"read_from":      "oldest",
+		"stat_interval":  "1h",
+		"discovery_mode": reader.DiscoveryModeInotify,
+	}
+	defer os.RemoveAll(dirname + "_meta")
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	assert.NoError(t, mr.Start())
+	defer mr.Close()
+
+	// 即使 stat_interval 很长，新建的目录和文件也能被及时感知
+	createDirWithName(dir1)
+	createFileWithContent(filepath.Join(dir1, "file1.log"), "abc123\n")
+	var data string
+	for i := 0; i < 10 && data == ""; i++ {
+		data, err = mr.ReadLine()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "abc123\n", data)
+	assert.Equal(t, map[string]interface{}{reader.StatsKeyWatches: 2}, mr.ExtraStats())
+
+	c["discovery_mode"] = "unknown"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
+
+func TestRecursiveLogPath(t *testing.T) {
+	dirname := "TestRecursiveLogPath"
+	os.RemoveAll(dirname)
+	defer os.RemoveAll(dirname)
+	for _, path := range []string{"a/b/c/1.log", "a/debug/2.log", "a/3.log", "other/4.log"} {
+		path = filepath.Join(dirname, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), DefaultDirPerm))
+		createFileWithContent(path, path+"\n")
+	}
+
+	c := conf.MapConf{
+		"log_path":          filepath.Join(dirname, "a", "**", "*.log"),
+		"log_path_includes": filepath.Join(dirname, "other", "*.log"),
+		"log_path_excludes": "debug",
+		"max_depth":         "2",
+		"meta_path":         dirname + "_meta",
+		"mode":              reader.ModeTailx,
+		"read_from":         "oldest",
+	}
+	defer os.RemoveAll(dirname + "_meta")
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	mr.statLogPath()
+	mr.armapmux.Lock()
+	var paths []string
+	for _, ar := range mr.fileReaders {
+		paths = append(paths, ar.originpath)
+	}
+	mr.armapmux.Unlock()
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dirname, "a", "b", "c", "1.log"),
+		filepath.Join(dirname, "a", "3.log"),
+		filepath.Join(dirname, "other", "4.log"),
+	}, paths)
+	for _, ar := range mr.getActiveReaders() {
+		ar.Close()
+	}
+
+	c["symlink_policy"] = "unknown"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package amqp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/sender"
+	"github.com/longxiucai/logkit/utils/amqputil"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+type Sender struct {
+	url        string
+	host       string
+	tlsConfig  *tls.Config
+	exchange   string
+	routingKey *sender.FieldTemplate
+	timeout    time.Duration
+
+	// conn 与 ch 在连接断开后的下一次发送时重新建立
+	lock sync.Mutex
+	conn *amqp091.Connection
+	ch   *amqp091.Channel
+
+	runnerName string
+}
+
+func init() {
+	sender.RegisterConstructor(sender.TypeAMQP, NewSender)
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	url, err := c.GetString(sender.KeyAMQPURL)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := amqp091.ParseURI(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", sender.KeyAMQPURL, err)
+	}
+	exchange, _ := c.GetStringOr(sender.KeyAMQPExchange, "")
+	routingKey, _ := c.GetStringOr(sender.KeyAMQPRoutingKey, "")
+	if exchange == "" && routingKey == "" {
+		return nil, fmt.Errorf("%s is required when %s is empty", sender.KeyAMQPRoutingKey, sender.KeyAMQPExchange)
+	}
+	timeoutStr, _ := c.GetStringOr(sender.KeyAMQPPublishTimeout, "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", sender.KeyAMQPPublishTimeout, timeoutStr, err)
+	}
+	tlsConfig, err := NewClientTLSConfig(c, TLSKeys{CA: sender.KeyAMQPTLSCA, Cert: sender.KeyAMQPTLSCert, Key: sender.KeyAMQPTLSKey})
+	if err != nil {
+		return nil, err
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, sender.UnderfinedRunnerName)
+
+	s := &Sender{
+		url:        url,
+		host:       amqputil.HostOf(uri),
+		tlsConfig:  tlsConfig,
+		exchange:   exchange,
+		routingKey: sender.NewFieldTemplate(routingKey),
+		timeout:    timeout,
+		runnerName: runnerName,
+	}
+	// 服务暂时不可用时不影响 runner 的启动，在发送时重新连接
+	if _, err = s.channel(); err != nil {
+		log.Warningf("Runner[%v] Sender[%v] %v, will retry when sending", runnerName, s.Name(), err)
+	}
+	return s, nil
+}
+
+func (s *Sender) Name() string {
+	return "amqpSender_" + s.host + "_" + s.exchange
+}
+
+func (_ *Sender) SkipDeepCopy() bool { return true }
+
+// channel 返回开启了 publisher confirm 的 channel，连接或者 channel 已经关闭时重新建立
+func (s *Sender) channel() (*amqp091.Channel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ch != nil && !s.ch.IsClosed() {
+		return s.ch, nil
+	}
+	s.closeLocked()
+
+	var conn *amqp091.Connection
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = amqp091.DialTLS(s.url, s.tlsConfig)
+	} else {
+		conn, err = amqp091.Dial(s.url)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("runner[%v] connect to amqp %v failed: %v", s.runnerName, s.host, err)
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("runner[%v] open amqp channel with publisher confirms failed: %v", s.runnerName, err)
+	}
+	s.conn, s.ch = conn, ch
+	return ch, nil
+}
+
+// reset 关闭当前的连接，下一次发送时重新建立
+func (s *Sender) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closeLocked()
+}
+
+func (s *Sender) closeLocked() error {
+	s.ch = nil
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	if errors.Is(err, amqp091.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// renderRoutingKey 将 routing key 中引用的字段替换为数据中对应的值，字段不存在时替换为空字符串
+func (s *Sender) renderRoutingKey(data Data) string {
+	routingKey, _ := s.routingKey.Render(data, nil)
+	return routingKey
+}
+
+func (s *Sender) Send(datas []Data) error {
+	ch, err := s.channel()
+	if err != nil {
+		return err
+	}
+
+	ste := &StatsError{
+		Ft:         true,
+		FtNotRetry: true,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	confirms := make([]*amqp091.DeferredConfirmation, len(datas))
+	var failed []Data
+	var lastErr error
+	for i, data := range datas {
+		body, err := jsoniter.Marshal(data)
+		if err != nil {
+			ste.Errors++
+			ste.LastError = fmt.Sprintf("%s marshal data failed: %v", s.Name(), err)
+			continue
+		}
+		if lastErr != nil {
+			// channel 已经不可用，剩余的数据由上层重试
+			failed = append(failed, data)
+			continue
+		}
+		confirms[i], err = ch.PublishWithDeferredConfirmWithContext(ctx, s.exchange, s.renderRoutingKey(data), false, false, amqp091.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp091.Persistent,
+			Timestamp:    time.Now(),
+			Body:         body,
+		})
+		if err != nil {
+			failed = append(failed, data)
+			lastErr = err
+		}
+	}
+
+	for i, confirm := range confirms {
+		if confirm == nil {
+			continue
+		}
+		ack, err := confirm.WaitContext(ctx)
+		if err != nil || !ack {
+			failed = append(failed, datas[i])
+			if err != nil {
+				lastErr = err
+			} else {
+				lastErr = errors.New("message nacked by server")
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		// 连接状态未知，重新建立连接避免之后的确认与本次发送混淆
+		s.reset()
+		log.Errorf("Runner[%v] Sender[%v] publish %d of %d messages failed: %v", s.runnerName, s.Name(), len(failed), len(datas), lastErr)
+		return reqerr.NewSendError(
+			fmt.Sprintf("%s publish %d messages failed: %v", s.Name(), len(failed), lastErr),
+			sender.ConvertDatasBack(failed),
+			reqerr.TypeDefault,
+		)
+	}
+	if ste.Errors > 0 {
+		return ste
+	}
+	return nil
+}
+
+func (s *Sender) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closeLocked()
+}
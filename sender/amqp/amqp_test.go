@@ -0,0 +1,44 @@
+package amqp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/sender"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestAMQPSenderRoutingKey(t *testing.T) {
+	// 服务不可用时仍然可以创建，发送时返回错误由上层重试
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	c := conf.MapConf{
+		sender.KeyAMQPURL:        "amqp://guest:guest@" + addr + "/",
+		sender.KeyAMQPExchange:   "logs",
+		sender.KeyAMQPRoutingKey: "app.%{[app]}.%{[level]}",
+	}
+	s, err := NewSender(c)
+	require.NoError(t, err)
+	defer s.Close()
+	as := s.(*Sender)
+	assert.Equal(t, "app.web.info", as.renderRoutingKey(Data{"app": "web", "level": "info"}))
+	assert.Equal(t, "app.db.", as.renderRoutingKey(Data{"app": "db"}))
+	assert.Error(t, s.Send([]Data{{"app": "web"}}))
+
+	c[sender.KeyAMQPRoutingKey] = "fixed"
+	s, err = NewSender(c)
+	require.NoError(t, err)
+	assert.Equal(t, "fixed", s.(*Sender).renderRoutingKey(Data{"app": "web"}))
+
+	delete(c, sender.KeyAMQPExchange)
+	delete(c, sender.KeyAMQPRoutingKey)
+	_, err = NewSender(c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,380 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/sender"
+	"github.com/longxiucai/logkit/times"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+const (
+	version = "1.1"
+
+	// 分块头部：2 字节的 magic，8 字节的消息 ID，1 字节的序号与 1 字节的分块总数
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var (
+	chunkMagic = []byte{0x1e, 0x0f}
+	// GELF 附加字段名只能包含字母、数字、下划线、点与横线
+	invalidFieldChars = regexp.MustCompile(`[^\w.\-]`)
+
+	// syslog 级别名称对应的数值
+	levels = map[string]int64{
+		"emerg": 0, "emergency": 0, "alert": 1, "crit": 2, "critical": 2, "fatal": 2,
+		"err": 3, "error": 3, "warn": 4, "warning": 4, "notice": 5,
+		"info": 6, "informational": 6, "debug": 7, "trace": 7,
+	}
+)
+
+type Sender struct {
+	network      string
+	address      string
+	compression  string
+	chunkSize    int
+	messageField string
+	host         string
+	timeout      time.Duration
+
+	conn       net.Conn
+	runnerName string
+}
+
+func init() {
+	sender.RegisterConstructor(sender.TypeGELF, NewSender)
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	address, err := c.GetString(sender.KeyGELFAddress)
+	if err != nil {
+		return nil, err
+	}
+	spl := strings.SplitN(address, "://", 2)
+	if len(spl) != 2 {
+		return nil, fmt.Errorf("invalid %s %q, should be like udp://127.0.0.1:12201", sender.KeyGELFAddress, address)
+	}
+	network := spl[0]
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q in %s", network, sender.KeyGELFAddress)
+	}
+	compression, _ := c.GetStringOr(sender.KeyGELFCompression, "gzip")
+	switch compression {
+	case "gzip", "zlib", "none":
+	default:
+		return nil, fmt.Errorf("unsupported %s %q", sender.KeyGELFCompression, compression)
+	}
+	chunkSize, _ := c.GetIntOr(sender.KeyGELFChunkSize, 1420)
+	if chunkSize <= chunkHeaderSize {
+		return nil, fmt.Errorf("%s should be greater than %d", sender.KeyGELFChunkSize, chunkHeaderSize)
+	}
+	messageField, _ := c.GetStringOr(sender.KeyGELFShortMessageField, "message")
+	host, _ := c.GetStringOr(sender.KeyGELFHost, "")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	timeoutStr, _ := c.GetStringOr(sender.KeyGELFTimeout, "10s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", sender.KeyGELFTimeout, timeoutStr, err)
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, sender.UnderfinedRunnerName)
+	s := &Sender{
+		network:      network,
+		address:      spl[1],
+		compression:  compression,
+		chunkSize:    chunkSize,
+		messageField: messageField,
+		host:         host,
+		timeout:      timeout,
+		runnerName:   runnerName,
+	}
+	if err = s.connect(); err != nil {
+		// tcp 服务暂时不可用时在发送时重连
+		log.Warningf("Runner[%v] Sender[%v] connect failed: %v", runnerName, s.Name(), err)
+	}
+	return s, nil
+}
+
+func (s *Sender) isUDP() bool {
+	return strings.HasPrefix(s.network, "udp")
+}
+
+func (s *Sender) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *Sender) Name() string {
+	return "gelfSender_" + s.network + "://" + s.address
+}
+
+func (_ *Sender) SkipDeepCopy() bool { return true }
+
+// fieldName 将字段名转换为合法的 GELF 附加字段名
+func fieldName(k string) string {
+	name := "_" + invalidFieldChars.ReplaceAllString(k, "_")
+	// _id 为 GELF 保留的字段
+	if name == "_id" {
+		name = "_id_"
+	}
+	return name
+}
+
+func timestamp(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return float64(t.UnixNano()) / 1e9, true
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case string:
+		ts, err := times.StrToTime(t)
+		if err != nil {
+			return 0, false
+		}
+		return float64(ts.UnixNano()) / 1e9, true
+	}
+	return 0, false
+}
+
+func level(v interface{}) (int64, bool) {
+	switch l := v.(type) {
+	case int64:
+		return l, true
+	case int:
+		return int64(l), true
+	case float64:
+		return int64(l), true
+	case string:
+		lv, ok := levels[strings.ToLower(l)]
+		return lv, ok
+	}
+	return 0, false
+}
+
+// encode 将数据转换为 GELF 消息，标准字段之外的字段作为附加字段，非数字的值转换为字符串
+func (s *Sender) encode(data Data) ([]byte, error) {
+	msg := map[string]interface{}{
+		"version": version,
+		"host":    s.host,
+	}
+	var hasMessage bool
+	for k, v := range data {
+		switch k {
+		case "host", "short_message", "full_message":
+			if str, ok := v.(string); ok && str != "" {
+				msg[k] = str
+				hasMessage = hasMessage || k == "short_message"
+				continue
+			}
+		case "timestamp":
+			if ts, ok := timestamp(v); ok {
+				msg[k] = ts
+				continue
+			}
+		case "level":
+			if lv, ok := level(v); ok {
+				msg[k] = lv
+				continue
+			}
+		}
+		switch vv := v.(type) {
+		case nil:
+		case string, float64, float32, int, int32, int64, uint, uint32, uint64:
+			msg[fieldName(k)] = vv
+		default:
+			bs, err := jsoniter.Marshal(vv)
+			if err != nil {
+				return nil, err
+			}
+			msg[fieldName(k)] = string(bs)
+		}
+	}
+	if !hasMessage {
+		if v, ok := data[s.messageField]; ok && v != nil && fmt.Sprint(v) != "" {
+			msg["short_message"] = fmt.Sprint(v)
+			delete(msg, fieldName(s.messageField))
+		} else {
+			bs, err := jsoniter.Marshal(data)
+			if err != nil {
+				return nil, err
+			}
+			msg["short_message"] = string(bs)
+		}
+	}
+	if _, ok := msg["timestamp"]; !ok {
+		msg["timestamp"] = float64(time.Now().UnixNano()/int64(time.Millisecond)) / 1e3
+	}
+	return jsoniter.Marshal(msg)
+}
+
+func (s *Sender) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch s.compression {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(msg); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write(msg); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return msg, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// chunks 将超过分块大小的消息拆分为 GELF 分块
+func (s *Sender) chunks(msg []byte) ([][]byte, error) {
+	if len(msg) <= s.chunkSize {
+		return [][]byte{msg}, nil
+	}
+	size := s.chunkSize - chunkHeaderSize
+	count := (len(msg) + size - 1) / size
+	if count > maxChunks {
+		return nil, fmt.Errorf("message size %d exceeds the limit of %d chunks", len(msg), maxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk := make([]byte, 0, chunkHeaderSize+end-i*size)
+		chunk = append(chunk, chunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, msg[i*size:end]...))
+	}
+	return chunks, nil
+}
+
+func (s *Sender) write(msg []byte) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	if s.timeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	var err error
+	if s.isUDP() {
+		var chunks [][]byte
+		if chunks, err = s.chunks(msg); err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if _, err = s.conn.Write(chunk); err != nil {
+				break
+			}
+		}
+	} else {
+		_, err = s.conn.Write(append(msg, 0))
+	}
+	if err != nil {
+		// 连接出错后重新建立连接
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Sender) Send(datas []Data) error {
+	ste := &StatsError{
+		Ft:         true,
+		FtNotRetry: true,
+	}
+	var failed []Data
+	var lastErr error
+	for i, data := range datas {
+		msg, err := s.encode(data)
+		if err == nil && s.isUDP() {
+			msg, err = s.compress(msg)
+		}
+		if err != nil {
+			// 重试也无法编码，丢弃该条数据
+			ste.Errors++
+			ste.LastError = fmt.Sprintf("%s encode data failed: %v", s.Name(), err)
+			continue
+		}
+		if err = s.write(msg); err != nil {
+			if _, ok := err.(net.Error); !ok && s.isUDP() {
+				ste.Errors++
+				ste.LastError = fmt.Sprintf("%s send data failed: %v", s.Name(), err)
+				continue
+			}
+			// 网络错误时剩余的数据都交给上层重试
+			failed = append(failed, datas[i:]...)
+			lastErr = err
+			break
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Errorf("Runner[%v] Sender[%v] send %d of %d messages failed: %v", s.runnerName, s.Name(), len(failed), len(datas), lastErr)
+		return reqerr.NewSendError(
+			fmt.Sprintf("%s send %d messages failed: %v", s.Name(), len(failed), lastErr),
+			sender.ConvertDatasBack(failed),
+			reqerr.TypeDefault,
+		)
+	}
+	if ste.Errors > 0 {
+		return ste
+	}
+	return nil
+}
+
+func (s *Sender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,121 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/parser"
+	"github.com/longxiucai/logkit/parser/gelf"
+	"github.com/longxiucai/logkit/sender"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func decode(t *testing.T, msg []byte) Data {
+	p, err := gelf.NewParser(conf.MapConf{parser.KeyParserName: "gelf"})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{string(msg)})
+	require.NoError(t, err.(*StatsError).ErrorDetail)
+	require.Len(t, datas, 1)
+	return datas[0]
+}
+
+func TestUDPSender(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	s, err := NewSender(conf.MapConf{
+		sender.KeyGELFAddress:   "udp://" + pc.LocalAddr().String(),
+		sender.KeyGELFChunkSize: "400",
+		sender.KeyGELFHost:      "logkit",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	random := make([]byte, 2000)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+	long := hex.EncodeToString(random)
+	require.NoError(t, s.Send([]Data{
+		{"message": "hello", "level": "error", "timestamp": "2013-11-21T17:11:02.307Z", "id": "x", "a b": int64(1), "nested": map[string]interface{}{"k": "v"}},
+		{"short_message": long, "host": "web1", "full_message": long},
+	}))
+
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, Data{
+		"version":       "1.1",
+		"host":          "logkit",
+		"short_message": "hello",
+		"level":         int64(3),
+		"timestamp":     "2013-11-21T17:11:02.307Z",
+		"id_":           "x",
+		"a_b":           int64(1),
+		"nested":        `{"k":"v"}`,
+	}, decode(t, buf[:n]))
+
+	// 压缩后仍超过分块大小的消息分块发送
+	var chunks [][]byte
+	for {
+		n, _, err = pc.ReadFrom(buf)
+		require.NoError(t, err)
+		chunk := append([]byte(nil), buf[:n]...)
+		require.True(t, bytes.HasPrefix(chunk, chunkMagic))
+		assert.True(t, len(chunk) <= 400)
+		chunks = append(chunks, chunk)
+		if len(chunks) == int(chunk[11]) {
+			break
+		}
+	}
+	msg := make([][]byte, len(chunks))
+	for _, c := range chunks {
+		msg[c[10]] = c[chunkHeaderSize:]
+	}
+	data := decode(t, bytes.Join(msg, nil))
+	assert.Equal(t, long, data["short_message"])
+	assert.Equal(t, long, data["full_message"])
+	assert.Equal(t, "web1", data["host"])
+}
+
+func TestTCPSender(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	s, err := NewSender(conf.MapConf{
+		sender.KeyGELFAddress: "tcp://" + l.Addr().String(),
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Send([]Data{{"raw": "line1"}, {"message": "line2", "level": int64(6)}}))
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(conn)
+	msg, err := rd.ReadBytes(0)
+	require.NoError(t, err)
+	data := decode(t, msg[:len(msg)-1])
+	assert.Equal(t, `{"raw":"line1"}`, data["short_message"])
+	assert.Equal(t, "line1", data["raw"])
+	msg, err = rd.ReadBytes(0)
+	require.NoError(t, err)
+	data = decode(t, msg[:len(msg)-1])
+	assert.Equal(t, "line2", data["short_message"])
+	assert.Equal(t, int64(6), data["level"])
+
+	_, err = NewSender(conf.MapConf{sender.KeyGELFAddress: "http://127.0.0.1:12201"})
+	assert.Error(t, err)
+}
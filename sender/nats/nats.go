@@ -0,0 +1,294 @@
+package nats
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	gonats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	log "k8s.io/klog/v2"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/sender"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+// maxRetryMsgIDs 为记录的待重试 Nats-Msg-Id 数量上限，超过时清空记录，之后的重试重新生成 ID
+const maxRetryMsgIDs = 100000
+
+type Sender struct {
+	servers        string
+	subject        string
+	subjectTmpl    *sender.FieldTemplate
+	defaultSubject string
+	msgIDField     string
+	timeout        time.Duration
+
+	conn *gonats.Conn
+	js   gonats.JetStreamContext
+
+	// retryIDs 按主题与消息内容记录发送失败的消息生成的 Nats-Msg-Id，重试时使用相同的 ID，ID 不写入数据中
+	retryLock  sync.Mutex
+	retryIDs   map[[sha256.Size]byte][]string
+	retryCount int
+
+	runnerName string
+}
+
+func init() {
+	sender.RegisterConstructor(sender.TypeNATS, NewSender)
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	servers, err := c.GetStringListOr(sender.KeyNATSServers, []string{gonats.DefaultURL})
+	if err != nil {
+		return nil, err
+	}
+	subject, err := c.GetString(sender.KeyNATSSubject)
+	if err != nil {
+		return nil, err
+	}
+	defaultSubject, _ := c.GetStringOr(sender.KeyNATSDefaultSubject, "")
+	msgIDField, _ := c.GetStringOr(sender.KeyNATSMsgIDField, "")
+	timeoutStr, _ := c.GetStringOr(sender.KeyNATSPublishTimeout, "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q failed: %v", sender.KeyNATSPublishTimeout, timeoutStr, err)
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, sender.UnderfinedRunnerName)
+
+	opts, err := connectOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		gonats.Name("logkit_"+runnerName),
+		gonats.MaxReconnects(-1),
+		gonats.RetryOnFailedConnect(true),
+	)
+	s := &Sender{
+		servers:        strings.Join(servers, ","),
+		subject:        subject,
+		subjectTmpl:    sender.NewFieldTemplate(subject),
+		defaultSubject: defaultSubject,
+		msgIDField:     msgIDField,
+		timeout:        timeout,
+		runnerName:     runnerName,
+		retryIDs:       make(map[[sha256.Size]byte][]string),
+	}
+	if s.conn, err = gonats.Connect(s.servers, opts...); err != nil {
+		return nil, fmt.Errorf("runner[%v] connect to nats %v failed: %v", runnerName, s.servers, err)
+	}
+	if s.js, err = s.conn.JetStream(); err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// connectOptions 根据认证与 TLS 配置生成连接选项
+func connectOptions(c conf.MapConf) ([]gonats.Option, error) {
+	var opts []gonats.Option
+	username, _ := c.GetStringOr(sender.KeyNATSUsername, "")
+	password, _ := c.GetStringOr(sender.KeyNATSPassword, "")
+	if username != "" {
+		opts = append(opts, gonats.UserInfo(username, password))
+	}
+	if token, _ := c.GetStringOr(sender.KeyNATSToken, ""); token != "" {
+		opts = append(opts, gonats.Token(token))
+	}
+	if creds, _ := c.GetStringOr(sender.KeyNATSCredsFile, ""); creds != "" {
+		opts = append(opts, gonats.UserCredentials(creds))
+	}
+	if ca, _ := c.GetStringOr(sender.KeyNATSTLSCA, ""); ca != "" {
+		opts = append(opts, gonats.RootCAs(ca))
+	}
+	certFile, _ := c.GetStringOr(sender.KeyNATSTLSCert, "")
+	keyFile, _ := c.GetStringOr(sender.KeyNATSTLSKey, "")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("%s and %s must be set together", sender.KeyNATSTLSCert, sender.KeyNATSTLSKey)
+		}
+		opts = append(opts, gonats.ClientCert(certFile, keyFile))
+	}
+	return opts, nil
+}
+
+func (s *Sender) Name() string {
+	return "natsSender_" + s.servers + "_" + s.subject
+}
+
+func (_ *Sender) SkipDeepCopy() bool { return true }
+
+// subjectToken 将字段的值转换为主题中的一段，去掉会改变主题层级或者被当作通配符的字符
+func subjectToken(v interface{}) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, fmt.Sprint(v))
+}
+
+// renderSubject 根据数据中的字段生成主题，引用的字段不存在时使用默认主题
+func (s *Sender) renderSubject(data Data) (string, bool) {
+	subject, complete := s.subjectTmpl.Render(data, subjectToken)
+	if !complete {
+		return s.defaultSubject, s.defaultSubject != ""
+	}
+	return subject, true
+}
+
+func retryKey(subject string, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(subject))
+	h.Write([]byte{0})
+	h.Write(body)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// msgID 返回消息的 Nats-Msg-Id，未配置去重字段时优先使用同一消息上次发送失败时生成的 ID，generated 表示 ID 为生成的
+func (s *Sender) msgID(data Data, key [sha256.Size]byte) (id string, generated bool) {
+	if s.msgIDField != "" {
+		if v, ok := data[s.msgIDField]; ok && v != nil {
+			return fmt.Sprint(v), false
+		}
+		return "", false
+	}
+	s.retryLock.Lock()
+	defer s.retryLock.Unlock()
+	if ids := s.retryIDs[key]; len(ids) > 0 {
+		if len(ids) == 1 {
+			delete(s.retryIDs, key)
+		} else {
+			s.retryIDs[key] = ids[1:]
+		}
+		s.retryCount--
+		return ids[0], true
+	}
+	return nuid.Next(), true
+}
+
+// saveRetryID 记录发送失败的消息生成的 Nats-Msg-Id，上层重试同一消息时使用
+func (s *Sender) saveRetryID(key [sha256.Size]byte, id string) {
+	s.retryLock.Lock()
+	defer s.retryLock.Unlock()
+	if s.retryCount >= maxRetryMsgIDs {
+		log.Warningf("Runner[%v] Sender[%v] more than %d messages are waiting for retry, forget their message ids", s.runnerName, s.Name(), maxRetryMsgIDs)
+		s.retryIDs = make(map[[sha256.Size]byte][]string)
+		s.retryCount = 0
+	}
+	s.retryIDs[key] = append(s.retryIDs[key], id)
+	s.retryCount++
+}
+
+func (s *Sender) Send(datas []Data) error {
+	ste := &StatsError{
+		Ft:         true,
+		FtNotRetry: true,
+	}
+	futures := make([]gonats.PubAckFuture, len(datas))
+	keys := make([][sha256.Size]byte, len(datas))
+	ids := make([]string, len(datas))
+	generated := make([]bool, len(datas))
+	var failed []Data
+	var lastErr error
+	for i, data := range datas {
+		subject, ok := s.renderSubject(data)
+		if !ok {
+			// 重试也无法生成主题，丢弃该条数据
+			ste.Errors++
+			ste.LastError = fmt.Sprintf("%s fields %v used in subject not found in data, discard it", s.Name(), s.subjectTmpl.Fields())
+			continue
+		}
+		body, err := jsoniter.Marshal(data)
+		if err != nil {
+			ste.Errors++
+			ste.LastError = fmt.Sprintf("%s marshal data failed: %v", s.Name(), err)
+			continue
+		}
+		msg := gonats.NewMsg(subject)
+		msg.Data = body
+		keys[i] = retryKey(subject, body)
+		ids[i], generated[i] = s.msgID(data, keys[i])
+		if ids[i] != "" {
+			msg.Header.Set(gonats.MsgIdHdr, ids[i])
+		}
+		if futures[i], err = s.js.PublishMsgAsync(msg); err != nil {
+			if generated[i] {
+				s.saveRetryID(keys[i], ids[i])
+			}
+			failed = append(failed, data)
+			lastErr = err
+		}
+	}
+
+	// 等待服务端的确认，超时之后不再等待，只将仍未确认的数据交给上层重试，重试时使用相同的 Nats-Msg-Id，不会产生重复数据
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	timeout := false
+	for i, future := range futures {
+		if future == nil {
+			continue
+		}
+		var err error
+		if !timeout {
+			select {
+			case <-future.Ok():
+			case err = <-future.Err():
+			case <-timer.C:
+				timeout = true
+			}
+		}
+		if timeout {
+			select {
+			case <-future.Ok():
+			case err = <-future.Err():
+			default:
+				err = gonats.ErrTimeout
+			}
+		}
+		if err != nil {
+			if generated[i] {
+				s.saveRetryID(keys[i], ids[i])
+			}
+			failed = append(failed, datas[i])
+			lastErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Errorf("Runner[%v] Sender[%v] publish %d of %d messages failed: %v", s.runnerName, s.Name(), len(failed), len(datas), lastErr)
+		return reqerr.NewSendError(
+			fmt.Sprintf("%s publish %d messages failed: %v", s.Name(), len(failed), lastErr),
+			sender.ConvertDatasBack(failed),
+			reqerr.TypeDefault,
+		)
+	}
+	if ste.Errors > 0 {
+		return ste
+	}
+	return nil
+}
+
+func (s *Sender) Close() error {
+	if err := s.conn.Drain(); err != nil {
+		s.conn.Close()
+		return err
+	}
+	return nil
+}
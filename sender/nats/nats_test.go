@@ -0,0 +1,128 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	gonats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/longxiucai/logkit/conf"
+	"github.com/longxiucai/logkit/sender"
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+func TestNATSSender(t *testing.T) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.NoError(t, err)
+	go s.Start()
+	defer s.Shutdown()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+
+	nc, err := gonats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&gonats.StreamConfig{Name: "LOGS", Subjects: []string{"logs.>"}})
+	require.NoError(t, err)
+
+	sub, err := js.SubscribeSync("logs.>")
+	require.NoError(t, err)
+
+	c := conf.MapConf{
+		sender.KeyNATSServers:        s.ClientURL(),
+		sender.KeyNATSSubject:        "logs.%{[app]}.%{[level]}",
+		sender.KeyNATSDefaultSubject: "logs.unknown",
+		sender.KeyNATSMsgIDField:     "id",
+	}
+	snd, err := NewSender(c)
+	require.NoError(t, err)
+	defer snd.Close()
+
+	err = snd.Send([]Data{
+		{"id": "1", "app": "web.front", "level": "info"},
+		{"id": "2", "app": "db"},
+		// 与第一条数据的去重 ID 相同，服务端不会重复保存
+		{"id": "1", "app": "web.front", "level": "info"},
+	})
+	assert.NoError(t, err)
+
+	expect := []string{"logs.web_front.info", "logs.unknown"}
+	for _, subject := range expect {
+		msg, err := sub.NextMsg(time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, subject, msg.Subject)
+	}
+	_, err = sub.NextMsg(200 * time.Millisecond)
+	assert.Equal(t, gonats.ErrTimeout, err)
+
+	info, err := js.StreamInfo("LOGS")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+
+	// 没有默认主题时丢弃缺少字段的数据
+	delete(c, sender.KeyNATSDefaultSubject)
+	snd2, err := NewSender(c)
+	require.NoError(t, err)
+	defer snd2.Close()
+	err = snd2.Send([]Data{{"app": "db"}})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.True(t, se.FtNotRetry)
+	assert.Equal(t, int64(1), se.Errors)
+}
+
+func TestNATSSenderGeneratedMsgID(t *testing.T) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.NoError(t, err)
+	go s.Start()
+	defer s.Shutdown()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+
+	nc, err := gonats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&gonats.StreamConfig{Name: "LOGS", Subjects: []string{"logs"}})
+	require.NoError(t, err)
+	sub, err := js.SubscribeSync("logs")
+	require.NoError(t, err)
+
+	snd, err := NewSender(conf.MapConf{
+		sender.KeyNATSServers: s.ClientURL(),
+		sender.KeyNATSSubject: "logs",
+	})
+	require.NoError(t, err)
+	defer snd.Close()
+
+	// 未配置去重字段时每条数据生成不同的 ID，内容相同的数据不会被去重
+	assert.NoError(t, snd.Send([]Data{{"a": "1"}, {"a": "1"}}))
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		msg, err := sub.NextMsg(time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":"1"}`, string(msg.Data))
+		ids[msg.Header.Get(gonats.MsgIdHdr)] = true
+	}
+	assert.Len(t, ids, 2)
+
+	// 失败的消息重试时使用记录的 ID，服务端丢弃已经保存过的消息，ID 不写入数据中
+	ns := snd.(*Sender)
+	data := Data{"a": "2"}
+	ns.saveRetryID(retryKey("logs", []byte(`{"a":"2"}`)), "retry-id")
+	ns.saveRetryID(retryKey("logs", []byte(`{"a":"2"}`)), "retry-id")
+	assert.NoError(t, snd.Send([]Data{data}))
+	assert.NoError(t, snd.Send([]Data{data}))
+	assert.Equal(t, Data{"a": "2"}, data)
+	assert.Empty(t, ns.retryIDs)
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":"2"}`, string(msg.Data))
+	assert.Equal(t, "retry-id", msg.Header.Get(gonats.MsgIdHdr))
+	_, err = sub.NextMsg(200 * time.Millisecond)
+	assert.Equal(t, gonats.ErrTimeout, err)
+}
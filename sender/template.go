@@ -0,0 +1,51 @@
+package sender
+
+import (
+	"fmt"
+	"regexp"
+
+	. "github.com/longxiucai/logkit/utils/models"
+)
+
+// fieldRefRe 匹配模板中引用字段的 %{[字段名]}
+var fieldRefRe = regexp.MustCompile(`%\{\[([^\]]+)\]\}`)
+
+// FieldTemplate 为以 %{[字段名]} 引用数据中字段的模板，用于按数据生成主题、routing key 等
+type FieldTemplate struct {
+	tmpl   string
+	fields []string
+}
+
+func NewFieldTemplate(tmpl string) *FieldTemplate {
+	t := &FieldTemplate{tmpl: tmpl}
+	for _, match := range fieldRefRe.FindAllStringSubmatch(tmpl, -1) {
+		t.fields = append(t.fields, match[1])
+	}
+	return t
+}
+
+// Fields 返回模板中引用的字段
+func (t *FieldTemplate) Fields() []string {
+	return t.fields
+}
+
+// Render 将引用的字段替换为 format 转换后的值，format 为 nil 时使用 fmt.Sprint；
+// 字段不存在或者值为空时替换为空字符串，并且 complete 为 false
+func (t *FieldTemplate) Render(data Data, format func(interface{}) string) (s string, complete bool) {
+	if len(t.fields) == 0 {
+		return t.tmpl, true
+	}
+	if format == nil {
+		format = func(v interface{}) string { return fmt.Sprint(v) }
+	}
+	complete = true
+	s = fieldRefRe.ReplaceAllStringFunc(t.tmpl, func(ref string) string {
+		v, ok := data[fieldRefRe.FindStringSubmatch(ref)[1]]
+		if !ok || v == nil || fmt.Sprint(v) == "" {
+			complete = false
+			return ""
+		}
+		return format(v)
+	})
+	return s, complete
+}
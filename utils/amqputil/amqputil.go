@@ -0,0 +1,17 @@
+// Package amqputil 为 amqp reader 与 sender 共用的工具函数
+package amqputil
+
+import (
+	"fmt"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// HostOf 返回地址中的 host:port 与非默认的 vhost，不包含用户名与密码
+func HostOf(uri amqp091.URI) string {
+	host := fmt.Sprintf("%s:%d", uri.Host, uri.Port)
+	if uri.Vhost != "/" {
+		host += "/" + uri.Vhost
+	}
+	return host
+}
@@ -127,6 +127,8 @@ type StatsInfo struct {
 	Trend      string  `json:"trend"`
 	LastError  string  `json:"last_error"`
 	FtQueueLag int64   `json:"-"`
+	// Extra 用于存放各组件特有的统计信息
+	Extra map[string]interface{} `json:"extra,omitempty"`
 }
 
 type ErrorQueue struct {
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/longxiucai/logkit/conf"
+)
+
+// TLSKeys 为 TLS 证书相关配置项的名字，配置项的值为证书文件的路径
+// CA 为校验对端证书的 CA 证书，Cert 与 Key 为自身的证书与私钥
+type TLSKeys struct {
+	CA   string
+	Cert string
+	Key  string
+	// InsecureSkipVerify 为是否跳过校验服务端证书的配置项，为空表示不支持该配置项
+	InsecureSkipVerify string
+}
+
+// NewClientTLSConfig 根据证书配置生成客户端的 TLS 配置，未配置任何 TLS 选项时返回 nil
+func NewClientTLSConfig(c conf.MapConf, keys TLSKeys) (*tls.Config, error) {
+	caFile, _ := c.GetStringOr(keys.CA, "")
+	certFile, _ := c.GetStringOr(keys.Cert, "")
+	keyFile, _ := c.GetStringOr(keys.Key, "")
+	var insecure bool
+	if keys.InsecureSkipVerify != "" {
+		insecure, _ = c.GetBoolOr(keys.InsecureSkipVerify, false)
+	}
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pool, err := loadCertPool(keys.CA, caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := loadKeyPair(keys, certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewServerTLSConfig 根据证书配置生成服务端的 TLS 配置，未配置证书时返回 nil
+// 配置了 CA 时要求客户端提供由该 CA 签发的证书，否则只在客户端提供证书时读取证书信息
+func NewServerTLSConfig(c conf.MapConf, keys TLSKeys) (*tls.Config, error) {
+	caFile, _ := c.GetStringOr(keys.CA, "")
+	certFile, _ := c.GetStringOr(keys.Cert, "")
+	keyFile, _ := c.GetStringOr(keys.Key, "")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("%s requires %s and %s", keys.CA, keys.Cert, keys.Key)
+		}
+		return nil, nil
+	}
+	cert, err := loadKeyPair(keys, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(keys.CA, caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func loadCertPool(key, caFile string) (*x509.CertPool, error) {
+	caData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %v", key, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no valid certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+func loadKeyPair(keys TLSKeys, certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("%s and %s must be set together", keys.Cert, keys.Key)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("load tls certificate failed: %v", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longxiucai/logkit/conf"
+)
+
+func TestTLSConfig(t *testing.T) {
+	keys := TLSKeys{CA: "tls_ca", Cert: "tls_cert", Key: "tls_key", InsecureSkipVerify: "tls_insecure"}
+
+	config, err := NewClientTLSConfig(conf.MapConf{}, keys)
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+	config, err = NewClientTLSConfig(conf.MapConf{"tls_insecure": "true"}, keys)
+	assert.NoError(t, err)
+	assert.True(t, config.InsecureSkipVerify)
+	_, err = NewClientTLSConfig(conf.MapConf{"tls_cert": "cert.pem"}, keys)
+	assert.EqualError(t, err, "tls_cert and tls_key must be set together")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0644))
+	_, err = NewClientTLSConfig(conf.MapConf{"tls_ca": caFile}, keys)
+	assert.EqualError(t, err, "no valid certificate found in "+caFile)
+
+	config, err = NewServerTLSConfig(conf.MapConf{}, keys)
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+	_, err = NewServerTLSConfig(conf.MapConf{"tls_ca": caFile}, keys)
+	assert.EqualError(t, err, "tls_ca requires tls_cert and tls_key")
+	_, err = NewServerTLSConfig(conf.MapConf{"tls_key": "key.pem"}, keys)
+	assert.EqualError(t, err, "tls_cert and tls_key must be set together")
+}